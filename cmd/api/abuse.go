@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/abuse"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// newChallenger returns the challenger chosen with -abuse-challenge, nil if there is none
+func newChallenger(cfg *config) (abuse.Challenger, error) {
+	switch cfg.abuse.challenge {
+	case "":
+		return nil, nil
+	case abuse.TypeProofOfWork:
+		if cfg.abuse.powDifficulty < 1 || cfg.abuse.powDifficulty > 32 {
+			return nil, errors.New("abuse-pow-difficulty must be between 1 and 32")
+		}
+
+		// challenges only live for minutes, so a new key on every start does no harm
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return abuse.NewProofOfWork(key, cfg.abuse.powDifficulty), nil
+	case abuse.TypeHCaptcha, abuse.TypeTurnstile:
+		if cfg.abuse.siteKey == "" || cfg.abuse.secretKey == "" {
+			return nil, fmt.Errorf("abuse-challenge %s needs abuse-captcha-site-key and abuse-captcha-secret-key", cfg.abuse.challenge)
+		}
+
+		if cfg.abuse.challenge == abuse.TypeHCaptcha {
+			return abuse.NewHCaptcha(cfg.abuse.siteKey, cfg.abuse.secretKey), nil
+		}
+		return abuse.NewTurnstile(cfg.abuse.siteKey, cfg.abuse.secretKey), nil
+	default:
+		return nil, fmt.Errorf("abuse-challenge must be pow, hcaptcha or turnstile, not %q", cfg.abuse.challenge)
+	}
+}
+
+// challengeSolution reads the solution of a challenge from the X-Challenge-Response header or the query
+// string, the CAPTCHA widgets on the download page submit theirs under their own names
+func challengeSolution(r *http.Request) string {
+	solution := r.Header.Get("X-Challenge-Response")
+	if solution != "" {
+		return solution
+	}
+
+	qs := r.URL.Query()
+	for _, key := range []string{"challenge_response", "h-captcha-response", "cf-turnstile-response"} {
+		if qs.Get(key) != "" {
+			return qs.Get(key)
+		}
+	}
+
+	return ""
+}
+
+// lookupBlocked answers a lookup which may not go ahead because the address is locked out, has to wait
+// or did not solve the challenge
+type lookupBlocked func(w http.ResponseWriter, r *http.Request, status abuse.Status)
+
+// abuseMetrics count what guardLookup did, they are published with expvar at /admin/metrics
+var abuseMetrics = expvar.NewMap("abuse")
+
+// guardCodeLookup protects the routes looking up a {code} against guessing, see guardLookup
+func (app *application) guardCodeLookup(next http.Handler) http.Handler {
+	return app.guardLookup(next, app.lookupBlockedResponse)
+}
+
+// guardCodePage is guardCodeLookup for pages, blocked browsers get a page to wait or solve the challenge on
+func (app *application) guardCodePage(next http.Handler) http.Handler {
+	return app.guardLookup(next, app.lookupBlockedPage)
+}
+
+// guardLookup counts the lookups answered with 404 per IP address and network. Once either reaches its
+// threshold the address has to wait out the backoff between lookups, waits up to -abuse-tarpit are spent
+// holding the request, and with -abuse-challenge solve a challenge for each of them. A code found that
+// way can be used again without a challenge, so downloading it takes only one. Addresses over
+// -abuse-lockout-failed-lookups are turned away altogether.
+func (app *application) guardLookup(next http.Handler, blocked lookupBlocked) http.Handler {
+	if app.lookups == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := remoteHost(r.RemoteAddr)
+		code := chi.URLParam(r, "code")
+
+		status, err := app.lookups.Admit(r.Context(), addr, code, app.config.abuse.tarpit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		switch {
+		case status.Locked:
+			abuseMetrics.Add("locked_out", 1)
+			blocked(w, r, status)
+			return
+		case status.RetryAfter > 0:
+			abuseMetrics.Add("slowed_down", 1)
+			blocked(w, r, status)
+			return
+		}
+
+		if status.Suspicious && app.challenger != nil {
+			err := app.challenger.Verify(r.Context(), challengeSolution(r), addr)
+			if err != nil {
+				if errors.Is(err, abuse.ErrChallengeFailed) {
+					abuseMetrics.Add("challenges_failed", 1)
+					blocked(w, r, status)
+				} else {
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			abuseMetrics.Add("challenges_passed", 1)
+		}
+
+		if status.Delay > 0 {
+			abuseMetrics.Add("tarpitted", 1)
+
+			timer := time.NewTimer(status.Delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			case <-app.shutdown.Done():
+				timer.Stop()
+				app.shuttingDownResponse(w, r)
+				return
+			}
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		switch {
+		case ww.Status() == http.StatusNotFound:
+			app.failLookup(context.WithoutCancel(r.Context()), app.contextGetLogger(r), addr)
+		case status.Suspicious && ww.Status() < http.StatusInternalServerError:
+			app.lookups.Pass(addr, code)
+		}
+	})
+}
+
+// failLookup counts a lookup which found nothing. ctx must not end when the client hangs up right
+// after the answer, or the failure would not be counted.
+func (app *application) failLookup(ctx context.Context, logger *slog.Logger, addr string) {
+	abuseMetrics.Add("failed_lookups", 1)
+
+	locked, err := app.lookups.Fail(ctx, addr)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if locked {
+		abuseMetrics.Add("lockouts", 1)
+		logger.Warn("address locked out for looking up unknown codes", "address", addr, "lockout", app.config.abuse.Lockout.String())
+	}
+}
+
+func (app *application) lookupBlockedResponse(w http.ResponseWriter, r *http.Request, status abuse.Status) {
+	switch {
+	case status.Locked:
+		app.lockedOutResponse(w, r, status.RetryAfter)
+	case status.RetryAfter > 0:
+		app.lookupBackoffResponse(w, r, status.RetryAfter)
+	default:
+		app.challengeRequiredResponse(w, r)
+	}
+}
+
+// challengePage is the data of templates/challenge.html
+type challengePage struct {
+	Locked bool
+	// Wait is the number of seconds before the page may be loaded again, the challenge is shown once it is 0
+	Wait      int
+	Challenge *abuse.Challenge
+}
+
+func (app *application) lookupBlockedPage(w http.ResponseWriter, r *http.Request, status abuse.Status) {
+	wait := int(math.Ceil(status.RetryAfter.Seconds()))
+
+	switch {
+	case status.Locked:
+		setRetryAfter(w, status.RetryAfter)
+		app.renderPage(w, r, http.StatusForbidden, "challenge.html", challengePage{Locked: true, Wait: wait})
+		return
+	case status.RetryAfter > 0:
+		setRetryAfter(w, status.RetryAfter)
+		app.renderPage(w, r, http.StatusTooManyRequests, "challenge.html", challengePage{Wait: wait})
+		return
+	}
+
+	challenge, err := app.challenger.Issue(remoteHost(r.RemoteAddr))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.renderPage(w, r, http.StatusPreconditionRequired, "challenge.html", challengePage{Challenge: challenge})
+}
+
+// getChallengeHandler issues a challenge to solve for lookups by code once a client is asked for one
+func (app *application) getChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.challenger == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	challenge, err := app.challenger.Issue(remoteHost(r.RemoteAddr))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"challenge": challenge}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listBlocksHandler lists the addresses and networks with failed lookups, whether they are slowed
+// down or locked out is in their state
+func (app *application) listBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	if app.lookups == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	records, err := app.lookups.Records(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"blocks": records}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// clearBlockHandler forgets the failed lookups and the lockout of the key in ?key=, such as ip:192.0.2.1
+// or net:192.0.2.0/24
+func (app *application) clearBlockHandler(w http.ResponseWriter, r *http.Request) {
+	if app.lookups == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+
+	v := validator.New()
+	v.Check(strings.HasPrefix(key, "ip:") || strings.HasPrefix(key, "net:"), "key", "must start with ip: or net:")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.lookups.Clear(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, abuse.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "block successfully cleared"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// deleteAccountHandler deletes the account of the user with everything of it. The rows go right away
+// and the blobs in the background, a blob left behind is picked up by reconcileStorage.
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user of a JWT may have an old email address, which could be someone else's by now
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, input.Password) {
+		return
+	}
+
+	files, err := app.models.Files.GetAllOwnedBy(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the files would go with the account
+	for _, file := range files {
+		if file.LegalHold {
+			v.AddError("account", "has files on legal hold and cannot be deleted")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	uploads, err := app.models.Uploads.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// revoked first, as the JWTs of the sessions keep working after their rows are gone otherwise
+	err = app.revokeSessions(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the other members of an organization left without an owner could not manage it anymore
+	err = app.models.Organizations.DeleteAllOwnedOnlyBy(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginAttempts.DeleteForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	logger := app.contextGetLogger(r)
+	logger.Info("account deleted", "user_id", user.ID, "files", len(files))
+
+	app.background(logger, func() {
+		for _, file := range files {
+			app.deleteBlob(logger, file)
+			if !file.Pending {
+				app.fileEvent(models.EventFileDeleted, file)
+			}
+		}
+		for _, upload := range uploads {
+			app.deleteUpload(upload)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account and all of its files were successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmPassword checks the password of user before a change of the account and writes the response if
+// it is wrong. It is checked like a login, so a stolen token cannot be used to guess it.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	attempt := &models.LoginAttempt{
+		Email:     user.Email,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+	}
+
+	_, err := app.authenticatePassword(attempt, password)
+	if err != nil {
+		var lockedErr *loginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			app.loginLockedResponse(w, r, lockedErr.wait)
+		case errors.Is(err, errInvalidCredentials):
+			v := validator.New()
+			v.AddError("password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errSuspendedAccount):
+			app.suspendedAccountResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// exportAccountHandler streams a zip archive of everything kept about the user, account.json with the
+// account and the files/<id>/ directories with the content of the files. The content of files which
+// cannot be read without the client is left out, account.json says why.
+func (app *application) exportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	files, err := app.models.Files.GetAllOwnedBy(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	webhooks, err := app.models.Webhooks.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Sessions.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the history is bounded by -login-history-retention, so a single page holds all of it
+	logins, _, err := app.models.LoginAttempts.GetAllForUser(user, models.Filters{Page: 1, PageSize: 1_000_000, Sort: "-created_at", SortSafelist: []string{"-created_at"}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	omitted := map[int64]string{}
+	for _, file := range files {
+		switch {
+		case file.Pending:
+			omitted[file.ID] = "the content was never uploaded"
+		case !file.Expiry.After(now):
+			omitted[file.ID] = "the file has expired"
+		case file.PassphraseProtected:
+			omitted[file.ID] = "the content is encrypted with a passphrase which is not stored"
+		case file.ScanStatus != models.ScanStatusClean && file.ScanStatus != models.ScanStatusSkipped:
+			omitted[file.ID] = "the content has not passed the malware scan"
+		}
+	}
+
+	account, err := json.MarshalIndent(envelope{
+		"exported_at":     now.UTC(),
+		"user":            user,
+		"files":           files,
+		"content_omitted": omitted,
+		"api_keys":        apiKeys,
+		"webhooks":        webhooks,
+		"passkeys":        passkeys,
+		"identities":      identities,
+		"sessions":        sessions,
+		"logins":          logins,
+	}, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	setArchiveHeaders(w, "file-transfer-export", archiveZip)
+
+	zw := zip.NewWriter(w)
+
+	err = app.writeExport(r, zw, account, files, omitted)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// the response has already started, all we can do is log and abort the archive
+		app.logError(r, err)
+	}
+}
+
+func (app *application) writeExport(r *http.Request, zw *zip.Writer, account []byte, files []*models.File, omitted map[int64]string) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "account.json",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = entry.Write(account)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if _, ok := omitted[file.ID]; ok {
+			continue
+		}
+
+		err := app.writeZipEntry(r.Context(), zw, fmt.Sprintf("files/%d/%s", file.ID, file.FullName()), file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
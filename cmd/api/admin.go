@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// adminFile shows the owner of a file, which is hidden from regular users, null for guest uploads
+type adminFile struct {
+	*models.File
+	UserID *int64 `json:"user_id"`
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := app.models.Users.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserHandler lets admins suspend users or change their role
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role      *string `json:"role"`
+		Suspended *bool   `json:"suspended"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	// an admin locking themselves out would leave nobody to undo it
+	v.Check(user.ID != app.contextGetUser(r).ID, "id", "must not be your own account")
+
+	role := user.Role
+
+	if input.Role != nil {
+		user.Role = *input.Role
+		v.Check(app.roles[user.Role] != nil, "role", "must be one of "+strings.Join(app.roleNames(), ", "))
+	}
+
+	if input.Suspended != nil {
+		user.Suspended = *input.Suspended
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// JWTs carry the role, so they are revoked for it to apply before they expire
+	if user.Suspended || (app.revocations != nil && user.Role != role) {
+		err = app.revokeSessions(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := app.models.Files.GetAllUnexpired()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	adminFiles := make([]adminFile, len(files))
+	for i, file := range files {
+		adminFiles[i] = adminFile{File: file, UserID: file.UserID}
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": adminFiles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteFileHandler removes any file, expired or not, regardless of its owner
+func (app *application) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	file, err := app.models.Files.Get(id)
+	if err == nil && file.LegalHold {
+		err = errLegalHold
+	}
+	if err == nil {
+		err = app.models.Files.Delete(file.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, errLegalHold):
+			app.legalHoldResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteBlob(app.contextGetLogger(r), file)
+	if !file.Pending {
+		app.fileEvent(models.EventFileDeleted, file)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "file successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	organizations, err := app.models.Organizations.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"organizations": organizations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOrganizationHandler changes the quota of an organization, a quota of null removes the limit.
+// The files already over a lowered quota are kept, only further uploads are refused.
+func (app *application) updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quota *int64 `json:"quota"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	organization, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	organization.Quota = input.Quota
+
+	v := validator.New()
+	if models.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.UpdateQuota(organization)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.models.Stats.Get()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// metricsHandler serves the variables published with expvar, such as abuseMetrics and memstats. cmdline
+// is left out, it would show secrets given as flags.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := envelope{}
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key != "cmdline" {
+			metrics[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"metrics": metrics}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// formats several files can be downloaded in as one archive
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// readArchiveFormat reads the format query parameter, zip unless tar.gz is asked for
+func (app *application) readArchiveFormat(qs url.Values, v *validator.Validator) string {
+	format := app.readString(qs, "format", archiveZip)
+	v.Check(validator.PermittedValue(format, archiveZip, archiveTarGz), "format", "must be zip or tar.gz")
+	return format
+}
+
+func setArchiveHeaders(w http.ResponseWriter, name, format string) {
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	switch format {
+	case archiveTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+	default:
+		w.Header().Set("Content-Type", "application/zip")
+	}
+}
+
+// archiveNames returns the names of files inside an archive, their names with the folders in front.
+// Files sharing a name get their ID added to it, so extracting does not overwrite one with another.
+func archiveNames(files []*models.File) []string {
+	seen := map[string]int{}
+	for _, file := range files {
+		seen[file.FullName()]++
+	}
+
+	names := make([]string, len(files))
+	for i, file := range files {
+		name := file.FullName()
+		if seen[name] > 1 {
+			ext := path.Ext(name)
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), file.ID, ext)
+		}
+		names[i] = name
+	}
+
+	return names
+}
+
+// writeArchive streams files into w as a zip or tar.gz archive. Nothing is staged on disk, the
+// content of each file is copied from storage straight into the archive.
+func (app *application) writeArchive(ctx context.Context, w io.Writer, format string, files []*models.File) error {
+	names := archiveNames(files)
+
+	switch format {
+	case archiveTarGz:
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+
+		for i, file := range files {
+			err := app.writeTarEntry(ctx, tw, names[i], file)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tw.Close()
+		if err != nil {
+			return err
+		}
+		return gw.Close()
+	default:
+		zw := zip.NewWriter(w)
+
+		for i, file := range files {
+			err := app.writeZipEntry(ctx, zw, names[i], file)
+			if err != nil {
+				return err
+			}
+		}
+
+		return zw.Close()
+	}
+}
+
+func (app *application) writeZipEntry(ctx context.Context, zw *zip.Writer, name string, file *models.File) error {
+	content, err := app.openFileContent(ctx, file, "")
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.LastUpdated,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// writeTarEntry needs the size up front, tar fails the entry if the content does not match it
+func (app *application) writeTarEntry(ctx context.Context, tw *tar.Writer, name string, file *models.File) error {
+	content, err := app.openFileContent(ctx, file, "")
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     file.Size,
+		Mode:     0644,
+		ModTime:  file.LastUpdated,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, content)
+	return err
+}
+
+// getUserFilesArchiveHandler streams the files of the user chosen with ?ids= as one archive
+func (app *application) getUserFilesArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	list := app.readCSV(qs, "ids", []string{})
+	format := app.readArchiveFormat(qs, v)
+
+	v.Check(len(list) > 0, "ids", "must be provided")
+	v.Check(len(list) <= maxArchiveFiles, "ids", fmt.Sprintf("must not contain more than %d entries", maxArchiveFiles))
+	v.Check(validator.Unique(list), "ids", "must not contain duplicate values")
+
+	ids := make([]int64, 0, len(list))
+	for _, s := range list {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("ids", "must be a comma separated list of file IDs")
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	files, err := app.models.Files.GetManyFromUser(ids, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(files) != len(ids) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	for _, file := range files {
+		if file.PassphraseProtected {
+			v.AddError("ids", fmt.Sprintf("%d: is protected with a passphrase and cannot be archived", file.ID))
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		if !app.checkFileScanned(w, r, file) {
+			return
+		}
+	}
+
+	setArchiveHeaders(w, "files", format)
+
+	err = app.writeArchive(r.Context(), w, format, files)
+	if err != nil {
+		// the response has already started, all we can do is log and abort the archive
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const auditContextKey = contextKey("audit")
+
+// auditRecord collects what the audit middleware does not see itself while a request is handled, like
+// requestLogger is shared by all handlers of a request
+type auditRecord struct {
+	mu      sync.Mutex
+	actorID *int64
+	objects []string
+}
+
+// auditedMethod reports whether requests with method change anything, those are the ones recorded
+func auditedMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return false
+	default:
+		return true
+	}
+}
+
+// auditActor records the user a request was made by, for requests which log them in
+func auditActor(ctx context.Context, userID int64) {
+	if record, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		record.mu.Lock()
+		defer record.mu.Unlock()
+
+		record.actorID = &userID
+	}
+}
+
+// auditObject records what a request was done to that is not in its route, like the files of an upload
+func auditObject(ctx context.Context, kind string, id int64) {
+	if record, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		record.mu.Lock()
+		defer record.mu.Unlock()
+
+		record.objects = append(record.objects, fmt.Sprintf("%s:%d", kind, id))
+	}
+}
+
+// routeObject returns the object of a route with an {id}, named after the path segment before it such
+// as file for /users/files/{id}
+func routeObject(pattern, id string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if segment == "{id}" && i > 0 {
+			return strings.TrimSuffix(segments[i-1], "s") + ":" + id
+		}
+	}
+	return ""
+}
+
+// audit records every request which may change something in the audit log once it is answered, with
+// the user, the route, what it was done to and whether it succeeded. Requests answered with 404 or 405
+// changed nothing and are left out, most of them are scanners.
+func (app *application) audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auditedMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := &auditRecord{}
+		r = r.WithContext(context.WithValue(r.Context(), auditContextKey, record))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+			return
+		}
+
+		record.mu.Lock()
+		defer record.mu.Unlock()
+
+		event := &models.AuditEvent{
+			ActorID: record.actorID,
+			Action:  r.Method,
+			Objects: record.objects,
+			IP:      remoteHost(r.RemoteAddr),
+			Success: status < http.StatusBadRequest,
+		}
+
+		// the route keeps codes, tokens and names out of the log
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			pattern := rctx.RoutePattern()
+			event.Action = r.Method + " " + pattern
+
+			if id := rctx.URLParam("id"); id != "" {
+				if object := routeObject(pattern, id); object != "" {
+					event.Objects = append([]string{object}, event.Objects...)
+				}
+			}
+		}
+
+		app.recordAudit(event)
+	})
+}
+
+// recordAudit inserts event into the audit log, a failure is logged but does not fail what was done
+func (app *application) recordAudit(event *models.AuditEvent) {
+	err := app.models.AuditEvents.Insert(event)
+	if err != nil {
+		app.logger.Error(err.Error(), "action", event.Action)
+	}
+}
+
+// listAuditEventsHandler lists the audit log, the last event first unless sort=created_at, filtered by
+// actor_id, action (a prefix), object such as file:42, ip and the [from, to) range of RFC 3339 times
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var filter models.AuditFilter
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	if qs.Has("actor_id") {
+		actorID, err := strconv.ParseInt(qs.Get("actor_id"), 10, 64)
+		if err != nil {
+			v.AddError("actor_id", "must be an integer value")
+		}
+		filter.ActorID = &actorID
+	}
+
+	filter.Action = app.readString(qs, "action", "")
+	filter.Object = app.readString(qs, "object", "")
+	filter.IP = app.readString(qs, "ip", "")
+	filter.From = readAuditTime(qs.Get("from"), "from", v)
+	filter.To = readAuditTime(qs.Get("to"), "to", v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.AuditEvents.GetAll(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAuditTime parses an RFC 3339 time of the query string, nil if it is left out
+func readAuditTime(s, key string, v *validator.Validator) *time.Time {
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 time")
+		return nil
+	}
+
+	return &t
+}
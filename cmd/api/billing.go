@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/billing"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// maxStripeEventSize bounds the body of a webhook request, events are a few kilobytes
+const maxStripeEventSize = 1 << 20
+
+// listBillingPlansHandler lists the plans which can be bought, those with a Stripe price
+func (app *application) listBillingPlansHandler(w http.ResponseWriter, r *http.Request) {
+	all, err := app.models.Plans.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	plans := []*models.Plan{}
+	for _, plan := range all {
+		if plan.StripePriceID != nil {
+			plans = append(plans, plan)
+		}
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"plans": plans}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCheckoutHandler starts a Checkout page of Stripe for a subscription to a plan and returns its URL.
+// The user is only put on the plan once the webhook hears that they paid. Users who already pay change
+// their subscription in the billing portal instead, so they are never charged twice.
+func (app *application) createCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlanID int64 `json:"plan_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	plan, err := app.models.Plans.Get(input.PlanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("plan_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if plan.StripePriceID == nil {
+		v.AddError("plan_id", "cannot be bought")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user of a JWT comes from its claims, which may be older than the last email change
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	params := billing.CheckoutParams{
+		PriceID:           *plan.StripePriceID,
+		CustomerEmail:     user.Email,
+		ClientReferenceID: strconv.FormatInt(user.ID, 10),
+		SuccessURL:        app.config.stripe.successURL,
+		CancelURL:         app.config.stripe.cancelURL,
+		Metadata:          map[string]string{"plan_id": strconv.FormatInt(plan.ID, 10)},
+	}
+
+	sub, err := app.models.Subscriptions.GetForUser(user.ID)
+	switch {
+	case err == nil && billing.Active(sub.Status):
+		v.AddError("plan_id", "you already have a subscription, change it in the billing portal")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case err == nil:
+		params.CustomerID = sub.CustomerID
+	case !errors.Is(err, models.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session, err := app.stripe.CreateCheckoutSession(r.Context(), params)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"checkout_url": session.URL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createBillingPortalHandler returns the URL of the billing portal of Stripe, where users who paid
+// change or cancel their subscription
+func (app *application) createBillingPortalHandler(w http.ResponseWriter, r *http.Request) {
+	sub, err := app.models.Subscriptions.GetForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	portal_url, err := app.stripe.CreatePortalSession(r.Context(), sub.CustomerID, app.config.stripe.successURL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"portal_url": portal_url}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// stripeWebhookHandler applies the events of Stripe to the plans of the users. A completed checkout puts
+// the user on the plan they paid for, a changed subscription on the plan of its price and one which ended
+// back on -default-plan. Errors are answered with 500 so that Stripe sends the event again, events which
+// are out of date or about unknown users or subscriptions are acknowledged without changing anything.
+func (app *application) stripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStripeEventSize))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	event, err := app.stripe.ParseEvent(payload, r.Header.Get("Stripe-Signature"), time.Now())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	logger := app.contextGetLogger(r).With("event_id", event.ID, "event_type", event.Type)
+
+	switch event.Type {
+	case billing.EventCheckoutCompleted:
+		err = app.applyCheckout(logger, event)
+	case billing.EventSubscriptionUpdated, billing.EventSubscriptionDeleted:
+		err = app.applySubscription(logger, event)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "event received"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyCheckout puts the user of a completed checkout on the plan of its metadata
+func (app *application) applyCheckout(logger *slog.Logger, event *billing.Event) error {
+	var session billing.CheckoutSession
+
+	err := json.Unmarshal(event.Data.Object, &session)
+	if err != nil {
+		return err
+	}
+
+	if session.Mode != "subscription" {
+		return nil
+	}
+
+	userID, err := strconv.ParseInt(session.ClientReferenceID, 10, 64)
+	if err != nil {
+		logger.Warn("checkout without a user", "client_reference_id", session.ClientReferenceID)
+		return nil
+	}
+
+	_, err = app.models.Users.Get(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			logger.Warn("checkout of a deleted user", "user_id", userID)
+			return nil
+		}
+		return err
+	}
+
+	var planID *int64
+
+	id, _ := strconv.ParseInt(session.Metadata["plan_id"], 10, 64)
+	plan, err := app.models.Plans.Get(id)
+	switch {
+	case err == nil:
+		planID = &plan.ID
+	case errors.Is(err, models.ErrRecordNotFound):
+		logger.Warn("checkout of a deleted plan", "user_id", userID, "plan_id", session.Metadata["plan_id"])
+	default:
+		return err
+	}
+
+	sub := &models.Subscription{
+		UserID:         userID,
+		CustomerID:     session.Customer,
+		SubscriptionID: session.Subscription,
+		Status:         "active",
+		UpdatedAt:      event.CreatedAt(),
+	}
+
+	err = app.models.Subscriptions.Start(sub, planID)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("subscription started", "user_id", userID, "plan_id", id)
+	return nil
+}
+
+// applySubscription puts the user of a changed subscription on the plan of its price, or takes them off
+// their plan if it ended or is unpaid
+func (app *application) applySubscription(logger *slog.Logger, event *billing.Event) error {
+	var sub billing.Subscription
+
+	err := json.Unmarshal(event.Data.Object, &sub)
+	if err != nil {
+		return err
+	}
+
+	var planID *int64
+
+	if sub.Active() && event.Type != billing.EventSubscriptionDeleted {
+		plan, err := app.models.Plans.GetByStripePrice(sub.PriceID())
+		switch {
+		case err == nil:
+			planID = &plan.ID
+		case errors.Is(err, models.ErrRecordNotFound):
+			logger.Warn("subscription to a price without a plan", "price_id", sub.PriceID())
+		default:
+			return err
+		}
+	}
+
+	err = app.models.Subscriptions.Update(sub.ID, sub.Status, planID, event.CreatedAt())
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			logger.Debug("subscription event ignored", "subscription_id", sub.ID)
+			return nil
+		}
+		return err
+	}
+
+	logger.Info("subscription updated", "subscription_id", sub.ID, "status", sub.Status)
+	return nil
+}
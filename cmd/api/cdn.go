@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/cdn"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// newCDN returns the signer of -cdn-provider, nil if downloads are not handed to a CDN
+func newCDN(cfg *config) (cdn.Signer, error) {
+	switch cfg.cdn.provider {
+	case "":
+		return nil, nil
+	case "cloudfront":
+		if cfg.cdn.cloudfront.keyPairID == "" || cfg.cdn.cloudfront.privateKey == "" {
+			return nil, errors.New("cdn-provider cloudfront requires cdn-cloudfront-key-pair-id and cdn-cloudfront-private-key")
+		}
+
+		data, err := os.ReadFile(cfg.cdn.cloudfront.privateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := cdn.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("cdn-cloudfront-private-key: %w", err)
+		}
+
+		return cdn.NewCloudFront(cfg.cdn.url, cfg.cdn.cloudfront.keyPairID, key), nil
+	case "cloudflare":
+		secret, err := hex.DecodeString(cfg.cdn.cloudflareSecret)
+		if err != nil || len(secret) < 32 {
+			return nil, errors.New("cdn-cloudflare-secret must be a hex encoded key of at least 32 bytes")
+		}
+
+		return cdn.NewCloudflare(cfg.cdn.url, secret), nil
+	default:
+		return nil, fmt.Errorf("cdn-provider %q is not cloudfront or cloudflare", cfg.cdn.provider)
+	}
+}
+
+// servedByCDN reports whether a download of file is handed to the CDN. Only buckets can be the origin of
+// a CDN, files on the local disk are always sent by the API. The CDN sends the stored bytes to whoever has
+// the URL, so files which are encrypted at rest, burn after a number of downloads or may only be downloaded
+// from some networks or countries are still sent by the API as well.
+func (app *application) servedByCDN(file *models.File) bool {
+	if app.cdn == nil || !slices.Contains([]string{"s3", "gcs", "azure"}, app.config.Storage.Backend) {
+		return false
+	}
+
+	return file.EncryptionKey == nil && file.MaxDownloads == nil && !app.downloadRestricted(file)
+}
+
+// cdnDownload registers a download of file like openDownload and returns the signed URL of the CDN it is
+// sent from instead of the content. The URL expires after -cdn-url-expiry, the download is counted now
+// unless it is resumed.
+func (app *application) cdnDownload(ctx context.Context, r *http.Request, file *models.File, download *models.Download) (string, error) {
+	if file.Pending {
+		return "", models.ErrRecordNotFound
+	}
+
+	err := fileScanError(file)
+	if err != nil {
+		return "", err
+	}
+
+	// the origin answers with the name and type of the file, S3 takes them from these parameters
+	query := url.Values{
+		"response-content-disposition": {fileDisposition(r, file)},
+		"response-content-type":        {file.ContentType},
+	}
+
+	target, err := app.cdn.SignURL(file.Path, query, time.Now().Add(app.config.cdn.expiry))
+	if err != nil {
+		return "", err
+	}
+
+	// files with a download limit are never sent from the CDN
+	if download == nil {
+		return target, nil
+	}
+
+	err = app.countDownload(ctx, file, download)
+	if err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// realIP replaces the address of requests from a reverse proxy of -trusted-proxies with the address of
+// the client, so rate limits, logs, the audit log and the checks of files see the client. It comes first,
+// before anything reads r.RemoteAddr. The forwarding headers of anyone else are ignored, as they can be
+// made up.
+func (app *application) realIP(next http.Handler) http.Handler {
+	if len(app.config.trustedProxies) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := app.forwardedIP(r); ip != nil {
+			_, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				port = "0"
+			}
+			r.RemoteAddr = net.JoinHostPort(ip.String(), port)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP returns the client address a trusted proxy forwarded r for, nil if r does not come from
+// one or it did not say. It is the last address of X-Forwarded-For which is not another trusted proxy,
+// or X-Real-IP without X-Forwarded-For. A hop which is no address is as untrusted as the client, so
+// then the request keeps the address of the proxy it came from.
+func (app *application) forwardedIP(r *http.Request) net.IP {
+	ip := net.ParseIP(remoteHost(r.RemoteAddr))
+	if ip == nil || !app.trustedProxy(ip) {
+		return nil
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+
+	// every proxy appends the address it got the request from, the ones left of the first untrusted
+	// address were sent by the client
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+		ip = hop
+		if !app.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (app *application) trustedProxy(ip net.IP) bool {
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client of r, as realIP left it. nil means it could not be parsed.
+func (app *application) clientIP(r *http.Request) net.IP {
+	return net.ParseIP(remoteHost(r.RemoteAddr))
+}
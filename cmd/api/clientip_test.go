@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedIP(t *testing.T) {
+	app := newTestApplication(t)
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.config.trustedProxies = append(app.config.trustedProxies, network)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:1234", []string{"198.51.100.1"}, "", ""},
+		{"proxy without headers", "10.0.0.1:1234", nil, "", ""},
+		{"single proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "", "198.51.100.1"},
+		{"spoofed addresses left of the client", "10.0.0.1:1234", []string{"192.0.2.9, 198.51.100.1, 10.0.0.2"}, "", "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"IPv6", "[fd00::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"X-Real-IP", "10.0.0.1:1234", nil, " 198.51.100.1 ", "198.51.100.1"},
+		{"X-Forwarded-For comes first", "10.0.0.1:1234", []string{"198.51.100.1"}, "192.0.2.9", "198.51.100.1"},
+		{"invalid X-Real-IP", "10.0.0.1:1234", nil, "unknown", ""},
+		{"invalid client hop", "10.0.0.1:1234", []string{"unknown"}, "", ""},
+		{"invalid hop behind a proxy", "10.0.0.1:1234", []string{"198.51.100.1, garbage, 10.0.0.2"}, "", ""},
+		{"empty hop", "10.0.0.1:1234", []string{"198.51.100.1,, 10.0.0.2"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			got := app.forwardedIP(r)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("got %s, want none", got)
+				}
+				return
+			}
+
+			if !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinSize is the smallest body worth compressing, most error responses stay below it
+const compressMinSize = 1024
+
+// compressEncodings are preferred in this order when the client accepts several equally
+var compressEncodings = []string{"zstd", "gzip"}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// compress compresses JSON responses with zstd or gzip, whichever the Accept-Encoding of the client
+// prefers. Downloads of files are never compressed, not even JSON files, because they are mostly
+// compressed already and Range requests and Content-Length refer to the stored bytes. Neither are
+// server-sent events, which have to reach the client as they are written.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the encoding of compressEncodings with the highest weight in header, "" if
+// the client accepts none of them
+func acceptedEncoding(header string) string {
+	weights := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+
+		weights[name] = weight
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range compressEncodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+		if ok && weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+
+	return best
+}
+
+// compressWriter holds back the status until the first write, which decides whether the response is
+// compressed: writeJSON writes the whole body at once, so it is known to be large enough.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	wroteHeader bool
+	decided     bool
+	encoder     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	// informational responses such as 103 Early Hints are not the final status
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.wroteHeader = true
+	w.status = status
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(p))
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide starts compressing if the response is JSON of at least compressMinSize with a body, size
+// is the length of the first write
+func (w *compressWriter) decide(size int) {
+	w.decided = true
+
+	h := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+
+	compressible := mediaType == "application/json" && h.Get("Content-Disposition") == "" &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if compressible && size >= compressMinSize && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+
+		// the validators of the uncompressed body do not apply to the compressed one
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch w.encoding {
+		case "zstd":
+			encoder := zstdWriters.Get().(*zstd.Encoder)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		default:
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close finishes the compressed stream, or sends the status of a response without a body
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			return
+		}
+		w.decided = true
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	switch encoder := w.encoder.(type) {
+	case *zstd.Encoder:
+		encoder.Close()
+		encoder.Reset(nil)
+		zstdWriters.Put(encoder)
+	case *gzip.Writer:
+		encoder.Close()
+		encoder.Reset(io.Discard)
+		gzipWriters.Put(encoder)
+	}
+}
+
+// Flush sends what was compressed so far, server-sent events are never compressed but go through it
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(0)
+	}
+
+	switch encoder := w.encoder.(type) {
+	case *zstd.Encoder:
+		encoder.Flush()
+	case *gzip.Writer:
+		encoder.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// ReadFrom keeps sendfile working for responses copied from a file, which are downloads and never
+// compressed
+func (w *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.decided {
+		w.decide(0)
+	}
+
+	if w.encoder != nil {
+		return io.Copy(w.encoder, src)
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
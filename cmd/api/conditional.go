@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// writeJSONETag is writeJSON for responses clients poll, like the lists, with a weak ETag hashed from
+// the body. A request with a matching If-None-Match gets a 304 without the body instead, the query still
+// runs but the client and proxies do not download the list again.
+func (app *application) writeJSONETag(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	sum := sha256.Sum256(js)
+	etag := `W/"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if status == http.StatusOK && etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+	return nil
+}
+
+// etagMatches reports whether the If-None-Match of r names etag. The comparison is weak, as it has to be
+// for If-None-Match, so "abc" and W/"abc" match.
+func etagMatches(r *http.Request, etag string) bool {
+	header := strings.Join(r.Header.Values("If-None-Match"), ",")
+	if header == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
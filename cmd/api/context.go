@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
 	"github.com/Li-Elias/File-Transfer/internal/models"
@@ -9,9 +10,28 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	apiKeyContextKey    = contextKey("api_key")
+	loggerContextKey    = contextKey("logger")
+	requestIDContextKey = contextKey("request_id")
+)
+
+// requestLogger is shared by all handlers of a request, so attributes which are only known later,
+// like the user, also end up in the request log written by the Logger middleware
+type requestLogger struct {
+	*slog.Logger
+}
 
+// contextSetUser also adds the user to the request logger and the audit log
 func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
+	if logger, ok := r.Context().Value(loggerContextKey).(*requestLogger); ok && !user.IsAnonymous() {
+		logger.Logger = logger.With("user_id", user.ID)
+	}
+	if !user.IsAnonymous() {
+		auditActor(r.Context(), user.ID)
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -23,3 +43,39 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 	}
 	return user
 }
+
+func (app *application) contextSetAPIKey(r *http.Request, apiKey *models.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, apiKey)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns nil unless the request was authenticated with an API key
+func (app *application) contextGetAPIKey(r *http.Request) *models.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	return apiKey
+}
+
+func (app *application) contextSetLogger(r *http.Request, logger *slog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, &requestLogger{logger})
+	return r.WithContext(ctx)
+}
+
+// contextGetLogger returns the logger of the request, or the application logger outside of the Logger middleware
+func (app *application) contextGetLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*requestLogger)
+	if !ok {
+		return app.logger
+	}
+	return logger.Logger
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns an empty string outside of the requestID middleware
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// copyUserFileHandler duplicates a file of the user under a new code with a fresh expiry, so a file which
+// is about to expire can be shared again without uploading it again. The copy keeps the name, details,
+// password and organization of the file, its downloads start over. The content is copied inside the storage
+// as it is stored, encrypted files keep their key.
+func (app *application) copyUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	defaultTTL, _, err := app.uploadDefaults(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	ttl := app.readExpiresInDefault(url.Values{"expires_in": {input.ExpiresIn}}, defaultTTL, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.models.Files.WithContext(r.Context()).GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if file.Pending {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if errors.Is(fileScanError(file), errQuarantined) {
+		v.AddError("file", "is quarantined and cannot be copied")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if file.OrganizationID != nil {
+		organization, err := app.models.Organizations.Get(*file.OrganizationID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if organization.Quota != nil {
+			usage, err := app.models.Files.GetOrganizationUsage(organization.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			left := *organization.Quota - usage
+			if file.Size > left {
+				v.AddError("file_size", fmt.Sprintf("must not be more than the %d bytes left of the quota of the organization", max(left, 0)))
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+		}
+	}
+
+	copied := *file
+	copied.Code = app.generateCode(user)
+	copied.Expiry = time.Now().Add(ttl)
+	copied.DownloadCount = 0
+	copied.HasThumbnail = false
+	copied.TransferID = nil
+
+	// only the checks are needed, the content is not read
+	_, err = app.applyPlan(&copied, nil, file.Size)
+	if err == nil {
+		err = app.checkDiskSpace(app.contextGetLogger(r), file.Size)
+	}
+	if err == nil {
+		err = app.copyFile(r.Context(), app.contextGetLogger(r), file, &copied)
+	}
+	if err != nil {
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"file": copied}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// copyFile inserts copied, a copy of the row of file, and copies the blob of file for it. Backends which can
+// copy objects themselves do, the content is streamed through the server otherwise. Like createFile, the
+// row is deleted again if the blob cannot be copied.
+func (app *application) copyFile(ctx context.Context, logger *slog.Logger, file, copied *models.File) error {
+	err := app.models.Files.WithContext(ctx).Insert(copied)
+	if err != nil {
+		return err
+	}
+	auditObject(ctx, "file", copied.ID)
+
+	err = app.copyBlob(ctx, file.Path, copied.Path)
+	if err == nil {
+		// Insert leaves out the content, which is the same as the one of file
+		err = app.models.Files.WithContext(ctx).UpdateContent(copied)
+	}
+	if err != nil {
+		app.discardFile(logger, copied)
+		return err
+	}
+
+	logger.Info("file copied", "file_id", file.ID, "copy_id", copied.ID, "bytes", copied.Size)
+
+	app.queueScan()
+	app.queueThumbnail(logger, copied)
+	app.fileEvent(models.EventFileUploaded, copied)
+
+	return nil
+}
+
+func (app *application) copyBlob(ctx context.Context, src, dst string) error {
+	if copier, ok := app.storage.(storage.DirectUploader); ok {
+		return copier.Copy(src, dst)
+	}
+
+	blob, err := app.tracedStorage(ctx).Get(src)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	return app.tracedStorage(ctx).Put(dst, blob, -1)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxDeliveryRecipients is the most addresses a file can be sent to at once
+const maxDeliveryRecipients = 10
+
+// sendUserFileHandler emails every recipient a signed link of their own, which downloads the file without
+// its code or password until expires_at, the expiry of the file by default. The emails are sent in the
+// background, GET /users/files/{id}/deliveries shows how that went and which links were used.
+func (app *application) sendUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Recipients []string   `json:"recipients"`
+		Message    string     `json:"message"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.WithContext(r.Context()).GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if file.Pending {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	expiresAt := file.Expiry
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+	// the signature only has whole seconds
+	expiresAt = time.Unix(expiresAt.Unix(), 0)
+
+	for i, email := range input.Recipients {
+		input.Recipients[i] = strings.ToLower(strings.TrimSpace(email))
+	}
+
+	v := validator.New()
+	v.Check(len(input.Recipients) > 0, "recipients", "must contain at least 1 email address")
+	v.Check(len(input.Recipients) <= maxDeliveryRecipients, "recipients", "must not contain more than 10 email addresses")
+	v.Check(validator.Unique(input.Recipients), "recipients", "must not contain duplicate email addresses")
+	for _, email := range input.Recipients {
+		v.Check(validator.Matches(email, validator.EmailRX), "recipients", "must only contain valid email addresses")
+	}
+	v.Check(len(input.Message) <= 1000, "message", "must not be more than 1000 bytes long")
+	v.Check(expiresAt.After(time.Now()), "expires_at", "must be in the future")
+	v.Check(!expiresAt.After(file.Expiry), "expires_at", "must not be after the expiry of the file")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries := []*models.Delivery{}
+	for _, email := range input.Recipients {
+		delivery := &models.Delivery{
+			FileID:     file.ID,
+			Email:      email,
+			Status:     models.DeliveryStatusPending,
+			LinkExpiry: expiresAt,
+		}
+
+		err = app.models.Deliveries.Insert(delivery)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
+		for _, delivery := range deliveries {
+			data := map[string]interface{}{
+				"senderName":   user.Name,
+				"fileName":     file.FullName(),
+				"message":      input.Message,
+				"downloadLink": app.deliveryFileURL(file, delivery.LinkExpiry, delivery.ID),
+				"linkExpiry":   delivery.LinkExpiry.UTC().Format(time.RFC1123),
+			}
+
+			status, message := models.DeliveryStatusSent, ""
+
+			err := app.mailer.Send(delivery.Email, "file_delivery.tmpl", data)
+			if err != nil {
+				// the error of the mail server is for the logs, the owner only learns that it failed
+				logger.Error(err.Error(), "delivery_id", delivery.ID)
+				status, message = models.DeliveryStatusFailed, "the email could not be sent"
+			}
+
+			err = app.models.Deliveries.UpdateStatus(delivery.ID, status, message)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserFileDeliveriesHandler lists who the file was sent to, whether the emails went out and which
+// links were used
+func (app *application) listUserFileDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = "-id"
+	filters.SortSafelist = []string{"-id"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.models.Files.GetFromUser(id, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deliveries, metadata, err := app.models.Deliveries.GetAllForFile(file, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"syscall"
+
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+)
+
+var errInsufficientStorage = errors.New("the server does not have enough storage space left for the upload")
+
+// isOutOfSpace reports whether storing failed for lack of space, either up front or because the disk filled up
+func isOutOfSpace(err error) bool {
+	return errors.Is(err, errInsufficientStorage) || errors.Is(err, syscall.ENOSPC)
+}
+
+// checkDiskSpace returns errInsufficientStorage if storing size more bytes, -1 if unknown, would leave less
+// than -storage-min-free-space on a storage backend on a disk. Other backends are never checked.
+func (app *application) checkDiskSpace(logger *slog.Logger, size int64) error {
+	spacer, ok := app.storage.(storage.FreeSpacer)
+	if !ok || app.config.disk.minFree == 0 {
+		return nil
+	}
+
+	free, err := spacer.FreeSpace()
+	if err != nil {
+		// uploads are not turned away because the free space is unknown
+		if !errors.Is(err, errors.ErrUnsupported) {
+			logger.Error(err.Error())
+		}
+		return nil
+	}
+
+	need := uint64(app.config.disk.minFree)
+	if size > 0 {
+		need += uint64(size)
+	}
+
+	if free < need {
+		logger.Warn("rejected upload, storage is low on space",
+			"free_bytes", free,
+			"min_free_bytes", app.config.disk.minFree,
+			"size", size,
+		)
+		return errInsufficientStorage
+	}
+
+	return nil
+}
+
+// requireDiskSpace rejects uploads with 507 Insufficient Storage while the local storage is low on space,
+// the length of the body is counted against the free space if it is known
+func (app *application) requireDiskSpace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := app.checkDiskSpace(app.contextGetLogger(r), r.ContentLength)
+		if err != nil {
+			app.insufficientStorageResponse(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
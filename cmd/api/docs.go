@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Li-Elias/File-Transfer/internal/docs"
+	"github.com/Li-Elias/File-Transfer/internal/web"
+)
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(docs.OpenAPI)
+}
+
+// docsHandler serves Swagger UI, the page loads its scripts and styles from unpkg.com
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docs.SwaggerUI)
+}
+
+// webAppHandler serves the browser UI, every path below /app gets the same page
+func (app *application) webAppHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(web.Index)
+}
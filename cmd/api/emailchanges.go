@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// emailChangeTTL is how long the new address has to confirm a change, the email says so too
+const emailChangeTTL = 24 * time.Hour
+
+// createEmailChangeHandler emails a token to the new address of the user, the address only changes once
+// it is sent back with confirmEmailChangeHandler. Asking again replaces the change waiting for it.
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidateEmail(v, input.Email)
+	models.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user of a JWT may have an old email address, which could be someone else's by now
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, input.Password) {
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from the current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, models.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	change, err := app.models.EmailChanges.New(user.ID, input.Email, emailChangeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"emailChangeToken": change.Token.Plaintext,
+		}
+
+		err = app.mailer.Send(change.Email, "email_change.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing instructions to confirm it"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler changes the email address with the token sent to the new one. The blobs of
+// files are stored under random keys, so nothing is moved. The tokens sent to the old address are
+// deleted and it is told about the change.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	change, err := app.models.EmailChanges.Confirm(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{models.ScopePasswordReset, models.ScopeMagicLink} {
+		err = app.models.Tokens.DeleteAllForUser(scope, change.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	user, err := app.models.Users.Get(change.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// JWTs carry the email address, so they are revoked for it to apply before they expire
+	if app.revocations != nil {
+		err = app.revokeSessions(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	logger := app.contextGetLogger(r)
+	logger.Info("email address changed", "user_id", user.ID)
+
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"email": change.Email,
+		}
+
+		err = app.mailer.Send(change.OldEmail, "email_changed.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteExpiredEmailChanges removes the email changes which were not confirmed in time
+func (app *application) deleteExpiredEmailChanges() error {
+	deleted, err := app.models.EmailChanges.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired email changes", "count", deleted)
+	}
+
+	return nil
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	app.contextGetLogger(r).Error(err.Error(), "url", redactedURL(r))
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -61,6 +66,26 @@ func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) filePasswordRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"password": "this file is password protected, send the password in the X-File-Password header or the password query parameter"}
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidFilePasswordResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"password": "the provided password is incorrect"}
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) filePassphraseRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"passphrase": "this file is encrypted with a passphrase, send it in the X-File-Passphrase header or the passphrase query parameter"}
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidFilePassphraseResponse(w http.ResponseWriter, r *http.Request) {
+	message := map[string]string{"passphrase": "the provided passphrase is incorrect"}
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
@@ -71,7 +96,135 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) suspendedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) tooManyRequests(w http.ResponseWriter, r *http.Request) {
 	message := "too many requests"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) contentTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request body is larger than the remaining upload length"
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) unsupportedTusVersionResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the Tus-Resumable header must be set to %s", tusVersion)
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) uploadOffsetConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Upload-Offset header does not match the current offset of the upload"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) shuttingDownResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "30")
+	message := "the server is shutting down, please try again shortly"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) invalidSignedURLResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the link is invalid"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) expiredSignedURLResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the link has expired"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
+func (app *application) fileScanPendingResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "10")
+	message := "the file is still being scanned for malware, please try again shortly"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) fileQuarantinedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the file has been removed because it failed the malware scan"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
+func (app *application) fileNetworkForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the file cannot be downloaded from your network"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) fileCountryForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the file cannot be downloaded from your country"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) legalHoldResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the file is on legal hold and cannot be deleted or replaced"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) directUploadsUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "direct uploads are not available on this server"
+	app.errorResponse(w, r, http.StatusNotImplemented, message)
+}
+
+// insufficientStorageResponse is sent for uploads turned away by checkDiskSpace
+// and for writes which ran out of space halfway, those are logged
+func (app *application) insufficientStorageResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, errInsufficientStorage) {
+		app.contextGetLogger(r).Warn(err.Error(), "url", redactedURL(r))
+	}
+
+	message := "the server does not have enough storage space left for the upload"
+	app.errorResponse(w, r, http.StatusInsufficientStorage, message)
+}
+
+func (app *application) tooManyTransfersResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "5")
+	message := "too many uploads and downloads in progress, please wait for one to finish"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// setRetryAfter sets the Retry-After header to wait rounded up to whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+func (app *application) lookupBackoffResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	setRetryAfter(w, wait)
+	message := "too many unknown codes were looked up from your address, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) lockedOutResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	setRetryAfter(w, wait)
+	message := "your address has been locked out for looking up too many unknown codes"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) challengeRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many unknown codes were looked up from your address, solve a challenge from GET /challenge and send the solution in the X-Challenge-Response header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	setRetryAfter(w, wait)
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/events"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// fileEvent tells the webhooks of the owner of file and the -events-broker about event. The request which
+// caused the event must not fail because of it, so errors are only logged.
+func (app *application) fileEvent(event string, file *models.File) {
+	payload, err := json.Marshal(envelope{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"user_id":    file.UserID,
+		"file":       file,
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "file_id", file.ID, "event", event)
+		return
+	}
+
+	app.events.Publish(events.Event{Type: event, Key: strconv.FormatInt(file.ID, 10), Payload: payload})
+	app.notifyWebhooks(event, file, payload)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// extendedPage is the data of templates/extended.html, Notice says why the file was not extended
+type extendedPage struct {
+	Name   string
+	Expiry time.Time
+	Notice string
+}
+
+// extensionSignature signs the file id, its current code and the expiry the owner was told about. Like
+// linkSignature, regenerating the code revokes the link.
+func (app *application) extensionSignature(file *models.File, expiry int64) string {
+	mac := hmac.New(sha256.New, app.config.links.signingKey)
+	fmt.Fprintf(mac, "extend.%d.%s.%d", file.ID, file.Code, expiry)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// extensionURL returns the link of the expiry notice of file, it works until the current expiry of file
+func (app *application) extensionURL(file *models.File) string {
+	expiry := file.Expiry.Unix()
+
+	query := url.Values{
+		"id":  {strconv.FormatInt(file.ID, 10)},
+		"exp": {strconv.FormatInt(expiry, 10)},
+		"sig": {app.extensionSignature(file, expiry)},
+	}
+
+	return app.config.publicURL + "/files/extend?" + query.Encode()
+}
+
+// notifyExpiringFiles emails the owners of the files which expire within -expiry-notice, once for every
+// expiry, with a link which keeps the file for -expiry-notice-extension. Other servers sharing the database
+// skip the files one of them has marked, and a failed email is not sent again.
+func (app *application) notifyExpiringFiles() error {
+	files, err := app.models.Files.GetAllExpiringBefore(time.Now().Add(app.config.expiryNotices.before))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if app.shutdown.Err() != nil {
+			break
+		}
+
+		err := app.models.Files.MarkExpiryNotified(file)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				app.logger.Error(err.Error(), "file_id", file.ID)
+			}
+			continue
+		}
+
+		app.fileEvent(models.EventFileExpiring, file)
+
+		user, err := app.models.Users.Get(*file.UserID)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				app.logger.Error(err.Error(), "file_id", file.ID)
+			}
+			continue
+		}
+
+		data := map[string]interface{}{
+			"fileName":   file.Name,
+			"fileCode":   file.Code,
+			"expiresAt":  file.Expiry.UTC().Format(time.RFC1123),
+			"extension":  formatExtension(app.config.expiryNotices.extension),
+			"extendLink": app.extensionURL(file),
+		}
+
+		logger := app.logger.With("file_id", file.ID)
+		app.background(logger, func() {
+			err := app.mailer.Send(user.Email, "file_expiring.tmpl", data)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		})
+	}
+
+	return nil
+}
+
+// formatExtension writes d in days or hours where it is a whole number of them, like 3 days
+func formatExtension(d time.Duration) string {
+	switch {
+	case d == 24*time.Hour:
+		return "1 day"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	default:
+		return d.String()
+	}
+}
+
+// extendFileHandler is the link of an expiry notice, opening it keeps the file for -expiry-notice-extension
+// from now without logging in. It never shortens the expiry, so opening it again, or a mail scanner opening it
+// first, does no harm. The page is for browsers, the link is only ever opened from the email.
+func (app *application) extendFileHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	invalid := extendedPage{Notice: "This link is invalid, it may have been replaced by a newer one."}
+
+	id, err := strconv.ParseInt(qs.Get("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.renderPage(w, r, http.StatusForbidden, "extended.html", invalid)
+		return
+	}
+
+	expiry, err := strconv.ParseInt(qs.Get("exp"), 10, 64)
+	if err != nil {
+		app.renderPage(w, r, http.StatusForbidden, "extended.html", invalid)
+		return
+	}
+
+	file, err := app.models.Files.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.renderPage(w, r, http.StatusForbidden, "extended.html", invalid)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the signature is checked before anything else about the file is revealed
+	if !hmac.Equal([]byte(qs.Get("sig")), []byte(app.extensionSignature(file, expiry))) {
+		app.renderPage(w, r, http.StatusForbidden, "extended.html", invalid)
+		return
+	}
+
+	if time.Now().Unix() >= expiry || file.DeletedAt != nil || !file.Expiry.After(time.Now()) {
+		page := extendedPage{Notice: "This link has expired, the file has expired or was deleted."}
+		app.renderPage(w, r, http.StatusGone, "extended.html", page)
+		return
+	}
+
+	extension := min(app.config.expiryNotices.extension, app.config.files.maxExpiry)
+
+	// the link never keeps a file longer than the plan of its owner allows
+	if file.UserID != nil {
+		plan, err := app.userPlan(*file.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if plan != nil && plan.MaxExpiry != nil {
+			extension = min(extension, *plan.MaxExpiry)
+		}
+	}
+	if extended := time.Now().Add(extension); extended.After(file.Expiry) {
+		file.Expiry = extended
+
+		err = app.models.Files.UpdateShare(file)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrEditConflict):
+				page := extendedPage{Notice: "The file was changed at the same time, please open the link again."}
+				app.renderPage(w, r, http.StatusConflict, "extended.html", page)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.renderPage(w, r, http.StatusOK, "extended.html", extendedPage{Name: file.Name, Expiry: file.Expiry})
+}
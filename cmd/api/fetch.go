@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+var errPrivateAddress = errors.New("address is not publicly routable")
+
+// blockedNetworks are reserved ranges not covered by the net.IP helpers used in publicIP
+var blockedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10",
+		"192.0.0.0/24",
+		"198.18.0.0/15",
+		"240.0.0.0/4",
+		"64:ff9b::/96",
+	}
+
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}()
+
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkPublicHost returns errPrivateAddress unless the host of rawURL resolves to public addresses only,
+// the dial is checked again since DNS records may change
+func checkPublicHost(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return errPrivateAddress
+		}
+	}
+
+	return nil
+}
+
+// fetchDialControl runs after the host name has been resolved, checking the address actually
+// dialed also covers redirects and DNS records which change between lookups
+func fetchDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// newFetchClient returns the client for fetching remote files. It never uses a proxy, which
+// would be dialed instead of the remote host and so bypass fetchDialControl.
+func newFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: fetchDialControl,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchError marks a failure of the remote server, as opposed to one of our storage
+type fetchError struct {
+	err error
+}
+
+func (e *fetchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+// fetchBody turns read errors of a remote body into fetchErrors
+type fetchBody struct {
+	io.ReadCloser
+}
+
+func (f fetchBody) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = &fetchError{err: err}
+	}
+	return n, err
+}
+
+// fetchFileName picks the name of a fetched file from Content-Disposition or the last path segment of its URL
+func fetchFileName(res *http.Response) string {
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
+
+	name := path.Base(res.Request.URL.Path)
+	if name == "/" || name == "." {
+		return "download"
+	}
+
+	return name
+}
+
+// fetchFileHandler downloads a remote http or https URL and stores it like an upload. Only publicly
+// routable addresses are fetched, the size is bounded by -file-max-size and the time by -fetch-timeout.
+func (app *application) fetchFileHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL              string   `json:"url"`
+		Name             string   `json:"name"`
+		Description      string   `json:"description"`
+		Tags             []string `json:"tags"`
+		ExpiresIn        string   `json:"expires_in"`
+		MaxDownloads     *int     `json:"max_downloads"`
+		Password         string   `json:"password"`
+		Passphrase       string   `json:"passphrase"`
+		ChecksumSHA256   string   `json:"checksum_sha256"`
+		NotifyOnDownload bool     `json:"notify_on_download"`
+		StripMetadata    bool     `json:"strip_metadata"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	values := url.Values{
+		"expires_in":      {input.ExpiresIn},
+		"password":        {input.Password},
+		"passphrase":      {input.Passphrase},
+		"checksum_sha256": {input.ChecksumSHA256},
+		"strip_metadata":  {strconv.FormatBool(input.StripMetadata)},
+	}
+
+	v := validator.New()
+
+	u, err := url.Parse(input.URL)
+	v.Check(input.URL != "", "url", "must be provided")
+	v.Check(len(input.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	ttl := app.readExpiresIn(values, v)
+
+	opts := storeOptions{
+		passphrase:    app.readFilePassphrase(values, v),
+		checksum:      app.readFileChecksum(values, v),
+		stripMetadata: app.readStripMetadata(values, v),
+		logger:        app.contextGetLogger(r),
+		ctx:           r.Context(),
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	req.Header.Set("User-Agent", "File-Transfer-Fetch")
+
+	res, err := app.fetchClient.Do(req)
+	if err != nil {
+		app.fetchFailedResponse(w, r, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		v.AddError("url", fmt.Sprintf("remote server responded with %s", res.Status))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if res.ContentLength > app.config.files.maxSize {
+		v.AddError("file_size", fmt.Sprintf("must not be more than %d bytes big", app.config.files.maxSize))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	name := input.Name
+	if name == "" {
+		name = fetchFileName(res)
+	}
+
+	new_file := app.newFile(user, name, 0, ttl)
+	new_file.Description = input.Description
+	new_file.MaxDownloads = input.MaxDownloads
+	new_file.NotifyOnDownload = input.NotifyOnDownload
+	if input.Tags != nil {
+		new_file.Tags = models.NormalizeTags(input.Tags)
+	}
+
+	err = app.readFilePassword(values, new_file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	size := int64(-1)
+	if res.ContentLength >= 0 {
+		size = res.ContentLength
+	}
+
+	// a MaxBytesReader without a ResponseWriter only limits the body
+	body := http.MaxBytesReader(nil, fetchBody{res.Body}, app.config.files.maxSize)
+
+	err = app.createFile(new_file, body, size, opts)
+	if err != nil {
+		var fetchErr *fetchError
+		switch {
+		case errors.As(err, &fetchErr):
+			app.fetchFailedResponse(w, r, err)
+		default:
+			app.storeFilePartErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": new_file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// fetchFailedResponse reports why a URL could not be fetched without exposing details of internal addresses
+func (app *application) fetchFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	message := "could not be fetched"
+	if errors.Is(err, errPrivateAddress) {
+		message = "must not point to a private or reserved address"
+	}
+
+	v := validator.New()
+	v.AddError("url", message)
+	app.failedValidationResponse(w, r, v.Errors)
+}
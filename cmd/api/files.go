@@ -1,109 +1,1103 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"os/signal"
+	"net/url"
 	"path/filepath"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
 
+	"github.com/Li-Elias/File-Transfer/internal/encryption"
+	"github.com/Li-Elias/File-Transfer/internal/filename"
+	"github.com/Li-Elias/File-Transfer/internal/metadata"
 	"github.com/Li-Elias/File-Transfer/internal/models"
 	"github.com/Li-Elias/File-Transfer/internal/validator"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// newFile returns a file of user, files of the anonymous user are guest uploads without an owner
+func (app *application) newFile(user *models.User, name string, size int64, ttl time.Duration) *models.File {
+	file := &models.File{
+		Name:   filename.Sanitize(name),
+		Size:   size,
+		Code:   app.generateCode(user),
+		Expiry: time.Now().Add(ttl),
+		Tags:   []string{},
+	}
+
+	if !user.IsAnonymous() {
+		file.UserID = &user.ID
+	}
+
+	// nothing can be downloaded before the content is stored and scanned
+	file.ScanStatus = app.scanStatus(file)
+
+	return file
+}
+
+// readExpiresIn reads the optional expires_in duration, it defaults to the configured file expiry
+func (app *application) readExpiresIn(values url.Values, v *validator.Validator) time.Duration {
+	return app.readExpiresInDefault(values, app.config.files.defaultExpiry, v)
+}
+
+// readExpiresInDefault is readExpiresIn with another default, such as the one of the user
+func (app *application) readExpiresInDefault(values url.Values, defaultValue time.Duration, v *validator.Validator) time.Duration {
+	ttl := app.readDuration(values, "expires_in", defaultValue, v)
+
+	v.Check(ttl > 0, "expires_in", "must be a positive duration")
+	v.Check(ttl <= app.config.files.maxExpiry, "expires_in", fmt.Sprintf("must not be more than %s", app.config.files.maxExpiry))
+
+	return ttl
+}
+
+// uploadDefaults returns the expiry and max downloads of the uploads of user which leave them out, the
+// defaults the user set with PATCH /users/me or else the configured expiry and no max
+func (app *application) uploadDefaults(user *models.User) (time.Duration, *int, error) {
+	settings, err := app.models.UserSettings.Get(user.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ttl := app.config.files.defaultExpiry
+	if settings.DefaultExpiry != nil {
+		// -file-max-expiry may have been lowered since the user set it
+		ttl = min(*settings.DefaultExpiry, app.config.files.maxExpiry)
+	}
+
+	return ttl, settings.DefaultMaxDownloads, nil
+}
+
+// readFilePassword hashes the optional password form field into file, problems with it are added to v
+func (app *application) readFilePassword(values url.Values, file *models.File, v *validator.Validator) error {
+	plaintext := values.Get("password")
+	if plaintext == "" {
+		return nil
+	}
+
+	if models.ValidatePasswordPlaintext(v, plaintext); !v.Valid() {
+		return nil
+	}
+
+	return file.Password.Set(plaintext)
+}
+
+// checkFilePassword reads the download password from the X-File-Password header or the password query parameter
+func (app *application) checkFilePassword(w http.ResponseWriter, r *http.Request, file *models.File) bool {
+	if !file.PasswordProtected {
+		return true
+	}
+
+	plaintext := r.Header.Get("X-File-Password")
+	if plaintext == "" {
+		plaintext = r.URL.Query().Get("password")
+	}
+
+	err := app.verifyFilePassword(file, plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPasswordRequired):
+			app.filePasswordRequiredResponse(w, r)
+		case errors.Is(err, errInvalidPassword):
+			app.invalidFilePasswordResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// checkFileAccess writes a 403 if the file cannot be downloaded from the address of the client, because
+// of its allowed networks or a blocked country. It comes before the password so the password cannot be
+// guessed from elsewhere.
+func (app *application) checkFileAccess(w http.ResponseWriter, r *http.Request, file *models.File) bool {
+	ip := app.clientIP(r)
+
+	if !file.AllowsIP(ip) {
+		app.fileNetworkForbiddenResponse(w, r)
+		return false
+	}
+
+	if country, blocked := app.blockedCountry(r, ip, file); blocked {
+		app.contextGetLogger(r).Info("download blocked", "file_id", file.ID, "country", country)
+		app.recordAudit(&models.AuditEvent{
+			Action:  "geoip block",
+			Objects: []string{fmt.Sprintf("file:%d", file.ID), "country:" + country},
+			IP:      ip.String(),
+		})
+		app.fileCountryForbiddenResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// readFilePassphrase reads the optional passphrase form field of an upload
+func (app *application) readFilePassphrase(values url.Values, v *validator.Validator) string {
+	passphrase := values.Get("passphrase")
+	if passphrase == "" {
+		return ""
+	}
+
+	v.Check(len(passphrase) >= 8, "passphrase", "must be at least 8 bytes long")
+	v.Check(len(passphrase) <= 1024, "passphrase", "must not be more than 1024 bytes long")
+
+	return passphrase
+}
+
+// readFileChecksum reads the optional hex encoded SHA-256 digest a client expects the upload to have
+func (app *application) readFileChecksum(values url.Values, v *validator.Validator) string {
+	checksum := strings.ToLower(values.Get("checksum_sha256"))
+	if checksum == "" {
+		return ""
+	}
+
+	_, err := hex.DecodeString(checksum)
+	v.Check(err == nil && len(checksum) == sha256.Size*2, "checksum_sha256", "must be a hex encoded SHA-256 digest")
+
+	return checksum
+}
+
+// readStripMetadata reads the optional strip_metadata flag. Stripping changes the content, so it cannot
+// be combined with a checksum of the content the client sends.
+func (app *application) readStripMetadata(values url.Values, v *validator.Validator) bool {
+	strip := app.readBool(values, "strip_metadata", false, v)
+	v.Check(!strip || values.Get("checksum_sha256") == "", "checksum_sha256", "must not be given together with strip_metadata")
+
+	return strip
+}
+
+// storeOptions are the optional client inputs for storing the content of a file, and the
+// logger and context of the request the content arrives with
+type storeOptions struct {
+	passphrase string
+	checksum   string
+	// contentType replaces the detected type, for content whose type is already known
+	contentType string
+	// stripMetadata removes EXIF, GPS and similar metadata from images before they are stored
+	stripMetadata bool
+	logger        *slog.Logger
+	ctx           context.Context
+}
+
+// context returns the context storing is traced as part of
+func (opts storeOptions) context() context.Context {
+	if opts.ctx == nil {
+		return context.Background()
+	}
+	return opts.ctx
+}
+
+// storeFileContent writes content to the storage of file and records its size and checksum, size is -1 if unknown.
+// With a passphrase the file key is wrapped by a key derived from it (the passphrase itself is never
+// stored), otherwise by the master key if one is configured. Every write gets a fresh key and nonce.
+// If the content does not match opts.checksum the write fails with errChecksumMismatch. With opts.stripMetadata
+// the size and checksum are those of the stripped content.
+func (app *application) storeFileContent(file *models.File, r io.Reader, size int64, opts storeOptions) error {
+	start_time := time.Now()
+
+	// the type is checked before anything is written, http.DetectContentType looks at 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	head = head[:n]
+
+	contentType := detectContentType(file.Name, head)
+	if opts.contentType != "" {
+		contentType = opts.contentType
+	}
+
+	err = app.checkFileType(file.Name, contentType)
+	if err != nil {
+		return err
+	}
+
+	src := io.MultiReader(bytes.NewReader(head), r)
+	if opts.stripMetadata {
+		stripped := metadata.Strip(src)
+		defer stripped.Close()
+
+		src = stripped
+		size = -1
+	}
+
+	digest := sha256.New()
+	content := &countingReader{r: &checksumReader{r: src, hash: digest, expected: opts.checksum}}
+
+	file.EncryptionKey = nil
+	file.EncryptionNonce = nil
+	file.PassphraseSalt = nil
+
+	var blob io.Reader = content
+	if opts.passphrase != "" || app.config.encryption.masterKey != nil {
+		key, err := encryption.NewKey()
+		if err != nil {
+			return err
+		}
+
+		nonce, err := encryption.NewNoncePrefix()
+		if err != nil {
+			return err
+		}
+
+		if opts.passphrase != "" {
+			file.EncryptionKey, file.PassphraseSalt, err = encryption.WrapKeyWithPassphrase(opts.passphrase, key)
+		} else {
+			file.EncryptionKey, err = encryption.WrapKey(app.config.encryption.masterKey, key)
+		}
+		if err != nil {
+			return err
+		}
+		file.EncryptionNonce = nonce
+
+		blob, err = encryption.NewEncrypter(content, key, nonce)
+		if err != nil {
+			return err
+		}
+
+		if size >= 0 {
+			size = encryption.EncryptedSize(size)
+		}
+	}
+
+	err = app.tracedStorage(opts.context()).Put(file.Path, blob, size)
+	if err != nil {
+		return err
+	}
+
+	file.Size = content.n
+	file.ChecksumSHA256 = hex.EncodeToString(digest.Sum(nil))
+	file.ContentType = contentType
+	file.PassphraseProtected = file.PassphraseSalt != nil
+	file.ScanStatus = app.scanStatus(file)
+
+	err = app.models.Files.WithContext(opts.context()).UpdateContent(file)
+	if err != nil {
+		return err
+	}
+
+	app.uploadLogger(file, opts).Info("file uploaded",
+		"file_id", file.ID,
+		"bytes", file.Size,
+		"µs", time.Since(start_time).Microseconds(),
+	)
+
+	app.queueScan()
+	app.queueThumbnail(app.uploadLogger(file, opts), file)
+
+	return nil
+}
+
+// uploadLogger returns the logger of the request storing file, or one with its owner where there is none
+func (app *application) uploadLogger(file *models.File, opts storeOptions) *slog.Logger {
+	if opts.logger == nil {
+		if file.UserID == nil {
+			return app.logger.With("guest", true)
+		}
+		return app.logger.With("user_id", *file.UserID)
+	}
+	return opts.logger
+}
+
+// checksumReader hashes everything read through it, on EOF it fails with errChecksumMismatch if
+// the digest differs from expected. Failing the read keeps storage from committing the blob.
+type checksumReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+
+	if err == io.EOF && c.expected != "" && c.expected != hex.EncodeToString(c.hash.Sum(nil)) {
+		return n, errChecksumMismatch
+	}
+
+	return n, err
+}
+
+// detectContentType trusts the magic bytes of the content, the extension of the name is only
+// consulted when they do not reveal more than plain text or binary data
+func detectContentType(name string, head []byte) string {
+	if contentType := sniffExecutable(head); contentType != "" {
+		return contentType
+	}
+
+	contentType := http.DetectContentType(head)
+
+	switch contentType {
+	case "application/octet-stream", "text/plain; charset=utf-8":
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			return byExtension
+		}
+	}
+
+	return contentType
+}
+
+// inlineContentTypes can be shown by browsers without running scripts from our origin
+var inlineContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+func canDisplayInline(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") {
+		return true
+	}
+
+	return validator.PermittedValue(mediaType, inlineContentTypes...)
+}
+
+type decryptedContent struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openFileContent returns the stored content of file, decrypted if it was stored encrypted. Files
+// encrypted with a passphrase fail with encryption.ErrInvalidPassphrase unless it is the right one.
+// openFileContent is traced as part of ctx
+func (app *application) openFileContent(ctx context.Context, file *models.File, passphrase string) (io.ReadSeekCloser, error) {
+	var key []byte
+	var err error
+
+	switch {
+	case file.EncryptionKey == nil:
+	case file.PassphraseProtected:
+		key, err = encryption.UnwrapKeyWithPassphrase(passphrase, file.PassphraseSalt, file.EncryptionKey)
+	case app.config.encryption.masterKey == nil:
+		err = errors.New("file is encrypted but no encryption master key is configured")
+	default:
+		key, err = encryption.UnwrapKey(app.config.encryption.masterKey, file.EncryptionKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := app.tracedStorage(ctx).Get(file.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return blob, nil
+	}
+
+	content, err := encryption.NewDecrypter(blob, key, file.EncryptionNonce)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+
+	return decryptedContent{ReadSeeker: content, Closer: blob}, nil
+}
+
+// createFile inserts file and stores its content within the limits of the plan of its user. If storing fails
+// the row is deleted again together with whatever was written of the blob, so no row is left without its content.
+func (app *application) createFile(file *models.File, r io.Reader, size int64, opts storeOptions) error {
+	r, err := app.applyPlan(file, r, size)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Files.WithContext(opts.context()).Insert(file)
+	if err != nil {
+		return err
+	}
+	auditObject(opts.context(), "file", file.ID)
+
+	err = app.storeFileContent(file, r, size, opts)
+	if err != nil {
+		app.discardFile(app.uploadLogger(file, opts), file)
+		return err
+	}
+
+	app.fileEvent(models.EventFileUploaded, file)
+
+	return nil
+}
+
+// discardFile removes the row and, in the background, the blob of a file whose content could not be stored.
+// A blob which is left behind once the row is gone is picked up by reconcileStorage.
+func (app *application) discardFile(logger *slog.Logger, file *models.File) {
+	err := app.models.Files.Delete(file.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		logger.Error(err.Error(), "file_id", file.ID)
+	}
+
+	app.background(logger, func() {
+		app.deleteBlob(logger, file)
+	})
+}
+
+// filePartReader limits the file part of a multipart upload to the maximum file size
+func (app *application) filePartReader(w http.ResponseWriter, part *multipart.Part) io.Reader {
+	return http.MaxBytesReader(w, part, app.config.files.maxSize)
+}
+
+func (app *application) storeFilePartErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	var typeErr *fileTypeError
+	var planErr *planLimitError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		v := validator.New()
+		v.AddError("file_size", fmt.Sprintf("must not be more than %d bytes big", app.config.files.maxSize))
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.As(err, &planErr):
+		v := validator.New()
+		v.AddError(planErr.key, planErr.message)
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, errChecksumMismatch):
+		v := validator.New()
+		v.AddError("checksum_sha256", "does not match the uploaded content")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.As(err, &typeErr):
+		v := validator.New()
+		v.AddError("file", typeErr.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, metadata.ErrInvalid), errors.Is(err, metadata.ErrUnsupported):
+		v := validator.New()
+		v.AddError("strip_metadata", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case isOutOfSpace(err):
+		app.insufficientStorageResponse(w, r, err)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// uploadFileHandler streams the upload into storage without buffering it,
+// form fields therefore have to be sent before the file part.
 func (app *application) uploadFileHandler(w http.ResponseWriter, r *http.Request) {
-	file, handler, err := r.FormFile("file")
+	part, values, err := app.readMultipartFile(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer part.Close()
+
+	user := app.contextGetUser(r)
+
+	defaultTTL, defaultMaxDownloads, err := app.uploadDefaults(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	ttl := app.readExpiresInDefault(values, defaultTTL, v)
+	new_file := app.newFile(user, part.FileName(), 0, ttl)
+	new_file.MaxDownloads = defaultMaxDownloads
+
+	if values.Has("max_downloads") {
+		maxDownloads := app.readInt(values, "max_downloads", 0, v)
+		new_file.MaxDownloads = &maxDownloads
+	}
+
+	new_file.NotifyOnDownload = app.readBool(values, "notify_on_download", false, v)
+	new_file.Tags = models.NormalizeTags(app.readCSV(values, "tags", []string{}))
+	new_file.AllowedNetworks = models.NormalizeNetworks(app.readCSV(values, "allowed_networks", nil))
+	new_file.BlockedCountries = models.NormalizeCountries(app.readCSV(values, "blocked_countries", nil))
+	new_file.Description = values.Get("description")
+
+	organization, maxSize, err := app.readUploadOrganization(values, user, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if organization != nil {
+		new_file.OrganizationID = &organization.ID
+	}
+
+	err = app.readFilePassword(values, new_file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	opts := storeOptions{
+		passphrase:    app.readFilePassphrase(values, v),
+		checksum:      app.readFileChecksum(values, v),
+		stripMetadata: app.readStripMetadata(values, v),
+		logger:        app.contextGetLogger(r),
+		ctx:           r.Context(),
+	}
+
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.createFile(new_file, http.MaxBytesReader(w, part, maxSize), -1, opts)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError) && maxSize < app.config.files.maxSize:
+			v.AddError("file_size", fmt.Sprintf("must not be more than the %d bytes left of the quota of the organization", maxSize))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.storeFilePartErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": new_file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// maxBatchFiles bounds the number of files of a batch upload
+const maxBatchFiles = 100
+
+// uploadFilesHandler stores every file part of a multipart body as a file of its own, each with its own code.
+// The form fields before the first file part apply to all of them. Besides the limit of each part, the whole
+// body is limited to the maximum file size and 1MB of form fields, so a batch is for many small files. If one
+// of the files cannot be stored, the ones before it are removed again.
+func (app *application) uploadFilesHandler(w http.ResponseWriter, r *http.Request) {
+	// readMultipartFiles puts the limit of the whole body on r.Body
+	reader, part, values, err := app.readMultipartFiles(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	logger := app.contextGetLogger(r)
+
+	defaultTTL, defaultMaxDownloads, err := app.uploadDefaults(user)
+	if err != nil {
+		part.Close()
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	ttl := app.readExpiresInDefault(values, defaultTTL, v)
+	v.Check(!values.Has("checksum_sha256"), "checksum_sha256", "is not supported for batch uploads")
+
+	// shared holds the settings every file of the batch is created with
+	shared := app.newFile(user, "", 0, ttl)
+	shared.MaxDownloads = defaultMaxDownloads
+
+	if values.Has("max_downloads") {
+		maxDownloads := app.readInt(values, "max_downloads", 0, v)
+		shared.MaxDownloads = &maxDownloads
+	}
+
+	shared.NotifyOnDownload = app.readBool(values, "notify_on_download", false, v)
+	shared.Tags = models.NormalizeTags(app.readCSV(values, "tags", []string{}))
+	shared.AllowedNetworks = models.NormalizeNetworks(app.readCSV(values, "allowed_networks", nil))
+	shared.BlockedCountries = models.NormalizeCountries(app.readCSV(values, "blocked_countries", nil))
+	shared.Description = values.Get("description")
+
+	err = app.readFilePassword(values, shared, v)
+	if err != nil {
+		part.Close()
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	opts := storeOptions{
+		passphrase:    app.readFilePassphrase(values, v),
+		stripMetadata: app.readStripMetadata(values, v),
+		logger:        logger,
+		ctx:           r.Context(),
+	}
+
+	if !v.Valid() {
+		part.Close()
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	files := []*models.File{}
+
+	// discard removes the files stored so far once the batch has failed
+	discard := func() {
+		for _, file := range files {
+			app.discardFile(logger, file)
+		}
+	}
+
+	for part != nil {
+		if len(files) == maxBatchFiles {
+			part.Close()
+			discard()
+			v.AddError("file", fmt.Sprintf("must not contain more than %d files", maxBatchFiles))
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		new_file := app.newFile(user, part.FileName(), 0, ttl)
+		new_file.MaxDownloads = shared.MaxDownloads
+		new_file.NotifyOnDownload = shared.NotifyOnDownload
+		new_file.Tags = shared.Tags
+		new_file.AllowedNetworks = shared.AllowedNetworks
+		new_file.BlockedCountries = shared.BlockedCountries
+		new_file.Description = shared.Description
+		new_file.Password = shared.Password
+
+		if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+			part.Close()
+			discard()
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.createFile(new_file, app.filePartReader(w, part), -1, opts)
+		part.Close()
+		if err != nil {
+			discard()
+			app.storeFilePartErrorResponse(w, r, err)
+			return
+		}
+
+		files = append(files, new_file)
+
+		part, err = nextMultipartFile(reader)
+		if err != nil {
+			discard()
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"files": files}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserFilesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		Tags []string
+		models.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Tags = models.NormalizeTags(app.readCSV(qs, "tag", []string{}))
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "size", "expiry", "created_at", "-id", "-name", "-size", "-expiry", "-created_at"}
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	files, metadata, err := app.models.Files.GetAllFromUser(user, input.Name, input.Tags, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": files, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) searchUserFilesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		models.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 200, "q", "must not be more than 200 bytes long")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// matches are always ordered by rank
+	input.Filters.Sort = "rank"
+	input.Filters.SortSafelist = []string{"rank"}
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	files, metadata, err := app.models.Files.SearchFromUser(user, input.Query, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": files, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	part, values, err := app.readMultipartFile(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer part.Close()
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	ttl := app.readExpiresIn(values, v)
+	// without a version the client replaces whatever version the file is at
+	version := app.readInt(values, "version", 0, v)
+	opts := storeOptions{
+		passphrase:    app.readFilePassphrase(values, v),
+		checksum:      app.readFileChecksum(values, v),
+		stripMetadata: app.readStripMetadata(values, v),
+		logger:        app.contextGetLogger(r),
+		ctx:           r.Context(),
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err == nil && file.Name != filename.Sanitize(part.FileName()) {
+		err = models.ErrRecordNotFound
+	}
+	// the content of a held file has to stay what it was
+	if err == nil && file.LegalHold {
+		err = errLegalHold
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, errLegalHold):
+			app.legalHoldResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if version == 0 {
+		version = file.Version
+	}
+
+	// the new content and expiry have to fit the plan like an upload, in place of the current content
+	replacing := *file
+	replacing.Expiry = time.Now().Add(ttl)
+	content, err := app.applyPlanReplacing(&replacing, app.filePartReader(w, part), -1)
+	if err != nil {
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	updated_file, err := app.models.Files.UpdateFromUser(file.Name, id, user, version, app.generateCode(user), replacing.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// check if path exists
+	if _, err := app.storage.Stat(updated_file.Path); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// blobs are only replaced once they have been written completely, the old content survives a failed write
+	err = app.storeFileContent(updated_file, content, -1, opts)
+	if err != nil {
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": updated_file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserFileDetailsHandler changes the description, tags, notification setting, allowed networks and blocked
+// countries of a file, fields left out stay the same
+func (app *application) updateUserFileDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Description      *string  `json:"description"`
+		Tags             []string `json:"tags"`
+		NotifyOnDownload *bool    `json:"notify_on_download"`
+		AllowedNetworks  []string `json:"allowed_networks"`
+		BlockedCountries []string `json:"blocked_countries"`
+		Version          *int     `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	defer file.Close()
 
 	user := app.contextGetUser(r)
 
-	new_file := &models.File{
-		Name:   handler.Filename,
-		Size:   handler.Size,
-		Path:   fmt.Sprintf("./cache/%s/%s", user.Email, handler.Filename),
-		Code:   app.generateUniqueString(),
-		Expiry: time.Now().Add(2 * time.Minute),
-		UserID: user.ID,
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Version != nil && *input.Version != file.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Description != nil {
+		file.Description = *input.Description
+	}
+	if input.Tags != nil {
+		file.Tags = models.NormalizeTags(input.Tags)
+	}
+	if input.NotifyOnDownload != nil {
+		file.NotifyOnDownload = *input.NotifyOnDownload
+	}
+	// empty lists lift the restrictions
+	if input.AllowedNetworks != nil {
+		file.AllowedNetworks = models.NormalizeNetworks(input.AllowedNetworks)
+	}
+	if input.BlockedCountries != nil {
+		file.BlockedCountries = models.NormalizeCountries(input.BlockedCountries)
 	}
 
 	v := validator.New()
-	if models.ValidateFile(v, new_file); !v.Valid() {
+	if models.ValidateFile(v, file, app.config.files.maxSize); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Files.Insert(new_file)
+	err = app.models.Files.UpdateDetails(file)
 	if err != nil {
 		switch {
-		case err.Error() == "duplicate path":
-			v.AddError("file", "path already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.createFile(file, new_file.Path)
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserFileExpiryHandler sets a new expiry counted from now, optionally with a new code
+func (app *application) updateUserFileExpiryHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	// delete file after expiry or server shutdown
-	timer := time.NewTimer(30 * time.Second)
-	cancel := make(chan os.Signal, 1)
-	signal.Notify(cancel, syscall.SIGINT, syscall.SIGTERM)
+	var input struct {
+		ExpiresIn      string `json:"expires_in"`
+		RegenerateCode bool   `json:"regenerate_code"`
+	}
 
-	app.background(func() {
-		select {
-		case <-timer.C:
-			err := app.deleteFileInBackground(new_file.Path, new_file.ID)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
-			}
-		case <-cancel:
-			err := app.deleteFileInBackground(new_file.Path, new_file.ID)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
-			}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	ttl := app.readExpiresIn(url.Values{"expires_in": {input.ExpiresIn}}, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err == nil {
+		file.Expiry = time.Now().Add(ttl)
+		if input.RegenerateCode {
+			file.Code = app.generateCode(user)
 		}
-	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": new_file}, nil)
+		err = app.checkPlanExpiry(file)
+	}
+	if err == nil {
+		err = app.models.Files.UpdateShare(file)
+	}
+	var planErr *planLimitError
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.As(err, &planErr):
+			v.AddError(planErr.key, planErr.message)
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) listUserFilesHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) listUserFileDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = app.readString(qs, "sort", "-downloaded_at")
+	filters.SortSafelist = []string{"downloaded_at", "-downloaded_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	user := app.contextGetUser(r)
 
-	files, err := app.models.Files.GetAllFromUser(user)
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	downloads, metadata, err := app.models.Downloads.GetAllForFile(file, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"files": files}, nil)
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"downloads": downloads, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) getUserFileHandler(w http.ResponseWriter, r *http.Request) {
+// regenerateUserFileCodeHandler replaces a leaked code, the old one stops working right away
+func (app *application) regenerateUserFileCodeHandler(w http.ResponseWriter, r *http.Request) {
 	id_str := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(id_str, 10, 64)
 	if err != nil || id < 1 {
@@ -114,10 +1108,16 @@ func (app *application) getUserFileHandler(w http.ResponseWriter, r *http.Reques
 	user := app.contextGetUser(r)
 
 	file, err := app.models.Files.GetFromUser(id, user)
+	if err == nil {
+		file.Code = app.generateCode(user)
+		err = app.models.Files.UpdateShare(file)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -130,7 +1130,7 @@ func (app *application) getUserFileHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-func (app *application) updateUserFileHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) deleteUserFileHandler(w http.ResponseWriter, r *http.Request) {
 	id_str := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(id_str, 10, 64)
 	if err != nil || id < 1 {
@@ -138,133 +1138,405 @@ func (app *application) updateUserFileHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	file, handler, err := r.FormFile("file")
+	user := app.contextGetUser(r)
+
+	trashed, err := app.deleteUserFile(id, user)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, errLegalHold):
+			app.legalHoldResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	defer file.Close()
 
-	user := app.contextGetUser(r)
+	// trashed files can be restored until the janitor purges them after -trash-retention
+	if trashed {
+		env := envelope{
+			"message":       "file moved to the trash",
+			"restore_until": time.Now().Add(app.config.trash.retention).UTC().Format(time.RFC3339),
+		}
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "file successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-	file_path := fmt.Sprintf("./cache/%s/%s", user.Email, handler.Filename)
+func (app *application) getFileFromCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
 
-	updated_file, err := app.models.Files.UpdateFromUser(file_path, id, user, app.generateUniqueString())
+	file_data, err := app.models.Files.GetFromCode(code)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.getTransferFromCode(w, r, code)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// check if path exists
-	if _, err := os.Stat(file_path); err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !app.checkFileAccess(w, r, file_data) || !app.checkFilePassword(w, r, file_data) {
+		return
+	}
+
+	app.serveFile(w, r, file_data, true)
+}
+
+// serveFile sends the content of a file whose password, if any, has been checked and registers the download.
+// cache lets caches keep the download under the rules of setCacheHeaders.
+func (app *application) serveFile(w http.ResponseWriter, r *http.Request, file_data *models.File, cache bool) {
+	passphrase := r.Header.Get("X-File-Passphrase")
+	if passphrase == "" {
+		passphrase = r.URL.Query().Get("passphrase")
+	}
+
+	// answered before the file is opened, a client which has the file already has not downloaded it again
+	if etagMatches(r, fileETag(file_data)) {
+		if cache {
+			app.setCacheHeaders(w, file_data)
+		}
+		w.Header().Set("ETag", fileETag(file_data))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	download := &models.Download{
+		IP:        truncatedIP(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+	}
+	if resumesDownload(r, file_data) {
+		download = nil
+	}
+
+	// the API stays in charge of who downloads what, the CDN only sends the bytes
+	if app.servedByCDN(file_data) {
+		target, err := app.cdnDownload(r.Context(), r, file_data, download)
+		if err != nil {
+			app.downloadErrorResponse(w, r, err)
+			return
+		}
+
+		app.contextGetLogger(r).Info("file download handed to cdn", "file_id", file_data.ID)
+
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, target, http.StatusFound)
 		return
 	}
 
-	err = app.createFile(file, file_path)
+	file, err := app.openDownload(r.Context(), file_data, passphrase, download)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.downloadErrorResponse(w, r, err)
 		return
 	}
+	defer file.Close()
+
+	setFileHeaders(w, r, file_data)
+	if cache {
+		app.setCacheHeaders(w, file_data)
+	}
 
-	// delete file after expiry or server shutdown
-	// exceptions for manual deleting
-	timer := time.NewTimer(2 * time.Minute)
-	cancel := make(chan os.Signal, 1)
-	signal.Notify(cancel, syscall.SIGINT, syscall.SIGTERM)
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	start_time := time.Now()
 
-	app.background(func() {
-		select {
-		case <-timer.C:
-			err := app.deleteFileInBackground(file_path, id)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
+	// handles Range, If-Range and the other conditional headers so interrupted downloads can resume
+	http.ServeContent(ww, r, file_data.Name, file_data.LastUpdated, file)
+
+	app.logDownload(r, file_data, ww, start_time)
+}
+
+// resumesDownload reports whether r only asks for ranges of file which leave out its first byte, as clients
+// resuming an interrupted download do. Every other request counts as a download: those without a Range header,
+// those whose ranges include the first byte or add up to the whole file, and those http.ServeContent answers
+// with the whole file, for an If-Range which does not match or a header it cannot parse.
+func resumesDownload(r *http.Request, file *models.File) bool {
+	ranges, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		return false
+	}
+
+	ifRange := r.Header.Get("If-Range")
+	if ifRange != "" && ifRange != fileETag(file) {
+		return false
+	}
+
+	var sent int64
+	for _, ra := range strings.Split(ranges, ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return false
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var start, end int64
+		if first == "" {
+			// a suffix range like -500 asks for the last bytes of the file, all of them if it is shorter
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n <= 0 {
+				return false
+			}
+			start, end = max(file.Size-n, 0), file.Size-1
+		} else {
+			var err error
+			start, err = strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return false
 			}
-		case <-cancel:
-			err := app.deleteFileInBackground(file_path, id)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
+
+			end = file.Size - 1
+			if last != "" {
+				n, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || n < start {
+					return false
+				}
+				end = min(n, end)
 			}
 		}
-	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": updated_file}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		if start >= file.Size {
+			continue
+		}
+		if start == 0 {
+			return false
+		}
+		sent += end - start + 1
 	}
+
+	return sent > 0 && sent < file.Size
 }
 
-func (app *application) deleteUserFileHandler(w http.ResponseWriter, r *http.Request) {
-	id_str := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(id_str, 10, 64)
-	if err != nil || id < 1 {
+// downloadErrorResponse answers a download which openDownload or cdnDownload refused
+func (app *application) downloadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errScanPending):
+		app.fileScanPendingResponse(w, r)
+	case errors.Is(err, errQuarantined):
+		app.fileQuarantinedResponse(w, r)
+	case errors.Is(err, errPassphraseRequired):
+		app.filePassphraseRequiredResponse(w, r)
+	case errors.Is(err, encryption.ErrInvalidPassphrase):
+		app.invalidFilePassphraseResponse(w, r)
+	case errors.Is(err, models.ErrRecordNotFound):
 		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logDownload records a download of file which has been answered through ww, responses
+// to conditional requests without content are no downloads
+func (app *application) logDownload(r *http.Request, file *models.File, ww middleware.WrapResponseWriter, start_time time.Time) {
+	if ww.Status() != http.StatusOK && ww.Status() != http.StatusPartialContent {
 		return
 	}
 
-	user := app.contextGetUser(r)
+	app.contextGetLogger(r).Info("file downloaded",
+		"file_id", file.ID,
+		"status", ww.Status(),
+		"bytes", ww.BytesWritten(),
+		"µs", time.Since(start_time).Microseconds(),
+	)
+}
 
-	path, err := app.models.Files.DeleteFromUser(id, user)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+// notifyFirstDownload emails the owner of file if they opted in for the file or for their account
+func (app *application) notifyFirstDownload(file *models.File) {
+	if file.UserID == nil {
+		return
 	}
-	err = os.Remove(path)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+
+	app.background(app.logger, func() {
+		user, err := app.models.Users.Get(*file.UserID)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				app.logger.Error(err.Error())
+			}
+			return
+		}
+
+		if !file.NotifyOnDownload && !user.NotifyOnDownload {
+			return
+		}
+
+		data := map[string]interface{}{
+			"fileName":     file.Name,
+			"fileCode":     file.Code,
+			"downloadedAt": time.Now().UTC().Format(time.RFC1123),
+		}
+
+		err = app.mailer.Send(user.Email, "file_downloaded.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// fileETag changes whenever the content of the file is replaced. It is the SHA-256 of the content, which
+// stays the same when the same content is uploaded again, files stored without one fall back to when
+// their content was last replaced.
+func fileETag(file *models.File) string {
+	if file.ChecksumSHA256 != "" {
+		return `"` + file.ChecksumSHA256 + `"`
+	}
+	return fmt.Sprintf("\"%d-%d-%d\"", file.ID, file.LastUpdated.Unix(), file.Size)
+}
+
+// setCacheHeaders lets browsers and CDNs keep a download of /files/{code} for -download-cache-max-age,
+// but never past the expiry of the file. Caches hand out files without asking the server, so files which
+// need its checks are never cached: those with a password, passphrase or download limit, and those only
+// allowed from some networks or countries.
+func (app *application) setCacheHeaders(w http.ResponseWriter, file *models.File) {
+	if app.config.downloads.cacheMaxAge == 0 {
 		return
 	}
-	err = app.deleteEmptyFolder(filepath.Dir(path))
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+
+	maxAge := min(app.config.downloads.cacheMaxAge, time.Until(file.Expiry)).Truncate(time.Second)
+
+	public := !file.PasswordProtected && !file.PassphraseProtected && file.MaxDownloads == nil && !app.downloadRestricted(file)
+
+	if !public || maxAge < time.Second {
+		w.Header().Set("Cache-Control", "private, no-store")
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "file successfully deleted"}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second)))
+	w.Header().Set("Expires", time.Now().Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+// downloadRestricted reports whether downloads of file are only allowed from some networks or countries
+func (app *application) downloadRestricted(file *models.File) bool {
+	return len(file.AllowedNetworks) > 0 || len(file.BlockedCountries) > 0 || len(app.config.geoip.blockedCountries) > 0
+}
+
+// setFileHeaders describes the download of a file, GET and HEAD requests for a code share them.
+// With ?inline=true images, PDFs, text, audio and video are shown by the browser instead of saved.
+func setFileHeaders(w http.ResponseWriter, r *http.Request, file *models.File) {
+	w.Header().Set("Content-Disposition", fileDisposition(r, file))
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fileETag(file))
+	w.Header().Set("X-File-Expiry", file.Expiry.UTC().Format(time.RFC3339))
+	if file.ChecksumSHA256 != "" {
+		w.Header().Set("X-Checksum-SHA256", file.ChecksumSHA256)
 	}
 }
 
-func (app *application) getFileFromCodeHandler(w http.ResponseWriter, r *http.Request) {
+// fileDisposition is the Content-Disposition of a download of file, inline if the request asks for it
+func fileDisposition(r *http.Request, file *models.File) string {
+	// pastes are shown unless ?inline=false, their types are limited to pasteLanguages
+	inline := r.URL.Query().Get("inline")
+	disposition := "attachment"
+	if (inline == "true" && canDisplayInline(file.ContentType)) || (file.Paste && inline != "false") {
+		disposition = "inline"
+	}
+
+	// quotes the name, or encodes it as UTF-8 when it is not plain ASCII
+	return mime.FormatMediaType(disposition, map[string]string{"filename": file.Name})
+}
+
+// fileMeta is what anyone holding a code may learn about a file before downloading it
+type fileMeta struct {
+	Name                string    `json:"name"`
+	Folder              string    `json:"folder,omitempty"`
+	Size                int64     `json:"size"`
+	ContentType         string    `json:"content_type"`
+	Expiry              time.Time `json:"expiry"`
+	ChecksumSHA256      string    `json:"checksum_sha256,omitempty"`
+	PasswordProtected   bool      `json:"password_protected"`
+	PassphraseProtected bool      `json:"passphrase_protected"`
+	ScanStatus          string    `json:"scan_status"`
+	HasThumbnail        bool      `json:"has_thumbnail"`
+	Paste               bool      `json:"paste,omitempty"`
+}
+
+func newFileMeta(file *models.File) fileMeta {
+	return fileMeta{
+		Name:                file.Name,
+		Folder:              file.Folder,
+		Size:                file.Size,
+		ContentType:         file.ContentType,
+		Expiry:              file.Expiry,
+		ChecksumSHA256:      file.ChecksumSHA256,
+		PasswordProtected:   file.PasswordProtected,
+		PassphraseProtected: file.PassphraseProtected,
+		ScanStatus:          file.ScanStatus,
+		HasThumbnail:        file.HasThumbnail,
+		Paste:               file.Paste,
+	}
+}
+
+// headFileFromCodeHandler sends the headers of a download without its body, it is not counted as a download
+func (app *application) headFileFromCodeHandler(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 
-	file_data, err := app.models.Files.GetFromCode(code)
+	file, err := app.models.Files.GetFromCode(code)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.headTransferFromCode(w, r, code)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	file, err := os.Open(file_data.Path)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !app.checkFileAccess(w, r, file) || !app.checkFilePassword(w, r, file) {
 		return
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	app.setCacheHeaders(w, file)
+	headFile(w, r, file)
+}
+
+// headFile sends the headers of a download of file without its body
+func headFile(w http.ResponseWriter, r *http.Request, file *models.File) {
+	if etagMatches(r, fileETag(file)) {
+		w.Header().Set("ETag", fileETag(file))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setFileHeaders(w, r, file)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Last-Modified", file.LastUpdated.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) getFileMetaFromCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	file, err := app.models.Files.GetFromCode(code)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.getTransferMetaFromCode(w, r, code)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", file_data.Name))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+	if !app.checkFileAccess(w, r, file) || !app.checkFilePassword(w, r, file) {
+		return
+	}
 
-	_, err = io.Copy(w, file)
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": newFileMeta(file)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+func TestGetUserFileHandler(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := insertUser(t, app, "owner@example.com")
+	other := insertUser(t, app, "other@example.com")
+	file := insertFile(t, app, owner, "report.pdf", "CODE1234")
+
+	tests := []struct {
+		name       string
+		user       *models.User
+		id         string
+		wantStatus int
+	}{
+		{"owner", owner, strconv.FormatInt(file.ID, 10), http.StatusOK},
+		{"another user", other, strconv.FormatInt(file.ID, 10), http.StatusNotFound},
+		{"unknown id", owner, "999", http.StatusNotFound},
+		{"invalid id", owner, "abc", http.StatusNotFound},
+		{"negative id", owner, "-1", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/users/files/"+tt.id, nil)
+			r = app.contextSetUser(withURLParam(r, "id", tt.id), tt.user)
+			rr := httptest.NewRecorder()
+
+			app.getUserFileHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				File models.File `json:"file"`
+			}
+			decodeResponse(t, rr, &body)
+
+			if body.File.ID != file.ID || body.File.Name != "report.pdf" || body.File.Code != "CODE1234" {
+				t.Errorf("got file %+v, want %d report.pdf CODE1234", body.File, file.ID)
+			}
+		})
+	}
+}
+
+func TestListUserFilesHandler(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := insertUser(t, app, "owner@example.com")
+	other := insertUser(t, app, "other@example.com")
+	insertFile(t, app, owner, "report.pdf", "CODE0001")
+	insertFile(t, app, owner, "photo.jpg", "CODE0002")
+	insertFile(t, app, owner, "annual report.pdf", "CODE0003")
+	insertFile(t, app, other, "report.pdf", "CODE0004")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCodes  []string
+	}{
+		{"all files", "", http.StatusOK, []string{"CODE0001", "CODE0002", "CODE0003"}},
+		{"by name", "?name=REPORT", http.StatusOK, []string{"CODE0001", "CODE0003"}},
+		{"sorted by name", "?sort=name", http.StatusOK, []string{"CODE0003", "CODE0002", "CODE0001"}},
+		{"second page", "?page=2&page_size=2", http.StatusOK, []string{"CODE0003"}},
+		{"unknown sort", "?sort=path", http.StatusUnprocessableEntity, nil},
+		{"page size too large", "?page_size=1000", http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/users/files"+tt.query, nil)
+			r = app.contextSetUser(r, owner)
+			rr := httptest.NewRecorder()
+
+			app.listUserFilesHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Files []models.File `json:"files"`
+			}
+			decodeResponse(t, rr, &body)
+
+			var codes []string
+			for _, file := range body.Files {
+				codes = append(codes, file.Code)
+			}
+
+			if len(codes) != len(tt.wantCodes) {
+				t.Fatalf("got codes %v, want %v", codes, tt.wantCodes)
+			}
+			for i := range codes {
+				if codes[i] != tt.wantCodes[i] {
+					t.Fatalf("got codes %v, want %v", codes, tt.wantCodes)
+				}
+			}
+		})
+	}
+}
+
+func TestListUserFilesHandlerNotModified(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := insertUser(t, app, "owner@example.com")
+	insertFile(t, app, owner, "report.pdf", "CODE0001")
+
+	list := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/files", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		app.listUserFilesHandler(rr, app.contextSetUser(r, owner))
+		return rr
+	}
+
+	first := list("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+
+	rr := list(etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("got status %d with %d bytes for a matching ETag, want 304 without a body", rr.Code, rr.Body.Len())
+	}
+
+	insertFile(t, app, owner, "photo.jpg", "CODE0002")
+
+	rr = list(etag)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("got status %d and ETag %q after a change, want 200 with a new ETag", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+func TestResumesDownload(t *testing.T) {
+	file := &models.File{ID: 1, Size: 1000, ChecksumSHA256: "abc"}
+
+	tests := []struct {
+		rangeHeader string
+		ifRange     string
+		want        bool
+	}{
+		{"", "", false},
+		{"bytes=0-", "", false},
+		{"bytes=0-99", "", false},
+		{"bytes=0-0,500-", "", false},
+		{"bytes=100-", "", true},
+		{"bytes= 100-199", "", true},
+		{"bytes=100-199,500-599", "", true},
+		{"bytes=500-99999", "", true},
+		{"bytes=-500", "", true},
+		{"bytes=100-,0-99", "", false},
+		{"bytes=-1000", "", false},
+		{"bytes=-5000", "", false},
+		{"bytes=1-600,500-", "", false},
+		{"bytes=1-,1-", "", false},
+		{"bytes=1000-", "", false},
+		{"bytes=-0", "", false},
+		{"bytes=", "", false},
+		{"bytes=100-", `"abc"`, true},
+		{"bytes=100-", `"old"`, false},
+		{"bytes=100-", "Mon, 02 Jan 2006 15:04:05 GMT", false},
+		{"items=100-", "", false},
+		{"bytes=abc", "", false},
+		{"bytes=100-,x", "", false},
+		{"bytes=200-100", "", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/files/abc", nil)
+		if tt.rangeHeader != "" {
+			r.Header.Set("Range", tt.rangeHeader)
+		}
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+
+		if got := resumesDownload(r, file); got != tt.want {
+			t.Errorf("resumesDownload with Range %q and If-Range %q = %t, want %t", tt.rangeHeader, tt.ifRange, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateUserFileHandlerLegalHold(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.files.defaultExpiry = time.Hour
+	app.config.files.maxExpiry = 24 * time.Hour
+
+	owner := insertUser(t, app, "owner@example.com")
+	file := insertFile(t, app, owner, "report.pdf", "CODE1234")
+
+	_, err := app.models.Files.SetLegalHold(file.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		filename   string
+		wantStatus int
+	}{
+		{"held file", "report.pdf", http.StatusConflict},
+		{"another name", "other.pdf", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			part, err := mw.CreateFormFile("file", tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte("new content"))
+			mw.Close()
+
+			id := strconv.FormatInt(file.ID, 10)
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/files/"+id, &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			r = app.contextSetUser(withURLParam(r, "id", id), owner)
+			rr := httptest.NewRecorder()
+
+			app.updateUserFileHandler(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+
+	held, err := app.models.Files.GetFromUser(file.ID, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Version != file.Version || held.Code != file.Code {
+		t.Errorf("got version %d and code %q, want the held file unchanged at %d and %q", held.Version, held.Code, file.Version, file.Code)
+	}
+}
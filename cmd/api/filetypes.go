@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// fileTypeError rejects content whose type or extension is not allowed by the file type lists
+type fileTypeError struct {
+	reason string
+}
+
+func (e *fileTypeError) Error() string {
+	return e.reason
+}
+
+// executableSignatures are magic bytes http.DetectContentType reports as plain binary data or text,
+// they are checked first so a renamed executable cannot pass for the type of its extension
+var executableSignatures = []struct {
+	prefix      []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+func sniffExecutable(head []byte) string {
+	for _, signature := range executableSignatures {
+		if bytes.HasPrefix(head, signature.prefix) {
+			return signature.contentType
+		}
+	}
+
+	return ""
+}
+
+// matchesContentType reports whether contentType is one of patterns, which may end in /* to match a whole type
+func matchesContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+		if mediaType == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkFileType applies -file-allowed-types, -file-blocked-types, -file-allowed-extensions and
+// -file-blocked-extensions to the detected content type and the extension of name
+func (app *application) checkFileType(name, contentType string) error {
+	lists := app.config.files
+	extension := strings.ToLower(filepath.Ext(name))
+
+	if matchesContentType(contentType, lists.blockedTypes) || (len(lists.allowedTypes) > 0 && !matchesContentType(contentType, lists.allowedTypes)) {
+		return &fileTypeError{reason: fmt.Sprintf("content type %s is not allowed", contentType)}
+	}
+
+	for _, blocked := range lists.blockedExtensions {
+		if extension == blocked {
+			return &fileTypeError{reason: fmt.Sprintf("extension %s is not allowed", extension)}
+		}
+	}
+
+	if len(lists.allowedExtensions) > 0 {
+		for _, allowed := range lists.allowedExtensions {
+			if extension == allowed {
+				return nil
+			}
+		}
+		if extension == "" {
+			return &fileTypeError{reason: "files without an extension are not allowed"}
+		}
+		return &fileTypeError{reason: fmt.Sprintf("extension %s is not allowed", extension)}
+	}
+
+	return nil
+}
+
+// parseExtensions reads a space separated list of extensions, with or without the leading dot
+func parseExtensions(val string) []string {
+	extensions := []string{}
+
+	for _, extension := range strings.Fields(strings.ToLower(val)) {
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		extensions = append(extensions, extension)
+	}
+
+	return extensions
+}
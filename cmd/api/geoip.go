@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// blockedCountry returns the country of ip and whether downloads of file are refused there, by
+// -geoip-blocked-countries or the blocked countries of the file. Without -geoip-database nothing is
+// blocked, and neither are addresses the database does not know.
+func (app *application) blockedCountry(r *http.Request, ip net.IP, file *models.File) (string, bool) {
+	if app.geoip == nil || len(app.config.geoip.blockedCountries) == 0 && len(file.BlockedCountries) == 0 {
+		return "", false
+	}
+
+	country, err := app.geoip.Country(ip)
+	if err != nil {
+		app.logError(r, err)
+		return "", false
+	}
+	if country == "" {
+		return "", false
+	}
+
+	return country, slices.Contains(app.config.geoip.blockedCountries, country) || slices.Contains(file.BlockedCountries, country)
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/abuse"
+	"github.com/Li-Elias/File-Transfer/internal/encryption"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/rpc"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcChunkSize is the size of the content messages of a download, well below the 4 MB message limit
+const grpcChunkSize = 64 * 1024
+
+// grpcServer implements rpc.FileTransferServer on top of the same operations as the REST handlers
+type grpcServer struct {
+	rpc.UnimplementedFileTransferServer
+	app *application
+}
+
+// newGRPCServer returns the gRPC server, which serves TLS with tlsConfig like the API server unless it is nil
+func (app *application) newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(app.grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(app.grpcStreamInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	srv := grpc.NewServer(opts...)
+
+	rpc.RegisterFileTransferServer(srv, &grpcServer{app: app})
+
+	return srv
+}
+
+// grpcAuthenticate adds the user of the authorization metadata to ctx like the authenticate
+// middleware does, calls without it are made by the anonymous user
+func (app *application) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return context.WithValue(ctx, userContextKey, models.AnonymousUser), nil
+	}
+
+	headerParts := strings.Split(values[0], " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, status.Error(codes.Unauthenticated, errInvalidToken.Error())
+	}
+
+	user, apiKey, err := app.authenticateToken(headerParts[1])
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidToken), errors.Is(err, errSuspendedAccount):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		default:
+			return nil, err
+		}
+	}
+
+	ctx = context.WithValue(ctx, userContextKey, user)
+	if apiKey != nil {
+		ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+	}
+
+	return ctx, nil
+}
+
+// grpcStatus passes status errors on and logs everything else as an internal error
+func (app *application) grpcStatus(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	app.logger.Error(err.Error(), "method", method)
+
+	return status.Error(codes.Internal, "the server encountered a problem and could not process your request")
+}
+
+func (app *application) grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = app.grpcStatus(info.FullMethod, fmt.Errorf("%s", p))
+		}
+	}()
+
+	ctx, err = app.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, app.grpcStatus(info.FullMethod, err)
+	}
+
+	err = app.grpcAudit(ctx, info.FullMethod, func(ctx context.Context) error {
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, app.grpcStatus(info.FullMethod, err)
+}
+
+// authenticatedStream replaces the context of a stream with the one carrying the user
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcStreamInterceptor also counts and limits the streams like trackTransfer and limitTransfers, they all move file content
+func (app *application) grpcStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = app.grpcStatus(info.FullMethod, fmt.Errorf("%s", p))
+		}
+	}()
+
+	if app.shutdown.Err() != nil {
+		return status.Error(codes.Unavailable, "the server is shutting down, please try again shortly")
+	}
+
+	app.transfers.Add(1)
+	defer app.transfers.Done()
+
+	ctx, err := app.grpcAuthenticate(ss.Context())
+	if err != nil {
+		return app.grpcStatus(info.FullMethod, err)
+	}
+
+	if app.transferLimiter != nil {
+		remoteAddr := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteAddr = p.Addr.String()
+		}
+
+		key := userOrAddrKey(ctx.Value(userContextKey).(*models.User), remoteAddr)
+		if !app.transferLimiter.acquire(key) {
+			return status.Error(codes.ResourceExhausted, "too many uploads and downloads in progress, please wait for one to finish")
+		}
+		defer app.transferLimiter.release(key)
+	}
+
+	err = app.grpcAudit(ctx, info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+	})
+	return app.grpcStatus(info.FullMethod, err)
+}
+
+// grpcAuditedMethods are the methods which change something, their calls are recorded in the audit log
+var grpcAuditedMethods = map[string]bool{
+	rpc.FileTransfer_Upload_FullMethodName:     true,
+	rpc.FileTransfer_DeleteFile_FullMethodName: true,
+}
+
+// grpcAudit runs call and records it in the audit log like the audit middleware records requests, calls
+// which found nothing are left out
+func (app *application) grpcAudit(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	if !grpcAuditedMethods[method] {
+		return call(ctx)
+	}
+
+	record := &auditRecord{}
+	if user := ctx.Value(userContextKey).(*models.User); !user.IsAnonymous() {
+		record.actorID = &user.ID
+	}
+
+	err := call(context.WithValue(ctx, auditContextKey, record))
+	if status.Code(err) == codes.NotFound {
+		return err
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	event := &models.AuditEvent{
+		ActorID: record.actorID,
+		Action:  "grpc " + method,
+		Objects: record.objects,
+		Success: err == nil,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		event.IP = remoteHost(p.Addr.String())
+	}
+
+	app.recordAudit(event)
+	return err
+}
+
+// grpcRequireUser is requirePermission for gRPC calls
+func (app *application) grpcRequireUser(ctx context.Context, permission string) (*models.User, error) {
+	user := ctx.Value(userContextKey).(*models.User)
+
+	if user.IsAnonymous() {
+		return nil, status.Error(codes.Unauthenticated, "you must be authenticated to access this resource")
+	}
+
+	if !user.Activated {
+		return nil, status.Error(codes.PermissionDenied, "your user account must be activated to access this resource")
+	}
+
+	apiKey, _ := ctx.Value(apiKeyContextKey).(*models.APIKey)
+	if !app.permitted(user, apiKey, permission) {
+		return nil, status.Error(codes.PermissionDenied, "your user account doesn't have the necessary permissions to access this resource")
+	}
+
+	return user, nil
+}
+
+func grpcValidationError(errs map[string]string) error {
+	return status.Error(codes.InvalidArgument, joinValidationErrors(errs))
+}
+
+func toRPCFile(file *models.File) *rpc.File {
+	f := &rpc.File{
+		Id:                  file.ID,
+		Name:                file.Name,
+		Description:         file.Description,
+		Size:                file.Size,
+		Code:                file.Code,
+		Expiry:              timestamppb.New(file.Expiry),
+		CreatedAt:           timestamppb.New(file.CreatedAt),
+		ContentType:         file.ContentType,
+		ChecksumSha256:      file.ChecksumSHA256,
+		Tags:                file.Tags,
+		PasswordProtected:   file.PasswordProtected,
+		PassphraseProtected: file.PassphraseProtected,
+		DownloadCount:       int32(file.DownloadCount),
+		ScanStatus:          file.ScanStatus,
+	}
+
+	if file.MaxDownloads != nil {
+		maxDownloads := int32(*file.MaxDownloads)
+		f.MaxDownloads = &maxDownloads
+	}
+
+	return f
+}
+
+// uploadStream reads the content messages following the metadata of an upload
+type uploadStream struct {
+	stream rpc.FileTransfer_UploadServer
+	chunk  []byte
+}
+
+func (u *uploadStream) Read(p []byte) (int, error) {
+	for len(u.chunk) == 0 {
+		req, err := u.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+
+		chunk, ok := req.Data.(*rpc.UploadRequest_Chunk)
+		if !ok {
+			return 0, status.Error(codes.InvalidArgument, "only the first message may carry metadata")
+		}
+		u.chunk = chunk.Chunk
+	}
+
+	n := copy(p, u.chunk)
+	u.chunk = u.chunk[n:]
+
+	return n, nil
+}
+
+func (s *grpcServer) Upload(stream rpc.FileTransfer_UploadServer) error {
+	app := s.app
+
+	user, err := app.grpcRequireUser(stream.Context(), models.PermissionFilesWrite)
+	if err != nil {
+		return err
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	input := req.GetMetadata()
+	if input == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the metadata")
+	}
+
+	// the same validation as a multipart upload, which reads these values from the form
+	values := url.Values{
+		"password":        {input.Password},
+		"passphrase":      {input.Passphrase},
+		"checksum_sha256": {input.ChecksumSha256},
+	}
+	if input.ExpiresIn != nil {
+		values.Set("expires_in", input.ExpiresIn.AsDuration().String())
+	}
+
+	v := validator.New()
+
+	ttl := app.readExpiresIn(values, v)
+	new_file := app.newFile(user, input.Name, 0, ttl)
+	new_file.Description = input.Description
+	new_file.NotifyOnDownload = input.NotifyOnDownload
+	new_file.Tags = models.NormalizeTags(input.Tags)
+	if input.MaxDownloads != nil {
+		maxDownloads := int(*input.MaxDownloads)
+		new_file.MaxDownloads = &maxDownloads
+	}
+
+	err = app.readFilePassword(values, new_file, v)
+	if err != nil {
+		return err
+	}
+
+	opts := storeOptions{
+		passphrase: app.readFilePassphrase(values, v),
+		checksum:   app.readFileChecksum(values, v),
+		ctx:        stream.Context(),
+	}
+
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		return grpcValidationError(v.Errors)
+	}
+
+	err = app.checkDiskSpace(app.logger, -1)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	// a MaxBytesReader without a ResponseWriter only limits the body
+	body := http.MaxBytesReader(nil, io.NopCloser(&uploadStream{stream: stream}), app.config.files.maxSize)
+
+	err = app.createFile(new_file, body, -1, opts)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var typeErr *fileTypeError
+		var planErr *planLimitError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			return status.Errorf(codes.InvalidArgument, "file_size: must not be more than %d bytes big", app.config.files.maxSize)
+		case errors.As(err, &planErr):
+			return status.Error(codes.InvalidArgument, planErr.Error())
+		case errors.Is(err, errChecksumMismatch):
+			return status.Error(codes.InvalidArgument, "checksum_sha256: does not match the uploaded content")
+		case errors.As(err, &typeErr):
+			return status.Errorf(codes.InvalidArgument, "file: %s", typeErr.Error())
+		case isOutOfSpace(err):
+			app.logger.Warn(err.Error())
+			return status.Error(codes.ResourceExhausted, errInsufficientStorage.Error())
+		default:
+			return err
+		}
+	}
+
+	return stream.SendAndClose(toRPCFile(new_file))
+}
+
+// guardGRPCLookup applies guardLookup to downloads over gRPC, a challenge solution is sent in the
+// x-challenge-response metadata
+func (app *application) guardGRPCLookup(ctx context.Context, addr, code string) error {
+	if app.lookups == nil {
+		return nil
+	}
+
+	lookup, err := app.lookups.Admit(ctx, addr, code, 0)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case lookup.Locked:
+		abuseMetrics.Add("locked_out", 1)
+		return status.Error(codes.PermissionDenied, "your address has been locked out for looking up too many unknown codes")
+	case lookup.RetryAfter > 0:
+		abuseMetrics.Add("slowed_down", 1)
+		return status.Errorf(codes.ResourceExhausted, "too many unknown codes were looked up from your address, please try again in %s", lookup.RetryAfter.Round(time.Second))
+	}
+
+	if lookup.Suspicious && app.challenger != nil {
+		solution := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			solution = strings.Join(md.Get("x-challenge-response"), "")
+		}
+
+		err := app.challenger.Verify(ctx, solution, addr)
+		if err != nil {
+			if errors.Is(err, abuse.ErrChallengeFailed) {
+				abuseMetrics.Add("challenges_failed", 1)
+				return status.Error(codes.FailedPrecondition, "too many unknown codes were looked up from your address, solve a challenge from GET /challenge and send the solution in the x-challenge-response metadata")
+			}
+			return err
+		}
+		abuseMetrics.Add("challenges_passed", 1)
+	}
+
+	return nil
+}
+
+// Download only serves single files, the zip archives of transfers are left to the REST API
+func (s *grpcServer) Download(req *rpc.DownloadRequest, stream rpc.FileTransfer_DownloadServer) error {
+	app := s.app
+
+	addr := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = remoteHost(p.Addr.String())
+	}
+
+	err := app.guardGRPCLookup(stream.Context(), addr, req.Code)
+	if err != nil {
+		return err
+	}
+
+	file_data, err := app.models.Files.GetFromCode(req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			if app.lookups != nil {
+				app.failLookup(context.WithoutCancel(stream.Context()), app.logger, addr)
+			}
+			return status.Error(codes.NotFound, "the requested resource could not be found")
+		default:
+			return err
+		}
+	}
+
+	err = app.verifyFilePassword(file_data, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPasswordRequired), errors.Is(err, errInvalidPassword):
+			return status.Error(codes.PermissionDenied, err.Error())
+		default:
+			return err
+		}
+	}
+
+	download := &models.Download{}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		download.IP = truncatedIP(p.Addr.String())
+	}
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		download.UserAgent = strings.Join(md.Get("user-agent"), " ")
+	}
+
+	content, err := app.openDownload(stream.Context(), file_data, req.Passphrase, download)
+	if err != nil {
+		switch {
+		case errors.Is(err, errScanPending):
+			return status.Error(codes.Unavailable, err.Error())
+		case errors.Is(err, errQuarantined):
+			return status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, errPassphraseRequired):
+			return status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, encryption.ErrInvalidPassphrase):
+			return status.Error(codes.PermissionDenied, "the provided passphrase is incorrect")
+		case errors.Is(err, models.ErrRecordNotFound):
+			return status.Error(codes.NotFound, "the requested resource could not be found")
+		default:
+			return err
+		}
+	}
+	defer content.Close()
+
+	err = stream.Send(&rpc.DownloadResponse{Data: &rpc.DownloadResponse_File{File: toRPCFile(file_data)}})
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = content
+
+	buckets, release := app.downloadBuckets(userOrAddrKey(stream.Context().Value(userContextKey).(*models.User), addr))
+	defer release()
+	if len(buckets) > 0 {
+		reader = &throttledReader{Reader: content, ctx: stream.Context(), buckets: buckets}
+	}
+
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			sendErr := stream.Send(&rpc.DownloadResponse{Data: &rpc.DownloadResponse_Chunk{Chunk: buf[:n]}})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *grpcServer) ListFiles(ctx context.Context, req *rpc.ListFilesRequest) (*rpc.ListFilesResponse, error) {
+	app := s.app
+
+	user, err := app.grpcRequireUser(ctx, models.PermissionFilesRead)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := models.Filters{
+		Page:         int(req.Page),
+		PageSize:     int(req.PageSize),
+		Sort:         req.Sort,
+		SortSafelist: []string{"id", "name", "size", "expiry", "created_at", "-id", "-name", "-size", "-expiry", "-created_at"},
+	}
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.PageSize == 0 {
+		filters.PageSize = 20
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+
+	v := validator.New()
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		return nil, grpcValidationError(v.Errors)
+	}
+
+	tags := models.NormalizeTags(req.Tags)
+	if tags == nil {
+		tags = []string{}
+	}
+
+	files, metadata, err := app.models.Files.GetAllFromUser(user, req.Name, tags, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &rpc.ListFilesResponse{
+		Files: make([]*rpc.File, len(files)),
+		Metadata: &rpc.Metadata{
+			CurrentPage:  int32(metadata.CurrentPage),
+			PageSize:     int32(metadata.PageSize),
+			FirstPage:    int32(metadata.FirstPage),
+			LastPage:     int32(metadata.LastPage),
+			TotalRecords: int32(metadata.TotalRecords),
+		},
+	}
+	for i, file := range files {
+		res.Files[i] = toRPCFile(file)
+	}
+
+	return res, nil
+}
+
+func (s *grpcServer) DeleteFile(ctx context.Context, req *rpc.DeleteFileRequest) (*rpc.DeleteFileResponse, error) {
+	app := s.app
+
+	user, err := app.grpcRequireUser(ctx, models.PermissionFilesWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	auditObject(ctx, "file", req.Id)
+
+	trashed, err := app.deleteUserFile(req.Id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, status.Error(codes.NotFound, "the requested resource could not be found")
+		case errors.Is(err, errLegalHold):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		default:
+			return nil, err
+		}
+	}
+
+	res := &rpc.DeleteFileResponse{}
+	if trashed {
+		res.RestoreUntil = timestamppb.New(time.Now().Add(app.config.trash.retention))
+	}
+
+	return res, nil
+}
+
+// serveGRPC runs the gRPC server on -grpc-port until stop is called, which waits for
+// running calls until ctx is done. It uses the certificates of the API server if it serves TLS.
+func (app *application) serveGRPC(tlsConfig *tls.Config) (stop func(ctx context.Context), err error) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.grpc.port))
+	if err != nil {
+		return nil, err
+	}
+
+	srv := app.newGRPCServer(tlsConfig)
+
+	go func() {
+		err := srv.Serve(lis)
+		if err != nil {
+			app.logger.Error(err.Error(), "addr", lis.Addr().String())
+		}
+	}()
+
+	app.logger.Info("starting grpc server", "addr", lis.Addr().String())
+
+	stop = func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			srv.Stop()
+		}
+	}
+
+	return stop, nil
+}
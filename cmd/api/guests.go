@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// guestUploadHandler stores a file uploaded without an account, it is only routed with -guest-uploads.
+// Guest files have no owner, so they cannot be changed or deleted and simply expire. They are limited
+// to -guest-max-file-size and -guest-max-expiry and guests to -rate-limit-guest-uploads per IP address.
+func (app *application) guestUploadHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.guests.maxSize+1_048_576)
+
+	part, values, err := app.readMultipartFile(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer part.Close()
+
+	v := validator.New()
+
+	ttl := app.readDuration(values, "expires_in", min(app.config.files.defaultExpiry, app.config.guests.maxExpiry), v)
+	v.Check(ttl > 0, "expires_in", "must be a positive duration")
+	v.Check(ttl <= app.config.guests.maxExpiry, "expires_in", fmt.Sprintf("must not be more than %s", app.config.guests.maxExpiry))
+
+	new_file := app.newFile(models.AnonymousUser, part.FileName(), 0, ttl)
+
+	if values.Has("max_downloads") {
+		maxDownloads := app.readInt(values, "max_downloads", 0, v)
+		new_file.MaxDownloads = &maxDownloads
+	}
+
+	err = app.readFilePassword(values, new_file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	opts := storeOptions{
+		passphrase:    app.readFilePassphrase(values, v),
+		checksum:      app.readFileChecksum(values, v),
+		stripMetadata: app.readStripMetadata(values, v),
+		logger:        app.contextGetLogger(r).With("guest", true),
+		ctx:           r.Context(),
+	}
+
+	if models.ValidateFile(v, new_file, app.config.guests.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.createFile(new_file, http.MaxBytesReader(w, part, app.config.guests.maxSize), -1, opts)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			v.AddError("file_size", fmt.Sprintf("must not be more than %d bytes big", app.config.guests.maxSize))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.storeFilePartErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": new_file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/storage"
 )
 
+// healthcheckHandler reports liveness, it does not look at any dependency so a failing database
+// does not get the server restarted
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
@@ -17,3 +26,77 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessCheck is the result of checking one dependency
+type readinessCheck struct {
+	Status    string  `json:"status"`
+	FreeBytes *uint64 `json:"free_bytes,omitempty"`
+}
+
+// readyzHandler reports whether the server can take traffic: the database answers, the storage backend
+// accepts writes and, for backends on a disk, at least -storage-min-free-space bytes are left.
+// Failures are logged, the response only names the dependency since it is not authenticated.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]*readinessCheck{}
+	ready := true
+
+	check := func(name string, err error) *readinessCheck {
+		result := &readinessCheck{Status: "ok"}
+		if err != nil {
+			app.contextGetLogger(r).Error(err.Error(), "check", name)
+			result.Status = "failed"
+			ready = false
+		}
+		checks[name] = result
+		return result
+	}
+
+	check("database", app.checkDatabase(r.Context()))
+	check("storage", app.checkStorageWrite())
+
+	if spacer, ok := app.storage.(storage.FreeSpacer); ok {
+		free, err := spacer.FreeSpace()
+		if err == nil && free < uint64(app.config.disk.minFree) {
+			err = fmt.Errorf("%d bytes free, less than the minimum of %d", free, app.config.disk.minFree)
+		}
+
+		if !errors.Is(err, errors.ErrUnsupported) {
+			result := check("disk", err)
+			if free > 0 {
+				result.FreeBytes = &free
+			}
+		}
+	}
+
+	status := http.StatusOK
+	env := envelope{"status": "ready", "checks": checks}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		env["status"] = "unavailable"
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return app.db.PingContext(ctx)
+}
+
+// checkStorageWrite writes and removes a small object under a key of its own, so probes of
+// several instances sharing a backend do not get in each other's way
+func (app *application) checkStorageWrite() error {
+	key := "readyz/" + newRequestID()
+
+	err := app.storage.Put(key, strings.NewReader("ok"), 2)
+	if err != nil {
+		return err
+	}
+
+	return app.storage.Delete(key)
+}
@@ -5,21 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"log/slog"
 	"mime/multipart"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/codes"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
 )
 
 type envelope map[string]interface{}
 
-var (
-	letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
-)
-
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.Marshal(data)
 	if err != nil {
@@ -90,96 +93,215 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
-func (app *application) background(fn func()) {
-	app.waitgroup.Add(1)
+// readMultipartFile collects the form fields of a multipart body up to the "file" part and returns
+// that part unread, so it can be streamed instead of being buffered in memory or on disk.
+func (app *application) readMultipartFile(w http.ResponseWriter, r *http.Request) (*multipart.Part, url.Values, error) {
+	_, part, values, err := app.readMultipartFiles(w, r)
+	return part, values, err
+}
 
-	go func() {
-		defer func() {
-			defer app.waitgroup.Done()
+// readMultipartFiles is readMultipartFile which also returns the reader for the parts after the first file part
+func (app *application) readMultipartFiles(w http.ResponseWriter, r *http.Request) (*multipart.Reader, *multipart.Part, url.Values, error) {
+	// the file part itself is streamed into storage, so the span only covers the fields before it
+	_, span := app.tracer.Start(r.Context(), "readMultipartFile", tracing.KindInternal)
+	defer span.End()
 
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.files.maxSize+1_048_576)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	values := url.Values{}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil, nil, errors.New("body must contain a file part")
 			}
-		}()
+			return nil, nil, nil, err
+		}
 
-		fn()
-	}()
+		if part.FormName() == "file" {
+			if part.FileName() == "" {
+				return nil, nil, nil, errors.New("file part must have a filename")
+			}
+			return reader, part, values, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 4096))
+		part.Close()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		values.Add(part.FormName(), string(value))
+	}
 }
 
-func (app *application) isFolderEmpty(dirPath string) (bool, error) {
-	entries, err := os.ReadDir(dirPath)
+// nextMultipartFile returns the next file part after the first one, or nil at the end of the body.
+// Form fields after the first file part are rejected since they would only apply to some files.
+func nextMultipartFile(reader *multipart.Reader) (*multipart.Part, error) {
+	part, err := reader.NextPart()
 	if err != nil {
-		return false, err
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	return len(entries) == 0, nil
+	if part.FormName() != "file" {
+		part.Close()
+		return nil, errors.New("form fields must be sent before the first file part")
+	}
+	if part.FileName() == "" {
+		part.Close()
+		return nil, errors.New("file part must have a filename")
+	}
+
+	return part, nil
 }
 
-func (app *application) deleteEmptyFolder(dirPath string) error {
-	isEmpty, err := app.isFolderEmpty(dirPath)
-	if err != nil {
-		return err
+func (app *application) readString(values url.Values, key string, defaultValue string) string {
+	s := values.Get(key)
+
+	if s == "" {
+		return defaultValue
 	}
 
-	if isEmpty {
-		err := os.Remove(dirPath)
-		if err != nil {
-			return err
+	return s
+}
+
+// readCSV reads a comma separated list, the key may also be repeated
+func (app *application) readCSV(values url.Values, key string, defaultValue []string) []string {
+	list := []string{}
+
+	for _, s := range values[key] {
+		if s == "" {
+			continue
 		}
+		list = append(list, strings.Split(s, ",")...)
 	}
 
-	return nil
+	if len(list) == 0 {
+		return defaultValue
+	}
+
+	return list
 }
 
-func (app *application) createFile(file multipart.File, file_path string) error {
-	folder_path := filepath.Dir(file_path)
+func (app *application) readInt(values url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := values.Get(key)
 
-	err := os.MkdirAll(folder_path, os.ModePerm)
-	if err != nil {
-		return err
+	if s == "" {
+		return defaultValue
 	}
 
-	f, err := os.Create(file_path)
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		return err
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+func (app *application) readBool(values url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := values.Get(key)
+
+	if s == "" {
+		return defaultValue
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, file)
+	b, err := strconv.ParseBool(s)
 	if err != nil {
-		return err
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
 	}
 
-	return nil
+	return b
 }
 
-// exceptions for manual deleting
-func (app *application) deleteFileInBackground(file_path string, file_id int64) error {
-	folder_path := filepath.Dir(file_path)
+func (app *application) readDuration(values url.Values, key string, defaultValue time.Duration, v *validator.Validator) time.Duration {
+	s := values.Get(key)
 
-	err := app.models.Files.Delete(file_id)
-	if err != nil && err.Error() != "record not found" {
-		return err
+	if s == "" {
+		return defaultValue
 	}
-	err = os.Remove(file_path)
-	if err != nil && err.Error() != fmt.Sprintf("remove %s: no such file or directory", file_path) {
-		return err
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		v.AddError(key, "must be a duration such as 30m or 24h")
+		return defaultValue
 	}
-	err = app.deleteEmptyFolder(folder_path)
-	if err != nil && err.Error() != fmt.Sprintf("open %s: no such file or directory", folder_path) {
-		return err
+
+	return d
+}
+
+// truncatedIP returns the client address with the host part zeroed (/24 for IPv4, /48 for IPv6),
+// enough to tell networks apart without storing who exactly downloaded a file
+func truncatedIP(remoteAddr string) string {
+	ip := net.ParseIP(remoteHost(remoteAddr))
+	if ip == nil {
+		return ""
 	}
 
-	return nil
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// joinValidationErrors lists the errors of a validator in a stable order, for transports
+// without a JSON body to put them in
+func joinValidationErrors(errs map[string]string) string {
+	fields := make([]string, 0, len(errs))
+	for field, message := range errs {
+		fields = append(fields, fmt.Sprintf("%s: %s", field, message))
+	}
+	sort.Strings(fields)
+
+	return strings.Join(fields, "; ")
 }
 
-func (app *application) generateUniqueString() string {
-	source := rand.NewSource(time.Now().UnixNano())
-	r := rand.New(source)
+// background runs fn in a goroutine which is waited for on shutdown, a panic is logged with logger.
+// Work started by a request gets the request logger, so what goes wrong later still has its request ID.
+func (app *application) background(logger *slog.Logger, fn func()) {
+	app.waitgroup.Add(1)
+
+	go func() {
+		defer func() {
+			defer app.waitgroup.Done()
+
+			if err := recover(); err != nil {
+				logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
 
-	b := make([]rune, 8)
-	for i := range b {
-		b[i] = letterRunes[r.Intn(len(letterRunes))]
+		fn()
+	}()
+}
+
+// generateCode returns a code for a new file or transfer of user in the style the user picked, or the
+// configured one. The settings are only a preference, if they cannot be read the configured style is used.
+func (app *application) generateCode(user *models.User) string {
+	style := app.config.codes.style
+
+	if !user.IsAnonymous() {
+		settings, err := app.models.UserSettings.Get(user.ID)
+		switch {
+		case err != nil:
+			app.logger.Error(err.Error())
+		case settings.CodeStyle != nil:
+			style = *settings.CodeStyle
+		}
 	}
-	return string(b)
+
+	if style == codes.StyleWords {
+		return codes.Words()
+	}
+
+	return codes.Alphanumeric(app.config.codes.alphabet, app.config.codes.length)
 }
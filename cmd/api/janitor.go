@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+)
+
+// janitor removes expired files until the server shuts down. Because the expiry is read from the
+// database, files that expired while the server was down are cleaned up on the next run. Retention
+// policies are applied first, the files they shorten are deleted in the same run.
+func (app *application) janitor(started time.Time) {
+	err := app.reconcileStorage(started)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+
+	ticker := time.NewTicker(app.config.janitor.interval)
+	defer ticker.Stop()
+
+	for {
+		err := app.applyRetentionPolicies()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		err = app.deleteExpiredFiles()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		if app.config.expiryNotices.before > 0 {
+			err = app.notifyExpiringFiles()
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.shutdown.Done():
+			return
+		}
+	}
+}
+
+// deleteBlob removes the stored content of a file whose row is already gone
+func (app *application) deleteBlob(logger *slog.Logger, file *models.File) {
+	err := app.storage.Delete(file.Path)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		logger.Error(err.Error(), "file_id", file.ID)
+	}
+}
+
+func (app *application) deleteExpiredFiles() error {
+	// pending direct uploads are given up once they can no longer be completed
+	pendingBefore := time.Now().Add(-app.config.presign.expiry - directUploadGrace)
+
+	files, err := app.models.Files.GetAllExpired(pendingBefore)
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+
+	for _, file := range files {
+		// whatever is left is deleted on the next run after a restart
+		if app.shutdown.Err() != nil {
+			break
+		}
+
+		// the row goes first, a blob left behind is picked up by reconcileStorage
+		err := app.models.Files.DeleteExpired(file.ID, pendingBefore)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				app.logger.Error(err.Error(), "file_id", file.ID)
+			}
+			continue
+		}
+
+		app.deleteBlob(app.logger, file)
+
+		if file.Pending {
+			app.deleteDirectUpload(file)
+			continue
+		}
+
+		app.fileEvent(models.EventFileExpired, file)
+
+		deleted++
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired files", "count", deleted)
+	}
+
+	err = app.purgeTrash()
+	if err != nil {
+		return err
+	}
+
+	transfers, err := app.models.Transfers.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if transfers > 0 {
+		app.logger.Info("deleted expired transfers", "count", transfers)
+	}
+
+	uploads, err := app.models.Uploads.GetAllExpired()
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		app.deleteUpload(upload)
+	}
+
+	if len(uploads) > 0 {
+		app.logger.Info("deleted expired uploads", "count", len(uploads))
+	}
+
+	err = app.deleteOldLoginAttempts()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredPasskeyChallenges()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredRevocations()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredEmailChanges()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredOrganizationInvitations()
+	if err != nil {
+		return err
+	}
+
+	err = app.deleteExpiredOwnershipTransfers()
+	if err != nil {
+		return err
+	}
+
+	// failed code lookups are kept for -abuse-window and lockouts until they end
+	if app.lookups != nil {
+		err = app.lookups.Sweep(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileStorage deletes blobs without a files or uploads row and files rows without a blob.
+// Only rows created before started are considered, newer ones may still be uploading.
+func (app *application) reconcileStorage(started time.Time) error {
+	files, err := app.models.Files.GetAll()
+	if err != nil {
+		return err
+	}
+
+	keys, err := app.storage.List()
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		stored[key] = true
+	}
+
+	known := make(map[string]bool, len(files))
+	orphanedRows := 0
+
+	for _, file := range files {
+		known[file.Path] = true
+
+		// pending files have no content until they are completed and are cleaned up by deleteExpiredFiles
+		if file.Pending {
+			known[directUploadKey(file)] = true
+			continue
+		}
+
+		// created_at is stored with second precision
+		if stored[file.Path] || !file.CreatedAt.Before(started.Add(-time.Second)) {
+			continue
+		}
+
+		err := app.models.Files.Delete(file.ID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			return err
+		}
+		orphanedRows++
+	}
+
+	uploads, err := app.models.Uploads.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		// the chunk after the last recorded one may be in the middle of being written
+		for i := 0; i <= upload.Chunks; i++ {
+			known[uploadChunkKey(upload.ID, i)] = true
+		}
+	}
+
+	orphanedBlobs := 0
+
+	for _, key := range keys {
+		if known[key] {
+			continue
+		}
+
+		err := app.storage.Delete(key)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return err
+		}
+		orphanedBlobs++
+	}
+
+	app.logger.Info("reconciled storage",
+		"orphaned_rows", orphanedRows,
+		"orphaned_blobs", orphanedBlobs,
+	)
+
+	return nil
+}
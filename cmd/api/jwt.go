@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/jwt"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// revocationList keeps the revoked sessions in memory, so that JWTs are checked against it without a
+// database round trip. Every instance reloads it every -jwt-revocation-refresh to pick up the sessions
+// revoked by the others.
+type revocationList struct {
+	mu sync.RWMutex
+	// sessions are the expiry of the revocations by session ID
+	sessions map[int64]time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{sessions: map[int64]time.Time{}}
+}
+
+func (l *revocationList) revoked(sessionID int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.sessions[sessionID]
+	return ok
+}
+
+func (l *revocationList) add(sessionIDs []int64, expiry time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range sessionIDs {
+		l.sessions[id] = expiry
+	}
+}
+
+// replace swaps the list for sessions, revocations added since they were loaded are kept
+func (l *revocationList) replace(sessions map[int64]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for id, expiry := range l.sessions {
+		if _, ok := sessions[id]; !ok && expiry.After(now) {
+			sessions[id] = expiry
+		}
+	}
+
+	l.sessions = sessions
+}
+
+func (app *application) loadRevocations() error {
+	sessions, err := app.models.Revocations.GetAllUnexpired()
+	if err != nil {
+		return err
+	}
+
+	app.revocations.replace(sessions)
+
+	return nil
+}
+
+// reloadRevocations reloads the revoked sessions until the server shuts down
+func (app *application) reloadRevocations() {
+	ticker := time.NewTicker(app.config.jwt.revocationRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-app.shutdown.Done():
+			return
+		}
+
+		err := app.loadRevocations()
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+// revokeSessionTokens makes the JWTs of sessions stop working until they expire, the sessions
+// themselves are deleted by the caller. It does nothing unless -auth-token-format is jwt.
+func (app *application) revokeSessionTokens(sessionIDs []int64) error {
+	if app.revocations == nil || len(sessionIDs) == 0 {
+		return nil
+	}
+
+	expiry := time.Now().Add(app.config.sessions.tokenTTL)
+
+	err := app.models.Revocations.Insert(sessionIDs, expiry)
+	if err != nil {
+		return err
+	}
+
+	app.revocations.add(sessionIDs, expiry)
+
+	return nil
+}
+
+// newJWT issues a JWT of session for user, it carries what authenticateJWT needs to know about the user
+func (app *application) newJWT(session *models.Session, user *models.User) (*models.Token, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.sessions.tokenTTL)
+
+	plaintext, err := app.config.jwt.keys.Sign(&jwt.Claims{
+		ID:               id,
+		Subject:          user.ID,
+		SessionID:        session.ID,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        expiry.Unix(),
+		Name:             user.Name,
+		Email:            user.Email,
+		Role:             user.Role,
+		Activated:        user.Activated,
+		NotifyOnDownload: user.NotifyOnDownload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    time.Unix(expiry.Unix(), 0),
+		Scope:     models.ScopeAuthentication,
+		SessionID: &session.ID,
+	}, nil
+}
+
+// verifyJWT returns the claims of a JWT which is neither expired nor revoked
+func (app *application) verifyJWT(token string) (*jwt.Claims, error) {
+	claims, err := app.config.jwt.keys.Verify(token, time.Now())
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if app.revocations.revoked(claims.SessionID) {
+		return nil, errInvalidToken
+	}
+
+	return claims, nil
+}
+
+// authenticateJWT returns the user of a JWT from its claims without looking the user up. Changes to the
+// user apply to the JWTs issued after them, suspending the account revokes its sessions.
+func (app *application) authenticateJWT(token string) (*models.User, error) {
+	claims, err := app.verifyJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.User{
+		ID:               claims.Subject,
+		Name:             claims.Name,
+		Email:            claims.Email,
+		Activated:        claims.Activated,
+		Role:             claims.Role,
+		NotifyOnDownload: claims.NotifyOnDownload,
+	}, nil
+}
+
+// deleteExpiredRevocations removes the revocations of sessions whose JWTs have all expired
+func (app *application) deleteExpiredRevocations() error {
+	deleted, err := app.models.Revocations.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired token revocations", "count", deleted)
+	}
+
+	return nil
+}
+
+// isJWT reports whether token is to be verified as a JWT, which is only done with -auth-token-format jwt
+func (app *application) isJWT(token string) bool {
+	return app.revocations != nil && jwt.IsToken(token)
+}
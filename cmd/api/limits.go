@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// transferLimiter counts the transfers each client has in flight, the counts only cover this process
+type transferLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active map[string]int
+}
+
+func newTransferLimiter(limit int) *transferLimiter {
+	return &transferLimiter{limit: limit, active: make(map[string]int)}
+}
+
+// acquire reports whether key may start another transfer, if so it has to be ended with release
+func (l *transferLimiter) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] >= l.limit {
+		return false
+	}
+	l.active[key]++
+
+	return true
+}
+
+func (l *transferLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active[key]--
+	if l.active[key] <= 0 {
+		delete(l.active, key)
+	}
+}
+
+// limitTransfers turns away uploads and downloads of clients which already have -transfer-concurrency
+// of them in flight. Users are counted over all their sessions and API keys, anonymous downloads by IP address.
+func (app *application) limitTransfers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.transferLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := app.clientKey(r)
+		if !app.transferLimiter.acquire(key) {
+			app.tooManyTransfersResponse(w, r)
+			return
+		}
+		defer app.transferLimiter.release(key)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// linkSignature signs the file id, its current code and the expiry of a link. The code is
+// part of the signature but not of the URL, so regenerating it revokes all links of the file.
+// Links emailed by sendUserFileHandler sign the id of their delivery too, 0 for other links.
+func (app *application) linkSignature(file *models.File, expiry, deliveryID int64) string {
+	mac := hmac.New(sha256.New, app.config.links.signingKey)
+	if deliveryID == 0 {
+		fmt.Fprintf(mac, "%d.%s.%d", file.ID, file.Code, expiry)
+	} else {
+		fmt.Fprintf(mac, "%d.%s.%d.%d", file.ID, file.Code, expiry, deliveryID)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *application) signedFileURL(file *models.File, expiresAt time.Time) string {
+	return app.deliveryFileURL(file, expiresAt, 0)
+}
+
+// deliveryFileURL is signedFileURL for the link of a delivery, downloads through it are recorded
+func (app *application) deliveryFileURL(file *models.File, expiresAt time.Time, deliveryID int64) string {
+	expiry := expiresAt.Unix()
+
+	query := url.Values{
+		"id":  {strconv.FormatInt(file.ID, 10)},
+		"exp": {strconv.FormatInt(expiry, 10)},
+		"sig": {app.linkSignature(file, expiry, deliveryID)},
+	}
+	if deliveryID != 0 {
+		query.Set("delivery", strconv.FormatInt(deliveryID, 10))
+	}
+
+	return app.config.publicURL + "/files/signed?" + query.Encode()
+}
+
+// createUserFileLinkHandler returns a URL which downloads the file until expires_at without its code or password
+func (app *application) createUserFileLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(!input.ExpiresAt.IsZero(), "expires_at", "must be provided")
+	v.Check(input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	v.Check(!input.ExpiresAt.After(file.Expiry), "expires_at", "must not be after the expiry of the file")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	link := envelope{
+		"url":        app.signedFileURL(file, input.ExpiresAt),
+		"expires_at": time.Unix(input.ExpiresAt.Unix(), 0).UTC(),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"link": link}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getFileFromSignedURLHandler serves a file for a link created by createUserFileLinkHandler
+func (app *application) getFileFromSignedURLHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	id, err := strconv.ParseInt(qs.Get("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.invalidSignedURLResponse(w, r)
+		return
+	}
+
+	expiry, err := strconv.ParseInt(qs.Get("exp"), 10, 64)
+	if err != nil {
+		app.invalidSignedURLResponse(w, r)
+		return
+	}
+
+	var deliveryID int64
+	if qs.Has("delivery") {
+		deliveryID, err = strconv.ParseInt(qs.Get("delivery"), 10, 64)
+		if err != nil || deliveryID < 1 {
+			app.invalidSignedURLResponse(w, r)
+			return
+		}
+	}
+
+	file_data, err := app.models.Files.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidSignedURLResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the signature is checked before anything else about the file is revealed
+	if !hmac.Equal([]byte(qs.Get("sig")), []byte(app.linkSignature(file_data, expiry, deliveryID))) {
+		app.invalidSignedURLResponse(w, r)
+		return
+	}
+
+	if time.Now().Unix() >= expiry {
+		app.expiredSignedURLResponse(w, r)
+		return
+	}
+
+	if file_data.DeletedAt != nil || !file_data.Expiry.After(time.Now()) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.checkFileAccess(w, r, file_data) {
+		return
+	}
+
+	if deliveryID != 0 {
+		// the download goes ahead without the record, it is only shown to the owner
+		err = app.models.Deliveries.SetDownloaded(deliveryID, file_data.ID)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	app.serveFile(w, r, file_data, false)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// loginLockout returns how long logins for the email of attempt, or from its address, are still locked.
+// Emails are counted whether they have an account or not, so a lockout does not tell which do.
+func (app *application) loginLockout(attempt *models.LoginAttempt) (time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-app.config.login.lockout)
+
+	var wait time.Duration
+
+	if app.config.login.maxFailures > 0 {
+		count, last, err := app.models.LoginAttempts.FailuresForEmail(attempt.Email, since)
+		if err != nil {
+			return 0, err
+		}
+		if count >= app.config.login.maxFailures {
+			wait = max(wait, last.Add(app.config.login.lockout).Sub(now))
+		}
+	}
+
+	if app.config.login.ipMaxFailures > 0 {
+		count, last, err := app.models.LoginAttempts.FailuresFromIP(attempt.IP, since)
+		if err != nil {
+			return 0, err
+		}
+		if count >= app.config.login.ipMaxFailures {
+			wait = max(wait, last.Add(app.config.login.lockout).Sub(now))
+		}
+	}
+
+	return wait, nil
+}
+
+// recordLoginAttempt stores attempt for the lockout and the login history, the login goes on if that fails
+func (app *application) recordLoginAttempt(attempt *models.LoginAttempt) {
+	err := app.models.LoginAttempts.Insert(attempt)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// failLogin records attempt as failed for reason
+func (app *application) failLogin(attempt *models.LoginAttempt, reason string) {
+	attempt.Success = false
+	attempt.Reason = reason
+	app.recordLoginAttempt(attempt)
+}
+
+// listLoginHistoryHandler lists the logins into the account of the user and the failed attempts, newest first
+func (app *application) listLoginHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	attempts, metadata, err := app.models.LoginAttempts.GetAllForUser(app.contextGetUser(r), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"logins": attempts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOldLoginAttempts removes the login attempts older than -login-history-retention
+func (app *application) deleteOldLoginAttempts() error {
+	deleted, err := app.models.LoginAttempts.DeleteOlderThan(time.Now().Add(-app.config.login.retention))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted old login attempts", "count", deleted)
+	}
+
+	return nil
+}
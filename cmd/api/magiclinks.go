@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// magicLinkTTL is how long a sign-in link can be used, the email says so too
+const magicLinkTTL = 15 * time.Minute
+
+// createMagicLinkHandler emails a one-time sign-in link. It opens ?redirect_to= or the web app with the
+// token in the fragment, which the page exchanges with exchangeMagicLinkHandler. Following the link does
+// not sign in by itself, as mail scanners open links too.
+func (app *application) createMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email      string `json:"email"`
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidateEmail(v, input.Email)
+	v.Check(input.RedirectTo == "" || app.allowedRedirect(input.RedirectTo), "redirect_to", "must be on the origin of the API or an allowed CORS origin")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, magicLinkTTL, models.ScopeMagicLink)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	link := input.RedirectTo
+	if link == "" {
+		link = app.config.publicURL + "/app"
+	}
+	link += "#" + url.Values{"magic_token": {token.Plaintext}}.Encode()
+
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"magicLink": link,
+		}
+
+		err = app.mailer.Send(user.Email, "magic_link.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing a sign-in link"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchangeMagicLinkHandler signs in with the token of a sign-in link, which cannot be used again
+func (app *application) exchangeMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByToken(models.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired sign-in link")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	app.recordLoginAttempt(&models.LoginAttempt{
+		UserID:    &user.ID,
+		Email:     user.Email,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+		Success:   true,
+	})
+
+	token, refresh, err := app.startSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,42 +1,325 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/Li-Elias/File-Transfer/internal/abuse"
+	"github.com/Li-Elias/File-Transfer/internal/billing"
+	"github.com/Li-Elias/File-Transfer/internal/cdn"
+	"github.com/Li-Elias/File-Transfer/internal/clamav"
+	"github.com/Li-Elias/File-Transfer/internal/codes"
 	"github.com/Li-Elias/File-Transfer/internal/db"
-	"github.com/Li-Elias/File-Transfer/internal/jsonlog"
+	"github.com/Li-Elias/File-Transfer/internal/encryption"
+	"github.com/Li-Elias/File-Transfer/internal/events"
+	"github.com/Li-Elias/File-Transfer/internal/geoip"
+	"github.com/Li-Elias/File-Transfer/internal/jwt"
 	"github.com/Li-Elias/File-Transfer/internal/mail"
 	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/oauth"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
+	"github.com/Li-Elias/File-Transfer/internal/webauthn"
 )
 
 type config struct {
-	port int
-	env  string
-	cors struct {
+	port            int
+	env             string
+	shutdownTimeout time.Duration
+	// publicURL is the base of links which are handed out, without a trailing slash
+	publicURL string
+	cors      struct {
 		allowedOrigins []string
 	}
+	// trustedProxies are the reverse proxies whose forwarding headers are believed, see realIP
+	trustedProxies []*net.IPNet
+	// geoip restricts downloads by country if database is set, see checkFileCountry
+	geoip struct {
+		database         string
+		blockedCountries []string
+	}
+	// cdn hands downloads to a CDN in front of the storage bucket if provider is set, see newCDN
+	cdn struct {
+		provider   string
+		url        string
+		expiry     time.Duration
+		cloudfront struct {
+			keyPairID  string
+			privateKey string
+		}
+		cloudflareSecret string
+	}
+
+	// tls makes the API server serve HTTPS with certFile and keyFile, or with certificates of Let's Encrypt
+	// for autocert.domains, see tlsConfig
+	tls struct {
+		certFile string
+		keyFile  string
+		autocert struct {
+			domains  []string
+			cacheDir string
+			email    string
+		}
+		// redirectPort is the plain HTTP server redirecting to HTTPS, 0 for none
+		redirectPort int
+		http2        bool
+		http3        bool
+	}
+	grpc struct {
+		port int
+	}
+	sftp struct {
+		port    int
+		hostKey string
+	}
+	presign struct {
+		expiry time.Duration
+	}
+	// disk applies to storage backends on a local disk
+	disk struct {
+		minFree int64
+	}
+	migrate struct {
+		enabled bool
+		storage.Storage
+	}
+	janitor struct {
+		interval time.Duration
+	}
+	// owners are emailed before their files expire if expiryNotices.before is set, see notifyExpiringFiles
+	expiryNotices struct {
+		before    time.Duration
+		extension time.Duration
+	}
+	trash struct {
+		retention time.Duration
+	}
+	fetch struct {
+		timeout time.Duration
+	}
+	transfers struct {
+		concurrency int
+	}
+	// limits apply per client IP address, global to every request and users to the authenticated routes on top
+	limits struct {
+		global    rateLimit
+		users     rateLimit
+		uploads   rateLimit
+		downloads rateLimit
+		tokens    rateLimit
+		webdav    rateLimit
+		guests    rateLimit
+	}
+	// download rates are in bytes per second, 0 disables the limit
+	downloads struct {
+		connectionRate int64
+		clientRate     int64
+		// cacheMaxAge is the longest caches may keep a public download, see setCacheHeaders
+		cacheMaxAge time.Duration
+	}
+	// guests are the limits of uploads without an account, POST /files only takes them if enabled
+	guests struct {
+		enabled   bool
+		maxSize   int64
+		maxExpiry time.Duration
+	}
+	// codes is the style of the codes of new files and transfers, users may pick another one. The length
+	// and alphabet are those of alphanumeric codes, with caseInsensitive the alphabet has no upper-case letters.
+	codes struct {
+		style           string
+		length          int
+		alphabet        string
+		caseInsensitive bool
+	}
+	// organizations get quota bytes for the files of their space when they are created, 0 for no limit
+	organizations struct {
+		quota int64
+	}
+	// plans.defaultName is the plan of the users who are not on one, empty for the limits of the server
+	plans struct {
+		defaultName string
+	}
+	// stripe sells the plans which have a Stripe price if secretKey is set, see createCheckoutHandler
+	stripe struct {
+		secretKey     string
+		webhookSecret string
+		successURL    string
+		cancelURL     string
+	}
+	clamav struct {
+		address string
+		timeout time.Duration
+	}
+	files struct {
+		defaultExpiry time.Duration
+		maxExpiry     time.Duration
+		maxSize       int64
+		// content types may end in /* to match a whole type, extensions start with a dot
+		allowedTypes      []string
+		blockedTypes      []string
+		allowedExtensions []string
+		blockedExtensions []string
+	}
+	encryption struct {
+		masterKey []byte
+	}
+	links struct {
+		signingKey []byte
+	}
+	// sessions are started by logging in, refreshing one replaces its refresh token and extends it by refreshTTL
+	sessions struct {
+		tokenTTL   time.Duration
+		refreshTTL time.Duration
+	}
+	// jwt issues authentication tokens as JWTs signed with keys instead of storing them, revocations are
+	// reloaded every revocationRefresh
+	jwt struct {
+		enabled           bool
+		keys              *jwt.Keys
+		revocationRefresh time.Duration
+	}
+	// login applies to logins with a password, a max of 0 disables the lockout
+	login struct {
+		maxFailures   int
+		ipMaxFailures int
+		lockout       time.Duration
+		retention     time.Duration
+	}
+	// oauth has the clients of the providers users can log in with, providers without a client ID are off
+	oauth struct {
+		google, github struct {
+			clientID     string
+			clientSecret string
+		}
+	}
+	// webauthn is the relying party passkeys are registered for, see newRelyingParty
+	webauthn struct {
+		rpID    string
+		origins []string
+	}
+	// abuse applies to lookups by code, see guardCodeLookup
+	abuse struct {
+		abuse.Config
+		// store is memory or db
+		store string
+		// tarpit is the longest wait a lookup is held for instead of being answered with 429
+		tarpit        time.Duration
+		challenge     string
+		siteKey       string
+		secretKey     string
+		powDifficulty int
+	}
+	otel   tracing.Config
+	events events.Config
 	db.DB
 	mail.SMTP
+	storage.Storage
 }
 
 type application struct {
 	config    config
-	logger    *jsonlog.Logger
+	logger    *slog.Logger
 	waitgroup sync.WaitGroup
+	// transfers counts the uploads and downloads in flight, see trackTransfer
+	transfers sync.WaitGroup
+	db        *sql.DB
 	models    models.Models
 	mailer    mail.Mailer
+	storage   storage.Backend
+	// fetchClient only connects to public addresses, see newFetchClient
+	fetchClient *http.Client
+	// scanner is nil unless -clamav-address is set, scanQueue wakes up scanFiles
+	scanner   *clamav.Client
+	scanQueue chan struct{}
+	// thumbnailSlots bounds the thumbnails generated at once, see createThumbnail
+	thumbnailSlots chan struct{}
+	// progress streams the progress of tus uploads to uploadEventsHandler
+	progress *uploadProgress
+	// downloadThrottle is nil unless -download-client-rate is set
+	downloadThrottle *downloadThrottle
+	// transferLimiter is nil unless -transfer-concurrency is set
+	transferLimiter *transferLimiter
+	// lookups is nil if -abuse-failed-lookups is 0, challenger unless -abuse-challenge is set
+	lookups    *abuse.Tracker
+	challenger abuse.Challenger
+	// relyingParty checks the passkeys
+	relyingParty *webauthn.RelyingParty
+	// oauthProviders are the providers configured with -oauth-*-client-id, by name
+	oauthProviders map[string]*oauth.Provider
+	// stripe is nil unless -stripe-secret-key is set
+	stripe *billing.Stripe
+	// roles are the permissions of every role by its name, loaded by serve
+	roles map[string]models.Permissions
+	// revocations are the revoked sessions whose JWTs may not be expired yet, nil unless -auth-token-format is jwt
+	revocations *revocationList
+	// tracer is nil unless -otel-endpoint is set, spans can be started on it regardless
+	tracer *tracing.Tracer
+	// events is nil unless -events-broker is set, events can be published to it regardless
+	events *events.Publisher
+	// geoip is nil unless -geoip-database is set
+	geoip *geoip.Reader
+	// cdn is nil unless -cdn-provider is set, downloads are then redirected to it, see servedByCDN
+	cdn cdn.Signer
+	// shutdown is cancelled by stop once the server begins shutting down,
+	// long running background tasks select on it
+	shutdown context.Context
+	stop     context.CancelFunc
+}
+
+// storageFlags registers the flags configuring a storage backend, prefix and the description prefix tell
+// the configured backend apart from the destination of -migrate-storage
+func storageFlags(s *storage.Storage, prefix, backend, description string) {
+	flag.StringVar(&s.Backend, prefix+"backend", backend, description+"Storage backend (local|s3|azure|gcs)")
+	flag.StringVar(&s.Local.Dir, prefix+"local-dir", "./cache", description+"Local storage directory")
+	flag.StringVar(&s.S3.Endpoint, prefix+"s3-endpoint", "", description+"S3 endpoint (defaults to AWS for the region)")
+	flag.StringVar(&s.S3.Region, prefix+"s3-region", "us-east-1", description+"S3 region")
+	flag.StringVar(&s.S3.Bucket, prefix+"s3-bucket", "", description+"S3 bucket")
+	flag.StringVar(&s.S3.AccessKey, prefix+"s3-access-key", "", description+"S3 access key")
+	flag.StringVar(&s.S3.SecretKey, prefix+"s3-secret-key", "", description+"S3 secret key")
+	flag.StringVar(&s.Azure.Endpoint, prefix+"azure-endpoint", "", description+"Azure Blob Storage endpoint (defaults to https://account.blob.core.windows.net)")
+	flag.StringVar(&s.Azure.Account, prefix+"azure-account", "", description+"Azure storage account name")
+	flag.StringVar(&s.Azure.Key, prefix+"azure-key", "", description+"Base64 encoded Azure storage account key")
+	flag.StringVar(&s.Azure.Container, prefix+"azure-container", "", description+"Azure Blob Storage container")
+	flag.StringVar(&s.GCS.Endpoint, prefix+"gcs-endpoint", "", description+"Google Cloud Storage endpoint (defaults to https://storage.googleapis.com)")
+	flag.StringVar(&s.GCS.Bucket, prefix+"gcs-bucket", "", description+"Google Cloud Storage bucket")
+	flag.StringVar(&s.GCS.Credentials, prefix+"gcs-credentials", "", description+"Path of the service account key file for Google Cloud Storage")
 }
 
 func main() {
 	var cfg config
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
+	flag.IntVar(&cfg.grpc.port, "grpc-port", 0, "gRPC server port (0 disables the gRPC server)")
+	flag.IntVar(&cfg.sftp.port, "sftp-port", 0, "SFTP server port (0 disables the SFTP server)")
+	flag.StringVar(&cfg.sftp.hostKey, "sftp-host-key", "", "Path of the PEM encoded SFTP host key (defaults to a temporary key)")
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "Path of the PEM encoded TLS certificate chain, with -tls-key the API server serves HTTPS")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "Path of the PEM encoded TLS private key")
+	flag.Func("autocert-domains", "Domains to get certificates for from Let's Encrypt, the API server then serves HTTPS (space separated)", func(val string) error {
+		cfg.tls.autocert.domains = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.tls.autocert.cacheDir, "autocert-cache", "autocert", "Directory the Let's Encrypt account key and certificates are kept in")
+	flag.StringVar(&cfg.tls.autocert.email, "autocert-email", "", "Email address Let's Encrypt sends notices about the certificates to")
+	flag.BoolVar(&cfg.tls.http2, "http2", true, "Serve HTTP/2 while TLS is on")
+	flag.BoolVar(&cfg.tls.http3, "http3", false, "Serve HTTP/3 on the UDP port of the same number while TLS is on")
+	flag.IntVar(&cfg.tls.redirectPort, "http-redirect-port", 80, "Port of the HTTP server redirecting to HTTPS and answering Let's Encrypt challenges while TLS is on (0 disables it)")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.publicURL, "public-url", "", "Base URL of the API used in links (defaults to http://localhost:port, or https:// with TLS)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time in-flight uploads and downloads get to finish on shutdown")
 
 	flag.StringVar(&cfg.DB.Dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -49,28 +332,488 @@ func main() {
 	flag.StringVar(&cfg.SMTP.Password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&cfg.SMTP.Sender, "smtp-sender", "<no-reply@file-transfer.io>", "SMTP sender")
 
+	storageFlags(&cfg.Storage, "storage-", "local", "")
+	flag.DurationVar(&cfg.presign.expiry, "storage-s3-presign-expiry", 15*time.Minute, "Validity of presigned direct upload URLs")
+	flag.Int64Var(&cfg.disk.minFree, "storage-min-free-space", 100_000_000, "Free space in bytes the local storage directory needs to accept uploads and for /readyz to succeed")
+
+	flag.BoolVar(&cfg.migrate.enabled, "migrate-storage", false, "Copy all stored files to the -migrate-storage-* backend and exit")
+	storageFlags(&cfg.migrate.Storage, "migrate-storage-", "", "Destination ")
+
+	flag.DurationVar(&cfg.files.defaultExpiry, "file-default-expiry", 2*time.Minute, "Expiry of uploaded files without an expires_in value")
+	flag.DurationVar(&cfg.files.maxExpiry, "file-max-expiry", 7*24*time.Hour, "Maximum expires_in value clients may request")
+	flag.Int64Var(&cfg.files.maxSize, "file-max-size", 1_000_000, "Maximum size of an uploaded file in bytes")
+	cfg.codes.style = codes.StyleAlphanumeric
+	flag.Func("code-style", "Style of download codes, 8 alphanumeric characters or 4 words such as amber-ferry-oak-tulip (alphanumeric|words, default alphanumeric)", func(val string) error {
+		if val != codes.StyleAlphanumeric && val != codes.StyleWords {
+			return errors.New("must be alphanumeric or words")
+		}
+		cfg.codes.style = val
+		return nil
+	})
+	flag.IntVar(&cfg.codes.length, "code-length", 8, "Length of alphanumeric download codes, from 6 to 64")
+	cfg.codes.alphabet = codes.AlphabetFull
+	flag.Func("code-alphabet", "Characters of alphanumeric download codes, unambiguous leaves out 0/O/o and 1/l/I (full|unambiguous, default full)", func(val string) error {
+		switch val {
+		case "full":
+			cfg.codes.alphabet = codes.AlphabetFull
+		case "unambiguous":
+			cfg.codes.alphabet = codes.AlphabetUnambiguous
+		default:
+			return errors.New("must be full or unambiguous")
+		}
+		return nil
+	})
+	flag.BoolVar(&cfg.codes.caseInsensitive, "code-case-insensitive", false, "Generate lower-case alphanumeric codes and find codes regardless of case, older mixed-case codes keep working")
+	flag.Int64Var(&cfg.organizations.quota, "organization-quota", 0, "Quota of new organizations in bytes, 0 for no limit")
+	flag.StringVar(&cfg.plans.defaultName, "default-plan", "", "Name of the plan of users who are not on one (empty for the limits of the server)")
+	flag.StringVar(&cfg.stripe.secretKey, "stripe-secret-key", "", "Stripe secret API key (empty disables selling plans)")
+	flag.StringVar(&cfg.stripe.webhookSecret, "stripe-webhook-secret", "", "Signing secret of the Stripe webhook endpoint")
+	flag.StringVar(&cfg.stripe.successURL, "stripe-success-url", "", "Page users return to after paying (defaults to the web app)")
+	flag.StringVar(&cfg.stripe.cancelURL, "stripe-cancel-url", "", "Page users return to if they cancel paying (defaults to the web app)")
+	flag.BoolVar(&cfg.guests.enabled, "guest-uploads", false, "Allow uploads without an account with POST /files")
+	flag.Int64Var(&cfg.guests.maxSize, "guest-max-file-size", 100_000, "Maximum size of a file uploaded without an account in bytes")
+	flag.DurationVar(&cfg.guests.maxExpiry, "guest-max-expiry", time.Hour, "Maximum expires_in value of files uploaded without an account")
+	flag.DurationVar(&cfg.janitor.interval, "janitor-interval", time.Minute, "Interval between expired file cleanups")
+	flag.DurationVar(&cfg.expiryNotices.before, "expiry-notice", 0, "Time before their files expire owners are emailed a link to keep them (0 disables the emails)")
+	flag.DurationVar(&cfg.expiryNotices.extension, "expiry-notice-extension", 72*time.Hour, "Time from when it is opened the link of an expiry email keeps a file for, at most -file-max-expiry")
+	flag.StringVar(&cfg.clamav.address, "clamav-address", "", "clamd address as host:port or unix socket path (empty disables malware scanning)")
+	flag.DurationVar(&cfg.clamav.timeout, "clamav-timeout", 2*time.Minute, "Maximum time for scanning a single file")
+	rateLimitFlag(&cfg.limits.global, "rate-limit-global", rateLimit{10, time.Minute}, "Rate limit of all API requests")
+	rateLimitFlag(&cfg.limits.users, "rate-limit-users", rateLimit{5, time.Minute}, "Rate limit of authenticated requests")
+	rateLimitFlag(&cfg.limits.uploads, "rate-limit-uploads", rateLimit{}, "Rate limit of uploads")
+	rateLimitFlag(&cfg.limits.downloads, "rate-limit-downloads", rateLimit{}, "Rate limit of downloads by code or signed link")
+	rateLimitFlag(&cfg.limits.tokens, "rate-limit-tokens", rateLimit{}, "Rate limit of the /tokens endpoints")
+	rateLimitFlag(&cfg.limits.webdav, "rate-limit-webdav", rateLimit{300, time.Minute}, "Rate limit of WebDAV requests")
+	rateLimitFlag(&cfg.limits.guests, "rate-limit-guest-uploads", rateLimit{10, time.Hour}, "Rate limit of uploads without an account")
+
+	flag.StringVar(&cfg.otel.Endpoint, "otel-endpoint", "", "OTLP/HTTP collector to export traces to, such as http://localhost:4318 (empty disables tracing)")
+	flag.StringVar(&cfg.otel.ServiceName, "otel-service-name", "file-transfer", "Service name traces are exported with")
+	flag.Float64Var(&cfg.otel.SampleRatio, "otel-sample-ratio", 1, "Share of requests to trace, between 0 and 1")
+	flag.StringVar(&cfg.events.Broker, "events-broker", "", "Message broker to publish file events to: nats or kafka (empty disables publishing)")
+	flag.StringVar(&cfg.events.URL, "events-url", "", "NATS server such as nats://localhost:4222, or Kafka REST Proxy such as http://localhost:8082")
+	flag.StringVar(&cfg.events.User, "events-user", "", "User to authenticate to the message broker with")
+	flag.StringVar(&cfg.events.Password, "events-password", "", "Password to authenticate to the message broker with, a NATS token without -events-user")
+	flag.StringVar(&cfg.events.Subject, "events-subject", "file-transfer", "Prefix of the NATS subjects, or the Kafka topic, file events are published to")
+	flag.IntVar(&cfg.transfers.concurrency, "transfer-concurrency", 0, "Maximum simultaneous uploads and downloads of a user or IP address (0 disables the limit)")
+	flag.Int64Var(&cfg.downloads.connectionRate, "download-connection-rate", 0, "Maximum bandwidth of a single download in bytes per second (0 disables the limit)")
+	flag.Int64Var(&cfg.downloads.clientRate, "download-client-rate", 0, "Maximum bandwidth of all downloads of a user or IP address together in bytes per second (0 disables the limit)")
+	flag.DurationVar(&cfg.downloads.cacheMaxAge, "download-cache-max-age", 0, "Longest browsers and CDNs may cache a download of a public file from /files/{code}, never past its expiry (0 disables caching)")
+	flag.DurationVar(&cfg.fetch.timeout, "fetch-timeout", 5*time.Minute, "Maximum time for fetching a file from a URL")
+	flag.DurationVar(&cfg.sessions.tokenTTL, "auth-token-ttl", 24*time.Hour, "Time authentication tokens are valid for")
+	flag.DurationVar(&cfg.sessions.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Time a session can go without being refreshed before it ends")
+	flag.Func("auth-token-format", "Format of authentication tokens (opaque|jwt, default opaque)", func(val string) error {
+		switch val {
+		case "opaque":
+			cfg.jwt.enabled = false
+		case "jwt":
+			cfg.jwt.enabled = true
+		default:
+			return errors.New("must be opaque or jwt")
+		}
+		return nil
+	})
+	flag.Func("jwt-keys", "Space separated id:hexkey pairs for signing JWTs, the first signs and all verify (defaults to a random key, JWTs then stop working on restart)", func(val string) error {
+		if val == "" {
+			return nil
+		}
+		keys, err := jwt.ParseKeys(val)
+		if err != nil {
+			return err
+		}
+		cfg.jwt.keys = keys
+		return nil
+	})
+	flag.DurationVar(&cfg.jwt.revocationRefresh, "jwt-revocation-refresh", 30*time.Second, "Interval between reloads of the revoked sessions, the longest a revocation on another instance takes to apply")
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 5, "Failed logins after which an account is locked for -login-lockout (0 disables the lockout)")
+	flag.IntVar(&cfg.login.ipMaxFailures, "login-ip-max-failures", 20, "Failed logins after which an IP address may not log in for -login-lockout (0 disables the lockout)")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "Time failed logins count against an account or IP address and the lockout lasts")
+	flag.DurationVar(&cfg.login.retention, "login-history-retention", 90*24*time.Hour, "Time login attempts are kept for the login history")
+	flag.IntVar(&cfg.abuse.Threshold, "abuse-failed-lookups", 10, "Unknown codes an IP address may look up before it is slowed down and challenged (0 disables the protection)")
+	flag.DurationVar(&cfg.abuse.Window, "abuse-window", time.Hour, "Time failed code lookups are remembered")
+	flag.IntVar(&cfg.abuse.PrefixThreshold, "abuse-prefix-failed-lookups", 50, "Unknown codes a /24 IPv4 or /64 IPv6 network may look up before it is slowed down and challenged (0 only counts addresses)")
+	flag.IntVar(&cfg.abuse.LockoutThreshold, "abuse-lockout-failed-lookups", 100, "Unknown codes an IP address may look up before it is locked out (0 never locks out)")
+	flag.DurationVar(&cfg.abuse.Lockout, "abuse-lockout", 24*time.Hour, "Time an IP address stays locked out")
+	flag.DurationVar(&cfg.abuse.MaxBackoff, "abuse-max-backoff", 15*time.Minute, "Maximum wait between code lookups of an IP address over -abuse-failed-lookups")
+	flag.DurationVar(&cfg.abuse.tarpit, "abuse-tarpit", 5*time.Second, "Longest wait a code lookup is held for instead of being answered with 429 (0 answers right away)")
+	flag.StringVar(&cfg.abuse.store, "abuse-store", "db", "Where failed code lookups are counted (db|memory), memory keeps separate counts per instance")
+	flag.StringVar(&cfg.abuse.challenge, "abuse-challenge", "", "Challenge an IP address over -abuse-failed-lookups solves for every lookup (pow|hcaptcha|turnstile, empty for none)")
+	flag.StringVar(&cfg.abuse.siteKey, "abuse-captcha-site-key", "", "hCaptcha or Turnstile site key")
+	flag.StringVar(&cfg.abuse.secretKey, "abuse-captcha-secret-key", "", "hCaptcha or Turnstile secret key")
+	flag.IntVar(&cfg.abuse.powDifficulty, "abuse-pow-difficulty", 18, "Leading zero bits of the SHA-256 hash of proof-of-work solutions")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 72*time.Hour, "Time deleted files can be restored from the trash (0 deletes files right away)")
+
+	flag.Func("file-allowed-types", "Only accept files of these content types, such as image/* (space separated)", func(val string) error {
+		cfg.files.allowedTypes = strings.Fields(strings.ToLower(val))
+		return nil
+	})
+	flag.Func("file-blocked-types", "Reject files of these content types (space separated)", func(val string) error {
+		cfg.files.blockedTypes = strings.Fields(strings.ToLower(val))
+		return nil
+	})
+	flag.Func("file-allowed-extensions", "Only accept files with these extensions (space separated)", func(val string) error {
+		cfg.files.allowedExtensions = parseExtensions(val)
+		return nil
+	})
+	flag.Func("file-blocked-extensions", "Reject files with these extensions, such as exe bat sh (space separated)", func(val string) error {
+		cfg.files.blockedExtensions = parseExtensions(val)
+		return nil
+	})
+
 	flag.Func("cors-allowed-origins", "Allowed CORS origins (space separated)", func(val string) error {
 		cfg.cors.allowedOrigins = strings.Fields(val)
 		return nil
 	})
+	flag.Func("trusted-proxies", "Addresses and CIDR ranges of reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted (space separated)", func(val string) error {
+		cfg.trustedProxies = nil
+		for _, field := range strings.Fields(val) {
+			network, err := models.ParseNetwork(field)
+			if err != nil {
+				return err
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, network)
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.geoip.database, "geoip-database", "", "MaxMind DB file such as GeoLite2-Country.mmdb to restrict downloads by country with (empty disables the restrictions)")
+	flag.Func("geoip-blocked-countries", "ISO codes of the countries downloads are refused from on the whole server, such as CN RU (space separated)", func(val string) error {
+		cfg.geoip.blockedCountries = models.NormalizeCountries(strings.Fields(val))
+		return nil
+	})
+
+	flag.StringVar(&cfg.cdn.provider, "cdn-provider", "", "CDN downloads are redirected to with signed URLs (cloudfront|cloudflare, empty serves them from the API)")
+	flag.StringVar(&cfg.cdn.url, "cdn-url", "", "Base URL the CDN serves the objects of the storage bucket under, such as https://d111111abcdef8.cloudfront.net")
+	flag.DurationVar(&cfg.cdn.expiry, "cdn-url-expiry", 5*time.Minute, "Time a signed CDN URL can be used to start the download")
+	flag.StringVar(&cfg.cdn.cloudfront.keyPairID, "cdn-cloudfront-key-pair-id", "", "ID of the public key in the trusted key group of the CloudFront distribution")
+	flag.StringVar(&cfg.cdn.cloudfront.privateKey, "cdn-cloudfront-private-key", "", "Path of the PEM encoded RSA private key CloudFront URLs are signed with")
+	flag.StringVar(&cfg.cdn.cloudflareSecret, "cdn-cloudflare-secret", "", "Hex encoded secret the Cloudflare token authentication checks the verify parameter with")
 
-	flag.Parse()
+	flag.StringVar(&cfg.oauth.google.clientID, "oauth-google-client-id", "", "Google OAuth client ID (empty disables logging in with Google)")
+	flag.StringVar(&cfg.oauth.google.clientSecret, "oauth-google-client-secret", "", "Google OAuth client secret")
+	flag.StringVar(&cfg.oauth.github.clientID, "oauth-github-client-id", "", "GitHub OAuth client ID (empty disables logging in with GitHub)")
+	flag.StringVar(&cfg.oauth.github.clientSecret, "oauth-github-client-secret", "", "GitHub OAuth client secret")
+
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", "", "Domain passkeys are registered for (defaults to the host of -public-url)")
+	flag.Func("webauthn-origins", "Origins of the pages passkeys are used on (space separated, defaults to the origin of -public-url)", func(val string) error {
+		cfg.webauthn.origins = strings.Fields(val)
+		return nil
+	})
+
+	flag.Func("encryption-master-key", "Hex encoded 32 byte key for encrypting stored files (empty disables encryption)", func(val string) error {
+		if val == "" {
+			return nil
+		}
+		key, err := hex.DecodeString(val)
+		if err != nil {
+			return err
+		}
+		if len(key) != encryption.KeySize {
+			return fmt.Errorf("must be %d bytes long", encryption.KeySize)
+		}
+		cfg.encryption.masterKey = key
+		return nil
+	})
+
+	flag.Func("link-signing-key", "Hex encoded key for signing download links (defaults to a random key, links then stop working on restart)", func(val string) error {
+		if val == "" {
+			return nil
+		}
+		key, err := hex.DecodeString(val)
+		if err != nil {
+			return err
+		}
+		if len(key) < 32 {
+			return errors.New("must be at least 32 bytes long")
+		}
+		cfg.links.signingKey = key
+		return nil
+	})
+
+	err := loadSettings()
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	if (cfg.tls.certFile == "") != (cfg.tls.keyFile == "") {
+		fatal(logger, errors.New("tls-cert and tls-key must be set together"))
+	}
+	if cfg.tls.certFile != "" && len(cfg.tls.autocert.domains) > 0 {
+		fatal(logger, errors.New("tls-cert and autocert-domains must not be set together"))
+	}
+
+	if cfg.publicURL == "" {
+		switch {
+		case len(cfg.tls.autocert.domains) > 0 && cfg.port == 443:
+			cfg.publicURL = "https://" + cfg.tls.autocert.domains[0]
+		case len(cfg.tls.autocert.domains) > 0:
+			cfg.publicURL = fmt.Sprintf("https://%s:%d", cfg.tls.autocert.domains[0], cfg.port)
+		case cfg.tls.certFile != "":
+			cfg.publicURL = fmt.Sprintf("https://localhost:%d", cfg.port)
+		default:
+			cfg.publicURL = fmt.Sprintf("http://localhost:%d", cfg.port)
+		}
+	}
+	cfg.publicURL = strings.TrimSuffix(cfg.publicURL, "/")
+
+	if cfg.jwt.enabled && cfg.jwt.keys == nil {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			fatal(logger, err)
+		}
+		cfg.jwt.keys = jwt.NewKeys("random", key)
+	}
+
+	if cfg.links.signingKey == nil {
+		cfg.links.signingKey = make([]byte, 32)
+		_, err := rand.Read(cfg.links.signingKey)
+		if err != nil {
+			fatal(logger, err)
+		}
+	}
+
+	if cfg.files.defaultExpiry > cfg.files.maxExpiry {
+		fatal(logger, errors.New("file-default-expiry must not be more than file-max-expiry"))
+	}
+
+	if cfg.guests.enabled && (cfg.guests.maxSize <= 0 || cfg.guests.maxSize > cfg.files.maxSize || cfg.guests.maxExpiry <= 0 || cfg.guests.maxExpiry > cfg.files.maxExpiry) {
+		fatal(logger, errors.New("guest-max-file-size and guest-max-expiry must be positive and not more than file-max-size and file-max-expiry"))
+	}
+
+	if cfg.codes.length < 6 || cfg.codes.length > 64 {
+		fatal(logger, errors.New("code-length must be from 6 to 64"))
+	}
+
+	if cfg.codes.caseInsensitive {
+		cfg.codes.alphabet = codes.Lower(cfg.codes.alphabet)
+	}
+
+	if cfg.organizations.quota < 0 {
+		fatal(logger, errors.New("organization-quota must not be negative"))
+	}
+
+	if cfg.expiryNotices.before < 0 {
+		fatal(logger, errors.New("expiry-notice must not be negative"))
+	}
+	if cfg.expiryNotices.extension <= 0 {
+		fatal(logger, errors.New("expiry-notice-extension must be positive"))
+	}
+
+	if len(cfg.geoip.blockedCountries) > 0 && cfg.geoip.database == "" {
+		fatal(logger, errors.New("geoip-blocked-countries requires geoip-database"))
+	}
+	for _, country := range cfg.geoip.blockedCountries {
+		if !models.ValidCountry(country) {
+			fatal(logger, fmt.Errorf("geoip-blocked-countries: %s is not a two letter country code", country))
+		}
+	}
+
+	if cfg.cdn.provider != "" && (cfg.cdn.url == "" || cfg.cdn.expiry <= 0) {
+		fatal(logger, errors.New("cdn-provider requires cdn-url and a positive cdn-url-expiry"))
+	}
+
+	if cfg.transfers.concurrency < 0 {
+		fatal(logger, errors.New("transfer-concurrency must not be negative"))
+	}
+
+	if cfg.downloads.connectionRate < 0 || cfg.downloads.clientRate < 0 {
+		fatal(logger, errors.New("download-connection-rate and download-client-rate must not be negative"))
+	}
+	if cfg.downloads.cacheMaxAge < 0 {
+		fatal(logger, errors.New("download-cache-max-age must not be negative"))
+	}
+
+	if cfg.disk.minFree < 0 {
+		fatal(logger, errors.New("storage-min-free-space must not be negative"))
+	}
+
+	if cfg.login.maxFailures < 0 || cfg.login.ipMaxFailures < 0 {
+		fatal(logger, errors.New("login-max-failures and login-ip-max-failures must not be negative"))
+	}
+
+	if cfg.sessions.tokenTTL <= 0 || cfg.sessions.refreshTTL < cfg.sessions.tokenTTL {
+		fatal(logger, errors.New("auth-token-ttl must be positive and refresh-token-ttl must not be shorter"))
+	}
+
+	if cfg.jwt.revocationRefresh <= 0 {
+		fatal(logger, errors.New("jwt-revocation-refresh must be positive"))
+	}
+
+	if cfg.login.lockout <= 0 || cfg.login.retention < cfg.login.lockout {
+		fatal(logger, errors.New("login-lockout must be positive and login-history-retention must not be shorter"))
+	}
+
+	if (cfg.oauth.google.clientID == "") != (cfg.oauth.google.clientSecret == "") || (cfg.oauth.github.clientID == "") != (cfg.oauth.github.clientSecret == "") {
+		fatal(logger, errors.New("oauth client IDs and secrets must be set together"))
+	}
+
+	if (cfg.stripe.secretKey == "") != (cfg.stripe.webhookSecret == "") {
+		fatal(logger, errors.New("stripe-secret-key and stripe-webhook-secret must be set together"))
+	}
+	if cfg.stripe.successURL == "" {
+		cfg.stripe.successURL = cfg.publicURL + "/app"
+	}
+	if cfg.stripe.cancelURL == "" {
+		cfg.stripe.cancelURL = cfg.publicURL + "/app"
+	}
+
+	if cfg.abuse.Threshold < 0 || cfg.abuse.PrefixThreshold < 0 || cfg.abuse.LockoutThreshold < 0 {
+		fatal(logger, errors.New("abuse-failed-lookups, abuse-prefix-failed-lookups and abuse-lockout-failed-lookups must not be negative"))
+	}
+
+	if cfg.abuse.Window <= 0 || cfg.abuse.MaxBackoff <= 0 || cfg.abuse.Lockout <= 0 || cfg.abuse.tarpit < 0 {
+		fatal(logger, errors.New("abuse-window, abuse-max-backoff and abuse-lockout must be positive, abuse-tarpit must not be negative"))
+	}
+
+	if cfg.abuse.store != "db" && cfg.abuse.store != "memory" {
+		fatal(logger, errors.New("abuse-store must be db or memory"))
+	}
+
+	if cfg.abuse.challenge != "" && cfg.abuse.Threshold == 0 {
+		fatal(logger, errors.New("abuse-challenge needs abuse-failed-lookups"))
+	}
+
+	if cfg.presign.expiry <= 0 || cfg.presign.expiry > 7*24*time.Hour {
+		fatal(logger, errors.New("storage-s3-presign-expiry must be positive and not more than 7 days"))
+	}
 
 	db, err := db.Init(&cfg.DB)
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		fatal(logger, err)
+	}
+	logger.Info("database connection pool established")
+
+	store, err := storage.New(&cfg.Storage)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	tracer, err := tracing.New(&cfg.otel, func(err error) {
+		logger.Error(err.Error())
+	})
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	publisher, err := events.New(&cfg.events, func(err error) {
+		logger.Error(err.Error())
+	})
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	var countries *geoip.Reader
+	if cfg.geoip.database != "" {
+		countries, err = geoip.Open(cfg.geoip.database)
+		if err != nil {
+			fatal(logger, err)
+		}
+	}
+
+	signer, err := newCDN(&cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	shutdown, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	var scanner *clamav.Client
+	if cfg.clamav.address != "" {
+		scanner = clamav.New(cfg.clamav.address, cfg.clamav.timeout)
+	}
+
+	var throttle *downloadThrottle
+	if cfg.downloads.clientRate > 0 {
+		throttle = newDownloadThrottle(cfg.downloads.clientRate)
+	}
+
+	models := models.NewModels(db, tracer, cfg.codes.caseInsensitive)
+
+	if cfg.plans.defaultName != "" {
+		_, err = models.Plans.GetByName(cfg.plans.defaultName)
+		if err != nil {
+			fatal(logger, fmt.Errorf("default-plan %s: %w", cfg.plans.defaultName, err))
+		}
+	}
+
+	var stripe *billing.Stripe
+	if cfg.stripe.secretKey != "" {
+		stripe = billing.NewStripe(cfg.stripe.secretKey, cfg.stripe.webhookSecret)
+	}
+
+	var lookups *abuse.Tracker
+	if cfg.abuse.Threshold > 0 {
+		var failures abuse.Store = models.LookupFailures
+		if cfg.abuse.store == "memory" {
+			failures = abuse.NewMemoryStore()
+		}
+		lookups = abuse.NewTracker(cfg.abuse.Config, failures)
+	}
+
+	challenger, err := newChallenger(&cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	relyingParty, err := newRelyingParty(&cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	var revocations *revocationList
+	if cfg.jwt.enabled {
+		revocations = newRevocationList()
+	}
+
+	var limiter *transferLimiter
+	if cfg.transfers.concurrency > 0 {
+		limiter = newTransferLimiter(cfg.transfers.concurrency)
 	}
-	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: models.NewModels(db),
-		mailer: mail.New(&cfg.SMTP),
+		config:           cfg,
+		logger:           logger,
+		db:               db,
+		models:           models,
+		mailer:           mail.New(&cfg.SMTP),
+		storage:          store,
+		fetchClient:      newFetchClient(cfg.fetch.timeout),
+		scanner:          scanner,
+		scanQueue:        make(chan struct{}, 1),
+		thumbnailSlots:   make(chan struct{}, thumbnailConcurrency),
+		progress:         newUploadProgress(),
+		downloadThrottle: throttle,
+		transferLimiter:  limiter,
+		lookups:          lookups,
+		challenger:       challenger,
+		relyingParty:     relyingParty,
+		oauthProviders:   newOAuthProviders(&cfg),
+		stripe:           stripe,
+		revocations:      revocations,
+		tracer:           tracer,
+		events:           publisher,
+		geoip:            countries,
+		cdn:              signer,
+		shutdown:         shutdown,
+		stop:             stop,
+	}
+
+	if cfg.migrate.enabled {
+		err = app.migrateStorage(&cfg.migrate.Storage)
+		if err != nil {
+			fatal(logger, err)
+		}
+		return
 	}
 
 	err = app.serve()
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		fatal(logger, err)
 	}
 }
+
+// fatal logs err and exits, as the standard logger's Fatal does
+func fatal(logger *slog.Logger, err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
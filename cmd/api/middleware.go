@@ -1,35 +1,106 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/Li-Elias/File-Transfer/internal/models"
-	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// requestID takes the ID of a request from the X-Request-ID header, so it can be followed across services, or
+// generates one. The ID is sent back in the response and ends up in every log line and error response of the request.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters, anything else is not put into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
 func (app *application) Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 		start_time := time.Now()
 
+		attrs := []any{
+			"request_id", app.contextGetRequestID(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"ip", remoteHost(r.RemoteAddr),
+		}
+		if id := tracing.TraceID(r.Context()); id != "" {
+			attrs = append(attrs, "trace_id", id)
+		}
+
+		r = app.contextSetLogger(r, app.logger.With(attrs...))
+
 		defer func() {
-			app.logger.PrintInfo("Request log", map[string]string{
-				"method": r.Method,
-				"url":    r.RequestURI,
-				"status": fmt.Sprintf("%d", ww.Status()),
-				"bytes":  fmt.Sprintf("%d", ww.BytesWritten()),
-				"µs":     fmt.Sprintf("%d", time.Since(start_time).Microseconds()),
-			})
+			app.contextGetLogger(r).Info("Request log",
+				"url", redactedURL(r),
+				"status", ww.Status(),
+				"bytes", ww.BytesWritten(),
+				"µs", time.Since(start_time).Microseconds(),
+			)
 		}()
 		next.ServeHTTP(ww, r)
 	})
 }
 
+// redactedURL hides secrets which may be passed in the query string, like file passwords
+func redactedURL(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("password") && !query.Has("passphrase") {
+		return r.RequestURI
+	}
+
+	for _, key := range []string{"password", "passphrase"} {
+		if query.Has(key) {
+			query.Set(key, "REDACTED")
+		}
+	}
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -47,19 +118,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		token := headerParts[1]
-
-		v := validator.New()
-		if models.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-
-		user, err := app.models.Users.GetByToken(models.ScopeAuthentication, token)
+		user, apiKey, err := app.authenticateToken(headerParts[1])
 		if err != nil {
 			switch {
-			case errors.Is(err, models.ErrRecordNotFound):
+			case errors.Is(err, errInvalidToken):
 				app.invalidAuthenticationTokenResponse(w, r)
+			case errors.Is(err, errSuspendedAccount):
+				app.suspendedAccountResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -67,6 +132,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		r = app.contextSetUser(r, user)
+		if apiKey != nil {
+			r = app.contextSetAPIKey(r, apiKey)
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -96,3 +164,98 @@ func (app *application) requireActivatedUser(next http.Handler) http.Handler {
 
 	return app.requireAuthenticatedUser(fn)
 }
+
+// requirePermission lets activated users through whose role grants permission, requests authenticated
+// with an API key also need it as a scope of the key
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.permitted(app.contextGetUser(r), app.contextGetAPIKey(r), permission) {
+				app.notPermittedResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+
+		return app.requireActivatedUser(fn)
+	}
+}
+
+// denyAPIKeys keeps API keys away from account management, which needs an authentication token
+func (app *application) denyAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackTransfer counts requests moving file content so shutdown can wait for them,
+// once the server is shutting down new ones are turned away
+func (app *application) trackTransfer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.shutdown.Err() != nil {
+			app.shuttingDownResponse(w, r)
+			return
+		}
+
+		app.transfers.Add(1)
+		defer app.transfers.Done()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadIdleTimeout is how long an upload may go without the client sending anything
+const uploadIdleTimeout = 30 * time.Second
+
+// uploadDeadline lifts the read timeout of the server for requests carrying file content, which take as long as
+// the client needs to send the file. The deadlines move forward as the body is read, so only uploads which stall
+// for uploadIdleTimeout are cut off, and the response has as long to be written after the last read.
+func (app *application) uploadDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &deadlineBody{ReadCloser: r.Body, rc: http.NewResponseController(w)}
+
+		err := body.extend()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		r.Body = body
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deadlineBody extends the read and write deadlines of the connection before reads, at most once a second
+type deadlineBody struct {
+	io.ReadCloser
+	rc       *http.ResponseController
+	extended time.Time
+}
+
+func (b *deadlineBody) extend() error {
+	now := time.Now()
+	if now.Sub(b.extended) < time.Second {
+		return nil
+	}
+	b.extended = now
+
+	err := b.rc.SetReadDeadline(now.Add(uploadIdleTimeout))
+	if err != nil {
+		return err
+	}
+
+	return b.rc.SetWriteDeadline(now.Add(uploadIdleTimeout))
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	err := b.extend()
+	if err != nil {
+		return 0, err
+	}
+
+	return b.ReadCloser.Read(p)
+}
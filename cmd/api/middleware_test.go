@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApplication(t)
+
+	user := insertUser(t, app, "user@example.com")
+	token, err := app.models.Tokens.New(user.ID, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := app.models.Tokens.New(user.ID, -time.Minute, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activation, err := app.models.Tokens.New(user.ID, time.Hour, models.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suspended := insertUser(t, app, "suspended@example.com")
+	suspended.Suspended = true
+	err = app.models.Users.Update(suspended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suspendedToken, err := app.models.Tokens.New(suspended.ID, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantUserID    int64
+	}{
+		{"no header", "", http.StatusOK, models.AnonymousUser.ID},
+		{"valid token", "Bearer " + token.Plaintext, http.StatusOK, user.ID},
+		{"not a bearer token", "Basic " + token.Plaintext, http.StatusUnauthorized, 0},
+		{"missing token", "Bearer", http.StatusUnauthorized, 0},
+		{"malformed token", "Bearer abc", http.StatusUnauthorized, 0},
+		{"unknown token", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized, 0},
+		{"expired token", "Bearer " + expired.Plaintext, http.StatusUnauthorized, 0},
+		{"token of another scope", "Bearer " + activation.Plaintext, http.StatusUnauthorized, 0},
+		{"suspended user", "Bearer " + suspendedToken.Plaintext, http.StatusForbidden, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *models.User
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = app.contextGetUser(r)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/users/files", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+
+			app.authenticate(next).ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if rr.Header().Get("Vary") != "Authorization" {
+				t.Errorf("got Vary %q, want Authorization", rr.Header().Get("Vary"))
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if got != nil {
+					t.Error("the next handler was called")
+				}
+				return
+			}
+
+			if got == nil || got.ID != tt.wantUserID {
+				t.Fatalf("got user %+v, want the user with ID %d", got, tt.wantUserID)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+)
+
+// migrateAttempts bounds how often a file whose content is replaced during the copy is copied again
+const migrateAttempts = 3
+
+var errContentChanged = errors.New("content changed while it was copied")
+
+// migrateStorage copies the content of all live files and tus uploads to the backend configured by cfg, under
+// the same keys, so the server only has to be restarted with the new backend afterwards. It can run next to a
+// serving instance and be repeated to pick up what changed since the last run, every copy is read back from the
+// destination and compared against the source. Blobs which fail are logged and reported at the end.
+func (app *application) migrateStorage(cfg *storage.Storage) error {
+	if cfg.Backend == "" {
+		return errors.New("migrate-storage-backend must be provided")
+	}
+	if *cfg == app.config.Storage {
+		return errors.New("migrate-storage-* must configure a different backend than storage-*")
+	}
+
+	dst, err := storage.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	files, err := app.models.Files.GetAllStored()
+	if err != nil {
+		return err
+	}
+
+	migrated, failed := 0, 0
+	var bytesCopied int64
+
+	for _, file := range files {
+		n, err := app.migrateFile(file, dst)
+		if err != nil {
+			app.logger.Error(err.Error(), "file_id", file.ID)
+			failed++
+			continue
+		}
+		// the row was deleted in the meantime
+		if n < 0 {
+			continue
+		}
+
+		migrated++
+		bytesCopied += n
+	}
+
+	uploads, err := app.models.Uploads.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		for i := 0; i < upload.Chunks; i++ {
+			n, err := copyBlob(app.storage, dst, uploadChunkKey(upload.ID, i))
+			if err != nil {
+				// the upload may have finished or expired since it was listed
+				if errors.Is(err, storage.ErrObjectNotFound) {
+					break
+				}
+				app.logger.Error(err.Error(), "upload_id", upload.ID)
+				failed++
+				break
+			}
+
+			bytesCopied += n
+		}
+	}
+
+	app.logger.Info("migrated storage",
+		"backend", cfg.Backend,
+		"files", migrated,
+		"uploads", len(uploads),
+		"bytes", bytesCopied,
+		"failed", failed,
+	)
+
+	if failed > 0 {
+		return fmt.Errorf("%d blobs could not be migrated, run again to retry them", failed)
+	}
+
+	return nil
+}
+
+// migrateFile copies the content of file to dst and returns its size, or -1 if the file has been deleted.
+// The row is read again after copying, content replaced in the meantime is copied once more.
+func (app *application) migrateFile(file *models.File, dst storage.Backend) (int64, error) {
+	for attempt := 0; attempt < migrateAttempts; attempt++ {
+		digest, n, err := copyBlobDigest(app.storage, dst, file.Path)
+		if err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				return app.migratedFileGone(file, dst)
+			}
+			return 0, err
+		}
+
+		current, err := app.models.Files.Get(file.ID)
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				return app.migratedFileGone(file, dst)
+			}
+			return 0, err
+		}
+
+		if current.ChecksumSHA256 != file.ChecksumSHA256 || !bytes.Equal(current.EncryptionNonce, file.EncryptionNonce) {
+			file = current
+			continue
+		}
+
+		// the stored checksum is of the plaintext, so encrypted blobs can only be compared with their source
+		if file.EncryptionKey == nil && file.ChecksumSHA256 != "" && digest != file.ChecksumSHA256 {
+			return 0, fmt.Errorf("content of %s does not match the checksum of the file", file.Path)
+		}
+
+		return n, nil
+	}
+
+	return 0, errContentChanged
+}
+
+// migratedFileGone removes what was copied of a file which has been deleted while it was migrated
+func (app *application) migratedFileGone(file *models.File, dst storage.Backend) (int64, error) {
+	err := dst.Delete(file.Path)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return 0, err
+	}
+
+	return -1, nil
+}
+
+func copyBlob(src, dst storage.Backend, key string) (int64, error) {
+	_, n, err := copyBlobDigest(src, dst, key)
+	return n, err
+}
+
+// copyBlobDigest streams the object under key from src to dst and reads it back from dst, the copy
+// only counts if both digests agree. It returns the hex encoded SHA-256 digest and the size.
+func copyBlobDigest(src, dst storage.Backend, key string) (string, int64, error) {
+	blob, err := src.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer blob.Close()
+
+	// the size of the opened object, a Stat could already see replaced content
+	size, err := blob.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, err = blob.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+
+	digest := sha256.New()
+
+	err = dst.Put(key, io.TeeReader(blob, digest), size)
+	if err != nil {
+		return "", 0, err
+	}
+
+	copied, err := dst.Get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer copied.Close()
+
+	check := sha256.New()
+
+	n, err := io.Copy(check, copied)
+	if err != nil {
+		return "", 0, err
+	}
+
+	sum := hex.EncodeToString(digest.Sum(nil))
+	if n != size || sum != hex.EncodeToString(check.Sum(nil)) {
+		return "", 0, fmt.Errorf("copy of %s differs from the source", key)
+	}
+
+	return sum, n, nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/oauth"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errUnverifiedEmail    = errors.New("the email address of the account must be verified with the provider")
+	errUnactivatedAccount = errors.New("activate the account with this email address before logging in with the provider")
+	errIdentityLinked     = errors.New("another account of the provider is already linked to the account with this email address")
+)
+
+// oauthStateCookie keeps the state, the PKCE verifier and the redirect of a login until the provider
+// sends the user back. The cookie cannot be set by other sites, so sending the state back proves the
+// login was started here.
+const oauthStateCookie = "oauth_state"
+
+// newOAuthProviders returns the providers with a client ID and secret, by name
+func newOAuthProviders(cfg *config) map[string]*oauth.Provider {
+	providers := map[string]*oauth.Provider{}
+
+	if cfg.oauth.google.clientID != "" {
+		providers[oauth.ProviderGoogle] = oauth.NewGoogle(cfg.oauth.google.clientID, cfg.oauth.google.clientSecret)
+	}
+	if cfg.oauth.github.clientID != "" {
+		providers[oauth.ProviderGitHub] = oauth.NewGitHub(cfg.oauth.github.clientID, cfg.oauth.github.clientSecret)
+	}
+
+	return providers
+}
+
+func (app *application) oauthCallbackURL(provider *oauth.Provider) string {
+	return app.config.publicURL + "/oauth/" + provider.Name + "/callback"
+}
+
+// allowedRedirect reports whether a login may send a token to redirect, which has to be on the
+// origin of -public-url or one of -cors-allowed-origins
+func (app *application) allowedRedirect(redirect string) bool {
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	origin := u.Scheme + "://" + u.Host
+
+	public, err := url.Parse(app.config.publicURL)
+	if err == nil && origin == public.Scheme+"://"+public.Host {
+		return true
+	}
+
+	for _, allowed := range app.config.cors.allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oauthLoginHandler sends the user to the provider to log in. With ?redirect_to= the token is handed to
+// that page in the fragment of the URL once they are back, otherwise the callback returns it as JSON.
+func (app *application) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	redirect := r.URL.Query().Get("redirect_to")
+	if redirect != "" && !app.allowedRedirect(redirect) {
+		app.badRequestResponse(w, r, errors.New("redirect_to must be on the origin of the API or an allowed CORS origin"))
+		return
+	}
+
+	state, err := randomString(16)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state + "." + verifier + "." + base64.RawURLEncoding.EncodeToString([]byte(redirect)),
+		Path:     "/oauth/" + provider.Name,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   strings.HasPrefix(app.config.publicURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, verifier, app.oauthCallbackURL(provider)), http.StatusFound)
+}
+
+// oauthCallbackHandler finishes a login the provider sent the user back from. The identity is linked to
+// the account with its email address, which is created if there is none, and a token is issued for it.
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("the login has expired, please start again"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth/" + provider.Name, MaxAge: -1})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		app.badRequestResponse(w, r, errors.New("the login has expired, please start again"))
+		return
+	}
+
+	state, verifier := parts[0], parts[1]
+	redirect, _ := base64.RawURLEncoding.DecodeString(parts[2])
+
+	qs := r.URL.Query()
+
+	if subtle.ConstantTimeCompare([]byte(qs.Get("state")), []byte(state)) != 1 {
+		app.badRequestResponse(w, r, errors.New("the login was not started here"))
+		return
+	}
+
+	if qs.Get("error") != "" || qs.Get("code") == "" {
+		app.oauthFailedResponse(w, r, string(redirect), http.StatusUnauthorized, "the login was denied")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), qs.Get("code"), verifier, app.oauthCallbackURL(provider))
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrDenied):
+			app.oauthFailedResponse(w, r, string(redirect), http.StatusUnauthorized, "the login was denied")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.oauthUser(identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail), errors.Is(err, errUnactivatedAccount), errors.Is(err, errIdentityLinked):
+			app.oauthFailedResponse(w, r, string(redirect), http.StatusConflict, err.Error())
+		case errors.Is(err, errSuspendedAccount):
+			app.oauthFailedResponse(w, r, string(redirect), http.StatusForbidden, "your user account has been suspended")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordLoginAttempt(&models.LoginAttempt{
+		UserID:    &user.ID,
+		Email:     user.Email,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+		Success:   true,
+	})
+
+	token, refresh, err := app.startSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(redirect) > 0 {
+		fragment := url.Values{
+			"authentication_token": {token.Plaintext},
+			"expiry":               {token.Expiry.Format(time.RFC3339)},
+			"refresh_token":        {refresh.Plaintext},
+		}
+		http.Redirect(w, r, string(redirect)+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oauthUser returns the user an identity is linked to. Identities which are not linked yet are linked
+// to the account with their email address, if it is verified. Accounts which were never activated are
+// left alone, as whoever registered them may not own the address. Without an account one is created,
+// activated right away and with a random password, which can be set with a password reset.
+func (app *application) oauthUser(identity *oauth.Identity) (*models.User, error) {
+	linked, err := app.models.Identities.Get(identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		user, err := app.models.Users.Get(linked.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.Suspended {
+			return nil, errSuspendedAccount
+		}
+		return user, nil
+	case !errors.Is(err, models.ErrRecordNotFound):
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := app.models.Users.GetByEmail(identity.Email)
+	switch {
+	case err == nil:
+		if !user.Activated {
+			return nil, errUnactivatedAccount
+		}
+	case errors.Is(err, models.ErrRecordNotFound):
+		user, err = app.provisionOAuthUser(identity)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if user.Suspended {
+		return nil, errSuspendedAccount
+	}
+
+	err = app.models.Identities.Insert(&models.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   user.ID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateIdentity):
+			return nil, errIdentityLinked
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (app *application) provisionOAuthUser(identity *oauth.Identity) (*models.User, error) {
+	name := identity.Name
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	for len(name) > 50 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	user := &models.User{
+		Name:      name,
+		Email:     identity.Email,
+		Activated: true,
+	}
+
+	password, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	app.logger.Info("user created from oauth login", "user_id", user.ID, "provider", identity.Provider)
+
+	return user, nil
+}
+
+// oauthFailedResponse tells the page of redirect why the login failed in the fragment of its URL,
+// without a redirect the error is returned as JSON
+func (app *application) oauthFailedResponse(w http.ResponseWriter, r *http.Request, redirect string, status int, message string) {
+	if redirect != "" {
+		http.Redirect(w, r, redirect+"#"+url.Values{"error": {message}}.Encode(), http.StatusFound)
+		return
+	}
+
+	app.errorResponse(w, r, status, message)
+}
+
+func (app *application) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	identities, err := app.models.Identities.GetAllForUser(app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteIdentityHandler unlinks the account at a provider, logging in with it again links it again
+func (app *application) deleteIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Identities.DeleteFromUser(chi.URLParam(r, "provider"), app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "identity successfully unlinked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// organizationInvitationTTL is how long an invitation can be accepted, the email says so too
+const organizationInvitationTTL = 7 * 24 * time.Hour
+
+// memberOrganization returns the organization of the id URL parameter if the user is a member of it,
+// otherwise it writes the response. Organizations of others are not found, as with files.
+func (app *application) memberOrganization(w http.ResponseWriter, r *http.Request) (*models.Organization, bool) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	organization, err := app.models.Organizations.GetForUser(id, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return organization, true
+}
+
+// ownedOrganization is memberOrganization for owners, other members are not permitted
+func (app *application) ownedOrganization(w http.ResponseWriter, r *http.Request) (*models.Organization, bool) {
+	organization, ok := app.memberOrganization(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if organization.Role != models.OrganizationRoleOwner {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return organization, true
+}
+
+// readUploadOrganization returns the organization of the organization_id form field, nil without one,
+// and how many bytes the upload may take up. Uploads into an organization are limited to what is left of
+// its quota, concurrent uploads may still go over it by one file each.
+func (app *application) readUploadOrganization(values url.Values, user *models.User, v *validator.Validator) (*models.Organization, int64, error) {
+	maxSize := app.config.files.maxSize
+
+	if !values.Has("organization_id") {
+		return nil, maxSize, nil
+	}
+
+	id, err := strconv.ParseInt(values.Get("organization_id"), 10, 64)
+	if err != nil {
+		v.AddError("organization_id", "must be an integer value")
+		return nil, maxSize, nil
+	}
+
+	organization, err := app.models.Organizations.GetForUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("organization_id", "must be an organization you are a member of")
+			return nil, maxSize, nil
+		default:
+			return nil, 0, err
+		}
+	}
+
+	if organization.Quota != nil {
+		usage, err := app.models.Files.GetOrganizationUsage(organization.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		left := *organization.Quota - usage
+		v.Check(left > 0, "organization_id", "has used up the quota of the organization")
+		maxSize = min(maxSize, left)
+	}
+
+	return organization, maxSize, nil
+}
+
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	organization := &models.Organization{Name: input.Name}
+	if app.config.organizations.quota > 0 {
+		quota := app.config.organizations.quota
+		organization.Quota = &quota
+	}
+
+	v := validator.New()
+	if models.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(organization, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/organizations/%d", organization.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": organization}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	organizations, err := app.models.Organizations.GetAllForUser(app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"organizations": organizations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showOrganizationHandler returns the organization with its members and how much of its quota is used
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(organization.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	usage, err := app.models.Files.GetOrganizationUsage(organization.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization, "members": members, "usage": usage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOrganizationHandler deletes the organization, the files of its space stay with the members who
+// uploaded them
+func (app *application) deleteOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.ownedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Organizations.Delete(organization.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "organization successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOrganizationFilesHandler lists the files of the space of the organization, members download them
+// by their code like everybody else
+func (app *application) listOrganizationFilesHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	filters := models.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: []string{"-id"},
+	}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	files, metadata, err := app.models.Files.WithContext(r.Context()).GetAllFromOrganization(organization.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": files, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeOrganizationMemberHandler lets owners remove members and members leave. The last owner cannot
+// leave, they delete the organization instead.
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	user_id_str := chi.URLParam(r, "user_id")
+	userID, err := strconv.ParseInt(user_id_str, 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != user.ID && organization.Role != models.OrganizationRoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(organization.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	owners := 0
+	var removed *models.OrganizationMember
+	for _, member := range members {
+		if member.Role == models.OrganizationRoleOwner {
+			owners++
+		}
+		if member.UserID == userID {
+			removed = member
+		}
+	}
+
+	if removed == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if removed.Role == models.OrganizationRoleOwner && owners == 1 {
+		v := validator.New()
+		v.AddError("user_id", "must not be the last owner of the organization")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.RemoveMember(organization.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOrganizationInvitationHandler emails an invitation into the organization. Addresses without an
+// account register with it first, the invitation can only be accepted by the account of the address.
+func (app *application) createOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.ownedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = models.OrganizationRoleMember
+	}
+
+	v := validator.New()
+	models.ValidateEmail(v, input.Email)
+	models.ValidateOrganizationRole(v, input.Role)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invitation, err := app.models.Organizations.NewInvitation(organization.ID, strings.ToLower(input.Email), input.Role, organizationInvitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the user of a JWT comes from its claims, the name may be older but is good enough for the email
+	inviter := app.contextGetUser(r)
+
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"organizationName": organization.Name,
+			"inviterName":      inviter.Name,
+			"role":             invitation.Role,
+			"invitationToken":  invitation.Token.Plaintext,
+		}
+
+		err = app.mailer.Send(invitation.Email, "organization_invitation.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the address containing the invitation"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) acceptOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user of a JWT may have an old email address, which could be someone else's by now
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	organization, err := app.models.Organizations.AcceptInvitation(input.TokenPlaintext, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token, or one for another email address")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteExpiredOrganizationInvitations removes the invitations which were not accepted in time
+func (app *application) deleteExpiredOrganizationInvitations() error {
+	deleted, err := app.models.Organizations.DeleteExpiredInvitations()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired organization invitations", "count", deleted)
+	}
+
+	return nil
+}
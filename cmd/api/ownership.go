@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// ownershipTransferTTL is how long the recipient has to accept a file, the email says so too
+const ownershipTransferTTL = 7 * 24 * time.Hour
+
+// transferUserFileOwnershipHandler offers a file to another registered user, who becomes its owner by
+// accepting it with confirmFileOwnershipTransferHandler. The blobs of files are stored under random keys,
+// so nothing is moved. The response is the same whether the address has an account or not.
+func (app *application) transferUserFileOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.WithContext(r.Context()).GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if file.Pending {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	recipient, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil && recipient.ID == user.ID:
+		v.AddError("email", "must not be your own email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case err == nil && recipient.Activated:
+		transfer, err := app.models.OwnershipTransfers.New(file.ID, user.ID, recipient.ID, ownershipTransferTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		logger := app.contextGetLogger(r)
+		app.background(logger, func() {
+			data := map[string]interface{}{
+				"senderName":    user.Name,
+				"fileName":      file.FullName(),
+				"transferToken": transfer.Token.Plaintext,
+			}
+
+			err := app.mailer.Send(recipient.Email, "ownership_transfer.tmpl", data)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		})
+	case err != nil && !errors.Is(err, models.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "if " + input.Email + " has an account, an email will be sent to it to accept the file"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkOwnershipPlan checks the file of transfer against the plan of user, who would own it, with a
+// *planLimitError if it goes over a limit
+func (app *application) checkOwnershipPlan(transfer *models.OwnershipTransfer, user *models.User) error {
+	file, err := app.models.Files.Get(transfer.FileID)
+	if err != nil {
+		return err
+	}
+
+	taken := *file
+	taken.UserID = &user.ID
+
+	// only the checks are needed, the content is not read
+	_, err = app.applyPlan(&taken, nil, file.Size)
+	return err
+}
+
+// confirmFileOwnershipTransferHandler makes the user the owner of the file of a transfer to them and
+// tells the previous owner. The file has to fit into the plan of the user like an upload of it would.
+func (app *application) confirmFileOwnershipTransferHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user of a JWT comes from its claims, without the download notifications setting the file takes
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	transfer, err := app.models.OwnershipTransfers.Get(input.TokenPlaintext, user)
+	if err == nil {
+		err = app.checkOwnershipPlan(transfer, user)
+	}
+	if err == nil {
+		transfer, err = app.models.OwnershipTransfers.Confirm(input.TokenPlaintext, user)
+	}
+	if err != nil {
+		var planErr *planLimitError
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired ownership transfer token, or the file is gone")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.As(err, &planErr):
+			v.AddError(planErr.key, planErr.message)
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	file, err := app.models.Files.WithContext(r.Context()).GetFromUser(transfer.FileID, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previousOwner, err := app.models.Users.Get(transfer.FromUserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	logger := app.contextGetLogger(r)
+	logger.Info("file ownership transferred", "file_id", file.ID, "from_user_id", previousOwner.ID, "to_user_id", user.ID)
+
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"recipientName": user.Name,
+			"fileName":      file.FullName(),
+		}
+
+		err := app.mailer.Send(previousOwner.Email, "ownership_transferred.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteExpiredOwnershipTransfers removes the ownership transfers which were not accepted in time
+func (app *application) deleteExpiredOwnershipTransfers() error {
+	deleted, err := app.models.OwnershipTransfers.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired ownership transfers", "count", deleted)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/pages"
+	"github.com/go-chi/chi/v5"
+)
+
+// downloadPage is the data of templates/download.html
+type downloadPage struct {
+	Name    string
+	Summary string
+	// Files lists the files of a transfer
+	Files       []fileMeta
+	Expiry      time.Time
+	PageURL     string
+	DownloadURL string
+	// ThumbnailURL is set for images with a thumbnail which anyone may see
+	ThumbnailURL string
+	// Protected hides the name and size of a password protected file until the password is known
+	Protected           bool
+	PasswordProtected   bool
+	PassphraseProtected bool
+	// Notice replaces the download button when the content cannot be downloaded
+	Notice string
+}
+
+// scanNotice explains why a file which has not passed the malware scan cannot be downloaded
+func scanNotice(file *models.File) string {
+	switch file.ScanStatus {
+	case models.ScanStatusClean, models.ScanStatusSkipped:
+		return ""
+	case models.ScanStatusPending:
+		return "This file is still being scanned for malware, please try again shortly."
+	default:
+		return "This file has been removed because it failed the malware scan."
+	}
+}
+
+// downloadPageHandler renders a page for browsers showing what is behind a code, the download button
+// goes to /files/{code}. The page is never counted as a download.
+func (app *application) downloadPageHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	page := downloadPage{
+		PageURL:     app.config.publicURL + "/d/" + url.PathEscape(code),
+		DownloadURL: app.config.publicURL + "/files/" + url.PathEscape(code),
+	}
+
+	file, err := app.models.Files.GetFromCode(code)
+	switch {
+	case err == nil:
+		page.Name = file.Name
+		page.Summary = pages.FormatSize(file.Size)
+		if file.Description != "" {
+			page.Summary += " - " + file.Description
+		}
+		page.Expiry = file.Expiry
+		page.Protected = file.PasswordProtected
+		page.PasswordProtected = file.PasswordProtected
+		page.PassphraseProtected = file.PassphraseProtected
+		page.Notice = scanNotice(file)
+		if page.Notice == "" {
+			ip := app.clientIP(r)
+			if !file.AllowsIP(ip) {
+				page.Notice = "This file cannot be downloaded from your network."
+			} else if _, blocked := app.blockedCountry(r, ip, file); blocked {
+				page.Notice = "This file cannot be downloaded from your country."
+			}
+		}
+		if file.HasThumbnail && !file.PasswordProtected && page.Notice == "" {
+			page.ThumbnailURL = page.DownloadURL + "/thumbnail"
+		}
+	case errors.Is(err, models.ErrRecordNotFound):
+		ok, err := app.readTransferPage(code, &page)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.renderPage(w, r, http.StatusNotFound, "not_found.html", nil)
+			return
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.renderPage(w, r, http.StatusOK, "download.html", page)
+}
+
+// redirectCodeHandler sends the short link /r/{code} on to the page of the code for browsers and to the
+// download for anything else. The code is not looked up here, where it goes does that and guards against
+// guessing.
+func (app *application) redirectCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	target := app.config.publicURL + "/files/" + url.PathEscape(code)
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		target = app.config.publicURL + "/d/" + url.PathEscape(code)
+	}
+
+	// the code is part of the URL and must not leak to other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// readTransferPage fills in page for the transfer with code, it reports false if there is none
+func (app *application) readTransferPage(code string, page *downloadPage) (bool, error) {
+	transfer, err := app.models.Transfers.GetFromCode(code)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	files, err := app.models.Files.GetAllFromTransfer(transfer)
+	if err != nil {
+		return false, err
+	}
+
+	if len(files) == 0 {
+		return false, nil
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size
+		page.Files = append(page.Files, newFileMeta(file))
+
+		if page.Notice == "" {
+			page.Notice = scanNotice(file)
+		}
+	}
+
+	page.Name = fmt.Sprintf("%d files", len(files))
+	page.Summary = pages.FormatSize(size) + " as a zip archive"
+	page.Expiry = transfer.Expiry
+
+	return true, nil
+}
+
+func (app *application) renderPage(w http.ResponseWriter, r *http.Request, status int, templateFile string, data interface{}) {
+	page, err := pages.Render(templateFile, data)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// the code is part of the URL and must not leak to other sites
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+
+	_, err = w.Write(page)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/Li-Elias/File-Transfer/internal/webauthn"
+	"github.com/go-chi/chi/v5"
+)
+
+// passkeyChallengeTTL is how long the options of a registration or login can be answered
+const passkeyChallengeTTL = 5 * time.Minute
+
+// newRelyingParty returns the relying party of -webauthn-rp-id and -webauthn-origins, which default
+// to the host and origin of -public-url
+func newRelyingParty(cfg *config) (*webauthn.RelyingParty, error) {
+	u, err := url.Parse(cfg.publicURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("public-url must be an absolute URL, not %q", cfg.publicURL)
+	}
+
+	rp := &webauthn.RelyingParty{
+		ID:      cfg.webauthn.rpID,
+		Name:    "File-Transfer",
+		Origins: cfg.webauthn.origins,
+	}
+
+	if rp.ID == "" {
+		rp.ID = u.Hostname()
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{u.Scheme + "://" + u.Host}
+	}
+
+	return rp, nil
+}
+
+// passkeyUserHandle is the user ID passkeys store, the account ID as it does not tell anything about the user
+func passkeyUserHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// createPasskeyOptionsHandler returns the options to pass to navigator.credentials.create, the
+// credential it creates is registered with createPasskeyHandler
+func (app *application) createPasskeyOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	exclude := [][]byte{}
+	for _, passkey := range passkeys {
+		exclude = append(exclude, passkey.CredentialID)
+	}
+
+	challenge, err := app.models.Passkeys.NewChallenge(&user.ID, models.ScopePasskeyRegistration, passkeyChallengeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	passkeyUser := webauthn.User{ID: passkeyUserHandle(user.ID), Name: user.Email, DisplayName: user.Name}
+	options := app.relyingParty.CreationOptions(passkeyUser, challenge, int(passkeyChallengeTTL.Milliseconds()), exclude)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"options": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string `json:"name"`
+		Credential struct {
+			Response webauthn.AttestationResponse `json:"response"`
+		} `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	passkey := &models.Passkey{
+		UserID: user.ID,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+	if models.ValidatePasskey(v, passkey); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	response := input.Credential.Response
+
+	challenge, err := webauthn.Challenge(response.ClientDataJSON)
+	if err == nil {
+		var userID *int64
+		userID, err = app.models.Passkeys.ConsumeChallenge(challenge, models.ScopePasskeyRegistration)
+		if err == nil && (userID == nil || *userID != user.ID) {
+			err = models.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, webauthn.ErrVerification), errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("credential", "must answer unexpired options from POST /users/passkeys/options")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credential, err := app.relyingParty.VerifyRegistration(challenge, response)
+	if err != nil {
+		v.AddError("credential", "could not be verified")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	passkey.CredentialID = credential.ID
+	passkey.PublicKey = credential.PublicKey
+	passkey.SignCount = credential.SignCount
+
+	err = app.models.Passkeys.Insert(passkey)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicatePasskey):
+			v.AddError("credential", "is already registered")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"passkey": passkey}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"passkeys": passkeys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Passkeys.DeleteFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "passkey successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasskeyLoginOptionsHandler returns the options to pass to navigator.credentials.get, the
+// credential it returns is exchanged for a token with createPasskeyAuthenticationTokenHandler
+func (app *application) createPasskeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := app.models.Passkeys.NewChallenge(nil, models.ScopePasskeyLogin, passkeyChallengeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	options := app.relyingParty.RequestOptions(challenge, int(passkeyChallengeTTL.Milliseconds()))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"options": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasskeyAuthenticationTokenHandler logs in with a passkey instead of the email address and
+// password. Passkeys cannot be guessed, so the lockout of failed password logins does not apply.
+func (app *application) createPasskeyAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Credential struct {
+			RawID    webauthn.Base64URL         `json:"rawId"`
+			Response webauthn.AssertionResponse `json:"response"`
+		} `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	response := input.Credential.Response
+
+	var passkey *models.Passkey
+
+	challenge, err := webauthn.Challenge(response.ClientDataJSON)
+	if err == nil {
+		_, err = app.models.Passkeys.ConsumeChallenge(challenge, models.ScopePasskeyLogin)
+	}
+	if err == nil {
+		passkey, err = app.models.Passkeys.GetByCredentialID(input.Credential.RawID)
+	}
+	if err == nil && response.UserHandle != nil && string(response.UserHandle) != string(passkeyUserHandle(passkey.UserID)) {
+		err = models.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, webauthn.ErrVerification), errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credential := &webauthn.Credential{ID: passkey.CredentialID, PublicKey: passkey.PublicKey, SignCount: passkey.SignCount}
+
+	signCount, err := app.relyingParty.VerifyAssertion(challenge, credential, response)
+	if err == nil {
+		err = app.models.Passkeys.UpdateSignCount(passkey, signCount)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, webauthn.ErrVerification), errors.Is(err, models.ErrEditConflict):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(passkey.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	app.recordLoginAttempt(&models.LoginAttempt{
+		UserID:    &user.ID,
+		Email:     user.Email,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+		Success:   true,
+	})
+
+	token, refresh, err := app.startSession(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteExpiredPasskeyChallenges removes the options which were never answered
+func (app *application) deleteExpiredPasskeyChallenges() error {
+	deleted, err := app.models.Passkeys.DeleteExpiredChallenges()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired passkey challenges", "count", deleted)
+	}
+
+	return nil
+}
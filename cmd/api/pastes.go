@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// maxPasteSize bounds the content of a paste, a JSON body is bounded by readJSON to the same size
+const maxPasteSize = 1_048_576
+
+// pasteLanguage is a language a paste can be created in, its content type lets viewers highlight the syntax
+type pasteLanguage struct {
+	name        string
+	contentType string
+	extension   string
+}
+
+// pasteLanguages are shown inline, so types browsers could run scripts from, such as HTML, SVG
+// and XML, are left out on purpose
+var pasteLanguages = []pasteLanguage{
+	{"text", "text/plain", ".txt"},
+	{"c", "text/x-c", ".c"},
+	{"cpp", "text/x-c++", ".cpp"},
+	{"csharp", "text/x-csharp", ".cs"},
+	{"css", "text/css", ".css"},
+	{"diff", "text/x-diff", ".diff"},
+	{"go", "text/x-go", ".go"},
+	{"ini", "text/x-ini", ".ini"},
+	{"java", "text/x-java", ".java"},
+	{"javascript", "text/javascript", ".js"},
+	{"json", "application/json", ".json"},
+	{"kotlin", "text/x-kotlin", ".kt"},
+	{"markdown", "text/markdown", ".md"},
+	{"php", "text/x-php", ".php"},
+	{"python", "text/x-python", ".py"},
+	{"ruby", "text/x-ruby", ".rb"},
+	{"rust", "text/x-rust", ".rs"},
+	{"shell", "text/x-shellscript", ".sh"},
+	{"sql", "application/sql", ".sql"},
+	{"toml", "application/toml", ".toml"},
+	{"typescript", "text/x-typescript", ".ts"},
+	{"yaml", "application/yaml", ".yaml"},
+}
+
+func findPasteLanguage(name string) (pasteLanguage, bool) {
+	for _, language := range pasteLanguages {
+		if language.name == name {
+			return language, true
+		}
+	}
+	return pasteLanguage{}, false
+}
+
+func pasteLanguageNames() string {
+	names := make([]string, len(pasteLanguages))
+	for i, language := range pasteLanguages {
+		names[i] = language.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// pasteInput is a paste as it is sent in a JSON body, or as the query parameters of a text body
+type pasteInput struct {
+	Content          string   `json:"content"`
+	Name             string   `json:"name"`
+	Language         string   `json:"language"`
+	Description      string   `json:"description"`
+	Tags             []string `json:"tags"`
+	ExpiresIn        string   `json:"expires_in"`
+	MaxDownloads     *int     `json:"max_downloads"`
+	Password         string   `json:"password"`
+	Passphrase       string   `json:"passphrase"`
+	NotifyOnDownload bool     `json:"notify_on_download"`
+}
+
+// readPasteText reads a text body as the content of a paste, everything else comes from the query string
+func (app *application) readPasteText(w http.ResponseWriter, r *http.Request, input *pasteInput, v *validator.Validator) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasteSize)
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxPasteSize)
+		}
+		return err
+	}
+
+	qs := r.URL.Query()
+
+	input.Content = string(content)
+	input.Name = qs.Get("name")
+	input.Language = qs.Get("language")
+	input.Description = qs.Get("description")
+	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.ExpiresIn = qs.Get("expires_in")
+	input.Password = qs.Get("password")
+	input.Passphrase = qs.Get("passphrase")
+	input.NotifyOnDownload = app.readBool(qs, "notify_on_download", false, v)
+
+	if qs.Has("max_downloads") {
+		maxDownloads := app.readInt(qs, "max_downloads", 0, v)
+		input.MaxDownloads = &maxDownloads
+	}
+
+	return nil
+}
+
+// createPasteHandler stores text sent as is, either as the content of a JSON body or as a text/* body of
+// its own, as a file which is shown in the browser. The language decides the name and content type.
+func (app *application) createPasteHandler(w http.ResponseWriter, r *http.Request) {
+	var input pasteInput
+
+	v := validator.New()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	case strings.HasPrefix(mediaType, "text/"):
+		err := app.readPasteText(w, r, &input, v)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	values := url.Values{
+		"expires_in": {input.ExpiresIn},
+		"password":   {input.Password},
+		"passphrase": {input.Passphrase},
+	}
+
+	ttl := app.readExpiresIn(values, v)
+
+	if input.Language == "" {
+		input.Language = "text"
+	}
+	language, ok := findPasteLanguage(input.Language)
+	v.Check(ok, "language", "must be one of "+pasteLanguageNames())
+
+	v.Check(input.Content != "", "content", "must be provided")
+	v.Check(len(input.Content) <= maxPasteSize, "content", fmt.Sprintf("must not be more than %d bytes long", maxPasteSize))
+	v.Check(utf8.ValidString(input.Content), "content", "must be valid UTF-8 text")
+
+	if input.Name == "" {
+		input.Name = "paste" + language.extension
+	}
+
+	user := app.contextGetUser(r)
+
+	new_file := app.newFile(user, input.Name, int64(len(input.Content)), ttl)
+	new_file.Paste = true
+	new_file.Description = input.Description
+	new_file.MaxDownloads = input.MaxDownloads
+	new_file.NotifyOnDownload = input.NotifyOnDownload
+	if input.Tags != nil {
+		new_file.Tags = models.NormalizeTags(input.Tags)
+	}
+
+	err := app.readFilePassword(values, new_file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	opts := storeOptions{
+		passphrase:  app.readFilePassphrase(values, v),
+		contentType: language.contentType + "; charset=utf-8",
+		logger:      app.contextGetLogger(r),
+		ctx:         r.Context(),
+	}
+
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.createFile(new_file, strings.NewReader(input.Content), new_file.Size, opts)
+	if err != nil {
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"file": new_file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// planLimitError is an upload which would go over a limit of the plan of its user, key is the field
+// the validation error is reported for
+type planLimitError struct {
+	key     string
+	message string
+}
+
+func (e *planLimitError) Error() string {
+	return e.key + ": " + e.message
+}
+
+// planLimitReader fails with err once more than n bytes are read, like http.MaxBytesReader
+type planLimitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *planLimitReader) Read(p []byte) (int, error) {
+	// one byte more than is left shows whether the content goes on
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+
+	n = int(l.n)
+	l.n = 0
+	return n, l.err
+}
+
+// userPlan returns the plan of the user with userID, the one of -default-plan if they are not on one and
+// nil for the limits of the server. It is what a user is downgraded to when their subscription ends.
+func (app *application) userPlan(userID int64) (*models.Plan, error) {
+	plan, err := app.models.Plans.GetForUser(userID)
+	if err != nil || plan != nil || app.config.plans.defaultName == "" {
+		return plan, err
+	}
+
+	plan, err = app.models.Plans.GetByName(app.config.plans.defaultName)
+	if errors.Is(err, models.ErrRecordNotFound) {
+		// the plan was deleted since the server started
+		return nil, nil
+	}
+
+	return plan, err
+}
+
+// applyPlan checks file against the plan of its user and returns r limited to the bytes the plan has left
+// for it, size is -1 if unknown. Files without a user or users without a plan only have the limits of the
+// server. Like the quota of organizations, concurrent uploads may still go over the storage by one file each.
+func (app *application) applyPlan(file *models.File, r io.Reader, size int64) (io.Reader, error) {
+	return app.limitToPlan(file, false, r, size)
+}
+
+// applyPlanReplacing is applyPlan for new content of file, which is already one of the files of its user.
+// The content it replaces is left out of their storage.
+func (app *application) applyPlanReplacing(file *models.File, r io.Reader, size int64) (io.Reader, error) {
+	return app.limitToPlan(file, true, r, size)
+}
+
+func (app *application) limitToPlan(file *models.File, replacing bool, r io.Reader, size int64) (io.Reader, error) {
+	if file.UserID == nil {
+		return r, nil
+	}
+
+	plan, err := app.userPlan(*file.UserID)
+	if err != nil || plan == nil {
+		return r, err
+	}
+
+	err = planExpiryError(plan, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.MaxFiles == nil && plan.MaxStorage == nil && plan.MaxFileSize == nil {
+		return r, nil
+	}
+
+	usage, count, err := app.models.Files.GetUserUsage(*file.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if replacing {
+		usage -= file.Size
+		count--
+	}
+
+	if plan.MaxFiles != nil && count >= *plan.MaxFiles {
+		return nil, &planLimitError{"file", fmt.Sprintf("must not be more than the %d files of the %s plan", *plan.MaxFiles, plan.Name)}
+	}
+
+	limitErr := &planLimitError{key: "file_size"}
+	limit := int64(-1)
+
+	if plan.MaxFileSize != nil {
+		limit = *plan.MaxFileSize
+		limitErr.message = fmt.Sprintf("must not be more than %d bytes big on the %s plan", limit, plan.Name)
+	}
+	if plan.MaxStorage != nil {
+		left := max(*plan.MaxStorage-usage, 0)
+		if limit < 0 || left < limit {
+			limit = left
+			limitErr.message = fmt.Sprintf("must not be more than the %d bytes left of the storage of the %s plan", limit, plan.Name)
+		}
+	}
+
+	if limit < 0 {
+		return r, nil
+	}
+	if size > limit {
+		return nil, limitErr
+	}
+
+	return &planLimitReader{r: r, n: limit, err: limitErr}, nil
+}
+
+// checkPlanExpiry returns a *planLimitError if file expires later than the plan of its user allows
+func (app *application) checkPlanExpiry(file *models.File) error {
+	if file.UserID == nil {
+		return nil
+	}
+
+	plan, err := app.userPlan(*file.UserID)
+	if err != nil || plan == nil {
+		return err
+	}
+
+	return planExpiryError(plan, file)
+}
+
+func planExpiryError(plan *models.Plan, file *models.File) error {
+	if plan.MaxExpiry != nil && time.Until(file.Expiry) > *plan.MaxExpiry {
+		return &planLimitError{"expires_in", fmt.Sprintf("must not be more than %s on the %s plan", plan.MaxExpiry, plan.Name)}
+	}
+	return nil
+}
+
+// readPlanInput reads the fields of a plan, max_expiry is a duration such as 720h
+func (app *application) readPlanInput(w http.ResponseWriter, r *http.Request, plan *models.Plan, v *validator.Validator) error {
+	var input struct {
+		Name          string  `json:"name"`
+		MaxFileSize   *int64  `json:"max_file_size"`
+		MaxStorage    *int64  `json:"max_storage"`
+		MaxExpiry     *string `json:"max_expiry"`
+		MaxFiles      *int    `json:"max_files"`
+		StripePriceID *string `json:"stripe_price_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		return err
+	}
+
+	plan.Name = input.Name
+	plan.MaxFileSize = input.MaxFileSize
+	plan.MaxStorage = input.MaxStorage
+	plan.MaxFiles = input.MaxFiles
+	plan.StripePriceID = input.StripePriceID
+	plan.MaxExpiry = nil
+
+	if input.MaxExpiry != nil {
+		d, err := time.ParseDuration(*input.MaxExpiry)
+		if err != nil {
+			v.AddError("max_expiry", "must be a valid duration")
+		} else {
+			plan.MaxExpiry = &d
+		}
+	}
+
+	return nil
+}
+
+func (app *application) listPlansHandler(w http.ResponseWriter, r *http.Request) {
+	plans, err := app.models.Plans.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"plans": plans}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPlanHandler(w http.ResponseWriter, r *http.Request) {
+	plan := &models.Plan{}
+
+	v := validator.New()
+
+	err := app.readPlanInput(w, r, plan, v)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if models.ValidatePlan(v, plan); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Plans.Insert(plan)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicatePlanName):
+			v.AddError("name", "a plan with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrDuplicatePlanPrice):
+			v.AddError("stripe_price_id", "is the price of another plan")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePlanHandler replaces the limits of a plan, a limit left out or null is removed. The files already
+// over lowered limits are kept, only further uploads are refused.
+func (app *application) updatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	plan, err := app.models.Plans.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	err = app.readPlanInput(w, r, plan, v)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if models.ValidatePlan(v, plan); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Plans.Update(plan)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicatePlanName):
+			v.AddError("name", "a plan with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrDuplicatePlanPrice):
+			v.AddError("stripe_price_id", "is the price of another plan")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletePlanHandler removes a plan, its users get -default-plan or the limits of the server
+func (app *application) deletePlanHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Plans.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "plan successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPlanHandler assigns a plan to a user, a plan_id of null gives them -default-plan or the limits of
+// the server. The webhook of a subscription of the user replaces it once the subscription changes.
+func (app *application) updateUserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		PlanID *int64 `json:"plan_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var plan *models.Plan
+	if input.PlanID != nil {
+		plan, err = app.models.Plans.Get(*input.PlanID)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				v := validator.New()
+				v.AddError("plan_id", "does not exist")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.models.Plans.SetForUser(id, input.PlanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// directUploadGrace is how long a direct upload can still be completed after its URL expired,
+// pending files older than that are removed by the janitor
+const directUploadGrace = time.Hour
+
+// directUploadKey is where the client uploads the content of a pending file. It is copied to the
+// path of the file on completion, so a URL which is still valid cannot replace checked content.
+func directUploadKey(file *models.File) string {
+	return fmt.Sprintf("uploads/direct/%d", file.ID)
+}
+
+// directUploader returns the storage backend if clients can upload to it directly. Content the
+// server never sees cannot be encrypted with the master key, so encryption rules them out.
+func (app *application) directUploader() storage.DirectUploader {
+	uploader, ok := app.storage.(storage.DirectUploader)
+	if !ok || app.config.encryption.masterKey != nil {
+		return nil
+	}
+
+	return uploader
+}
+
+// presignFileHandler creates a pending file and returns a URL the content can be PUT to
+// without going through the API, POST /users/files/{id}/complete makes it downloadable
+func (app *application) presignFileHandler(w http.ResponseWriter, r *http.Request) {
+	uploader := app.directUploader()
+	if uploader == nil {
+		app.directUploadsUnavailableResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name             string   `json:"name"`
+		Size             int64    `json:"size"`
+		Description      string   `json:"description"`
+		Tags             []string `json:"tags"`
+		ExpiresIn        string   `json:"expires_in"`
+		MaxDownloads     *int     `json:"max_downloads"`
+		Password         string   `json:"password"`
+		ChecksumSHA256   string   `json:"checksum_sha256"`
+		NotifyOnDownload bool     `json:"notify_on_download"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	values := url.Values{
+		"expires_in":      {input.ExpiresIn},
+		"password":        {input.Password},
+		"checksum_sha256": {input.ChecksumSHA256},
+	}
+
+	v := validator.New()
+
+	v.Check(input.Size >= 0, "file_size", "must not be negative")
+	ttl := app.readExpiresIn(values, v)
+
+	user := app.contextGetUser(r)
+
+	new_file := app.newFile(user, input.Name, input.Size, ttl)
+	new_file.Description = input.Description
+	new_file.MaxDownloads = input.MaxDownloads
+	new_file.NotifyOnDownload = input.NotifyOnDownload
+	new_file.ChecksumSHA256 = app.readFileChecksum(values, v)
+	new_file.Pending = true
+	if input.Tags != nil {
+		new_file.Tags = models.NormalizeTags(input.Tags)
+	}
+
+	err = app.readFilePassword(values, new_file, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the content does not go through the API, so the plan can only be checked against the declared size
+	_, err = app.applyPlan(new_file, nil, input.Size)
+	if err != nil {
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Files.Insert(new_file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	upload_url, err := uploader.PresignPut(directUploadKey(new_file), app.config.presign.expiry)
+	if err != nil {
+		app.discardFile(app.contextGetLogger(r), new_file)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	upload := envelope{
+		"url":        upload_url,
+		"method":     http.MethodPut,
+		"expires_at": time.Now().Add(app.config.presign.expiry).UTC().Truncate(time.Second),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"file": new_file, "upload": upload}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeFileHandler checks the directly uploaded content of a pending file like storeFileContent
+// checks uploads. Failed checks remove the content, the file stays pending so it can be uploaded again.
+func (app *application) completeFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	uploader := app.directUploader()
+	if uploader == nil {
+		app.directUploadsUnavailableResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	if !file.Pending {
+		v.AddError("file", "has already been completed")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key := directUploadKey(file)
+
+	info, err := app.storage.Stat(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrObjectNotFound):
+			v.AddError("file", "has not been uploaded yet")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if info.Size != file.Size {
+		app.deleteDirectUpload(file)
+		v.AddError("file_size", fmt.Sprintf("does not match the declared size of %d bytes", file.Size))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = uploader.Copy(key, file.Path)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.deleteDirectUpload(file)
+
+	err = app.checkDirectUpload(file)
+	if err != nil {
+		app.deleteBlob(app.contextGetLogger(r), file)
+		app.storeFilePartErrorResponse(w, r, err)
+		return
+	}
+
+	file.ScanStatus = app.scanStatus(file)
+
+	err = app.models.Files.Complete(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.queueScan()
+	app.queueThumbnail(app.contextGetLogger(r), file)
+	app.fileEvent(models.EventFileUploaded, file)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkDirectUpload detects the content type of a completed upload and applies the file type rules to
+// it. The content is only read completely if the client gave a checksum, which is then verified.
+func (app *application) checkDirectUpload(file *models.File) error {
+	blob, err := app.storage.Get(file.Path)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(blob, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	file.ContentType = detectContentType(file.Name, head[:n])
+
+	err = app.checkFileType(file.Name, file.ContentType)
+	if err != nil {
+		return err
+	}
+
+	if file.ChecksumSHA256 == "" {
+		return nil
+	}
+
+	_, err = blob.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, &checksumReader{r: blob, hash: sha256.New(), expected: file.ChecksumSHA256})
+	return err
+}
+
+// deleteDirectUpload removes what the client uploaded for a pending file
+func (app *application) deleteDirectUpload(file *models.File) {
+	err := app.storage.Delete(directUploadKey(file))
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		app.logger.Error(err.Error(), "file_id", file.ID)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// showProfileHandler returns the user with their settings, the defaults their uploads get
+func (app *application) showProfileHandler(w http.ResponseWriter, r *http.Request) {
+	// the user of a JWT comes from its claims, which may be older than the last PATCH /users/me
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	settings, err := app.models.UserSettings.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// plan is null for the limits of the server, usage is what counts against it
+	plan, err := app.userPlan(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	usage, count, err := app.models.Files.GetUserUsage(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user":     user,
+		"settings": settings,
+		"plan":     plan,
+		"usage":    envelope{"storage": usage, "files": count},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateProfileHandler changes the name, the notifications and the upload defaults of the user. An
+// empty default_expiry or code_style and a default_max_downloads of 0 go back to the configured defaults.
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name                *string `json:"name"`
+		NotifyOnDownload    *bool   `json:"notify_on_download"`
+		DefaultExpiry       *string `json:"default_expiry"`
+		DefaultMaxDownloads *int    `json:"default_max_downloads"`
+		CodeStyle           *string `json:"code_style"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// the user of a JWT comes from its claims, without the password hash Update writes back
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	settings, err := app.models.UserSettings.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.NotifyOnDownload != nil {
+		user.NotifyOnDownload = *input.NotifyOnDownload
+	}
+
+	if input.DefaultExpiry != nil {
+		if *input.DefaultExpiry == "" {
+			settings.DefaultExpiry = nil
+		} else {
+			expiry, err := time.ParseDuration(*input.DefaultExpiry)
+			if err != nil {
+				v.AddError("default_expiry", "must be a duration such as 30m or 24h")
+			} else {
+				// the settings keep whole seconds
+				expiry = expiry.Truncate(time.Second)
+				settings.DefaultExpiry = &expiry
+			}
+		}
+	}
+	if input.DefaultMaxDownloads != nil {
+		if *input.DefaultMaxDownloads == 0 {
+			settings.DefaultMaxDownloads = nil
+		} else {
+			settings.DefaultMaxDownloads = input.DefaultMaxDownloads
+		}
+	}
+	if input.CodeStyle != nil {
+		if *input.CodeStyle == "" {
+			settings.CodeStyle = nil
+		} else {
+			settings.CodeStyle = input.CodeStyle
+		}
+	}
+
+	models.ValidateUser(v, user)
+	if models.ValidateUserSettings(v, settings, app.config.files.maxExpiry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.UserSettings.Upsert(settings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "settings": settings}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
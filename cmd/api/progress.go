@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// progressInterval is the least time between two progress events of an upload
+	progressInterval = 250 * time.Millisecond
+	// progressHeartbeat keeps proxies from closing idle event streams
+	progressHeartbeat = 15 * time.Second
+)
+
+type uploadEvent struct {
+	name string
+	data envelope
+}
+
+// uploadProgress fans the progress of tus uploads out to the event streams watching them.
+// It only knows about the requests handled by this process.
+type uploadProgress struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan uploadEvent]struct{}
+}
+
+func newUploadProgress() *uploadProgress {
+	return &uploadProgress{watchers: make(map[string]map[chan uploadEvent]struct{})}
+}
+
+// watch returns a channel of the events of upload id, it has to be released with the returned function
+func (p *uploadProgress) watch(id string) (<-chan uploadEvent, func()) {
+	ch := make(chan uploadEvent, 1)
+
+	p.mu.Lock()
+	if p.watchers[id] == nil {
+		p.watchers[id] = make(map[chan uploadEvent]struct{})
+	}
+	p.watchers[id][ch] = struct{}{}
+	p.mu.Unlock()
+
+	return ch, func() {
+		p.mu.Lock()
+		delete(p.watchers[id], ch)
+		if len(p.watchers[id]) == 0 {
+			delete(p.watchers, id)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// publish never blocks, a watcher which has not read the previous event only gets the latest one
+func (p *uploadProgress) publish(id string, event uploadEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ch := range p.watchers[id] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- event
+	}
+}
+
+func (p *uploadProgress) publishOffset(upload *models.Upload, offset int64) {
+	p.publish(upload.ID, uploadEvent{
+		name: "progress",
+		data: envelope{"offset": offset, "length": upload.Length},
+	})
+}
+
+// progressReader publishes how much of a chunk storage has read, which is what has reached it
+// rather than what has left the client
+type progressReader struct {
+	r        *countingReader
+	progress *uploadProgress
+	upload   *models.Upload
+	last     time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+
+	if time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.progress.publishOffset(p.upload, p.upload.Offset+p.r.n)
+	}
+
+	return n, err
+}
+
+// uploadEventsHandler streams the progress of a tus upload as server-sent events: progress events
+// with the offset stored so far, then a complete event with the file or an error event
+func (app *application) uploadEventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.GetFromUser(chi.URLParam(r, "id"), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	events, release := app.progress.watch(upload.ID)
+	defer release()
+
+	// the stream lasts as long as the upload, not just the usual write timeout
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event uploadEvent) error {
+		data, err := json.Marshal(event.data)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	err = send(uploadEvent{name: "progress", data: envelope{"offset": upload.Offset, "length": upload.Length}})
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(progressHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			err := send(event)
+			if err != nil || event.name != "progress" {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the white border around a code in modules, scanners need at least 4
+const qrQuietZone = 4
+
+// publicFileURL is the URL anyone with the code downloads the file from
+func (app *application) publicFileURL(file *models.File) string {
+	return app.config.publicURL + "/files/" + url.PathEscape(file.Code)
+}
+
+// qrSVG draws every dark module of code as a unit square, the image is scaled by its width and height
+func qrSVG(code *qr.Code, scale int) []byte {
+	size := code.Size + 2*qrQuietZone
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size*scale, size*scale, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			}
+		}
+	}
+
+	b.WriteString(`"/></svg>`)
+
+	return []byte(b.String())
+}
+
+// getUserFileQRHandler returns a QR code of the download URL as a png or, with format=svg, an svg image
+func (app *application) getUserFileQRHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	format := app.readString(qs, "format", "png")
+	scale := app.readInt(qs, "scale", 8, v)
+
+	v.Check(validator.PermittedValue(format, "png", "svg"), "format", "must be png or svg")
+	v.Check(scale > 0 && scale <= 32, "scale", "must be between 1 and 32")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	code, err := qr.Encode(app.publicFileURL(file), qr.M)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	code.Scale = scale
+
+	var image []byte
+	switch format {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		image = qrSVG(code, scale)
+	default:
+		w.Header().Set("Content-Type", "image/png")
+		image = code.PNG()
+	}
+
+	// the code of the file changes when it is regenerated
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+
+	_, err = w.Write(image)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/httprate"
+)
+
+// rateLimit allows requests per window and client IP address, a zero rateLimit allows everything
+type rateLimit struct {
+	requests int
+	window   time.Duration
+}
+
+func (l rateLimit) String() string {
+	if l.requests == 0 {
+		return "0"
+	}
+
+	// 1m instead of 1m0s
+	window := l.window.String()
+	if strings.HasSuffix(window, "m0s") {
+		window = strings.TrimSuffix(window, "0s")
+	}
+	if strings.HasSuffix(window, "h0m") {
+		window = strings.TrimSuffix(window, "0m")
+	}
+
+	return fmt.Sprintf("%d/%s", l.requests, window)
+}
+
+// parseRateLimit reads a limit like 10/1m, 0 disables the limit
+func parseRateLimit(val string) (rateLimit, error) {
+	if val == "0" || val == "" {
+		return rateLimit{}, nil
+	}
+
+	requests, window, ok := strings.Cut(val, "/")
+	if !ok {
+		return rateLimit{}, errors.New("must be requests/window, such as 10/1m, or 0")
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return rateLimit{}, errors.New("must allow at least one request")
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return rateLimit{}, errors.New("must have a positive window, such as 1m")
+	}
+
+	return rateLimit{requests: n, window: d}, nil
+}
+
+// Set makes rateLimit a flag.Value
+func (l *rateLimit) Set(val string) error {
+	parsed, err := parseRateLimit(val)
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// rateLimitFlag registers a flag for a rate limit
+func rateLimitFlag(l *rateLimit, name string, value rateLimit, usage string) {
+	*l = value
+	flag.Var(l, name, usage+" as requests/window, 0 disables the limit")
+}
+
+// rateLimit returns a middleware enforcing l, each use of it counts separately. Clients are told apart by
+// httprate.KeyByIP, which keys on r.RemoteAddr as realIP left it and puts IPv6 addresses of one /64 together.
+func (app *application) rateLimit(l rateLimit) func(http.Handler) http.Handler {
+	if l.requests == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return httprate.Limit(
+		l.requests,
+		l.window,
+		httprate.WithKeyFuncs(httprate.KeyByIP),
+		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			app.tooManyRequests(w, r)
+		}),
+	)
+}
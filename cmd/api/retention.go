@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// applyRetentionPolicies shortens the expiry of the files which outlived their retention policy, the
+// janitor runs it before deleteExpiredFiles so they are deleted and announced as expired in the same run
+func (app *application) applyRetentionPolicies() error {
+	n, err := app.models.RetentionPolicies.Apply()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Info("retention policies applied", "files", n)
+	}
+
+	return nil
+}
+
+// readRetentionPolicyInput reads and validates a retention policy, max_lifetime is a duration such as 720h.
+// It writes the response and returns nil if the input is invalid.
+func (app *application) readRetentionPolicyInput(w http.ResponseWriter, r *http.Request) *models.RetentionPolicy {
+	var input struct {
+		MaxLifetime         *string `json:"max_lifetime"`
+		DeleteAfterDownload bool    `json:"delete_after_download"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+
+	policy := &models.RetentionPolicy{DeleteAfterDownload: input.DeleteAfterDownload}
+
+	v := validator.New()
+
+	if input.MaxLifetime != nil {
+		d, err := time.ParseDuration(*input.MaxLifetime)
+		if err != nil {
+			v.AddError("max_lifetime", "must be a valid duration")
+		} else {
+			policy.MaxLifetime = &d
+		}
+	}
+
+	if v.Valid() {
+		models.ValidateRetentionPolicy(v, policy)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	return policy
+}
+
+// writeRetentionPolicy writes the result of getting a retention policy, users and organizations
+// without one get a 404
+func (app *application) writeRetentionPolicy(w http.ResponseWriter, r *http.Request, policy *models.RetentionPolicy, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"retention_policy": policy}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeRetentionPolicyDeleted writes the result of deleting a retention policy
+func (app *application) writeRetentionPolicyDeleted(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "retention policy successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	policy, err := app.models.RetentionPolicies.GetForUser(id)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+// updateUserRetentionPolicyHandler sets the retention policy of the files a user keeps outside of
+// organizations, the janitor applies it on its next run
+func (app *application) updateUserRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	policy := app.readRetentionPolicyInput(w, r)
+	if policy == nil {
+		return
+	}
+
+	err = app.models.RetentionPolicies.SetForUser(id, policy)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+func (app *application) deleteUserRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.RetentionPolicies.DeleteForUser(id)
+	app.writeRetentionPolicyDeleted(w, r, err)
+}
+
+func (app *application) showOrganizationRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	policy, err := app.models.RetentionPolicies.GetForOrganization(id)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+// updateOrganizationRetentionPolicyHandler sets the retention policy of the space of an organization
+func (app *application) updateOrganizationRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	policy := app.readRetentionPolicyInput(w, r)
+	if policy == nil {
+		return
+	}
+
+	err = app.models.RetentionPolicies.SetForOrganization(id, policy)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+func (app *application) deleteOrganizationRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.RetentionPolicies.DeleteForOrganization(id)
+	app.writeRetentionPolicyDeleted(w, r, err)
+}
+
+// showMemberRetentionPolicyHandler shows the members of an organization how long its files are kept
+func (app *application) showMemberRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.memberOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := app.models.RetentionPolicies.GetForOrganization(organization.ID)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+// updateOwnedRetentionPolicyHandler lets the owners of an organization set its retention policy
+func (app *application) updateOwnedRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.ownedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	policy := app.readRetentionPolicyInput(w, r)
+	if policy == nil {
+		return
+	}
+
+	err := app.models.RetentionPolicies.SetForOrganization(organization.ID, policy)
+	app.writeRetentionPolicy(w, r, policy, err)
+}
+
+func (app *application) deleteOwnedRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	organization, ok := app.ownedOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.RetentionPolicies.DeleteForOrganization(organization.ID)
+	app.writeRetentionPolicyDeleted(w, r, err)
+}
+
+// updateLegalHoldHandler places a file on legal hold or releases it. Held files are neither expired
+// nor deleted by a retention policy, the trash or their owner, they stay until the hold is released.
+func (app *application) updateLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		LegalHold *bool `json:"legal_hold"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.LegalHold != nil, "legal_hold", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	file, err := app.models.Files.SetLegalHold(id, *input.LegalHold)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.contextGetLogger(r).Info("legal hold changed", "file_id", file.ID, "legal_hold", file.LegalHold)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// loadRoles reads the permissions of the roles, they only change with the migrations so they are read
+// once at startup
+func (app *application) loadRoles() error {
+	roles, err := app.models.Permissions.GetAllByRole()
+	if err != nil {
+		return err
+	}
+
+	for _, role := range []string{models.RoleAdmin, models.RoleMember, models.RoleReadonly} {
+		if roles[role] == nil {
+			return errors.New("the role " + role + " is missing, the migrations may not be up to date")
+		}
+	}
+
+	app.roles = roles
+
+	return nil
+}
+
+// permitted reports whether the role of user grants permission and, if the request is authenticated
+// with apiKey, the key has it as a scope. It is the one check of the HTTP, gRPC, WebDAV and SFTP APIs.
+func (app *application) permitted(user *models.User, apiKey *models.APIKey, permission string) bool {
+	if !app.roles[user.Role].Include(permission) {
+		return false
+	}
+
+	return apiKey == nil || apiKey.HasScope(permission)
+}
+
+// roleNames returns the names of the roles in order
+func (app *application) roleNames() []string {
+	names := make([]string, 0, len(app.roles))
+	for name := range app.roles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	type role struct {
+		Name        string             `json:"name"`
+		Permissions models.Permissions `json:"permissions"`
+	}
+
+	roles := []role{}
+	for _, name := range app.roleNames() {
+		roles = append(roles, role{Name: name, Permissions: app.roles[name]})
+	}
+
+	err := app.writeJSONETag(w, r, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
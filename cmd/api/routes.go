@@ -2,67 +2,231 @@ package main
 
 import (
 	"net/http"
-	"time"
 
+	"github.com/Li-Elias/File-Transfer/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/go-chi/httprate"
 )
 
 func (app *application) routes() http.Handler {
 	router := chi.NewRouter()
 
+	router.Use(app.realIP)
+	router.Use(app.requestID)
+	router.Use(app.trace)
+	router.Use(app.audit)
+
+	// outside of the API router, so health checks are never rate limited
+	router.Get("/healthcheck", app.healthcheckHandler)
+	router.Get("/healthz", app.healthcheckHandler)
+	router.Get("/readyz", app.readyzHandler)
+
+	router.Mount(webdavPrefix, app.webdavRoutes())
+	router.Mount("/", app.apiRoutes())
+
+	return router
+}
+
+func (app *application) apiRoutes() http.Handler {
+	router := chi.NewRouter()
+
+	// shared by the routes of each group, so they count against the same limit
+	uploads := app.rateLimit(app.config.limits.uploads)
+	downloads := app.rateLimit(app.config.limits.downloads)
+	tokens := app.rateLimit(app.config.limits.tokens)
+
 	router.Use(app.Logger)
 	router.Use(middleware.Recoverer)
+	router.Use(app.compress)
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   app.config.cors.allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Range", "If-Range", "If-None-Match", "If-Modified-Since", "X-File-Password", "X-File-Passphrase", "X-Challenge-Response", "X-Request-ID", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"},
+		ExposedHeaders:   []string{"Link", "Location", "Accept-Ranges", "Content-Disposition", "Content-Range", "ETag", "Retry-After", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Expires", "Upload-Length", "Upload-Offset", "X-File-Code", "X-File-ID", "X-Checksum-SHA256", "X-File-Expiry", "X-Request-ID"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 	router.Use(app.authenticate)
-	router.Use(httprate.Limit(
-		10,
-		1*time.Minute,
-		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
-			app.tooManyRequests(w, r)
-		}),
-	))
+	router.Use(app.rateLimit(app.config.limits.global))
 
 	router.NotFound(app.notFoundResponse)
 	router.MethodNotAllowed(app.methodNotAllowedResponse)
 
-	router.Get("/healthcheck", app.healthcheckHandler)
+	router.Get("/openapi.json", app.openAPIHandler)
+	router.Get("/docs", app.docsHandler)
+	router.Get("/app", app.webAppHandler)
+	router.Get("/app/*", app.webAppHandler)
 
 	router.Group(func(router chi.Router) {
 		router.Use(app.requireActivatedUser)
-		router.Use(httprate.Limit(
-			5,
-			1*time.Minute,
-			httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
-				app.tooManyRequests(w, r)
-			}),
-		))
-
-		router.Get("/users/files", app.listUserFilesHandler)
-		router.Post("/users/files", app.uploadFileHandler)
-		router.Get("/users/files/{id}", app.getUserFileHandler)
-		router.Put("/users/files/{id}", app.updateUserFileHandler)
-		router.Delete("/users/files/{id}", app.deleteUserFileHandler)
+		router.Use(app.rateLimit(app.config.limits.users))
+
+		read := router.With(app.requirePermission(models.PermissionFilesRead))
+		write := router.With(app.requirePermission(models.PermissionFilesWrite))
+
+		read.Get("/users/files", app.listUserFilesHandler)
+		write.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Post("/users/files", app.uploadFileHandler)
+		write.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Post("/users/files/batch", app.uploadFilesHandler)
+		write.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Post("/users/files/fetch", app.fetchFileHandler)
+		write.With(uploads).Post("/users/files/presign", app.presignFileHandler)
+		write.With(uploads, app.requireDiskSpace, app.uploadDeadline).Post("/users/pastes", app.createPasteHandler)
+		read.Get("/users/files/search", app.searchUserFilesHandler)
+		read.With(downloads, app.trackTransfer, app.limitTransfers, app.throttleDownload).Get("/users/files/archive", app.getUserFilesArchiveHandler)
+		read.Get("/users/files/{id}", app.getUserFileHandler)
+		read.Get("/users/files/{id}/downloads", app.listUserFileDownloadsHandler)
+		read.Get("/users/files/{id}/qr", app.getUserFileQRHandler)
+		read.Get("/users/files/{id}/deliveries", app.listUserFileDeliveriesHandler)
+		write.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Put("/users/files/{id}", app.updateUserFileHandler)
+		write.With(app.trackTransfer, app.limitTransfers).Post("/users/files/{id}/complete", app.completeFileHandler)
+		write.Patch("/users/files/{id}", app.updateUserFileDetailsHandler)
+		write.Patch("/users/files/{id}/expiry", app.updateUserFileExpiryHandler)
+		write.Post("/users/files/{id}/regenerate-code", app.regenerateUserFileCodeHandler)
+		write.With(app.trackTransfer).Post("/users/files/{id}/copy", app.copyUserFileHandler)
+		write.Delete("/users/files/{id}", app.deleteUserFileHandler)
+		write.Post("/users/files/{id}/restore", app.restoreUserFileHandler)
+		write.Post("/users/files/{id}/links", app.createUserFileLinkHandler)
+		write.Post("/users/files/{id}/share", app.shareUserFileHandler)
+		write.Post("/users/files/{id}/send", app.sendUserFileHandler)
+		write.Post("/users/files/{id}/transfer-ownership", app.transferUserFileOwnershipHandler)
+		write.Put("/users/files/transfer-ownership", app.confirmFileOwnershipTransferHandler)
+		read.Get("/users/shared-with-me", app.listSharedWithMeHandler)
+		read.Get("/users/trash", app.listUserTrashHandler)
+
+		write.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Post("/users/transfers", app.createTransferHandler)
+
+		router.With(app.denyAPIKeys).Get("/organizations", app.listUserOrganizationsHandler)
+		router.With(app.denyAPIKeys).Post("/organizations", app.createOrganizationHandler)
+		router.With(app.denyAPIKeys).Put("/organizations/invitations", app.acceptOrganizationInvitationHandler)
+		router.With(app.denyAPIKeys).Get("/organizations/{id}", app.showOrganizationHandler)
+		router.With(app.denyAPIKeys).Delete("/organizations/{id}", app.deleteOrganizationHandler)
+		read.Get("/organizations/{id}/files", app.listOrganizationFilesHandler)
+		router.With(app.denyAPIKeys).Post("/organizations/{id}/invitations", app.createOrganizationInvitationHandler)
+		router.With(app.denyAPIKeys).Delete("/organizations/{id}/members/{user_id}", app.removeOrganizationMemberHandler)
+		router.With(app.denyAPIKeys).Get("/organizations/{id}/retention-policy", app.showMemberRetentionPolicyHandler)
+		router.With(app.denyAPIKeys).Put("/organizations/{id}/retention-policy", app.updateOwnedRetentionPolicyHandler)
+		router.With(app.denyAPIKeys).Delete("/organizations/{id}/retention-policy", app.deleteOwnedRetentionPolicyHandler)
+
+		router.With(app.denyAPIKeys).Get("/users/api-keys", app.listAPIKeysHandler)
+		router.With(app.denyAPIKeys).Post("/users/api-keys", app.createAPIKeyHandler)
+		router.With(app.denyAPIKeys).Delete("/users/api-keys/{id}", app.deleteAPIKeyHandler)
+
+		router.With(app.denyAPIKeys).Get("/users/me", app.showProfileHandler)
+		router.With(app.denyAPIKeys).Patch("/users/me", app.updateProfileHandler)
+		router.With(app.denyAPIKeys).Delete("/users/me", app.deleteAccountHandler)
+		router.With(app.denyAPIKeys, downloads, app.trackTransfer, app.limitTransfers, app.throttleDownload).Get("/users/me/export", app.exportAccountHandler)
+
+		router.With(app.denyAPIKeys).Post("/users/email-change", app.createEmailChangeHandler)
+		router.With(app.denyAPIKeys).Patch("/users/notifications", app.updateUserNotificationsHandler)
+		router.With(app.denyAPIKeys).Get("/users/sessions", app.listSessionsHandler)
+		router.With(app.denyAPIKeys).Delete("/users/sessions", app.deleteAllSessionsHandler)
+		router.With(app.denyAPIKeys).Delete("/users/sessions/{id}", app.deleteSessionHandler)
+		router.With(app.denyAPIKeys).Get("/users/sessions/history", app.listLoginHistoryHandler)
+
+		router.With(app.denyAPIKeys).Get("/users/identities", app.listIdentitiesHandler)
+		router.With(app.denyAPIKeys).Delete("/users/identities/{provider}", app.deleteIdentityHandler)
+
+		router.With(app.denyAPIKeys).Get("/users/passkeys", app.listPasskeysHandler)
+		router.With(app.denyAPIKeys).Post("/users/passkeys", app.createPasskeyHandler)
+		router.With(app.denyAPIKeys).Post("/users/passkeys/options", app.createPasskeyOptionsHandler)
+		router.With(app.denyAPIKeys).Delete("/users/passkeys/{id}", app.deletePasskeyHandler)
+
+		router.With(app.denyAPIKeys).Get("/users/webhooks", app.listWebhooksHandler)
+		router.With(app.denyAPIKeys).Post("/users/webhooks", app.createWebhookHandler)
+		router.With(app.denyAPIKeys).Delete("/users/webhooks/{id}", app.deleteWebhookHandler)
+
+		if app.stripe != nil {
+			router.With(app.denyAPIKeys).Post("/users/billing/checkout", app.createCheckoutHandler)
+			router.With(app.denyAPIKeys).Post("/users/billing/portal", app.createBillingPortalHandler)
+		}
+	})
+
+	router.Route("/admin", func(router chi.Router) {
+		router.Use(app.requirePermission(models.PermissionAdminRead))
+		router.Use(app.denyAPIKeys)
+
+		write := router.With(app.requirePermission(models.PermissionAdminWrite))
+
+		router.Get("/users", app.listUsersHandler)
+		write.Patch("/users/{id}", app.updateUserHandler)
+		router.Get("/roles", app.listRolesHandler)
+		router.Get("/files", app.listFilesHandler)
+		write.Delete("/files/{id}", app.deleteFileHandler)
+		write.Put("/files/{id}/legal-hold", app.updateLegalHoldHandler)
+		router.Get("/organizations", app.listOrganizationsHandler)
+		write.Patch("/organizations/{id}", app.updateOrganizationHandler)
+		router.Get("/organizations/{id}/retention-policy", app.showOrganizationRetentionPolicyHandler)
+		write.Put("/organizations/{id}/retention-policy", app.updateOrganizationRetentionPolicyHandler)
+		write.Delete("/organizations/{id}/retention-policy", app.deleteOrganizationRetentionPolicyHandler)
+		router.Get("/plans", app.listPlansHandler)
+		write.Post("/plans", app.createPlanHandler)
+		write.Put("/plans/{id}", app.updatePlanHandler)
+		write.Delete("/plans/{id}", app.deletePlanHandler)
+		write.Put("/users/{id}/plan", app.updateUserPlanHandler)
+		router.Get("/users/{id}/retention-policy", app.showUserRetentionPolicyHandler)
+		write.Put("/users/{id}/retention-policy", app.updateUserRetentionPolicyHandler)
+		write.Delete("/users/{id}/retention-policy", app.deleteUserRetentionPolicyHandler)
+		router.Get("/stats", app.getStatsHandler)
+		router.Get("/config", app.showConfigHandler)
+		router.Get("/metrics", app.metricsHandler)
+		router.Get("/blocks", app.listBlocksHandler)
+		router.Get("/audit", app.listAuditEventsHandler)
+		write.Delete("/blocks", app.clearBlockHandler)
 	})
 
-	router.Get("/files/{code}", app.getFileFromCodeHandler)
+	router.Route("/uploads", func(router chi.Router) {
+		// not part of tus, so it goes without the Tus-Resumable header
+		router.With(app.requirePermission(models.PermissionFilesWrite)).Get("/{id}/events", app.uploadEventsHandler)
+
+		router.Group(func(router chi.Router) {
+			router.Use(app.tusResumable)
+
+			router.Options("/", app.uploadOptionsHandler)
+
+			router.Group(func(router chi.Router) {
+				router.Use(app.requirePermission(models.PermissionFilesWrite))
+
+				router.With(uploads, app.requireDiskSpace, app.uploadDeadline).Post("/", app.createUploadHandler)
+				router.Head("/{id}", app.getUploadOffsetHandler)
+				router.With(uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Patch("/{id}", app.patchUploadHandler)
+			})
+		})
+	})
+
+	if app.config.guests.enabled {
+		router.With(app.rateLimit(app.config.limits.guests), uploads, app.trackTransfer, app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Post("/files", app.guestUploadHandler)
+	}
+
+	if app.stripe != nil {
+		router.Get("/billing/plans", app.listBillingPlansHandler)
+		router.Post("/billing/stripe/webhook", app.stripeWebhookHandler)
+	}
+
+	router.With(downloads, app.trackTransfer, app.limitTransfers, app.throttleDownload).Get("/files/signed", app.getFileFromSignedURLHandler)
+	router.Get("/files/extend", app.extendFileHandler)
+	router.With(app.guardCodeLookup, downloads, app.trackTransfer, app.limitTransfers, app.throttleDownload).Get("/files/{code}", app.getFileFromCodeHandler)
+	router.With(app.guardCodeLookup).Head("/files/{code}", app.headFileFromCodeHandler)
+	router.With(app.guardCodeLookup).Get("/files/{code}/meta", app.getFileMetaFromCodeHandler)
+	router.With(app.guardCodeLookup).Get("/files/{code}/thumbnail", app.getFileThumbnailFromCodeHandler)
+	router.With(app.guardCodePage).Get("/d/{code}", app.downloadPageHandler)
+	router.Get("/r/{code}", app.redirectCodeHandler)
+	router.Get("/challenge", app.getChallengeHandler)
 
 	router.Post("/users", app.registerUserHandler)
 	router.Put("/users/activated", app.activateUserHandler)
 	router.Put("/users/password", app.updateUserPasswordHandler)
+	router.Put("/users/email-change", app.confirmEmailChangeHandler)
 
-	router.Post("/tokens/authenticate", app.createAuthenticationTokenHandler)
-	router.Post("/tokens/activation", app.createActivationTokenHandler)
-	router.Post("/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.With(tokens).Post("/tokens/authenticate", app.createAuthenticationTokenHandler)
+	router.With(tokens).Post("/tokens/refresh", app.createRefreshTokenHandler)
+	router.With(tokens).Post("/tokens/passkey", app.createPasskeyAuthenticationTokenHandler)
+	router.With(tokens).Post("/tokens/passkey/options", app.createPasskeyLoginOptionsHandler)
+	router.With(tokens).Get("/oauth/{provider}", app.oauthLoginHandler)
+	router.With(tokens).Get("/oauth/{provider}/callback", app.oauthCallbackHandler)
+	router.With(tokens).Post("/tokens/activation", app.createActivationTokenHandler)
+	router.With(tokens).Post("/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.With(tokens).Post("/tokens/magic-link", app.createMagicLinkHandler)
+	router.With(tokens).Put("/tokens/magic-link", app.exchangeMagicLinkHandler)
 
 	return router
 }
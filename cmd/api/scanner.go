@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/clamav"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+const (
+	scanInterval  = 10 * time.Second
+	scanBatchSize = 10
+)
+
+// scanStatus is the status of newly stored content of file, pending until the scanner has seen it
+func (app *application) scanStatus(file *models.File) string {
+	switch {
+	case app.scanner == nil:
+		return models.ScanStatusClean
+	case file.PassphraseSalt != nil:
+		return models.ScanStatusSkipped
+	default:
+		return models.ScanStatusPending
+	}
+}
+
+// queueScan wakes up scanFiles instead of letting a new file wait for the next interval
+func (app *application) queueScan() {
+	if app.scanner == nil {
+		return
+	}
+
+	select {
+	case app.scanQueue <- struct{}{}:
+	default:
+	}
+}
+
+// scanFiles scans pending files until the server shuts down. Pending files are read from the
+// database, so scans interrupted by a restart are picked up again.
+func (app *application) scanFiles() {
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+
+	for {
+		files, err := app.models.Files.GetAllPendingScan(scanBatchSize)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		for _, file := range files {
+			if app.shutdown.Err() != nil {
+				return
+			}
+			app.scanFile(file)
+		}
+
+		// a full batch means more are waiting
+		if len(files) == scanBatchSize {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.scanQueue:
+		case <-app.shutdown.Done():
+			return
+		}
+	}
+}
+
+func (app *application) scanFile(file *models.File) {
+	logger := app.logger.With("file_id", file.ID)
+
+	content, err := app.openFileContent(context.Background(), file, "")
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	defer content.Close()
+
+	result, err := app.scanner.Scan(content)
+	switch {
+	case errors.Is(err, clamav.ErrScanFailed):
+		logger.Error(err.Error())
+		file.ScanStatus = models.ScanStatusFailed
+	case err != nil:
+		// clamd is unreachable or timed out, the file stays pending and is tried again
+		logger.Error(err.Error())
+		return
+	case result.Infected:
+		logger.Info("malware found", "signature", result.Signature)
+		file.ScanStatus = models.ScanStatusInfected
+	default:
+		file.ScanStatus = models.ScanStatusClean
+	}
+
+	err = app.models.Files.UpdateScanStatus(file)
+	if err != nil {
+		if !errors.Is(err, models.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	// the row is kept so the owner can see why the file is gone, it is removed when it expires
+	if file.ScanStatus != models.ScanStatusClean {
+		app.deleteBlob(app.logger, file)
+	}
+}
+
+// checkFileScanned responds for files which cannot be downloaded (yet) because of their malware scan
+func (app *application) checkFileScanned(w http.ResponseWriter, r *http.Request, file *models.File) bool {
+	switch fileScanError(file) {
+	case nil:
+		return true
+	case errScanPending:
+		app.fileScanPendingResponse(w, r)
+	default:
+		app.fileQuarantinedResponse(w, r)
+	}
+	return false
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,48 +14,155 @@ import (
 
 func (app *application) serve() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		ErrorLog:     log.New(app.logger, "", 0),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		IdleTimeout:       time.Minute,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	tlsConfig, manager, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+
+	if tlsConfig != nil {
+		err = app.configureHTTP2(srv)
+		if err != nil {
+			return err
+		}
+	}
+
+	stopHTTP3 := func(ctx context.Context) {}
+	if tlsConfig != nil && app.config.tls.http3 {
+		stop, err := app.serveHTTP3(srv)
+		if err != nil {
+			return err
+		}
+		stopHTTP3 = stop
+	}
+
+	// every request is checked against the roles, including those of the gRPC and SFTP servers
+	err = app.loadRoles()
+	if err != nil {
+		return err
+	}
+
+	// the HTTP/3, gRPC, redirect and SFTP servers share the shutdown of the HTTP server, they are stopped right after it
+	stopGRPC := func(ctx context.Context) {}
+	if app.config.grpc.port != 0 {
+		stop, err := app.serveGRPC(tlsConfig)
+		if err != nil {
+			return err
+		}
+		stopGRPC = stop
+	}
+
+	stopRedirect := func(ctx context.Context) {}
+	if app.tlsEnabled() && app.config.tls.redirectPort != 0 {
+		stop, err := app.serveRedirect(manager)
+		if err != nil {
+			return err
+		}
+		stopRedirect = stop
+	}
+
+	stopSFTP := func(ctx context.Context) {}
+	if app.config.sftp.port != 0 {
+		stop, err := app.serveSFTP()
+		if err != nil {
+			return err
+		}
+		stopSFTP = stop
+	}
+
+	// the sessions revoked before a restart must be known before the first JWT is checked
+	if app.revocations != nil {
+		err := app.loadRevocations()
+		if err != nil {
+			return err
+		}
 	}
 
 	shutdownError := make(chan error)
 
+	started := time.Now()
+	app.background(app.logger, func() {
+		app.janitor(started)
+	})
+	app.background(app.logger, app.deliverWebhooks)
+	if app.revocations != nil {
+		app.background(app.logger, app.reloadRevocations)
+	}
+	if app.scanner != nil {
+		app.background(app.logger, app.scanFiles)
+	}
+
+	// the only place signals are handled, everything else waits on app.shutdown
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 		s := <-quit
 
-		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.Info("shutting down server", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// turns away new uploads and downloads and stops the background loops
+		app.stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
 		if err != nil {
-			shutdownError <- err
+			app.logger.Info("closing remaining connections", "addr", srv.Addr)
+			srv.Close()
 		}
 
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
+		stopHTTP3(ctx)
+		stopRedirect(ctx)
+		stopGRPC(ctx)
+		stopSFTP(ctx)
+
+		// handlers of closed connections still remove what they had half stored
+		app.transfers.Wait()
+
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
 		app.waitgroup.Wait()
-		shutdownError <- nil
+
+		// the spans and events of the last requests, the shutdown timeout may already be used up
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+
+		flushErr := app.tracer.Shutdown(flushCtx)
+		if flushErr != nil {
+			app.logger.Error(flushErr.Error())
+		}
+
+		flushErr = app.events.Shutdown(flushCtx)
+		if flushErr != nil {
+			app.logger.Error(flushErr.Error())
+		}
+
+		shutdownError <- err
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
+	app.logger.Info("starting server",
+		"addr", srv.Addr,
+		"env", app.config.env,
+		"tls", app.tlsEnabled(),
+	)
 
-	err := srv.ListenAndServe()
+	if app.tlsEnabled() {
+		// the certificates come from srv.TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -65,9 +172,7 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.PrintInfo("stopped server", map[string]string{
-		"addr": srv.Addr,
-	})
+	app.logger.Info("stopped server", "addr", srv.Addr)
 
 	return nil
 }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// The operations below are shared by the REST handlers, the gRPC service and the SFTP and WebDAV
+// servers. They return errors instead of writing responses, each transport maps them to its own status codes.
+
+var (
+	errInvalidToken       = errors.New("invalid or missing authentication token")
+	errSuspendedAccount   = errors.New("the user account has been suspended")
+	errPasswordRequired   = errors.New("the file is password protected")
+	errInvalidPassword    = errors.New("the provided password is incorrect")
+	errPassphraseRequired = errors.New("the file is encrypted with a passphrase")
+	errScanPending        = errors.New("the file is still being scanned for malware")
+	errQuarantined        = errors.New("the file has been removed because it failed the malware scan")
+	errInvalidCredentials = errors.New("invalid authentication credentials")
+	errInactiveAccount    = errors.New("your user account must be activated to access this resource")
+	errShuttingDown       = errors.New("the server is shutting down, please try again shortly")
+	errStoreFailed        = errors.New("the file could not be stored")
+	errLegalHold          = errors.New("the file is on legal hold and cannot be deleted or replaced")
+)
+
+// authenticateToken looks up the user of an authentication token or API key, apiKey is nil for tokens.
+// JWTs are verified without a lookup, see authenticateJWT.
+func (app *application) authenticateToken(token string) (*models.User, *models.APIKey, error) {
+	if app.isJWT(token) {
+		user, err := app.authenticateJWT(token)
+		return user, nil, err
+	}
+
+	isAPIKey := strings.HasPrefix(token, models.APIKeyPrefix)
+
+	v := validator.New()
+	if isAPIKey {
+		models.ValidateAPIKeyPlaintext(v, token)
+	} else {
+		models.ValidateTokenPlaintext(v, token)
+	}
+	if !v.Valid() {
+		return nil, nil, errInvalidToken
+	}
+
+	var user *models.User
+	var apiKey *models.APIKey
+	var err error
+
+	if isAPIKey {
+		apiKey, err = app.models.APIKeys.GetByPlaintext(token)
+		if err == nil {
+			user, err = app.models.Users.Get(apiKey.UserID)
+		}
+	} else {
+		user, err = app.models.Users.GetByToken(models.ScopeAuthentication, token)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, nil, errInvalidToken
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if user.Suspended {
+		return nil, nil, errSuspendedAccount
+	}
+
+	return user, apiKey, nil
+}
+
+// loginLockedError is returned while logins for an email address or from an address are locked,
+// wait is how long the lockout still lasts
+type loginLockedError struct {
+	wait time.Duration
+}
+
+func (e *loginLockedError) Error() string {
+	return "too many failed login attempts, please try again later"
+}
+
+// authenticatePassword looks up the user with the email address of attempt and the account password,
+// for transports which log in with credentials instead of a token. Failed attempts are recorded and
+// count towards the lockout, successful ones are left for the caller to record.
+func (app *application) authenticatePassword(attempt *models.LoginAttempt, plaintext string) (*models.User, error) {
+	attempt.Email = strings.ToLower(attempt.Email)
+
+	// attempts while locked are recorded but not counted, so the lockout ends even if they go on
+	wait, err := app.loginLockout(attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.models.Users.GetByEmail(attempt.Email)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+
+	if wait > 0 {
+		app.failLogin(attempt, models.LoginLocked)
+		return nil, &loginLockedError{wait: wait}
+	}
+
+	if user == nil {
+		app.failLogin(attempt, models.LoginInvalidCredentials)
+		return nil, errInvalidCredentials
+	}
+
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if !match {
+		app.failLogin(attempt, models.LoginInvalidCredentials)
+		return nil, errInvalidCredentials
+	}
+
+	if user.Suspended {
+		app.failLogin(attempt, models.LoginSuspended)
+		return nil, errSuspendedAccount
+	}
+
+	return user, nil
+}
+
+// verifyFilePassword checks the download password of a password protected file
+func (app *application) verifyFilePassword(file *models.File, plaintext string) error {
+	if !file.PasswordProtected {
+		return nil
+	}
+
+	if plaintext == "" {
+		return errPasswordRequired
+	}
+
+	match, err := file.Password.Matches(plaintext)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		return errInvalidPassword
+	}
+
+	return nil
+}
+
+// fileScanError reports why a file cannot be downloaded (yet) because of its malware scan
+func fileScanError(file *models.File) error {
+	switch file.ScanStatus {
+	case models.ScanStatusClean, models.ScanStatusSkipped:
+		return nil
+	case models.ScanStatusPending:
+		return errScanPending
+	default:
+		return errQuarantined
+	}
+}
+
+// burnedContent deletes the blob of a file which reached its download limit once it has been sent
+type burnedContent struct {
+	io.ReadSeekCloser
+	app  *application
+	file *models.File
+}
+
+func (b burnedContent) Close() error {
+	err := b.ReadSeekCloser.Close()
+	b.app.deleteBlob(b.app.logger, b.file)
+	return err
+}
+
+// openDownload opens the content of a file whose password has been checked and counts the download,
+// download only needs the client details filled in. download is nil for a resumed download, which was
+// counted when it started. The content has to be closed by the caller.
+func (app *application) openDownload(ctx context.Context, file *models.File, passphrase string, download *models.Download) (io.ReadSeekCloser, error) {
+	// a pending direct upload has no content yet
+	if file.Pending {
+		return nil, models.ErrRecordNotFound
+	}
+
+	err := fileScanError(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.PassphraseProtected && passphrase == "" {
+		return nil, errPassphraseRequired
+	}
+
+	content, err := app.openFileContent(ctx, file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if download == nil {
+		// the download limit still holds for files which are kept after reaching it
+		if file.DownloadLimitReached() {
+			content.Close()
+			return nil, models.ErrRecordNotFound
+		}
+		return content, nil
+	}
+
+	err = app.countDownload(ctx, file, download)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+
+	// burn after reading, the row goes right away so nobody else can find the code. Files on legal hold
+	// are kept, the download limit still stops further downloads.
+	if file.DownloadLimitReached() && !file.LegalHold {
+		err = app.models.Files.Delete(file.ID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			content.Close()
+			return nil, err
+		}
+		app.fileEvent(models.EventFileDeleted, file)
+		return burnedContent{ReadSeekCloser: content, app: app, file: file}, nil
+	}
+
+	return content, nil
+}
+
+// countDownload registers a download of file, adds it to the history and tells the owner about the first one
+func (app *application) countDownload(ctx context.Context, file *models.File, download *models.Download) error {
+	err := app.models.Files.WithContext(ctx).RegisterDownload(file)
+	if err != nil {
+		return err
+	}
+
+	download.FileID = file.ID
+	app.recordDownload(download)
+	app.fileEvent(models.EventFileDownloaded, file)
+
+	// the counter is incremented atomically, so exactly one download sees the first one
+	if file.DownloadCount == 1 {
+		app.notifyFirstDownload(file)
+	}
+
+	return nil
+}
+
+// recordDownload adds a download to the history of its file, a failure must not break the download itself
+func (app *application) recordDownload(download *models.Download) {
+	// headers are not guaranteed to be valid UTF-8, which the text column requires
+	if len(download.UserAgent) > 256 {
+		download.UserAgent = download.UserAgent[:256]
+	}
+	download.UserAgent = strings.ToValidUTF8(download.UserAgent, "")
+
+	err := app.models.Downloads.Insert(download)
+	if err != nil {
+		app.logger.Error(err.Error(), "file_id", download.FileID)
+	}
+}
+
+// deleteUserFile moves a file of user into the trash, or deletes it right away when
+// -trash-retention is 0. It reports whether the file was trashed. Files on legal hold are not deleted.
+func (app *application) deleteUserFile(id int64, user *models.User) (bool, error) {
+	// the file goes with the event
+	file, err := app.models.Files.GetFromUser(id, user)
+	if err != nil {
+		return false, err
+	}
+
+	if file.LegalHold {
+		return false, errLegalHold
+	}
+
+	if app.config.trash.retention > 0 {
+		return true, app.models.Files.Trash(id, user)
+	}
+
+	path, err := app.models.Files.DeleteFromUser(id, user)
+	if err != nil {
+		return false, err
+	}
+
+	if !file.Pending {
+		app.fileEvent(models.EventFileDeleted, file)
+	}
+
+	err = app.storage.Delete(path)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// startTransfer counts a transfer like trackTransfer does, it fails once the server shuts down
+func (app *application) startTransfer() bool {
+	if app.shutdown.Err() != nil {
+		return false
+	}
+
+	app.transfers.Add(1)
+	return true
+}
+
+// contentWriter hands what is written to it to a store function running in the background, for
+// transports which write the content instead of passing a reader. The content is limited to -file-max-size.
+type contentWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+func (app *application) newContentWriter(store func(r io.Reader) error) *contentWriter {
+	pr, pw := io.Pipe()
+
+	w := &contentWriter{pw: pw, done: make(chan struct{})}
+
+	go func() {
+		defer close(w.done)
+
+		// a MaxBytesReader without a ResponseWriter only limits the body
+		w.err = store(http.MaxBytesReader(nil, pr, app.config.files.maxSize))
+		pr.CloseWithError(w.err)
+	}()
+
+	return w
+}
+
+// Write fails with the error of store once it gave up on the content
+func (w *contentWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the content and returns the result of store
+func (w *contentWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// Abort makes store fail with err, so nothing of the content is kept
+func (w *contentWriter) Abort(err error) error {
+	w.pw.CloseWithError(err)
+	<-w.done
+	return w.err
+}
+
+// storeError turns an error of storing content into one which can be shown to the client,
+// anything not caused by the content itself is logged and hidden
+func (app *application) storeError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	var typeErr *fileTypeError
+	var planErr *planLimitError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("file_size: must not be more than %d bytes big", app.config.files.maxSize)
+	case errors.As(err, &planErr):
+		return planErr
+	case errors.Is(err, errChecksumMismatch):
+		return errors.New("checksum_sha256: does not match the uploaded content")
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("file: %s", typeErr.Error())
+	default:
+		app.logger.Error(err.Error())
+		return errStoreFailed
+	}
+}
+
+// fileInfo describes a file for the SFTP and WebDAV servers, which show the files of a user as
+// a single directory. It describes that directory if file is nil.
+type fileInfo struct {
+	file *models.File
+}
+
+func (fi fileInfo) Name() string {
+	if fi.file == nil {
+		return "/"
+	}
+	return fi.file.Name
+}
+
+func (fi fileInfo) Size() int64 {
+	if fi.file == nil {
+		return 0
+	}
+	return fi.file.Size
+}
+
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.file == nil {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	if fi.file == nil {
+		return time.Now()
+	}
+	return fi.file.LastUpdated
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.file == nil
+}
+
+func (fi fileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// startSession starts a session for a user who just logged in and returns its first authentication token
+// and its refresh token, every way of logging in ends here
+func (app *application) startSession(r *http.Request, user *models.User) (*models.Token, *models.Token, error) {
+	session := &models.Session{
+		UserID:    user.ID,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+	}
+
+	refresh, err := app.models.Sessions.New(session, app.config.sessions.refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auditActor(r.Context(), user.ID)
+	auditObject(r.Context(), "session", session.ID)
+
+	token, err := app.newSessionToken(session, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refresh, nil
+}
+
+// newSessionToken issues an authentication token of session for user, revoking the session revokes it too
+func (app *application) newSessionToken(session *models.Session, user *models.User) (*models.Token, error) {
+	if app.revocations != nil {
+		return app.newJWT(session, user)
+	}
+
+	token, err := models.GenerateToken(session.UserID, app.config.sessions.tokenTTL, models.ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.SessionID = &session.ID
+
+	err = app.models.Tokens.Insert(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// createRefreshTokenHandler exchanges a refresh token for a new authentication token and a new refresh
+// token, the old one cannot be used again
+func (app *application) createRefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.RefreshToken != "", "refresh_token", "must be provided")
+	v.Check(len(input.RefreshToken) == 26, "refresh_token", "must be 26 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	session, refresh, err := app.models.Sessions.Refresh(input.RefreshToken, remoteHost(r.RemoteAddr), r.UserAgent(), app.config.sessions.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRefreshTokenReused):
+			app.contextGetLogger(r).Warn("session revoked for reusing a refresh token", "address", remoteHost(r.RemoteAddr))
+			err = app.revokeSessionTokens([]int64{session.ID})
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(session.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	token, err := app.newSessionToken(session, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSessionsHandler lists the sessions of the user which can still be refreshed, the one of the token
+// in the request is marked as current
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.models.Sessions.GetAllForUser(app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	current, err := app.currentSessionID(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = current != nil && session.ID == *current
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// currentSessionID returns the ID of the session of an authentication token, nil if it has none
+func (app *application) currentSessionID(token string) (*int64, error) {
+	if app.isJWT(token) {
+		// the token was verified by authenticate already, it can only have been revoked since
+		claims, err := app.verifyJWT(token)
+		if err != nil {
+			return nil, nil
+		}
+		return &claims.SessionID, nil
+	}
+
+	return app.models.Sessions.GetIDForToken(token)
+}
+
+// deleteSessionHandler logs a session out, its refresh token and authentication tokens stop working
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Sessions.DeleteFromUser(id, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeSessionTokens([]int64{id})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllSessionsHandler logs the user out everywhere, including the session of the request
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.revokeSessions(app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeSessions deletes all sessions and authentication tokens of a user, tokens from before sessions
+// existed have none
+func (app *application) revokeSessions(user *models.User) error {
+	ids, err := app.models.Sessions.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.revokeSessionTokens(ids)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Tokens.DeleteAllForUser(models.ScopeAuthentication, user.ID)
+}
+
+// deleteExpiredSessions removes the sessions whose refresh token expired
+func (app *application) deleteExpiredSessions() error {
+	deleted, err := app.models.Sessions.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("deleted expired sessions", "count", deleted)
+	}
+
+	return nil
+}
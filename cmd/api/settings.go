@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Li-Elias/File-Transfer/internal/settings"
+)
+
+// envPrefix starts the environment variables which set flags, FILE_TRANSFER_DB_DSN sets -db-dsn
+const envPrefix = "FILE_TRANSFER_"
+
+// Where the value of a setting came from, in increasing order of precedence
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// settingValue wraps the value of every flag to remember where it was set and how, since
+// flags registered with flag.Func cannot print their value
+type settingValue struct {
+	flag.Value
+	raw    string
+	source string
+}
+
+func (v *settingValue) Set(val string) error {
+	err := v.Value.Set(val)
+	if err != nil {
+		return err
+	}
+	v.raw = val
+	return nil
+}
+
+func (v *settingValue) String() string {
+	// the flag package calls String on a zero value for the usage message
+	if v.Value == nil {
+		return ""
+	}
+
+	value := v.Value.String()
+	if value == "" {
+		return v.raw
+	}
+	return value
+}
+
+func (v *settingValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// loadSettings parses the command line, then sets the flags which were not given there from the
+// environment and, below that, from the file given with -config. Unknown settings are errors.
+func loadSettings() error {
+	path := flag.String("config", "", "YAML or TOML file with settings named like the flags, also "+envPrefix+"CONFIG")
+
+	flag.VisitAll(func(f *flag.Flag) {
+		f.Value = &settingValue{Value: f.Value, source: sourceDefault}
+	})
+
+	// the usage message names the type of each flag, which the wrapper hides
+	usage := flag.CommandLine.Usage
+	flag.CommandLine.Usage = func() {
+		flag.VisitAll(func(f *flag.Flag) {
+			f.Value = f.Value.(*settingValue).Value
+		})
+		usage()
+	}
+
+	flag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		f.Value.(*settingValue).source = sourceFlag
+	})
+
+	if *path == "" && os.Getenv(envPrefix+"CONFIG") != "" {
+		*path = os.Getenv(envPrefix + "CONFIG")
+		flag.Lookup("config").Value.(*settingValue).source = sourceEnv
+	}
+
+	if *path != "" {
+		values, err := settings.Read(*path)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if name == "config" {
+				return fmt.Errorf("%s: config cannot be set in the config file", *path)
+			}
+
+			err := setSetting(name, values[name], sourceFile)
+			if err != nil {
+				return fmt.Errorf("%s: %w", *path, err)
+			}
+		}
+	}
+
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, envPrefix) || key == envPrefix+"CONFIG" {
+			continue
+		}
+
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, envPrefix), "_", "-"))
+
+		err := setSetting(name, value, sourceEnv)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// setSetting sets the flag name unless it was given on the command line
+func setSetting(name, value, source string) error {
+	f := flag.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown setting %s", name)
+	}
+
+	v := f.Value.(*settingValue)
+	if v.source == sourceFlag {
+		return nil
+	}
+
+	// the value itself is left out, it may be a secret
+	err := v.Set(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	v.source = source
+
+	return nil
+}
+
+// secretSetting reports whether the value of a setting must not be shown, like passwords, keys and the DSN
+func secretSetting(name string) bool {
+	for _, suffix := range []string{"-password", "-key", "-keys", "-secret", "-dsn"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type effectiveSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// showConfigHandler lists the effective value of every setting and where it was set, secrets are redacted
+func (app *application) showConfigHandler(w http.ResponseWriter, r *http.Request) {
+	config := []effectiveSetting{}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		setting := effectiveSetting{Name: f.Name, Value: f.Value.String(), Source: sourceDefault}
+
+		if v, ok := f.Value.(*settingValue); ok {
+			setting.Source = v.source
+		}
+
+		if setting.Value != "" && secretSetting(f.Name) {
+			setting.Value = "REDACTED"
+		}
+
+		config = append(config, setting)
+	})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"config": config}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpMaxPending bounds the writes of an upload which arrived ahead of the current offset,
+// clients send several writes at once and the server handles them concurrently
+const sftpMaxPending = 8 * 1024 * 1024
+
+// sftpHostKey loads the host key from -sftp-host-key. Without one a new key is generated on
+// every start, which clients then warn about as a changed host key.
+func (app *application) sftpHostKey() (ssh.Signer, error) {
+	if app.config.sftp.hostKey != "" {
+		pem, err := os.ReadFile(app.config.sftp.hostKey)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKey(pem)
+	}
+
+	app.logger.Info("no sftp host key configured, using a temporary one")
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(key)
+}
+
+// sftpPasswordCallback logs users in with the email address and password of their account
+func (app *application) sftpPasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	attempt := &models.LoginAttempt{
+		Email:     conn.User(),
+		IP:        remoteHost(conn.RemoteAddr().String()),
+		UserAgent: string(conn.ClientVersion()),
+	}
+
+	user, err := app.authenticatePassword(attempt, string(password))
+	if err != nil {
+		var lockedErr *loginLockedError
+		if !errors.Is(err, errInvalidCredentials) && !errors.Is(err, errSuspendedAccount) && !errors.As(err, &lockedErr) {
+			app.logger.Error(err.Error())
+		}
+		return nil, err
+	}
+
+	attempt.Success = true
+	app.recordLoginAttempt(attempt)
+
+	if !user.Activated {
+		return nil, errInactiveAccount
+	}
+
+	perms := &ssh.Permissions{
+		Extensions: map[string]string{"user_id": strconv.FormatInt(user.ID, 10)},
+	}
+
+	return perms, nil
+}
+
+// sftpHandler serves the files of one user as a single flat directory. Uploading a name again
+// adds another file, only the newest file of every name is listed and can be read or removed.
+type sftpHandler struct {
+	app  *application
+	user *models.User
+	// addr is the host of the client, for the audit log
+	addr string
+}
+
+func (h *sftpHandler) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// permitted is requirePermission for SFTP requests, which are never made with an API key
+func (h *sftpHandler) permitted(permission string) error {
+	if !h.app.permitted(h.user, nil, permission) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+// fileName returns the name of the file at p, which have to be right below the root directory
+func (h *sftpHandler) fileName(p string) (string, error) {
+	if path.Dir(p) != "/" || p == "/" {
+		return "", sftp.ErrSSHFxNoSuchFile
+	}
+
+	return path.Base(p), nil
+}
+
+func (h *sftpHandler) getFile(p string) (*models.File, error) {
+	name, err := h.fileName(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := h.app.models.Files.GetFromUserByName(name, h.user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, sftp.ErrSSHFxNoSuchFile
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// Fileread opens the content of a file for its owner, which does not count as a download
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	err := h.permitted(models.PermissionFilesRead)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := h.getFile(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fileScanError(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// the passphrase never leaves the client, there is no way to ask for it here
+	if file.PassphraseProtected {
+		return nil, errPassphraseRequired
+	}
+
+	if !h.app.startTransfer() {
+		return nil, errShuttingDown
+	}
+
+	content, err := h.app.openFileContent(context.Background(), file, "")
+	if err != nil {
+		h.app.transfers.Done()
+		return nil, err
+	}
+
+	buckets, release := h.app.downloadBuckets(userOrAddrKey(h.user, h.addr))
+
+	return &sftpDownload{content: content, app: h.app, buckets: buckets, release: release}, nil
+}
+
+// Filewrite stores the written content as a new file with the default expiry
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	err := h.permitted(models.PermissionFilesWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := h.fileName(r.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	app := h.app
+
+	new_file := app.newFile(h.user, name, 0, app.config.files.defaultExpiry)
+
+	v := validator.New()
+	if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+		return nil, errors.New(joinValidationErrors(v.Errors))
+	}
+
+	err = app.checkDiskSpace(app.logger, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !app.startTransfer() {
+		return nil, errShuttingDown
+	}
+
+	upload := &sftpUpload{
+		app: app,
+		w: app.newContentWriter(func(r io.Reader) error {
+			err := app.createFile(new_file, r, -1, storeOptions{})
+			h.audit("sftp put", new_file.ID, err)
+			return err
+		}),
+		pending: make(map[int64][]byte),
+	}
+
+	return upload, nil
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		// clients set times and permissions after uploading, neither is kept
+		return nil
+	case "Remove":
+		err := h.permitted(models.PermissionFilesWrite)
+		if err != nil {
+			return err
+		}
+
+		file, err := h.getFile(r.Filepath)
+		if err != nil {
+			return err
+		}
+
+		_, err = h.app.deleteUserFile(file.ID, h.user)
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return sftp.ErrSSHFxNoSuchFile
+		case errors.Is(err, errLegalHold):
+			h.audit("sftp remove", file.ID, err)
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		h.audit("sftp remove", file.ID, err)
+		return err
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// audit records an upload or removal of the file with fileID in the audit log, fileID is 0 if none was created
+func (h *sftpHandler) audit(action string, fileID int64, err error) {
+	event := &models.AuditEvent{
+		ActorID: &h.user.ID,
+		Action:  action,
+		IP:      h.addr,
+		Success: err == nil,
+	}
+	if fileID != 0 {
+		event.Objects = []string{fmt.Sprintf("file:%d", fileID)}
+	}
+
+	h.app.recordAudit(event)
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	err := h.permitted(models.PermissionFilesRead)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		if r.Filepath != "/" {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+
+		files, err := h.app.models.Files.GetAllLatestFromUser(h.user)
+		if err != nil {
+			return nil, err
+		}
+
+		infos := make(sftpListing, len(files))
+		for i, file := range files {
+			infos[i] = fileInfo{file: file}
+		}
+		return infos, nil
+	case "Stat":
+		if r.Filepath == "/" {
+			return sftpListing{fileInfo{}}, nil
+		}
+
+		file, err := h.getFile(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListing{fileInfo{file: file}}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+type sftpListing []os.FileInfo
+
+func (l sftpListing) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// sftpDownload serves reads at arbitrary offsets from the seekable file content, throttled like the
+// downloads of the API
+type sftpDownload struct {
+	mu      sync.Mutex
+	content io.ReadSeekCloser
+	app     *application
+	buckets []*tokenBucket
+	release func()
+	closed  bool
+}
+
+func (d *sftpDownload) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := waitBuckets(context.Background(), d.buckets, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = d.content.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(d.content, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (d *sftpDownload) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	defer d.app.transfers.Done()
+	defer d.release()
+
+	return d.content.Close()
+}
+
+// sftpUpload passes the writes of an upload in order to the contentWriter storing them
+type sftpUpload struct {
+	mu      sync.Mutex
+	app     *application
+	w       *contentWriter
+	offset  int64
+	pending map[int64][]byte
+	// pendingSize is the total size of pending, see sftpMaxPending
+	pendingSize int
+	aborted     error
+	closed      bool
+}
+
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	switch {
+	case off < u.offset:
+		return 0, errors.New("overwriting parts of an upload is not supported")
+	case off > u.offset:
+		if _, ok := u.pending[off]; ok || u.pendingSize+len(p) > sftpMaxPending {
+			return 0, errors.New("writes must be sent in order")
+		}
+		u.pending[off] = append([]byte(nil), p...)
+		u.pendingSize += len(p)
+		return len(p), nil
+	}
+
+	n, err := u.w.Write(p)
+	u.offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	for {
+		chunk, ok := u.pending[u.offset]
+		if !ok {
+			return n, nil
+		}
+		delete(u.pending, u.offset)
+		u.pendingSize -= len(chunk)
+
+		written, err := u.w.Write(chunk)
+		u.offset += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// TransferError is called instead of a regular close when the connection is lost mid upload
+func (u *sftpUpload) TransferError(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.aborted = err
+}
+
+// Close completes the upload and reports whether the file could be stored
+func (u *sftpUpload) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return nil
+	}
+	u.closed = true
+	defer u.app.transfers.Done()
+
+	var err error
+	switch {
+	case u.aborted != nil:
+		return u.w.Abort(u.aborted)
+	case len(u.pending) > 0:
+		err = u.w.Abort(errors.New("upload is missing parts"))
+	default:
+		err = u.w.Close()
+	}
+
+	return u.app.storeError(err)
+}
+
+// serveSFTPConn runs the sftp subsystem for the sessions of an authenticated connection
+func (app *application) serveSFTPConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	// clients which never finish the handshake must not hold on to the connection
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+
+	conn.SetDeadline(time.Time{})
+
+	go ssh.DiscardRequests(reqs)
+
+	id, err := strconv.ParseInt(sconn.Permissions.Extensions["user_id"], 10, 64)
+	if err != nil {
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		app.logger.Error(err.Error(), "user_id", id)
+		return
+	}
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			defer channel.Close()
+
+			for req := range requests {
+				// the payload of a subsystem request is the length prefixed subsystem name
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				go ssh.DiscardRequests(requests)
+
+				handler := &sftpHandler{app: app, user: user, addr: remoteHost(conn.RemoteAddr().String())}
+				server := sftp.NewRequestServer(channel, handler.handlers())
+
+				err := server.Serve()
+				if err != nil && !errors.Is(err, io.EOF) {
+					app.logger.Error(err.Error(), "user_id", user.ID)
+				}
+				server.Close()
+				return
+			}
+		}()
+	}
+}
+
+// serveSFTP runs the SFTP server on -sftp-port until stop is called, which waits for
+// running transfers until ctx is done
+func (app *application) serveSFTP() (stop func(ctx context.Context), err error) {
+	hostKey, err := app.sftpHostKey()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: app.sftpPasswordCallback,
+	}
+	config.AddHostKey(hostKey)
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.sftp.port))
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	open := make(map[net.Conn]struct{})
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					app.logger.Error(err.Error(), "addr", lis.Addr().String())
+				}
+				return
+			}
+
+			mu.Lock()
+			open[conn] = struct{}{}
+			mu.Unlock()
+
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(open, conn)
+					mu.Unlock()
+				}()
+
+				app.serveSFTPConn(conn, config)
+			}()
+		}
+	}()
+
+	app.logger.Info("starting sftp server", "addr", lis.Addr().String())
+
+	// idle sessions would keep the server up until ctx is done, so connections are closed
+	// as soon as the transfers are finished, like the HTTP server closes idle connections
+	stop = func(ctx context.Context) {
+		lis.Close()
+
+		finished := make(chan struct{})
+		go func() {
+			app.transfers.Wait()
+			close(finished)
+		}()
+
+		select {
+		case <-finished:
+		case <-ctx.Done():
+		}
+
+		mu.Lock()
+		for conn := range open {
+			conn.Close()
+		}
+		mu.Unlock()
+	}
+
+	return stop, nil
+}
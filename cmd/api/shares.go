@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// shareUserFileHandler shares a file with an email address. If the address has an activated account the
+// file shows up in its GET /users/shared-with-me, otherwise the code is sent to the address. The response
+// is the same either way, so it cannot be used to find out who has an account.
+func (app *application) shareUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.WithContext(r.Context()).GetFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if file.Pending {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	recipient, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil && recipient.ID == user.ID:
+		v.AddError("email", "must not be your own email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case err == nil && recipient.Activated:
+		err = app.models.FileShares.Insert(file.ID, recipient.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case err == nil || errors.Is(err, models.ErrRecordNotFound):
+		app.sendFileShare(app.contextGetLogger(r), user, file, input.Email)
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "the file was shared with " + input.Email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendFileShare emails the code of file to an address without an account
+func (app *application) sendFileShare(logger *slog.Logger, sender *models.User, file *models.File, email string) {
+	app.background(logger, func() {
+		data := map[string]interface{}{
+			"senderName":        sender.Name,
+			"fileName":          file.FullName(),
+			"fileCode":          file.Code,
+			"downloadLink":      app.config.publicURL + "/d/" + url.PathEscape(file.Code),
+			"expiry":            file.Expiry.UTC().Format(time.RFC1123),
+			"passwordProtected": file.PasswordProtected,
+		}
+
+		err := app.mailer.Send(email, "file_shared.tmpl", data)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	})
+}
+
+// listSharedWithMeHandler lists the files other users shared with the user, downloaded by their code
+func (app *application) listSharedWithMeHandler(w http.ResponseWriter, r *http.Request) {
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = "-shared_at"
+	filters.SortSafelist = []string{"-shared_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	files, metadata, err := app.models.FileShares.GetAllForUser(app.contextGetUser(r), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": files, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/models/mocks"
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestApplication returns an application whose files, users and tokens are kept in memory. Only handlers
+// which touch nothing else can be tested with it, the other models have no database.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: mocks.NewModels(),
+	}
+}
+
+// insertUser adds an activated user with email
+func insertUser(t *testing.T, app *application, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Name: "Test", Email: email, Activated: true}
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// insertFile adds a file of user which expires in an hour
+func insertFile(t *testing.T, app *application, user *models.User, name, code string) *models.File {
+	t.Helper()
+
+	file := &models.File{
+		Name:   name,
+		Size:   4,
+		Code:   code,
+		Expiry: time.Now().Add(time.Hour),
+		UserID: &user.ID,
+	}
+
+	err := app.models.Files.Insert(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// withURLParam sets a route parameter of chi on r, as if r had been routed
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+// decodeResponse decodes the JSON body of rr into dst
+func decodeResponse(t *testing.T, rr *httptest.ResponseRecorder, dst any) {
+	t.Helper()
+
+	err := json.NewDecoder(rr.Body).Decode(dst)
+	if err != nil {
+		t.Fatalf("decoding the response %q: %v", rr.Body.String(), err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// throttleChunk is the most a single write waits for at once, so the rate stays smooth for large writes
+const throttleChunk = 32 << 10
+
+// tokenBucket allows rate bytes per second with bursts of up to one chunk. Waiting reserves the
+// tokens right away, so concurrent writers sharing a bucket queue up behind each other.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: throttleChunk, last: time.Now()}
+}
+
+// wait blocks until n bytes may be sent or ctx is done
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > throttleChunk {
+		b.tokens = throttleChunk
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// downloadThrottle hands out the buckets shared by all downloads of a client, a bucket is
+// dropped once the last download using it has finished
+type downloadThrottle struct {
+	mu      sync.Mutex
+	rate    int64
+	buckets map[string]*sharedBucket
+}
+
+type sharedBucket struct {
+	*tokenBucket
+	users int
+}
+
+func newDownloadThrottle(rate int64) *downloadThrottle {
+	return &downloadThrottle{rate: rate, buckets: make(map[string]*sharedBucket)}
+}
+
+// acquire returns the bucket of key, it has to be released with the returned function
+func (t *downloadThrottle) acquire(key string) (*tokenBucket, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[key]
+	if bucket == nil {
+		bucket = &sharedBucket{tokenBucket: newTokenBucket(t.rate)}
+		t.buckets[key] = bucket
+	}
+	bucket.users++
+
+	return bucket.tokenBucket, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		bucket.users--
+		if bucket.users == 0 {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// clientKey identifies who makes a request, the user if authenticated and the IP address otherwise
+func (app *application) clientKey(r *http.Request) string {
+	return userOrAddrKey(app.contextGetUser(r), r.RemoteAddr)
+}
+
+func userOrAddrKey(user *models.User, remoteAddr string) string {
+	if !user.IsAnonymous() {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	return "ip:" + remoteHost(remoteAddr)
+}
+
+// remoteHost strips the port from the address of a client
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// waitBuckets blocks until n bytes may be sent by every bucket or ctx is done
+func waitBuckets(ctx context.Context, buckets []*tokenBucket, n int) error {
+	for _, bucket := range buckets {
+		err := bucket.wait(ctx, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttledWriter waits for every bucket before passing a write on
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+
+		err := waitBuckets(w.ctx, w.buckets, len(chunk))
+		if err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledReader waits for every bucket before a read, for the downloads of gRPC, which are not
+// written to a ResponseWriter
+type throttledReader struct {
+	io.Reader
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	err := waitBuckets(r.ctx, r.buckets, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	return r.Reader.Read(p)
+}
+
+// downloadBuckets returns the buckets a download of the client key waits for, a new one for
+// -download-connection-rate and the one of the client for -download-client-rate, which has to be
+// released with the returned function. There are none if downloads are not throttled.
+func (app *application) downloadBuckets(key string) ([]*tokenBucket, func()) {
+	var buckets []*tokenBucket
+	release := func() {}
+
+	if app.config.downloads.connectionRate > 0 {
+		buckets = append(buckets, newTokenBucket(app.config.downloads.connectionRate))
+	}
+
+	if app.downloadThrottle != nil {
+		var bucket *tokenBucket
+		bucket, release = app.downloadThrottle.acquire(key)
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, release
+}
+
+// throttleDownload caps the bandwidth of a download at -download-connection-rate and that of all
+// downloads of the same client together at -download-client-rate
+func (app *application) throttleDownload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buckets, release := app.downloadBuckets(app.clientKey(r))
+		defer release()
+
+		if len(buckets) > 0 {
+			// a throttled download takes as long as the rate makes it, not just the usual write timeout
+			rc := http.NewResponseController(w)
+			err := rc.SetWriteDeadline(time.Time{})
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), buckets: buckets}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
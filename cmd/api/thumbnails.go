@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// thumbnailSize is the longest side of a thumbnail in pixels
+	thumbnailSize = 256
+	// thumbnailConcurrency bounds the images decoded at once, each may take up to 4 bytes per pixel
+	thumbnailConcurrency = 2
+	// maxThumbnailPixels keeps a small file claiming huge dimensions from exhausting the memory
+	maxThumbnailPixels = 50_000_000
+)
+
+// thumbnailTypes are the content types thumbnails are generated for
+var thumbnailTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+var errImageTooLarge = errors.New("image is too large for a thumbnail")
+
+// queueThumbnail generates the thumbnail of a newly stored image in the background. Encrypted files
+// get none, a preview stored in plain text would give away what the encryption protects.
+func (app *application) queueThumbnail(logger *slog.Logger, file *models.File) {
+	if !slices.Contains(thumbnailTypes, file.ContentType) || file.EncryptionKey != nil {
+		return
+	}
+
+	// the caller may still change file once it has returned
+	copied := *file
+
+	app.background(logger, func() {
+		app.createThumbnail(logger, &copied)
+	})
+}
+
+func (app *application) createThumbnail(logger *slog.Logger, file *models.File) {
+	app.thumbnailSlots <- struct{}{}
+	defer func() { <-app.thumbnailSlots }()
+
+	thumbnail, err := app.renderThumbnail(file)
+	if err != nil {
+		// mostly images which are damaged or too large, the file itself is not affected
+		logger.Info("thumbnail not generated", "file_id", file.ID, "error", err.Error())
+		return
+	}
+
+	// the content was replaced or the file deleted in the meantime
+	err = app.models.Files.UpdateThumbnail(file, thumbnail)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		logger.Error(err.Error(), "file_id", file.ID)
+	}
+}
+
+// renderThumbnail decodes the image of file and encodes it scaled down as JPEG, transparent
+// parts become white
+func (app *application) renderThumbnail(file *models.File) ([]byte, error) {
+	content, err := app.openFileContent(context.Background(), file, "")
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	config, _, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("%w: %dx%d", errImageTooLarge, config.Width, config.Height)
+	}
+
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+
+	scaled := scaleDown(src, thumbnailSize)
+
+	dst := image.NewRGBA(scaled.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), scaled, image.Point{}, draw.Over)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleDown shrinks src to fit into a square of size pixels, every pixel of the result is the average
+// of the pixels of src it covers. Images which already fit keep their size.
+func scaleDown(src image.Image, size int) *image.RGBA64 {
+	b := src.Bounds()
+
+	w, h := b.Dx(), b.Dy()
+	switch {
+	case w <= size && h <= size:
+	case w >= h:
+		w, h = size, max(1, h*size/w)
+	default:
+		w, h = max(1, w*size/h), size
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			// the components are premultiplied by alpha, so they can be averaged as they are
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// getFileThumbnailFromCodeHandler sends the thumbnail of an image behind a code, it is not counted as a download
+func (app *application) getFileThumbnailFromCodeHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	file, err := app.models.Files.GetFromCode(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkFileAccess(w, r, file) || !app.checkFilePassword(w, r, file) {
+		return
+	}
+
+	if !app.checkFileScanned(w, r, file) {
+		return
+	}
+
+	if !file.HasThumbnail {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	thumbnail, err := app.models.Files.GetThumbnail(file.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fileETag(file))
+
+	http.ServeContent(w, r, "", file.LastUpdated, bytes.NewReader(thumbnail))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+// tlsEnabled reports whether the API server serves HTTPS, with -tls-cert or -autocert-domains
+func (app *application) tlsEnabled() bool {
+	return app.config.tls.certFile != "" || len(app.config.tls.autocert.domains) > 0
+}
+
+// tlsConfig returns the TLS configuration of the API server, nil if it serves plain HTTP. The autocert
+// manager is returned as well for the HTTP-01 challenges, it is nil unless -autocert-domains is set.
+func (app *application) tlsConfig() (*tls.Config, *autocert.Manager, error) {
+	switch {
+	case len(app.config.tls.autocert.domains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(app.config.tls.autocert.domains...),
+			Cache:      autocert.DirCache(app.config.tls.autocert.cacheDir),
+			Email:      app.config.tls.autocert.email,
+		}
+
+		// also answers TLS-ALPN-01 challenges, which work without the redirect server if the port is 443
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+
+		return config, manager, nil
+	case app.config.tls.certFile != "":
+		cert, err := tls.LoadX509KeyPair(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		config := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		return config, nil, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// configureHTTP2 sets up HTTP/2 on srv, or turns it off with -http2=false. Only HTTPS is served over
+// HTTP/2, clients of plain HTTP stay on HTTP/1.1.
+func (app *application) configureHTTP2(srv *http.Server) error {
+	if !app.config.tls.http2 {
+		// a non-nil map keeps net/http from adding h2, the autocert configuration offers it already
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		srv.TLSConfig.NextProtos = slices.DeleteFunc(slices.Clone(srv.TLSConfig.NextProtos), func(proto string) bool {
+			return proto == "h2"
+		})
+		return nil
+	}
+
+	return http2.ConfigureServer(srv, &http2.Server{
+		IdleTimeout: srv.IdleTimeout,
+		// the default window of 1MB per stream leaves uploads waiting on links with a long round trip,
+		// downloads depend on the windows of the client
+		MaxUploadBufferPerStream:     8 << 20,
+		MaxUploadBufferPerConnection: 32 << 20,
+	})
+}
+
+// serveHTTP3 starts serving the handler of srv over HTTP/3 on the UDP port with the number of its TCP port,
+// and has srv announce it in Alt-Svc so clients switch over for their next requests. Like HTTP/2 the flow
+// control windows are larger than the defaults.
+func (app *application) serveHTTP3(srv *http.Server) (stop func(ctx context.Context), err error) {
+	h3 := &http3.Server{
+		Addr:      srv.Addr,
+		Handler:   srv.Handler,
+		TLSConfig: srv.TLSConfig,
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout:             srv.IdleTimeout,
+			MaxStreamReceiveWindow:     8 << 20,
+			MaxConnectionReceiveWindow: 32 << 20,
+		},
+		Logger: app.logger,
+	}
+
+	conn, err := net.ListenPacket("udp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := h3.Serve(conn)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(err.Error(), "addr", conn.LocalAddr().String())
+		}
+	}()
+
+	app.logger.Info("starting http3 server", "addr", conn.LocalAddr().String())
+
+	handler := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fails until the listener is up, the next responses announce it
+		_ = h3.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+
+	stop = func(ctx context.Context) {
+		// quic-go cannot shut down gracefully, so the uploads and downloads in flight get until the end
+		// of ctx before the connections are closed
+		done := make(chan struct{})
+		go func() {
+			app.transfers.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+
+		h3.Close()
+		conn.Close()
+	}
+
+	return stop, nil
+}
+
+// serveRedirect starts the plain HTTP server of -http-redirect-port, it redirects everything to the HTTPS
+// API server except for the HTTP-01 challenges of manager, if there is one
+func (app *application) serveRedirect(manager *autocert.Manager) (stop func(ctx context.Context), err error) {
+	var handler http.Handler = http.HandlerFunc(app.redirectHTTPS)
+	if manager != nil {
+		handler = manager.HTTPHandler(handler)
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+		Handler:      handler,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	lis, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := srv.Serve(lis)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(err.Error(), "addr", lis.Addr().String())
+		}
+	}()
+
+	app.logger.Info("starting redirect server", "addr", lis.Addr().String())
+
+	stop = func(ctx context.Context) {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			srv.Close()
+		}
+	}
+
+	return stop, nil
+}
+
+// redirectHTTPS sends the client to the same URL on the API server. 308 keeps the method and body, so
+// API clients which follow it do not turn an upload into a GET.
+func (app *application) redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if host == "" {
+		http.Error(w, "missing host", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case app.config.port != 443:
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	case strings.Contains(host, ":"):
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestServeHTTP3(t *testing.T) {
+	app := newTestApplication(t)
+
+	// httptest has a certificate for 127.0.0.1
+	certs := httptest.NewUnstartedServer(nil)
+	certs.StartTLS()
+	certs.Close()
+
+	srv := &http.Server{
+		Addr:        "127.0.0.1:0",
+		TLSConfig:   certs.TLS,
+		IdleTimeout: time.Minute,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}),
+	}
+
+	stop, err := app.serveHTTP3(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop(context.Background())
+
+	// the TCP server announces the UDP port in Alt-Svc once the listener is up
+	var altSvc string
+	for i := 0; i < 100 && altSvc == ""; i++ {
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		altSvc = rr.Header().Get("Alt-Svc")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, port, ok := strings.Cut(altSvc, `h3=":`)
+	port, _, _ = strings.Cut(port, `"`)
+	if !ok || port == "" {
+		t.Fatalf("got Alt-Svc %q, want the HTTP/3 port", altSvc)
+	}
+
+	client := &http.Client{Transport: &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://127.0.0.1:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("got protocol %q, want HTTP/3.0", body)
+	}
+}
@@ -29,35 +29,38 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	attempt := &models.LoginAttempt{
+		Email:     input.Email,
+		IP:        remoteHost(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+	}
+
+	user, err := app.authenticatePassword(attempt, input.Password)
 	if err != nil {
+		var lockedErr *loginLockedError
 		switch {
-		case errors.Is(err, models.ErrRecordNotFound):
+		case errors.As(err, &lockedErr):
+			app.loginLockedResponse(w, r, lockedErr.wait)
+		case errors.Is(err, errInvalidCredentials):
 			app.invalidCredentialsResponse(w, r)
+		case errors.Is(err, errSuspendedAccount):
+			app.suspendedAccountResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	if !match {
-		app.invalidCredentialsResponse(w, r)
-		return
-	}
+	attempt.Success = true
+	app.recordLoginAttempt(attempt)
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	token, refresh, err := app.startSession(r, user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -104,14 +107,15 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	app.background(func() {
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 		}
 
 		err = app.mailer.Send(user.Email, "activation_token.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			logger.Error(err.Error())
 		}
 	})
 
@@ -163,14 +167,15 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	app.background(func() {
+	logger := app.contextGetLogger(r)
+	app.background(logger, func() {
 		data := map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
 		}
 
 		err = app.mailer.Send(user.Email, "password_reset_token.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			logger.Error(err.Error())
 		}
 	})
 
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// trace records a span for every request, continuing the trace of the caller if it sent a traceparent header
+func (app *application) trace(next http.Handler) http.Handler {
+	if app.tracer == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := app.tracer.Start(ctx, r.Method, tracing.KindServer,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("request_id", app.contextGetRequestID(r)),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// the pattern is only known once the request has been routed
+		if pattern := chi.RouteContext(ctx).RoutePattern(); pattern != "" {
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(tracing.String("http.route", pattern))
+		}
+
+		span.SetAttributes(tracing.Int64("http.response.status_code", int64(ww.Status())))
+		if ww.Status() >= 500 {
+			span.SetError(errors.New(http.StatusText(ww.Status())))
+		}
+	})
+}
+
+// tracedStorage returns the storage backend recording its operations as spans of the trace in ctx
+func (app *application) tracedStorage(ctx context.Context) storage.Backend {
+	if app.tracer == nil {
+		return app.storage
+	}
+
+	return tracedBackend{Backend: app.storage, tracer: app.tracer, ctx: ctx, name: app.config.Storage.Backend}
+}
+
+type tracedBackend struct {
+	storage.Backend
+	tracer *tracing.Tracer
+	ctx    context.Context
+	name   string
+}
+
+func (b tracedBackend) start(operation, key string) *tracing.Span {
+	_, span := b.tracer.Start(b.ctx, "storage."+operation, tracing.KindClient,
+		tracing.String("storage.backend", b.name),
+		tracing.String("storage.key", key),
+	)
+	return span
+}
+
+// Put also records how long it waited for r, for uploads mostly the client sending the content,
+// which tells a slow client apart from a slow backend
+func (b tracedBackend) Put(key string, r io.Reader, size int64) error {
+	span := b.start("Put", key)
+	defer span.End()
+
+	source := &waitReader{r: r}
+	err := b.Backend.Put(key, source, size)
+
+	span.SetAttributes(
+		tracing.Int64("storage.bytes", source.n),
+		tracing.Int64("storage.source_wait_ms", source.wait.Milliseconds()),
+	)
+	span.SetError(err)
+
+	return err
+}
+
+// Get records a span lasting until the object is closed, so it covers reading it
+func (b tracedBackend) Get(key string) (io.ReadSeekCloser, error) {
+	span := b.start("Get", key)
+
+	object, err := b.Backend.Get(key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrObjectNotFound) {
+			span.SetError(err)
+		}
+		span.End()
+		return nil, err
+	}
+
+	return &tracedObject{ReadSeekCloser: object, span: span}, nil
+}
+
+func (b tracedBackend) Delete(key string) error {
+	span := b.start("Delete", key)
+	defer span.End()
+
+	err := b.Backend.Delete(key)
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+func (b tracedBackend) Stat(key string) (*storage.Info, error) {
+	span := b.start("Stat", key)
+	defer span.End()
+
+	info, err := b.Backend.Stat(key)
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		span.SetError(err)
+	}
+
+	return info, err
+}
+
+// waitReader sums up the time spent in and the bytes returned by Read
+type waitReader struct {
+	r    io.Reader
+	n    int64
+	wait time.Duration
+}
+
+func (w *waitReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.r.Read(p)
+	w.wait += time.Since(start)
+	w.n += int64(n)
+	return n, err
+}
+
+type tracedObject struct {
+	io.ReadSeekCloser
+	span *tracing.Span
+	n    int64
+}
+
+func (o *tracedObject) Read(p []byte) (int, error) {
+	n, err := o.ReadSeekCloser.Read(p)
+	o.n += int64(n)
+	return n, err
+}
+
+func (o *tracedObject) Close() error {
+	err := o.ReadSeekCloser.Close()
+	o.span.SetAttributes(tracing.Int64("storage.bytes", o.n))
+	o.span.End()
+	return err
+}
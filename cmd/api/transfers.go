@@ -0,0 +1,412 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/filename"
+	"github.com/Li-Elias/File-Transfer/internal/metadata"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// maxArchiveFiles bounds the number of files extracted from the zip archive of a transfer
+const maxArchiveFiles = 1000
+
+// maxTransferFiles bounds the number of file parts of a transfer, the body is limited to that many files
+// of the maximum file size
+const maxTransferFiles = 100
+
+// transferEntry is a file of a new transfer together with where its content comes from
+type transferEntry struct {
+	file *models.File
+	open func() (io.ReadCloser, error)
+}
+
+// createTransferHandler stores the file parts of a multipart body as a transfer under one code. Names
+// with folders in front, as browsers send them for a directory, keep the folders. With ?extract=true the
+// single file part is a zip archive whose files become the transfer instead.
+func (app *application) createTransferHandler(w http.ResponseWriter, r *http.Request) {
+	// ParseMultipartForm writes what does not fit into memory to temporary files, like readMultipartFile
+	// there is 1MB on top for the form fields
+	maxBody := maxTransferFiles * app.config.files.maxSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody+1_048_576)
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			v := validator.New()
+			v.AddError("file", fmt.Sprintf("must not be more than %d bytes big together", maxBody))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File["file"]
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	v.Check(len(headers) > 0, "file", "must be provided")
+	v.Check(len(headers) <= maxTransferFiles, "file", fmt.Sprintf("must not be more than %d files", maxTransferFiles))
+
+	ttl := app.readExpiresIn(r.Form, v)
+	strip := app.readStripMetadata(r.Form, v)
+	extract := app.readBool(r.URL.Query(), "extract", false, v)
+	if extract {
+		v.Check(len(headers) <= 1, "file", "must be a single zip archive to extract")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transfer := &models.Transfer{
+		Code:   app.generateCode(user),
+		Expiry: time.Now().Add(ttl),
+		UserID: user.ID,
+	}
+
+	entries := []transferEntry{}
+	if extract {
+		archive, err := headers[0].Open()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer archive.Close()
+
+		entries, err = app.archiveEntries(user, archive, headers[0].Size, ttl, v)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		for _, handler := range headers {
+			folder, name := filename.SanitizePath(multipartPath(handler))
+
+			new_file := app.newFile(user, name, handler.Size, ttl)
+			new_file.Folder = folder
+
+			open := func() (io.ReadCloser, error) {
+				return handler.Open()
+			}
+			entries = append(entries, transferEntry{file: new_file, open: open})
+		}
+	}
+
+	names := []string{}
+	size := int64(0)
+	for _, entry := range entries {
+		entry.file.Expiry = transfer.Expiry
+
+		models.ValidateFile(v, entry.file, app.config.files.maxSize)
+		transfer.Files = append(transfer.Files, entry.file)
+		names = append(names, entry.file.FullName())
+		size += entry.file.Size
+	}
+
+	v.Check(validator.Unique(names), "file", "must not contain duplicate file names")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the body was counted by requireDiskSpace, the content of an archive is only known now
+	if extract {
+		err = app.checkDiskSpace(app.contextGetLogger(r), size)
+		if err != nil {
+			app.insufficientStorageResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Transfers.Insert(transfer)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, entry := range entries {
+		entry.file.TransferID = &transfer.ID
+
+		err = app.createTransferFile(r, entry, strip)
+		if err != nil {
+			app.deleteTransfer(transfer)
+
+			var typeErr *fileTypeError
+			switch {
+			case errors.As(err, &typeErr):
+				v.AddError("file", fmt.Sprintf("%s: %s", entry.file.FullName(), typeErr.Error()))
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum):
+				v.AddError("file", fmt.Sprintf("%s: is damaged in the archive", entry.file.FullName()))
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, metadata.ErrInvalid), errors.Is(err, metadata.ErrUnsupported):
+				v.AddError("strip_metadata", fmt.Sprintf("%s: %s", entry.file.FullName(), err.Error()))
+				app.failedValidationResponse(w, r, v.Errors)
+			case isOutOfSpace(err):
+				app.insufficientStorageResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"transfer": transfer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// multipartPath returns the file name of a part as the client sent it. FileHeader.Filename
+// drops the folders in front of it, which browsers send for the files of a directory.
+func multipartPath(handler *multipart.FileHeader) string {
+	_, params, err := mime.ParseMediaType(handler.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return handler.Filename
+	}
+	return params["filename"]
+}
+
+// archiveEntries returns the files of a zip archive as entries of a transfer, folders only appear through
+// the paths of the files in them. Problems with the archive are added to v.
+func (app *application) archiveEntries(user *models.User, archive io.ReaderAt, size int64, ttl time.Duration, v *validator.Validator) ([]transferEntry, error) {
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		switch {
+		case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrAlgorithm), errors.Is(err, zip.ErrChecksum):
+			v.AddError("file", "must be a zip archive")
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	entries := []transferEntry{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if len(entries) == maxArchiveFiles {
+			v.AddError("file", fmt.Sprintf("must not contain more than %d files", maxArchiveFiles))
+			return nil, nil
+		}
+
+		folder, name := filename.SanitizePath(f.Name)
+
+		// zip checks the content against the size it declares
+		new_file := app.newFile(user, name, int64(min(f.UncompressedSize64, math.MaxInt64)), ttl)
+		new_file.Folder = folder
+
+		entries = append(entries, transferEntry{file: new_file, open: f.Open})
+	}
+
+	return entries, nil
+}
+
+func (app *application) createTransferFile(r *http.Request, entry transferEntry, strip bool) error {
+	content, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	opts := storeOptions{stripMetadata: strip, logger: app.contextGetLogger(r), ctx: r.Context()}
+
+	return app.createFile(entry.file, content, entry.file.Size, opts)
+}
+
+// deleteTransfer removes a transfer together with the files which were already stored for it
+func (app *application) deleteTransfer(transfer *models.Transfer) {
+	for _, file := range transfer.Files {
+		if file.ID == 0 {
+			continue
+		}
+
+		err := app.models.Files.Delete(file.ID)
+		switch {
+		case err == nil:
+			// it was stored and announced as uploaded
+			app.fileEvent(models.EventFileDeleted, file)
+		case !errors.Is(err, models.ErrRecordNotFound):
+			app.logger.Error(err.Error(), "file_id", file.ID)
+		}
+
+		err = app.storage.Delete(file.Path)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			app.logger.Error(err.Error(), "file_id", file.ID)
+		}
+	}
+
+	err := app.models.Transfers.Delete(transfer.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.logger.Error(err.Error(), "transfer_id", transfer.ID)
+	}
+}
+
+// readTransferFromCode looks up a transfer and its files, transfers without files left are not found
+func (app *application) readTransferFromCode(w http.ResponseWriter, r *http.Request, code string) (*models.Transfer, []*models.File, bool) {
+	transfer, err := app.models.Transfers.GetFromCode(code)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	files, err := app.models.Files.GetAllFromTransfer(transfer)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	if len(files) == 0 {
+		app.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	return transfer, files, true
+}
+
+func setTransferHeaders(w http.ResponseWriter, transfer *models.Transfer, format string) {
+	setArchiveHeaders(w, "transfer-"+transfer.Code, format)
+	w.Header().Set("X-File-Expiry", transfer.Expiry.UTC().Format(time.RFC3339))
+}
+
+// transferFileFromPath returns the file of a transfer chosen with the path query parameter, a name
+// with its folder in front, or nil if the whole transfer is asked for
+func (app *application) transferFileFromPath(w http.ResponseWriter, r *http.Request, files []*models.File) (*models.File, bool) {
+	qs := r.URL.Query()
+	if !qs.Has("path") {
+		return nil, true
+	}
+
+	for _, file := range files {
+		if file.FullName() == qs.Get("path") {
+			return file, true
+		}
+	}
+
+	app.notFoundResponse(w, r)
+	return nil, false
+}
+
+// readTransferFormat reads the archive format a whole transfer is downloaded in
+func (app *application) readTransferFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	v := validator.New()
+
+	format := app.readArchiveFormat(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return format, true
+}
+
+// getTransferFromCode streams all files of a transfer as a single zip or tar.gz archive, or with ?path= only one of them
+func (app *application) getTransferFromCode(w http.ResponseWriter, r *http.Request, code string) {
+	transfer, files, ok := app.readTransferFromCode(w, r, code)
+	if !ok {
+		return
+	}
+
+	file, ok := app.transferFileFromPath(w, r, files)
+	if !ok {
+		return
+	}
+	if file != nil {
+		if app.checkFileAccess(w, r, file) {
+			app.serveFile(w, r, file, false)
+		}
+		return
+	}
+
+	format, ok := app.readTransferFormat(w, r)
+	if !ok {
+		return
+	}
+
+	for _, file := range files {
+		if !app.checkFileAccess(w, r, file) || !app.checkFileScanned(w, r, file) {
+			return
+		}
+	}
+
+	setTransferHeaders(w, transfer, format)
+
+	err := app.writeArchive(r.Context(), w, format, files)
+	if err != nil {
+		// the response has already started, all we can do is log and abort the archive
+		app.logError(r, err)
+	}
+}
+
+// headTransferFromCode sends the headers of a transfer download, the archive size is only known once it is written
+func (app *application) headTransferFromCode(w http.ResponseWriter, r *http.Request, code string) {
+	transfer, files, ok := app.readTransferFromCode(w, r, code)
+	if !ok {
+		return
+	}
+
+	file, ok := app.transferFileFromPath(w, r, files)
+	if !ok {
+		return
+	}
+	if file != nil {
+		headFile(w, r, file)
+		return
+	}
+
+	format, ok := app.readTransferFormat(w, r)
+	if !ok {
+		return
+	}
+
+	setTransferHeaders(w, transfer, format)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) getTransferMetaFromCode(w http.ResponseWriter, r *http.Request, code string) {
+	transfer, files, ok := app.readTransferFromCode(w, r, code)
+	if !ok {
+		return
+	}
+
+	meta := make([]fileMeta, len(files))
+	for i, file := range files {
+		meta[i] = newFileMeta(file)
+	}
+
+	data := envelope{
+		"transfer": envelope{
+			"code":   transfer.Code,
+			"expiry": transfer.Expiry,
+			"files":  meta,
+		},
+	}
+
+	err := app.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
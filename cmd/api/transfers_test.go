@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateTransferHandlerBodyLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.files.maxSize = 1024
+
+	user := insertUser(t, app, "owner@example.com")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	// far more than 1MB on top of maxTransferFiles files of 1KB, spread over parts which are each small enough
+	for i := 0; i < 2000; i++ {
+		part, err := mw.CreateFormFile("file", fmt.Sprintf("part%d.txt", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(bytes.Repeat([]byte("x"), 1000))
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/users/transfers", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = app.contextSetUser(r, user)
+	rr := httptest.NewRecorder()
+
+	app.createTransferHandler(rr, r)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+	}
+
+	var resp struct {
+		Error map[string]string `json:"error"`
+	}
+	decodeResponse(t, rr, &resp)
+
+	want := fmt.Sprintf("must not be more than %d bytes big together", maxTransferFiles*1024)
+	if resp.Error["file"] != want {
+		t.Errorf("got error %q, want %q", resp.Error["file"], want)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *application) listUserTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var filters models.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = []string{"name", "size", "deleted_at", "-name", "-size", "-deleted_at"}
+
+	if models.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	files, metadata, err := app.models.Files.GetTrashFromUser(user, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"files": files, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreUserFileHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	file, err := app.models.Files.Restore(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"file": file}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash permanently deletes the files which have been in the trash for longer than -trash-retention
+func (app *application) purgeTrash() error {
+	before := time.Now().Add(-app.config.trash.retention)
+
+	files, err := app.models.Files.GetAllTrashedBefore(before)
+	if err != nil {
+		return err
+	}
+
+	purged := 0
+
+	for _, file := range files {
+		if app.shutdown.Err() != nil {
+			break
+		}
+
+		err := app.models.Files.DeleteTrashed(file.ID, before)
+		if err != nil {
+			if !errors.Is(err, models.ErrRecordNotFound) {
+				app.logger.Error(err.Error(), "file_id", file.ID)
+			}
+			continue
+		}
+
+		app.deleteBlob(app.logger, file)
+		app.fileEvent(models.EventFileDeleted, file)
+
+		purged++
+	}
+
+	if purged > 0 {
+		app.logger.Info("purged trashed files", "count", purged)
+	}
+
+	return nil
+}
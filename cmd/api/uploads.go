@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/filename"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/storage"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+// resumable uploads following the tus.io core protocol with the creation and expiration extensions
+
+const (
+	tusVersion = "1.0.0"
+	uploadTTL  = 24 * time.Hour
+)
+
+func uploadChunkKey(uploadID string, index int) string {
+	return fmt.Sprintf("uploads/%s/%d", uploadID, index)
+}
+
+func (app *application) tusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			app.unsupportedTusVersionResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) uploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(app.config.files.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("Upload-Length header must be a valid integer"))
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	upload := &models.Upload{
+		Name:    filename.Sanitize(metadata["filename"]),
+		Length:  length,
+		FileTTL: app.readExpiresIn(url.Values{"expires_in": {metadata["expires_in"]}}, v),
+		Expiry:  time.Now().Add(uploadTTL),
+		UserID:  user.ID,
+	}
+
+	if models.ValidateUpload(v, upload, app.config.files.maxSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// completeUpload checks the plan again, this is so that the chunks are not uploaded for nothing
+	_, err = app.applyPlan(&models.File{UserID: &user.ID, Expiry: time.Now().Add(upload.FileTTL)}, nil, length)
+	if err != nil {
+		app.completeUploadErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Uploads.Insert(upload)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if upload.IsComplete() {
+		new_file, err := app.completeUpload(r, upload, user)
+		if err != nil {
+			app.completeUploadErrorResponse(w, r, err)
+			return
+		}
+		setUploadedFileHeaders(w, new_file)
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/uploads/%s", upload.ID))
+	w.Header().Set("Upload-Expires", upload.Expiry.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (app *application) getUploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.GetFromUser(chi.URLParam(r, "id"), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expiry.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.badRequestResponse(w, r, errors.New("Upload-Offset header must be a valid integer"))
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.GetFromUser(chi.URLParam(r, "id"), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if offset != upload.Offset {
+		app.uploadOffsetConflictResponse(w, r)
+		return
+	}
+
+	if r.ContentLength > upload.Length-upload.Offset {
+		app.contentTooLargeResponse(w, r)
+		return
+	}
+
+	body := &countingReader{r: http.MaxBytesReader(w, r.Body, upload.Length-upload.Offset)}
+	progress := &progressReader{r: body, progress: app.progress, upload: upload, last: time.Now()}
+
+	err = app.storage.Put(uploadChunkKey(upload.ID, upload.Chunks), progress, r.ContentLength)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.contentTooLargeResponse(w, r)
+		case isOutOfSpace(err):
+			app.insufficientStorageResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Uploads.Advance(upload, body.n)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.uploadOffsetConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.progress.publishOffset(upload, upload.Offset)
+
+	if upload.IsComplete() {
+		new_file, err := app.completeUpload(r, upload, user)
+		if err != nil {
+			message := "the upload could not be completed"
+			var typeErr *fileTypeError
+			if errors.As(err, &typeErr) {
+				message = typeErr.Error()
+			}
+			app.progress.publish(upload.ID, uploadEvent{name: "error", data: envelope{"error": message}})
+			app.completeUploadErrorResponse(w, r, err)
+			return
+		}
+		app.progress.publish(upload.ID, uploadEvent{name: "complete", data: envelope{"file": new_file}})
+		setUploadedFileHeaders(w, new_file)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expiry.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeUpload joins the chunks of a finished upload into a new file and removes the upload
+func (app *application) completeUpload(r *http.Request, upload *models.Upload, user *models.User) (*models.File, error) {
+	new_file := app.newFile(user, upload.Name, upload.Length, upload.FileTTL)
+
+	chunks := &chunkReader{storage: app.storage, upload: upload}
+	defer chunks.Close()
+
+	err := app.createFile(new_file, chunks, upload.Length, storeOptions{logger: app.contextGetLogger(r), ctx: r.Context()})
+	if err != nil {
+		// the upload can never complete with a rejected file type, other errors may go away on a retry
+		var typeErr *fileTypeError
+		if errors.As(err, &typeErr) {
+			app.deleteUpload(upload)
+		}
+		return nil, err
+	}
+
+	app.deleteUpload(upload)
+
+	return new_file, nil
+}
+
+func (app *application) deleteUpload(upload *models.Upload) {
+	err := app.models.Uploads.Delete(upload.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.logger.Error(err.Error(), "upload_id", upload.ID)
+	}
+
+	for i := 0; i < upload.Chunks; i++ {
+		err := app.storage.Delete(uploadChunkKey(upload.ID, i))
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			app.logger.Error(err.Error(), "upload_id", upload.ID)
+		}
+	}
+}
+
+func (app *application) completeUploadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var typeErr *fileTypeError
+	var planErr *planLimitError
+
+	switch {
+	case errors.As(err, &typeErr):
+		v := validator.New()
+		v.AddError("file", typeErr.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.As(err, &planErr):
+		v := validator.New()
+		v.AddError(planErr.key, planErr.message)
+		app.failedValidationResponse(w, r, v.Errors)
+	case isOutOfSpace(err):
+		app.insufficientStorageResponse(w, r, err)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func setUploadedFileHeaders(w http.ResponseWriter, file *models.File) {
+	w.Header().Set("X-File-ID", strconv.FormatInt(file.ID, 10))
+	w.Header().Set("X-File-Code", file.Code)
+}
+
+// parseUploadMetadata decodes the comma separated "key base64value" pairs of an Upload-Metadata header
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+
+		switch len(parts) {
+		case 0:
+			continue
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Upload-Metadata value for %q must be base64 encoded", parts[0])
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, errors.New("Upload-Metadata header is malformed")
+		}
+	}
+
+	return metadata, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// chunkReader reads the stored chunks of an upload one after another
+type chunkReader struct {
+	storage storage.Backend
+	upload  *models.Upload
+	index   int
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if c.index >= c.upload.Chunks {
+				return 0, io.EOF
+			}
+
+			chunk, err := c.storage.Get(uploadChunkKey(c.upload.ID, c.index))
+			if err != nil {
+				return 0, err
+			}
+			c.current = chunk
+			c.index++
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
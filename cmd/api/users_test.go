@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+func TestActivateUserHandler(t *testing.T) {
+	app := newTestApplication(t)
+
+	user := &models.User{Name: "Test", Email: "new@example.com"}
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, models.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authToken, err := app.models.Tokens.New(user.ID, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activate := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/v1/users/activated", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		app.activateUserHandler(rr, r)
+		return rr
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"malformed body", `{"token":`, http.StatusBadRequest},
+		{"unknown field", `{"token": "` + token.Plaintext + `", "id": 1}`, http.StatusBadRequest},
+		{"short token", `{"token": "abc"}`, http.StatusUnprocessableEntity},
+		{"token of another scope", `{"token": "` + authToken.Plaintext + `"}`, http.StatusUnprocessableEntity},
+		{"valid token", `{"token": "` + token.Plaintext + `"}`, http.StatusOK},
+		{"token used twice", `{"token": "` + token.Plaintext + `"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := activate(tt.body)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+
+	stored, err := app.models.Users.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Activated {
+		t.Error("the user is not activated")
+	}
+
+	// the authentication token of the user is not touched by the activation
+	_, err = app.models.Users.GetByToken(models.ScopeAuthentication, authToken.Plaintext)
+	if err != nil {
+		t.Errorf("the authentication token stopped working: %v", err)
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/filename"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/net/webdav"
+)
+
+const webdavPrefix = "/webdav"
+
+// chi only routes the methods it knows about
+func init() {
+	for _, method := range []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"} {
+		chi.RegisterMethod(method)
+	}
+}
+
+// webdavRoutes serves the files of the authenticated user as a single directory which can be
+// mounted as a network drive. WebDAV clients send their credentials with every request and make
+// a lot of them, so it has its own authentication and rate limit instead of the ones of the API.
+func (app *application) webdavRoutes() http.Handler {
+	handler := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: &webdavFS{app: app},
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	router := chi.NewRouter()
+
+	router.Use(app.Logger)
+	router.Use(middleware.Recoverer)
+	router.Use(app.rateLimit(app.config.limits.webdav))
+	router.Use(app.webdavAuthenticate)
+	router.Use(app.trackTransfer)
+
+	router.Handle("/", handler)
+	router.Handle("/*", handler)
+	// only requests moving content count towards -transfer-concurrency, clients send many PROPFINDs at once
+	router.With(app.limitTransfers, app.throttleDownload).Get("/*", app.webdavGetHandler)
+	router.Head("/*", app.webdavGetHandler)
+	router.With(app.limitTransfers, app.requireDiskSpace, app.uploadDeadline).Put("/*", handler.ServeHTTP)
+
+	return router
+}
+
+// webdavAuthenticate logs requests in with basic auth, using the email address and password of the
+// account or an API key as the password. Keys are limited to their scopes, reading needs files:read
+// and everything else files:write.
+func (app *application) webdavAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		email, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="File-Transfer"`)
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		var user *models.User
+		var apiKey *models.APIKey
+		var err error
+
+		if strings.HasPrefix(password, models.APIKeyPrefix) {
+			user, apiKey, err = app.authenticateToken(password)
+		} else {
+			// clients send the credentials with every request, so only the failed logins are recorded
+			attempt := &models.LoginAttempt{Email: email, IP: remoteHost(r.RemoteAddr), UserAgent: r.UserAgent()}
+			user, err = app.authenticatePassword(attempt, password)
+		}
+		if err != nil {
+			var lockedErr *loginLockedError
+			switch {
+			case errors.As(err, &lockedErr):
+				app.loginLockedResponse(w, r, lockedErr.wait)
+			case errors.Is(err, errInvalidToken), errors.Is(err, errInvalidCredentials):
+				w.Header().Set("WWW-Authenticate", `Basic realm="File-Transfer"`)
+				app.invalidCredentialsResponse(w, r)
+			case errors.Is(err, errSuspendedAccount):
+				app.suspendedAccountResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		permission := models.PermissionFilesWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			permission = models.PermissionFilesRead
+		}
+
+		if !app.permitted(user, apiKey, permission) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		if apiKey != nil {
+			r = app.contextSetAPIKey(r, apiKey)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// webdavGetHandler serves file content like serveFile does, without counting it as a download.
+// webdav.Handler would answer files which cannot be read yet with a bare 500.
+func (app *application) webdavGetHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	name := strings.TrimPrefix(r.URL.Path, webdavPrefix)
+	if name == "" || name == "/" {
+		app.methodNotAllowedResponse(w, r)
+		return
+	}
+
+	fs := &webdavFS{app: app}
+
+	file, err := fs.getFile(user, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	content, err := fs.openContent(r.Context(), file)
+	if err != nil {
+		switch {
+		case errors.Is(err, errScanPending):
+			app.fileScanPendingResponse(w, r)
+		case errors.Is(err, errQuarantined):
+			app.fileQuarantinedResponse(w, r)
+		case errors.Is(err, errPassphraseRequired):
+			app.filePassphraseRequiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fileETag(file))
+
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	start_time := time.Now()
+
+	http.ServeContent(ww, r, file.Name, file.LastUpdated, content)
+
+	app.logDownload(r, file, ww, start_time)
+}
+
+// webdavFS implements webdav.FileSystem on the files of the user of the request
+type webdavFS struct {
+	app *application
+}
+
+// fileName returns the name of the file at p, which has to be right below the root directory.
+// Names the file would not be stored under are refused, the client would not find it again.
+func (fs *webdavFS) fileName(p string) (string, error) {
+	p = path.Clean("/" + p)
+	if p == "/" || path.Dir(p) != "/" {
+		return "", os.ErrNotExist
+	}
+
+	name := path.Base(p)
+	if filename.Sanitize(name) != name {
+		return "", os.ErrNotExist
+	}
+
+	return name, nil
+}
+
+func (fs *webdavFS) getFile(user *models.User, p string) (*models.File, error) {
+	name, err := fs.fileName(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := fs.app.models.Files.GetFromUserByName(name, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, os.ErrNotExist
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// openContent opens the content of a file for its owner, the passphrase never reaches the server
+func (fs *webdavFS) openContent(ctx context.Context, file *models.File) (io.ReadSeekCloser, error) {
+	err := fileScanError(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.PassphraseProtected {
+		return nil, errPassphraseRequired
+	}
+
+	return fs.app.openFileContent(ctx, file, "")
+}
+
+func isRoot(name string) bool {
+	return path.Clean("/"+name) == "/"
+}
+
+func (fs *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// OpenFile only writes when the file is truncated, which is how PUT opens it. Other writable
+// opens, like the one of PROPPATCH, get a handle which cannot be written to.
+func (fs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	app := fs.app
+	user := ctx.Value(userContextKey).(*models.User)
+
+	if isRoot(name) {
+		if flag&os.O_TRUNC != 0 {
+			return nil, os.ErrPermission
+		}
+		return &webdavDir{app: app, user: user}, nil
+	}
+
+	file, err := fs.getFile(user, name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		if file == nil {
+			return nil, os.ErrNotExist
+		}
+		return &webdavFile{fs: fs, file: file}, nil
+	}
+
+	if file == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+
+		file_name, err := fs.fileName(name)
+		if err != nil {
+			return nil, os.ErrPermission
+		}
+
+		new_file := app.newFile(user, file_name, 0, app.config.files.defaultExpiry)
+
+		v := validator.New()
+		if models.ValidateFile(v, new_file, app.config.files.maxSize); !v.Valid() {
+			return nil, errors.New(joinValidationErrors(v.Errors))
+		}
+
+		upload := &webdavUpload{
+			app:  app,
+			info: *new_file,
+			w: app.newContentWriter(func(r io.Reader) error {
+				return app.createFile(new_file, r, -1, storeOptions{ctx: ctx})
+			}),
+		}
+		return upload, nil
+	}
+
+	// replacing the content would silently drop the passphrase, held files keep theirs
+	if file.PassphraseProtected || file.LegalHold {
+		return nil, os.ErrPermission
+	}
+
+	auditObject(ctx, "file", file.ID)
+
+	// like PUT /users/files/{id} the old content survives a failed write, the code stays the same
+	upload := &webdavUpload{
+		app:  app,
+		info: *file,
+		w: app.newContentWriter(func(r io.Reader) error {
+			r, err := app.applyPlanReplacing(file, r, -1)
+			if err != nil {
+				return err
+			}
+			return app.storeFileContent(file, r, -1, storeOptions{})
+		}),
+	}
+	return upload, nil
+}
+
+func (fs *webdavFS) RemoveAll(ctx context.Context, name string) error {
+	user := ctx.Value(userContextKey).(*models.User)
+
+	if isRoot(name) {
+		return os.ErrPermission
+	}
+
+	file, err := fs.getFile(user, name)
+	if err != nil {
+		return err
+	}
+
+	auditObject(ctx, "file", file.ID)
+
+	_, err = fs.app.deleteUserFile(file.ID, user)
+	switch {
+	case errors.Is(err, models.ErrRecordNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, errLegalHold):
+		return os.ErrPermission
+	}
+
+	return err
+}
+
+// Rename changes the name of a file, webdav.Handler removes a file at newName first if
+// the client asked to overwrite it
+func (fs *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
+	user := ctx.Value(userContextKey).(*models.User)
+
+	file, err := fs.getFile(user, oldName)
+	if err != nil {
+		return err
+	}
+
+	file.Name, err = fs.fileName(newName)
+	if err != nil {
+		return os.ErrPermission
+	}
+
+	auditObject(ctx, "file", file.ID)
+
+	v := validator.New()
+	if models.ValidateFile(v, file, fs.app.config.files.maxSize); !v.Valid() {
+		return errors.New(joinValidationErrors(v.Errors))
+	}
+
+	// a conflict means the file was changed or deleted since it was looked up
+	err = fs.app.models.Files.UpdateDetails(file)
+	if errors.Is(err, models.ErrEditConflict) {
+		return os.ErrNotExist
+	}
+
+	return err
+}
+
+func (fs *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	user := ctx.Value(userContextKey).(*models.User)
+
+	if isRoot(name) {
+		return fileInfo{}, nil
+	}
+
+	file, err := fs.getFile(user, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{file: file}, nil
+}
+
+// ContentType saves webdav.Handler from opening every listed file to sniff its type
+func (fi fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.file.ContentType, nil
+}
+
+func (fi fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return fileETag(fi.file), nil
+}
+
+// webdavDir is the root directory, listing the newest file of every name
+type webdavDir struct {
+	app   *application
+	user  *models.User
+	files []os.FileInfo
+	read  bool
+}
+
+func (d *webdavDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		files, err := d.app.models.Files.GetAllLatestFromUser(d.user)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			d.files = append(d.files, fileInfo{file: file})
+		}
+		d.read = true
+	}
+
+	if count <= 0 {
+		files := d.files
+		d.files = nil
+		return files, nil
+	}
+
+	if len(d.files) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.files) {
+		count = len(d.files)
+	}
+
+	files := d.files[:count]
+	d.files = d.files[count:]
+
+	return files, nil
+}
+
+func (d *webdavDir) Stat() (os.FileInfo, error) {
+	return fileInfo{}, nil
+}
+
+func (d *webdavDir) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *webdavDir) Close() error {
+	return nil
+}
+
+// webdavFile is a file opened for reading, the content is only opened once it is read so
+// listings and property requests do not touch the storage
+type webdavFile struct {
+	mu      sync.Mutex
+	fs      *webdavFS
+	file    *models.File
+	content io.ReadSeekCloser
+}
+
+func (f *webdavFile) open() error {
+	if f.content != nil {
+		return nil
+	}
+
+	content, err := f.fs.openContent(context.Background(), f.file)
+	if err != nil {
+		return err
+	}
+	f.content = content
+
+	return nil
+}
+
+func (f *webdavFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.open()
+	if err != nil {
+		return 0, err
+	}
+
+	return f.content.Read(p)
+}
+
+func (f *webdavFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.open()
+	if err != nil {
+		return 0, err
+	}
+
+	return f.content.Seek(offset, whence)
+}
+
+func (f *webdavFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	return fileInfo{file: f.file}, nil
+}
+
+func (f *webdavFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *webdavFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.content == nil {
+		return nil
+	}
+
+	err := f.content.Close()
+	f.content = nil
+
+	return err
+}
+
+// webdavUpload is a file opened by PUT, what is written to it is stored once it is closed
+type webdavUpload struct {
+	app *application
+	// info is a copy of the file taken when it was opened, the file itself is
+	// changed by the store function while the content is written
+	info   models.File
+	w      *contentWriter
+	closed bool
+}
+
+func (u *webdavUpload) Write(p []byte) (int, error) {
+	n, err := u.w.Write(p)
+	u.info.Size += int64(n)
+	return n, err
+}
+
+// Stat is called before Close, the size is what has been written so far
+func (u *webdavUpload) Stat() (os.FileInfo, error) {
+	info := u.info
+	return fileInfo{file: &info}, nil
+}
+
+func (u *webdavUpload) Close() error {
+	if u.closed {
+		return nil
+	}
+	u.closed = true
+
+	return u.app.storeError(u.w.Close())
+}
+
+func (u *webdavUpload) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (u *webdavUpload) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (u *webdavUpload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
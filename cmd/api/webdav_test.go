@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestWebdavOpenFileLegalHold(t *testing.T) {
+	app := newTestApplication(t)
+
+	owner := insertUser(t, app, "owner@example.com")
+	file := insertFile(t, app, owner, "report.pdf", "CODE1234")
+
+	_, err := app.models.Files.SetLegalHold(file.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &webdavFS{app: app}
+	ctx := context.WithValue(context.Background(), userContextKey, owner)
+
+	tests := []struct {
+		name    string
+		flag    int
+		wantErr error
+	}{
+		{"overwrite", os.O_WRONLY | os.O_TRUNC, os.ErrPermission},
+		{"overwrite or create", os.O_RDWR | os.O_CREATE | os.O_TRUNC, os.ErrPermission},
+		{"read", os.O_RDONLY, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := fs.OpenFile(ctx, "/report.pdf", tt.flag, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if f != nil {
+				f.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	webhookInterval    = 10 * time.Second
+	webhookMaxAttempts = 8
+	webhookBatchSize   = 50
+)
+
+// webhookClient only dials public addresses like the client of remote fetches. Redirects are not
+// followed, a webhook has to answer at its registered URL.
+var webhookClient = func() *http.Client {
+	client := newFetchClient(10 * time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}()
+
+// createWebhookHandler returns the signing secret once, later listings leave it out
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	webhook := &models.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		UserID: user.ID,
+	}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// deliveries would never reach a name resolving to a private address, so it is refused right away
+	err = checkPublicHost(r.Context(), webhook.URL)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPrivateAddress):
+			v.AddError("url", "must not point to a private or loopback address")
+		default:
+			v.AddError("url", "must have a host name which resolves")
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	webhooks, err := app.models.Webhooks.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSONETag(w, r, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id_str := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Webhooks.DeleteFromUser(id, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyWebhooks queues the payload of event for the webhooks of the owner of file, see fileEvent
+func (app *application) notifyWebhooks(event string, file *models.File, payload []byte) {
+	if file.UserID == nil {
+		return
+	}
+
+	err := app.models.Webhooks.Enqueue(*file.UserID, event, payload)
+	if err != nil {
+		app.logger.Error(err.Error(), "file_id", file.ID, "event", event)
+	}
+}
+
+// deliverWebhooks sends queued deliveries until the server shuts down. The queue lives in the
+// database, so deliveries which are due while the server is down are sent after a restart.
+func (app *application) deliverWebhooks() {
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+
+	for {
+		deliveries, err := app.models.Webhooks.GetDueDeliveries(webhookBatchSize)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		for _, delivery := range deliveries {
+			if app.shutdown.Err() != nil {
+				return
+			}
+			app.deliverWebhook(delivery)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.shutdown.Done():
+			return
+		}
+	}
+}
+
+func (app *application) deliverWebhook(delivery *models.WebhookDelivery) {
+	logger := app.logger.With("delivery_id", delivery.ID, "webhook_id", delivery.WebhookID)
+
+	err := sendWebhook(delivery)
+	if err == nil {
+		err = app.models.Webhooks.DeleteDelivery(delivery.ID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	delivery.Attempts++
+	logger = logger.With("attempts", delivery.Attempts)
+
+	if delivery.Attempts >= webhookMaxAttempts {
+		logger.Error(fmt.Sprintf("giving up on webhook delivery: %s", err))
+
+		err = app.models.Webhooks.DeleteDelivery(delivery.ID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	logger.Info(fmt.Sprintf("webhook delivery failed: %s", err))
+
+	// 30s, 1m, 2m, ... about 2 hours in total before giving up
+	delivery.NextAttempt = time.Now().Add(30 * time.Second << (delivery.Attempts - 1))
+
+	err = app.models.Webhooks.RetryDelivery(delivery)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// sendWebhook posts the payload of delivery. The X-Webhook-Signature header is the hex encoded
+// HMAC-SHA256 of the X-Webhook-Timestamp value, a dot and the body, keyed with the webhook secret.
+func sendWebhook(delivery *models.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(delivery.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "File-Transfer-Webhook")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type client struct {
+	cfg  *config
+	http *http.Client
+}
+
+func (c *client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.cfg.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+
+	return req, nil
+}
+
+// do sends req and decodes the JSON response into dst, which may be nil.
+// Error responses are turned into an error carrying the message of the API.
+func (c *client) do(req *http.Request, dst interface{}) error {
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return responseError(res)
+	}
+
+	if dst == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+// responseError reads the {"error": ...} envelope of the API, the value is either a
+// message or, for failed validation, a map of field names to messages
+func responseError(res *http.Response) error {
+	var env struct {
+		Error interface{} `json:"error"`
+	}
+
+	err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&env)
+	if err != nil || env.Error == nil {
+		return fmt.Errorf("server responded with %s", res.Status)
+	}
+
+	switch e := env.Error.(type) {
+	case string:
+		return fmt.Errorf("%s", e)
+	case map[string]interface{}:
+		fields := []string{}
+		for field, message := range e {
+			fields = append(fields, fmt.Sprintf("%s %v", field, message))
+		}
+		sort.Strings(fields)
+		return fmt.Errorf("%s", strings.Join(fields, ", "))
+	default:
+		return fmt.Errorf("server responded with %s", res.Status)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config is read from $XDG_CONFIG_HOME/ft/config.json (or the platform equivalent),
+// FT_API_URL and FT_TOKEN take precedence over the file
+type config struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("FT_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "ft", "config.json"), nil
+}
+
+func loadConfig() (*config, error) {
+	cfg := &config{URL: "http://localhost:4000"}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(data, cfg)
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, fs.ErrNotExist):
+	default:
+		return nil, err
+	}
+
+	if url := os.Getenv("FT_API_URL"); url != "" {
+		cfg.URL = url
+	}
+	if token := os.Getenv("FT_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	return cfg, nil
+}
@@ -0,0 +1,287 @@
+// Command ft is a command-line client for the File-Transfer API.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: ft <command> [flags] [arguments]
+
+Commands:
+  upload <path>   upload a file and print its code
+  get <code>      download the file or transfer of a code
+  list            list your files
+  delete <id>     delete one of your files
+
+The API URL and token are read from FT_API_URL and FT_TOKEN, or from the
+"url" and "token" keys of the JSON file at FT_CONFIG (by default config.json
+in the ft directory of the user configuration directory).
+`
+
+type file struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Code      string    `json:"code"`
+	Expiry    time.Time `json:"expiry"`
+	Downloads int       `json:"download_count"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ft: reading config:", err)
+		os.Exit(1)
+	}
+
+	c := &client{cfg: cfg, http: &http.Client{}}
+
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "upload":
+		err = c.upload(args)
+	case "get":
+		err = c.get(args)
+	case "list":
+		err = c.list(args)
+	case "delete":
+		err = c.delete(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "ft: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ft:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *client) upload(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	expiresIn := fs.String("expires-in", "", "lifetime of the file, such as 30m or 24h")
+	password := fs.String("password", "", "password needed to download the file")
+	maxDownloads := fs.Int("max-downloads", 0, "delete the file after this many downloads")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("upload needs exactly one path")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// the body is streamed, the server needs the form fields ahead of the file part
+	body, w := io.Pipe()
+	mw := multipart.NewWriter(w)
+	bar := newProgress(os.Stderr, info.Name(), info.Size())
+
+	go func() {
+		fields := map[string]string{"expires_in": *expiresIn, "password": *password}
+		if *maxDownloads > 0 {
+			fields["max_downloads"] = strconv.Itoa(*maxDownloads)
+		}
+
+		for key, value := range fields {
+			if value == "" {
+				continue
+			}
+			err := mw.WriteField(key, value)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := mw.CreateFormFile("file", info.Name())
+		if err == nil {
+			_, err = io.Copy(part, io.TeeReader(f, bar))
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		w.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(http.MethodPost, "/users/files", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var res struct {
+		File file `json:"file"`
+	}
+
+	err = c.do(req, &res)
+	bar.finish()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\texpires %s\n", res.File.Code, res.File.Expiry.Local().Format(time.RFC1123))
+
+	return nil
+}
+
+func (c *client) get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output := fs.String("o", "", "write to this path instead of the name sent by the server")
+	password := fs.String("password", "", "password of the file")
+	passphrase := fs.String("passphrase", "", "passphrase of an encrypted file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("get needs exactly one code")
+	}
+
+	req, err := c.newRequest(http.MethodGet, "/files/"+url.PathEscape(fs.Arg(0)), nil)
+	if err != nil {
+		return err
+	}
+	if *password != "" {
+		req.Header.Set("X-File-Password", *password)
+	}
+	if *passphrase != "" {
+		req.Header.Set("X-File-Passphrase", *passphrase)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return responseError(res)
+	}
+
+	path := *output
+	if path == "" {
+		path = fs.Arg(0)
+		_, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+		// only the base name, a malicious server must not be able to write outside the working directory
+		name := filepath.Base(params["filename"])
+		if err == nil && name != "." && name != string(filepath.Separator) {
+			path = name
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	bar := newProgress(os.Stderr, filepath.Base(path), res.ContentLength)
+
+	_, err = io.Copy(io.MultiWriter(f, bar), res.Body)
+	bar.finish()
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+
+	return nil
+}
+
+func (c *client) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	name := fs.String("name", "", "only list files whose name contains this value")
+	page := fs.Int("page", 1, "page to list")
+	fs.Parse(args)
+
+	qs := url.Values{}
+	qs.Set("page", strconv.Itoa(*page))
+	if *name != "" {
+		qs.Set("name", *name)
+	}
+
+	req, err := c.newRequest(http.MethodGet, "/users/files?"+qs.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Files    []file `json:"files"`
+		Metadata struct {
+			CurrentPage  int `json:"current_page"`
+			LastPage     int `json:"last_page"`
+			TotalRecords int `json:"total_records"`
+		} `json:"metadata"`
+	}
+
+	err = c.do(req, &res)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSIZE\tCODE\tDOWNLOADS\tEXPIRY")
+	for _, f := range res.Files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", f.ID, f.Name, formatSize(f.Size), f.Code, f.Downloads, f.Expiry.Local().Format(time.RFC1123))
+	}
+	tw.Flush()
+
+	if res.Metadata.LastPage > 1 {
+		fmt.Fprintf(os.Stderr, "page %d of %d, %d files\n", res.Metadata.CurrentPage, res.Metadata.LastPage, res.Metadata.TotalRecords)
+	}
+
+	return nil
+}
+
+func (c *client) delete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("delete needs exactly one id")
+	}
+
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil || id < 1 {
+		return fmt.Errorf("invalid id %q", fs.Arg(0))
+	}
+
+	req, err := c.newRequest(http.MethodDelete, "/users/files/"+strconv.FormatInt(id, 10), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const progressWidth = 30
+
+// progress draws a bar on w while bytes pass through it, total is -1 if the size is unknown
+type progress struct {
+	w       io.Writer
+	name    string
+	total   int64
+	current int64
+	drawn   time.Time
+}
+
+func newProgress(w io.Writer, name string, total int64) *progress {
+	return &progress{w: w, name: name, total: total}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.current += int64(len(b))
+
+	// redrawing on every write slows down fast transfers for no visible difference
+	if time.Since(p.drawn) >= 100*time.Millisecond {
+		p.draw()
+	}
+
+	return len(b), nil
+}
+
+func (p *progress) draw() {
+	p.drawn = time.Now()
+
+	if p.total <= 0 {
+		fmt.Fprintf(p.w, "\r%s %s", p.name, formatSize(p.current))
+		return
+	}
+
+	done := int(progressWidth * p.current / p.total)
+	if done > progressWidth {
+		done = progressWidth
+	}
+
+	fmt.Fprintf(p.w, "\r%s [%s%s] %3d%% %s/%s", p.name,
+		strings.Repeat("=", done), strings.Repeat(" ", progressWidth-done),
+		100*p.current/p.total, formatSize(p.current), formatSize(p.total))
+}
+
+// finish draws the final state and ends the line
+func (p *progress) finish() {
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,316 @@
+// Package abuse slows down clients which guess codes. Failed lookups are counted per IP address and per
+// network, once a client has too many of them it has to wait out an exponentially growing backoff between
+// lookups and may be asked to solve a challenge, a CAPTCHA or a proof of work, for every further lookup.
+// Addresses which keep going are locked out.
+package abuse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Delete for keys without a record
+var ErrNotFound = errors.New("abuse: no record")
+
+// Config decides when a client is treated as guessing
+type Config struct {
+	// Threshold is the number of failed lookups after which an address is suspicious
+	Threshold int
+	// PrefixThreshold is Threshold for the /24 IPv4 or /64 IPv6 network of an address, 0 disables it
+	PrefixThreshold int
+	// LockoutThreshold is the number of failed lookups after which an address is locked out, 0 disables it.
+	// Networks are never locked out, many honest clients may share them.
+	LockoutThreshold int
+	// Lockout is how long an address stays locked out
+	Lockout time.Duration
+	// Window is how long failed lookups are remembered after the last one
+	Window time.Duration
+	// MaxBackoff bounds the time a suspicious client has to wait between lookups
+	MaxBackoff time.Duration
+}
+
+// Record is what a Store keeps about a key, an address prefixed with ip: or a network prefixed with net:
+type Record struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	// State is locked, slowed or tracked, it is filled in by Tracker.Records
+	State string `json:"state"`
+}
+
+// Store keeps the records of failed lookups, in memory or in a database shared by all instances
+type Store interface {
+	// Get returns the record of key, a zero record if there is none
+	Get(ctx context.Context, key string) (Record, error)
+	// Fail counts a failed lookup at now, failures before since are forgotten first
+	Fail(ctx context.Context, key string, now, since time.Time) (Record, error)
+	// Lock locks key out until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	// List returns the records with failures after since or locked after now, most failures first
+	List(ctx context.Context, since, now time.Time) ([]Record, error)
+	Delete(ctx context.Context, key string) error
+	// DeleteExpired removes the records which List would leave out
+	DeleteExpired(ctx context.Context, since, now time.Time) error
+}
+
+// Status is what a client may do next
+type Status struct {
+	// Delay is how long the lookup is held before it goes ahead
+	Delay time.Duration
+	// RetryAfter is set if the lookup may not go ahead, for the time until the next one may
+	RetryAfter time.Duration
+	// Locked is set if the address is locked out, RetryAfter is then the time left of the lockout
+	Locked bool
+	// Suspicious is set once the address or its network reached the threshold of failed lookups
+	Suspicious bool
+}
+
+// Tracker decides about the lookups of clients. The failures are kept in the store, while the next lookup
+// a suspicious address may do and the codes it found stay in memory, they only matter for minutes.
+type Tracker struct {
+	cfg   Config
+	store Store
+
+	mu      sync.Mutex
+	clients map[string]*client
+	now     func() time.Time
+}
+
+type client struct {
+	// next is the earliest time of the next lookup, so concurrent lookups queue up behind each other
+	next time.Time
+	// passes are the codes the client already found after solving a challenge, with their expiry
+	passes map[string]time.Time
+	seen   time.Time
+}
+
+func NewTracker(cfg Config, store Store) *Tracker {
+	return &Tracker{cfg: cfg, store: store, clients: make(map[string]*client), now: time.Now}
+}
+
+// Keys returns the keys addr is counted under, the address itself and its network
+func Keys(addr string) (address, network string) {
+	address = "ip:" + addr
+
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return address, ""
+	case ip.To4() != nil:
+		return address, "net:" + (&net.IPNet{IP: ip.To4().Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	default:
+		return address, "net:" + (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+	}
+}
+
+// Admit decides about a lookup of code by addr. A lookup which has to wait no more than maxWait is
+// admitted with that Delay, longer waits are turned away with RetryAfter. Codes passed to Pass go
+// through as if the client were not suspicious, so it can download what it found without another challenge.
+func (t *Tracker) Admit(ctx context.Context, addr, code string, maxWait time.Duration) (Status, error) {
+	now := t.now()
+
+	t.mu.Lock()
+	c := t.clients[addr]
+	if c != nil {
+		if expiry, ok := c.passes[code]; ok && now.Before(expiry) {
+			t.mu.Unlock()
+			return Status{}, nil
+		}
+	}
+	t.mu.Unlock()
+
+	addressKey, networkKey := Keys(addr)
+
+	address, err := t.store.Get(ctx, addressKey)
+	if err != nil {
+		return Status{}, err
+	}
+
+	if address.LockedUntil != nil && now.Before(*address.LockedUntil) {
+		return Status{Locked: true, RetryAfter: address.LockedUntil.Sub(now)}, nil
+	}
+
+	var network Record
+	if networkKey != "" && t.cfg.PrefixThreshold > 0 {
+		network, err = t.store.Get(ctx, networkKey)
+		if err != nil {
+			return Status{}, err
+		}
+	}
+
+	// the backoff of whichever is further over its threshold applies
+	var allowed time.Time
+	var backoff time.Duration
+	suspicious := false
+
+	for _, check := range []struct {
+		record    Record
+		threshold int
+	}{{address, t.cfg.Threshold}, {network, t.cfg.PrefixThreshold}} {
+		if check.threshold == 0 || check.record.Failures < check.threshold || now.Sub(check.record.LastFailure) > t.cfg.Window {
+			continue
+		}
+
+		suspicious = true
+		d := t.backoff(check.record.Failures - check.threshold)
+		if check.record.LastFailure.Add(d).After(allowed) {
+			allowed = check.record.LastFailure.Add(d)
+		}
+		backoff = max(backoff, d)
+	}
+
+	if !suspicious {
+		return Status{}, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c = t.clients[addr]
+	if c == nil {
+		c = &client{}
+		t.clients[addr] = c
+	}
+	c.seen = now
+
+	if c.next.After(allowed) {
+		allowed = c.next
+	}
+
+	wait := max(allowed.Sub(now), 0)
+	if wait > maxWait {
+		return Status{RetryAfter: wait, Suspicious: true}, nil
+	}
+
+	c.next = now.Add(wait + backoff)
+
+	return Status{Delay: wait, Suspicious: true}, nil
+}
+
+// Fail records a lookup of addr which found nothing, it reports whether the address got locked out by it
+func (t *Tracker) Fail(ctx context.Context, addr string) (bool, error) {
+	now := t.now()
+	since := now.Add(-t.cfg.Window)
+
+	addressKey, networkKey := Keys(addr)
+
+	address, err := t.store.Fail(ctx, addressKey, now, since)
+	if err != nil {
+		return false, err
+	}
+
+	if networkKey != "" && t.cfg.PrefixThreshold > 0 {
+		_, err = t.store.Fail(ctx, networkKey, now, since)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if t.cfg.LockoutThreshold == 0 || address.Failures < t.cfg.LockoutThreshold {
+		return false, nil
+	}
+	if address.LockedUntil != nil && now.Before(*address.LockedUntil) {
+		return false, nil
+	}
+
+	err = t.store.Lock(ctx, addressKey, now.Add(t.cfg.Lockout))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Pass lets a suspicious addr look up code without further challenges until the failures are forgotten
+func (t *Tracker) Pass(addr, code string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.clients[addr]
+	if c == nil {
+		return
+	}
+
+	if c.passes == nil {
+		c.passes = make(map[string]time.Time)
+	}
+	c.passes[code] = t.now().Add(t.cfg.Window)
+}
+
+// Records returns the tracked addresses and networks with their state
+func (t *Tracker) Records(ctx context.Context) ([]Record, error) {
+	now := t.now()
+
+	records, err := t.store.List(ctx, now.Add(-t.cfg.Window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		records[i].State = t.state(records[i], now)
+	}
+
+	return records, nil
+}
+
+func (t *Tracker) state(record Record, now time.Time) string {
+	if record.LockedUntil != nil && now.Before(*record.LockedUntil) {
+		return "locked"
+	}
+
+	threshold := t.cfg.Threshold
+	if strings.HasPrefix(record.Key, "net:") {
+		threshold = t.cfg.PrefixThreshold
+	}
+	if threshold > 0 && record.Failures >= threshold && now.Sub(record.LastFailure) <= t.cfg.Window {
+		return "slowed"
+	}
+
+	return "tracked"
+}
+
+// Clear forgets the failures and the lockout of key
+func (t *Tracker) Clear(ctx context.Context, key string) error {
+	err := t.store.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if addr, ok := strings.CutPrefix(key, "ip:"); ok {
+		t.mu.Lock()
+		delete(t.clients, addr)
+		t.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Sweep removes the records and clients whose failures are forgotten and which are not locked out
+func (t *Tracker) Sweep(ctx context.Context) error {
+	now := t.now()
+	since := now.Add(-t.cfg.Window)
+
+	t.mu.Lock()
+	for addr, c := range t.clients {
+		if c.seen.Before(since) {
+			delete(t.clients, addr)
+		}
+	}
+	t.mu.Unlock()
+
+	return t.store.DeleteExpired(ctx, since, now)
+}
+
+// backoff is the time to wait after the last failure, it starts at a second on reaching the
+// threshold and doubles with every further failure
+func (t *Tracker) backoff(n int) time.Duration {
+	if n >= 32 {
+		return t.cfg.MaxBackoff
+	}
+	return min(time.Second<<n, t.cfg.MaxBackoff)
+}
@@ -0,0 +1,91 @@
+package abuse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Captcha verifies the tokens of a CAPTCHA widget with the siteverify API of its provider. hCaptcha and
+// Cloudflare Turnstile share the same API.
+type Captcha struct {
+	Type      string
+	SiteKey   string
+	SecretKey string
+	VerifyURL string
+	Client    *http.Client
+}
+
+func NewHCaptcha(siteKey, secretKey string) *Captcha {
+	return &Captcha{
+		Type:      TypeHCaptcha,
+		SiteKey:   siteKey,
+		SecretKey: secretKey,
+		VerifyURL: "https://api.hcaptcha.com/siteverify",
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func NewTurnstile(siteKey, secretKey string) *Captcha {
+	return &Captcha{
+		Type:      TypeTurnstile,
+		SiteKey:   siteKey,
+		SecretKey: secretKey,
+		VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issue returns the site key, the widget itself comes from the provider
+func (c *Captcha) Issue(addr string) (*Challenge, error) {
+	return &Challenge{Type: c.Type, SiteKey: c.SiteKey}, nil
+}
+
+// Verify asks the provider whether solution is the token of a solved CAPTCHA, tokens are good for a single use
+func (c *Captcha) Verify(ctx context.Context, solution, addr string) error {
+	if solution == "" {
+		return ErrChallengeFailed
+	}
+
+	form := url.Values{
+		"secret":   {c.SecretKey},
+		"response": {solution},
+		"remoteip": {addr},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("abuse: %s siteverify returned %s", c.Type, resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrChallengeFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package abuse
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrChallengeFailed is returned for solutions which are wrong, expired or used before
+var ErrChallengeFailed = errors.New("abuse: challenge failed")
+
+// Challenge types
+const (
+	TypeProofOfWork = "pow"
+	TypeHCaptcha    = "hcaptcha"
+	TypeTurnstile   = "turnstile"
+)
+
+// Challenge tells a client what to solve. CAPTCHAs are shown with the site key, a proof of work is solved by
+// finding a nonce for which the SHA-256 hash of "token:nonce" starts with difficulty zero bits.
+type Challenge struct {
+	Type       string `json:"type"`
+	SiteKey    string `json:"site_key,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+// Challenger issues challenges and verifies their solutions, addr is the IP address of the client
+type Challenger interface {
+	Issue(addr string) (*Challenge, error)
+	Verify(ctx context.Context, solution, addr string) error
+}
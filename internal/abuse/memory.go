@@ -0,0 +1,112 @@
+package abuse
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the records of a single instance, they are gone after a restart
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if record == nil {
+		return Record{}, nil
+	}
+	return *record, nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, now, since time.Time) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if record == nil {
+		record = &Record{Key: key}
+		s.records[key] = record
+	}
+
+	if record.LastFailure.Before(since) {
+		record.Failures = 0
+	}
+	record.Failures++
+	record.LastFailure = now
+
+	return *record, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if record == nil {
+		return ErrNotFound
+	}
+	record.LockedUntil = &until
+
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, since, now time.Time) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := []Record{}
+	for _, record := range s.records {
+		if !expired(record, since, now) {
+			records = append(records, *record)
+		}
+	}
+
+	slices.SortFunc(records, func(a, b Record) int {
+		if a.Failures != b.Failures {
+			return cmp.Compare(b.Failures, a.Failures)
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return records, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records[key] == nil {
+		return ErrNotFound
+	}
+	delete(s.records, key)
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, since, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if expired(record, since, now) {
+			delete(s.records, key)
+		}
+	}
+
+	return nil
+}
+
+func expired(record *Record, since, now time.Time) bool {
+	return record.LastFailure.Before(since) && (record.LockedUntil == nil || !now.Before(*record.LockedUntil))
+}
@@ -0,0 +1,126 @@
+package abuse
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+)
+
+// powTTL is how long a proof of work challenge can be solved
+const powTTL = 5 * time.Minute
+
+// ProofOfWork issues challenges which cost the client CPU time instead of a CAPTCHA. Tokens are signed,
+// so nothing is stored until they are used, and bound to the address they were issued to.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+
+	mu sync.Mutex
+	// used are the tokens already solved with their expiry, a token is good for a single lookup
+	used      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewProofOfWork(key []byte, difficulty int) *ProofOfWork {
+	return &ProofOfWork{key: key, difficulty: difficulty, used: make(map[string]time.Time), now: time.Now}
+}
+
+// Issue returns a new token, it consists of the expiry, random bytes and the difficulty followed by a signature
+func (p *ProofOfWork) Issue(addr string) (*Challenge, error) {
+	payload := make([]byte, 25)
+	binary.BigEndian.PutUint64(payload, uint64(p.now().Add(powTTL).Unix()))
+	_, err := rand.Read(payload[8:24])
+	if err != nil {
+		return nil, err
+	}
+	payload[24] = byte(p.difficulty)
+
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload, addr))
+
+	return &Challenge{Type: TypeProofOfWork, Token: token, Difficulty: p.difficulty}, nil
+}
+
+// Verify checks a solution of the form token:nonce
+func (p *ProofOfWork) Verify(ctx context.Context, solution, addr string) error {
+	token, _, ok := strings.Cut(solution, ":")
+	if !ok {
+		return ErrChallengeFailed
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrChallengeFailed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) != 25 {
+		return ErrChallengeFailed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, p.sign(payload, addr)) {
+		return ErrChallengeFailed
+	}
+
+	now := p.now()
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !now.Before(expiry) {
+		return fmt.Errorf("%w: token expired", ErrChallengeFailed)
+	}
+
+	hash := sha256.Sum256([]byte(solution))
+	if leadingZeros(hash[:]) < int(payload[24]) {
+		return ErrChallengeFailed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sweep(now)
+
+	if _, ok := p.used[token]; ok {
+		return fmt.Errorf("%w: token used before", ErrChallengeFailed)
+	}
+	p.used[token] = expiry
+
+	return nil
+}
+
+func (p *ProofOfWork) sign(payload []byte, addr string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(payload)
+	mac.Write([]byte(addr))
+	return mac.Sum(nil)
+}
+
+// sweep forgets the used tokens which expired anyway, at most once per TTL
+func (p *ProofOfWork) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < powTTL {
+		return
+	}
+	p.lastSweep = now
+
+	for token, expiry := range p.used {
+		if !now.Before(expiry) {
+			delete(p.used, token)
+		}
+	}
+}
+
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
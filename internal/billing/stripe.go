@@ -0,0 +1,255 @@
+// Package billing sells plans as Stripe subscriptions. Users pay on a Checkout page of Stripe, which
+// then tells the webhook of the server about the subscription with signed events.
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of the events the webhook acts on, the others are acknowledged and ignored
+const (
+	EventCheckoutCompleted   = "checkout.session.completed"
+	EventSubscriptionUpdated = "customer.subscription.updated"
+	EventSubscriptionDeleted = "customer.subscription.deleted"
+)
+
+// SignatureTolerance is how old the timestamp of a signed event may be, older ones could be replayed
+const SignatureTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned for events which were not signed with the webhook secret
+var ErrInvalidSignature = errors.New("billing: invalid webhook signature")
+
+// Stripe is a client of the Stripe API
+type Stripe struct {
+	SecretKey     string
+	WebhookSecret string
+	APIURL        string
+	Client        *http.Client
+}
+
+func NewStripe(secretKey, webhookSecret string) *Stripe {
+	return &Stripe{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		APIURL:        "https://api.stripe.com",
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// CheckoutParams is a subscription to PriceID, the customer is CustomerID if they paid before or else
+// CustomerEmail. Metadata is set on the session and on the subscription it creates.
+type CheckoutParams struct {
+	PriceID           string
+	CustomerID        string
+	CustomerEmail     string
+	ClientReferenceID string
+	SuccessURL        string
+	CancelURL         string
+	Metadata          map[string]string
+}
+
+// CheckoutSession is a Checkout page, the user is sent to URL to pay
+type CheckoutSession struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	Mode              string            `json:"mode"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// Subscription is what a customer pays for, the price of its first item is the plan
+type Subscription struct {
+	ID       string            `json:"id"`
+	Customer string            `json:"customer"`
+	Status   string            `json:"status"`
+	Metadata map[string]string `json:"metadata"`
+	Items    struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// PriceID returns the price of the first item of sub
+func (sub *Subscription) PriceID() string {
+	if len(sub.Items.Data) == 0 {
+		return ""
+	}
+	return sub.Items.Data[0].Price.ID
+}
+
+// Active reports whether sub still pays for its plan
+func (sub *Subscription) Active() bool {
+	return Active(sub.Status)
+}
+
+// Active reports whether a subscription with status still pays for its plan. Subscriptions which are past
+// due keep it while Stripe retries the payment, it cancels them once it gives up.
+func Active(status string) bool {
+	switch status {
+	case "active", "trialing", "past_due":
+		return true
+	default:
+		return false
+	}
+}
+
+// Event is a signed event of the webhook, Object is the checkout session or subscription it is about
+type Event struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// CreatedAt returns when the event happened, events may be delivered out of order
+func (e *Event) CreatedAt() time.Time {
+	return time.Unix(e.Created, 0)
+}
+
+// CreateCheckoutSession starts a Checkout page for a subscription
+func (s *Stripe) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error) {
+	form := url.Values{
+		"mode":                    {"subscription"},
+		"line_items[0][price]":    {params.PriceID},
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {params.SuccessURL},
+		"cancel_url":              {params.CancelURL},
+		"client_reference_id":     {params.ClientReferenceID},
+	}
+	if params.CustomerID != "" {
+		form.Set("customer", params.CustomerID)
+	} else {
+		form.Set("customer_email", params.CustomerEmail)
+	}
+	for key, value := range params.Metadata {
+		form.Set("metadata["+key+"]", value)
+		form.Set("subscription_data[metadata]["+key+"]", value)
+	}
+
+	var session CheckoutSession
+
+	err := s.post(ctx, "/v1/checkout/sessions", form, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ParseEvent checks the Stripe-Signature header of payload and decodes it. The header has the timestamp
+// of the signature as t and one or more signatures as v1, HMAC-SHA256 of the timestamp, a dot and payload.
+func (s *Stripe) ParseEvent(payload []byte, header string, now time.Time) (*Event, error) {
+	var timestamp string
+	var signatures []string
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > SignatureTolerance || age < -SignatureTolerance {
+		return nil, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	valid := false
+	for _, signature := range signatures {
+		sig, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(sig, expected) {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// CreatePortalSession returns the URL of the billing portal of Stripe for the customer with customerID,
+// where they can change or cancel their subscription and return to returnURL
+func (s *Stripe) CreatePortalSession(ctx context.Context, customerID, returnURL string) (string, error) {
+	form := url.Values{
+		"customer":   {customerID},
+		"return_url": {returnURL},
+	}
+
+	var session struct {
+		URL string `json:"url"`
+	}
+
+	err := s.post(ctx, "/v1/billing_portal/sessions", form, &session)
+	if err != nil {
+		return "", err
+	}
+
+	return session.URL, nil
+}
+
+// post sends form to the API endpoint path and decodes the response into dst
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.APIURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.SecretKey, "")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return fmt.Errorf("billing: stripe returned %s: %s", resp.Status, result.Error.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
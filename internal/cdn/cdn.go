@@ -0,0 +1,165 @@
+// Package cdn signs download URLs of a CDN which serves the objects of the storage bucket, so the API
+// only decides who may download a file and the CDN sends it. Signed URLs expire, the CDN refuses them
+// afterwards and anything it was not signed for.
+package cdn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer returns the URL of the object key, with query added to it, which the CDN accepts until expires
+type Signer interface {
+	SignURL(key string, query url.Values, expires time.Time) (string, error)
+}
+
+// objectURL is the unsigned URL of key under base, each segment of the key is escaped
+func objectURL(base, key string, query url.Values) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	u := strings.TrimSuffix(base, "/") + "/" + strings.Join(segments, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u
+}
+
+// CloudFront signs URLs with a canned policy, checked by CloudFront against the public key of the key
+// pair in a trusted key group of the distribution
+type CloudFront struct {
+	baseURL   string
+	keyPairID string
+	key       *rsa.PrivateKey
+}
+
+func NewCloudFront(baseURL, keyPairID string, key *rsa.PrivateKey) *CloudFront {
+	return &CloudFront{baseURL: baseURL, keyPairID: keyPairID, key: key}
+}
+
+type cannedPolicy struct {
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Resource  string `json:"Resource"`
+	Condition struct {
+		DateLessThan struct {
+			EpochTime int64 `json:"AWS:EpochTime"`
+		} `json:"DateLessThan"`
+	} `json:"Condition"`
+}
+
+func (c *CloudFront) SignURL(key string, query url.Values, expires time.Time) (string, error) {
+	resource := objectURL(c.baseURL, key, query)
+
+	statement := policyStatement{Resource: resource}
+	statement.Condition.DateLessThan.EpochTime = expires.Unix()
+
+	// CloudFront rebuilds the policy from the URL to check the signature, so it has to be byte for byte
+	// the same, without the escaping of & json.Marshal does and the newline of Encode
+	var policy bytes.Buffer
+	enc := json.NewEncoder(&policy)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(cannedPolicy{Statement: []policyStatement{statement}})
+	if err != nil {
+		return "", err
+	}
+
+	// CloudFront only takes SHA-1 for signed URLs
+	digest := sha1.Sum(bytes.TrimSuffix(policy.Bytes(), []byte("\n")))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signed := url.Values{
+		"Expires":     {strconv.FormatInt(expires.Unix(), 10)},
+		"Signature":   {cloudFrontEncoding.Replace(base64.StdEncoding.EncodeToString(signature))},
+		"Key-Pair-Id": {c.keyPairID},
+	}
+
+	separator := "?"
+	if len(query) > 0 {
+		separator = "&"
+	}
+
+	return resource + separator + signed.Encode(), nil
+}
+
+// cloudFrontEncoding makes base64 safe for URLs the way CloudFront expects, which differs from base64url
+var cloudFrontEncoding = strings.NewReplacer("+", "-", "=", "_", "/", "~")
+
+// ParsePrivateKey reads the PEM encoded RSA key of a CloudFront key pair, in PKCS #1 or PKCS #8
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("cdn: no PEM encoded key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("cdn: CloudFront keys must be RSA keys")
+	}
+
+	return key, nil
+}
+
+// Cloudflare signs URLs for the verify parameter of Cloudflare token authentication: the expiry, a dash
+// and the HMAC-SHA256 of the path followed by the expiry. The query is not signed.
+type Cloudflare struct {
+	baseURL string
+	secret  []byte
+}
+
+func NewCloudflare(baseURL string, secret []byte) *Cloudflare {
+	return &Cloudflare{baseURL: baseURL, secret: secret}
+}
+
+func (c *Cloudflare) SignURL(key string, query url.Values, expires time.Time) (string, error) {
+	u, err := url.Parse(objectURL(c.baseURL, key, nil))
+	if err != nil {
+		return "", err
+	}
+
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(u.EscapedPath() + expiry))
+
+	signed := url.Values{}
+	for name, values := range query {
+		signed[name] = values
+	}
+	signed.Set("verify", expiry+"-"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	u.RawQuery = signed.Encode()
+
+	return u.String(), nil
+}
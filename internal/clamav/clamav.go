@@ -0,0 +1,117 @@
+// Package clamav scans content with a clamd daemon using its INSTREAM command.
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const chunkSize = 64 * 1024
+
+// ErrScanFailed is returned when clamd could not scan the content, for example because it
+// is larger than its StreamMaxLength. Retrying the same content fails again.
+var ErrScanFailed = errors.New("clamav: scan failed")
+
+type Client struct {
+	// Network is "tcp" or "unix"
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// New returns a client for address, which is a host:port or, if it starts with a /, a unix socket path
+func New(address string, timeout time.Duration) *Client {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+
+	return &Client{Network: network, Address: address, Timeout: timeout}
+}
+
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scan streams r to clamd and returns its verdict
+func (c *Client) Scan(r io.Reader) (*Result, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(c.Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeChunks(conn, r)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// writeChunks sends r as length prefixed chunks followed by the zero length chunk ending the stream.
+// Only errors reading r are returned: clamd closes the connection once the stream is over its size
+// limit, the reason is in its reply, and other write errors surface when reading the reply.
+func writeChunks(conn net.Conn, r io.Reader) error {
+	buf := make([]byte, 4+chunkSize)
+
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			_, werr := conn.Write(buf[:4+n])
+			if werr != nil {
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	conn.Write([]byte{0, 0, 0, 0})
+
+	return nil
+}
+
+// parseReply reads "stream: OK", "stream: <signature> FOUND" or "<reason> ERROR"
+func parseReply(reply string) (*Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return nil, fmt.Errorf("%w: %s", ErrScanFailed, strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return nil, fmt.Errorf("clamav: unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,92 @@
+// Package codes generates the codes files and transfers are downloaded with, alphanumeric ones such as
+// x7Kq2mPa or word codes such as amber-ferry-oak-tulip, which are easier to read out.
+package codes
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+const (
+	StyleAlphanumeric = "alphanumeric"
+	StyleWords        = "words"
+)
+
+// Alphabets of alphanumeric codes, AlphabetUnambiguous leaves out the characters which are easily
+// mistaken for each other: 0, O and o, 1, l and I
+const (
+	AlphabetFull        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	AlphabetUnambiguous = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Separator joins the words of a code
+const Separator = "-"
+
+// WordCount is the number of words of a code. Every word of the list of 4096 is 12 bits, so a code has
+// 48 bits, as many as 8 characters of AlphabetFull.
+const WordCount = 4
+
+//go:embed words.txt
+var wordList string
+
+var words = strings.Fields(wordList)
+
+// Lower returns alphabet without its upper-case letters, for codes which are matched case-insensitively
+func Lower(alphabet string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return -1
+		}
+		return r
+	}, alphabet)
+}
+
+// Alphanumeric returns a new code of length random characters of alphabet
+func Alphanumeric(alphabet string, length int) string {
+	runes := []rune(alphabet)
+	max := big.NewInt(int64(len(runes)))
+
+	b := make([]rune, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = runes[n.Int64()]
+	}
+
+	return string(b)
+}
+
+// Words returns a new code of WordCount random words
+func Words() string {
+	max := big.NewInt(int64(len(words)))
+
+	b := make([]string, WordCount)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = words[n.Int64()]
+	}
+
+	return strings.Join(b, Separator)
+}
+
+// Normalize returns the word code code was typed for, with any case and runs of spaces, dots,
+// underscores or hyphens between the words. Anything which is not made of several words, such as an
+// alphanumeric code, is returned as it is, as those are case-sensitive.
+func Normalize(code string) string {
+	parts := strings.FieldsFunc(code, func(r rune) bool {
+		return r == ' ' || r == '.' || r == '_' || r == '-'
+	})
+	if len(parts) < 2 {
+		return code
+	}
+
+	return strings.ToLower(strings.Join(parts, Separator))
+}
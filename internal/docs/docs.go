@@ -0,0 +1,13 @@
+// Package docs holds the OpenAPI description of the API and the Swagger UI page rendering it.
+// openapi.json is maintained by hand, update it together with routes.go.
+package docs
+
+import (
+	_ "embed"
+)
+
+//go:embed openapi.json
+var OpenAPI []byte
+
+//go:embed swagger.html
+var SwaggerUI []byte
@@ -0,0 +1,287 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Files are encrypted with AES-256-GCM in segments of SegmentSize bytes (the STREAM construction),
+// each segment has its own nonce made of a random per-file prefix, the segment counter and a flag
+// marking the last segment. This keeps memory use constant and allows seeking for range requests.
+
+const (
+	KeySize         = 32
+	NoncePrefixSize = 7
+	SegmentSize     = 64 * 1024
+
+	overhead = 16
+)
+
+var (
+	ErrInvalidKey  = errors.New("encryption: invalid key")
+	ErrCorrupted   = errors.New("encryption: message authentication failed")
+	ErrInvalidSeek = errors.New("encryption: invalid seek")
+)
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func NewKey() ([]byte, error) {
+	return randomBytes(KeySize)
+}
+
+func NewNoncePrefix() ([]byte, error) {
+	return randomBytes(NoncePrefixSize)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// WrapKey encrypts a per-file key with the master key, the random nonce is prepended
+func WrapKey(masterKey, key []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+func UnwrapKey(masterKey, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrCorrupted
+	}
+
+	key, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+
+	return key, nil
+}
+
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, NoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[NoncePrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func segments(plaintextSize int64) int64 {
+	if plaintextSize == 0 {
+		return 1
+	}
+	return (plaintextSize + SegmentSize - 1) / SegmentSize
+}
+
+// EncryptedSize returns the length of the ciphertext for plaintextSize bytes of content
+func EncryptedSize(plaintextSize int64) int64 {
+	return plaintextSize + segments(plaintextSize)*overhead
+}
+
+func decryptedSize(ciphertextSize int64) (int64, error) {
+	n := (ciphertextSize + SegmentSize + overhead - 1) / (SegmentSize + overhead)
+	if n == 0 || ciphertextSize-n*overhead < 0 {
+		return 0, ErrCorrupted
+	}
+	return ciphertextSize - n*overhead, nil
+}
+
+type encrypter struct {
+	src    *bufio.Reader
+	gcm    cipher.AEAD
+	prefix []byte
+	index  uint32
+	plain  []byte
+	sealed []byte
+	done   bool
+}
+
+// NewEncrypter returns a reader producing the ciphertext of everything read from r
+func NewEncrypter(r io.Reader, key, noncePrefix []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(noncePrefix) != NoncePrefixSize {
+		return nil, ErrInvalidKey
+	}
+
+	return &encrypter{
+		src:    bufio.NewReader(r),
+		gcm:    gcm,
+		prefix: noncePrefix,
+		plain:  make([]byte, SegmentSize),
+	}, nil
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.sealed) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(e.src, e.plain)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			e.done = true
+		case err != nil:
+			return 0, err
+		default:
+			// a full segment is only the last one if nothing follows it
+			_, err := e.src.Peek(1)
+			if errors.Is(err, io.EOF) {
+				e.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+
+		e.sealed = e.gcm.Seal(e.sealed[:0], segmentNonce(e.prefix, e.index, e.done), e.plain[:n], nil)
+		e.index++
+	}
+
+	n := copy(p, e.sealed)
+	e.sealed = e.sealed[n:]
+	return n, nil
+}
+
+type decrypter struct {
+	src      io.ReadSeeker
+	gcm      cipher.AEAD
+	prefix   []byte
+	size     int64
+	offset   int64
+	segments int64
+	index    int64
+	segment  []byte
+	buf      []byte
+}
+
+// NewDecrypter returns a seekable reader of the plaintext stored in rs
+func NewDecrypter(rs io.ReadSeeker, key, noncePrefix []byte) (io.ReadSeeker, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(noncePrefix) != NoncePrefixSize {
+		return nil, ErrInvalidKey
+	}
+
+	ciphertextSize, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := decryptedSize(ciphertextSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decrypter{
+		src:      rs,
+		gcm:      gcm,
+		prefix:   noncePrefix,
+		size:     size,
+		segments: segments(size),
+		index:    -1,
+		buf:      make([]byte, SegmentSize+overhead),
+	}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+
+	index := d.offset / SegmentSize
+	if index != d.index {
+		err := d.load(index)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.segment[d.offset%SegmentSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *decrypter) load(index int64) error {
+	_, err := d.src.Seek(index*(SegmentSize+overhead), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.ReadFull(d.src, d.buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	last := index == d.segments-1
+	d.segment, err = d.gcm.Open(d.segment[:0], segmentNonce(d.prefix, uint32(index), last), d.buf[:n], nil)
+	if err != nil {
+		d.index = -1
+		return ErrCorrupted
+	}
+	d.index = index
+
+	return nil
+}
+
+func (d *decrypter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, ErrInvalidSeek
+	}
+
+	if offset < 0 {
+		return 0, ErrInvalidSeek
+	}
+
+	d.offset = offset
+	return offset, nil
+}
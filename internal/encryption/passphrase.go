@@ -0,0 +1,62 @@
+package encryption
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters recommended for interactive logins (2017), about 32 MiB of memory per derivation
+const (
+	SaltSize = 16
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var ErrInvalidPassphrase = errors.New("encryption: invalid passphrase")
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, KeySize)
+}
+
+// WrapKeyWithPassphrase encrypts a per-file key with a key derived from passphrase. Neither the
+// passphrase nor the derived key have to be stored, the returned salt is needed to unwrap it again.
+func WrapKeyWithPassphrase(passphrase string, key []byte) (wrapped, salt []byte, err error) {
+	salt, err = randomBytes(SaltSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	passphraseKey, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err = WrapKey(passphraseKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wrapped, salt, nil
+}
+
+func UnwrapKeyWithPassphrase(passphrase string, salt, wrapped []byte) ([]byte, error) {
+	passphraseKey, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := UnwrapKey(passphraseKey, wrapped)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCorrupted):
+			return nil, ErrInvalidPassphrase
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
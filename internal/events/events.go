@@ -0,0 +1,212 @@
+// Package events publishes the lifecycle events of files to a message broker, NATS or Kafka through
+// the Kafka REST Proxy, so other systems can react to them without polling the API.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retryInterval is how often events which could not be published are tried again, new events are
+	// sent right away
+	retryInterval = 5 * time.Second
+	batchSize     = 256
+	// maxQueued bounds the events waiting to be published, more are dropped while the broker is unreachable
+	maxQueued = 4096
+)
+
+// Brokers events can be published to
+const (
+	BrokerNATS  = "nats"
+	BrokerKafka = "kafka"
+)
+
+type Config struct {
+	// Broker is nats or kafka, empty disables publishing
+	Broker string
+	// URL is the NATS server, such as nats://localhost:4222 or tls://..., or the base URL of the
+	// Kafka REST Proxy, such as http://localhost:8082
+	URL      string
+	User     string
+	Password string
+	// Subject is the prefix of the NATS subjects, events of type file.uploaded are published to
+	// <subject>.file.uploaded, and the Kafka topic all events are published to
+	Subject string
+}
+
+// Event is published to the subject of its type, Key is what Kafka partitions by so the events of
+// one file stay in order. Payload is JSON.
+type Event struct {
+	Type    string
+	Key     string
+	Payload []byte
+}
+
+// sender hands a batch of events to a broker, it fails unless the broker accepted all of them
+type sender interface {
+	send(batch []Event) error
+	close()
+}
+
+// Publisher queues events and publishes them in the background. Events which could not be published are
+// tried again in order until the queue is full, so an event may be delivered more than once.
+// A nil Publisher drops every event, so callers do not have to check whether publishing is enabled.
+type Publisher struct {
+	sender  sender
+	onError func(error)
+
+	mu      sync.Mutex
+	queue   []Event
+	dropped int
+
+	flush   chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts a publisher for cfg.Broker, errors of the broker are passed to onError.
+// It returns nil without a broker.
+func New(cfg *Config, onError func(error)) (*Publisher, error) {
+	if cfg.Broker == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Subject == "" || strings.ContainsAny(cfg.Subject, " \t\r\n*>") {
+		return nil, fmt.Errorf("invalid events subject %q", cfg.Subject)
+	}
+
+	var s sender
+
+	switch cfg.Broker {
+	case BrokerNATS:
+		if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			return nil, fmt.Errorf("invalid nats url %q", cfg.URL)
+		}
+		s = newNATS(u, cfg)
+	case BrokerKafka:
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid kafka rest proxy url %q", cfg.URL)
+		}
+		s = newKafka(u, cfg)
+	default:
+		return nil, errors.New("events broker must be nats or kafka")
+	}
+
+	p := &Publisher{
+		sender:  s,
+		onError: onError,
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// Publish queues event, it never blocks on the broker
+func (p *Publisher) Publish(event Event) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+
+	if len(p.queue) >= maxQueued {
+		p.dropped++
+		return
+	}
+	p.queue = append(p.queue, event)
+
+	select {
+	case p.flush <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown publishes the remaining events, events published afterwards are dropped
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	close(p.stop)
+	p.mu.Unlock()
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+	defer p.sender.close()
+
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.flush:
+		case <-p.stop:
+			p.publish()
+			return
+		}
+		p.publish()
+	}
+}
+
+// publish sends all queued events in batches, a batch which fails stays at the front of the queue
+func (p *Publisher) publish() {
+	for {
+		p.mu.Lock()
+		batch := p.queue
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		dropped := p.dropped
+		p.dropped = 0
+		p.mu.Unlock()
+
+		if dropped > 0 {
+			p.onError(fmt.Errorf("events: dropped %d events, the queue was full", dropped))
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		err := p.sender.send(batch)
+		if err != nil {
+			p.onError(err)
+			return
+		}
+
+		// only publish takes events off the queue, Publish appends behind the batch
+		p.mu.Lock()
+		p.queue = p.queue[len(batch):]
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kafkaSender produces to one topic through the v2 API of the Kafka REST Proxy, the events of a batch
+// are one request
+type kafkaSender struct {
+	client   *http.Client
+	url      string
+	user     string
+	password string
+}
+
+func newKafka(u *url.URL, cfg *Config) *kafkaSender {
+	return &kafkaSender{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		url:      strings.TrimSuffix(u.String(), "/") + "/topics/" + url.PathEscape(cfg.Subject),
+		user:     cfg.User,
+		password: cfg.Password,
+	}
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (s *kafkaSender) send(batch []Event) error {
+	records := make([]kafkaRecord, len(batch))
+	for i, event := range batch {
+		records[i] = kafkaRecord{Key: event.Key, Value: event.Payload}
+	}
+
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("events: kafka: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("events: kafka: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	// the proxy answers 200 even if single records could not be produced
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("events: kafka: %w", err)
+	}
+
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("events: kafka: error %d: %s", *offset.ErrorCode, offset.Error)
+		}
+	}
+
+	return nil
+}
+
+func (s *kafkaSender) close() {}
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// natsTimeout bounds connecting and every batch, including the PONG which confirms it
+const natsTimeout = 10 * time.Second
+
+// natsSender speaks the text protocol of NATS core over one connection, which is opened again after
+// an error. A batch is followed by a PING, the PONG means the server processed every PUB before it.
+type natsSender struct {
+	addr     string
+	tls      bool
+	host     string
+	user     string
+	password string
+	subject  string
+
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newNATS(u *url.URL, cfg *Config) *natsSender {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	return &natsSender{
+		addr:     addr,
+		tls:      u.Scheme == "tls",
+		host:     u.Hostname(),
+		user:     cfg.User,
+		password: cfg.Password,
+		subject:  cfg.Subject,
+	}
+}
+
+func (s *natsSender) send(batch []Event) error {
+	// the server closes connections which were idle for too long, that is only noticed when writing
+	reused := s.conn != nil
+
+	err := s.sendOnce(batch)
+	if err != nil && reused {
+		s.close()
+		err = s.sendOnce(batch)
+	}
+	if err != nil {
+		s.close()
+		return fmt.Errorf("events: nats: %w", err)
+	}
+	return nil
+}
+
+func (s *natsSender) sendOnce(batch []Event) error {
+	if s.conn == nil {
+		err := s.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	for _, event := range batch {
+		fmt.Fprintf(s.w, "PUB %s.%s %d\r\n", s.subject, event.Type, len(event.Payload))
+		s.w.Write(event.Payload)
+		s.w.WriteString("\r\n")
+	}
+	s.w.WriteString("PING\r\n")
+
+	err := s.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return s.waitPong()
+}
+
+// connect reads the INFO of the server, upgrades to TLS if asked to and logs in with CONNECT
+func (s *natsSender) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+
+	if s.tls || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	options := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "file-transfer",
+		"lang":     "go",
+		"protocol": 0,
+	}
+	switch {
+	case s.user != "":
+		options["user"] = s.user
+		options["pass"] = s.password
+	case s.password != "":
+		options["auth_token"] = s.password
+	}
+
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = conn
+	s.r = r
+	s.w = bufio.NewWriter(conn)
+
+	// an error about the login is read by waitPong after the first batch
+	s.w.WriteString("CONNECT " + string(connect) + "\r\n")
+
+	return nil
+}
+
+// waitPong reads until the PONG for the PING after a batch, answering the PINGs of the server
+func (s *natsSender) waitPong() error {
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			s.w.WriteString("PONG\r\n")
+			err = s.w.Flush()
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (s *natsSender) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
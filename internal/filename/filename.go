@@ -0,0 +1,82 @@
+// Package filename turns names supplied by clients into names which are safe to store and to send back
+package filename
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength is the longest name in bytes, longer names are shortened and keep their extension
+const MaxLength = 50
+
+// maxExtensionLength is the longest extension kept when a name is shortened
+const maxExtensionLength = 16
+
+// Sanitize drops any directory in front of name, normalizes it to NFC and removes
+// control and formatting characters such as right-to-left overrides. Leading dots and
+// surrounding spaces are trimmed, so the result is never "." or "..", but may be empty.
+func Sanitize(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = norm.NFC.String(name)
+
+	// both separators, a name from a Windows client may use either
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "/" || name == "." {
+		return ""
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, name)
+
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	name = strings.TrimRight(name, ". ")
+
+	return truncate(name)
+}
+
+// SanitizePath splits a relative path such as "photos/2024/beach.jpg" into its folder and name. Every
+// directory of the folder is sanitized like a name and dropped if that leaves it empty, which also drops
+// "." and "..", so the folder never points outside of where it is put.
+func SanitizePath(name string) (string, string) {
+	directories := strings.Split(strings.ReplaceAll(name, "\\", "/"), "/")
+
+	folder := []string{}
+	for _, directory := range directories[:len(directories)-1] {
+		if directory = Sanitize(directory); directory != "" {
+			folder = append(folder, directory)
+		}
+	}
+
+	return strings.Join(folder, "/"), Sanitize(directories[len(directories)-1])
+}
+
+// truncate shortens name to MaxLength bytes on a rune boundary, keeping a short extension
+func truncate(name string) string {
+	if len(name) <= MaxLength {
+		return name
+	}
+
+	extension := path.Ext(name)
+	if len(extension) > maxExtensionLength {
+		extension = ""
+	}
+
+	base := strings.TrimSuffix(name, extension)
+	limit := MaxLength - len(extension)
+	for limit > 0 && !utf8.RuneStart(base[limit]) {
+		limit--
+	}
+
+	return strings.TrimRight(base[:limit], ". ") + extension
+}
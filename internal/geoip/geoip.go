@@ -0,0 +1,356 @@
+// Package geoip looks up the country of IP addresses in a MaxMind DB file, such as GeoLite2-Country or
+// GeoIP2-City. Only what is needed for the country is decoded, the whole file is kept in memory.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the size of the zeros between the search tree and the data section
+const dataSeparator = 16
+
+var ErrInvalidDatabase = errors.New("geoip: invalid MaxMind DB file")
+
+// Reader is safe for concurrent use, lookups do not change it
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	// ipv4Start is the node IPv4 addresses are looked up from, ::/96 in an IPv6 database
+	ipv4Start uint
+	ipVersion uint
+}
+
+// Open reads the database at path
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(buf)
+}
+
+// New reads a database from its content
+func New(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start == -1 {
+		return nil, ErrInvalidDatabase
+	}
+
+	d := decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	r := &Reader{
+		buf:        buf,
+		nodeCount:  uintValue(metadata["node_count"]),
+		recordSize: uintValue(metadata["record_size"]),
+		ipVersion:  uintValue(metadata["ip_version"]),
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("geoip: unsupported record size %d", r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSeparator > uint(start) {
+		return nil, ErrInvalidDatabase
+	}
+	r.data = buf[treeSize+dataSeparator : start]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Country returns the ISO 3166-1 code of the country of ip, such as DE, or "" if the database does not
+// know it. The registered country is used for addresses without a country of their own, like those of
+// anycast networks.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	if ip == nil {
+		return "", nil
+	}
+
+	record, err := r.lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]any)
+		if code, ok := country["iso_code"].(string); ok && code != "" {
+			return code, nil
+		}
+	}
+
+	return "", nil
+}
+
+// lookup walks the search tree along the bits of ip and decodes the record it ends at, nil if there is none
+func (r *Reader) lookup(ip net.IP) (map[string]any, error) {
+	node := uint(0)
+	bits := net.IP(ip.To16())
+
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, ErrInvalidDatabase
+	}
+
+	offset := node - r.nodeCount - dataSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, ErrInvalidDatabase
+	}
+
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (r *Reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.buf[node*8+bit*4:]))
+	}
+}
+
+// Types of the data section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// decoder reads values of the data section, pointers are offsets into buf
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset after it
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// pointers do not point at pointers, so this ends
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		extra := uintBytes(d.buf[offset : offset+n])
+		offset += n
+
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return bytes.Clone(b), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return uint64(uintBytes(b)), offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return int64(int32(uintBytes(b))), offset, nil
+	case typeUint128:
+		// too large for the values needed here, kept as its bytes
+		return bytes.Clone(b), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("geoip: unknown data type %d", typ)
+	}
+}
+
+// pointer reads the pointer with control byte ctrl whose remaining bytes start at offset
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+n]
+	vvv := uint(ctrl & 0x7)
+
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = vvv<<8 | uintBytes(b)
+	case 2:
+		pointer = (vvv<<16 | uintBytes(b)) + 2048
+	case 3:
+		pointer = (vvv<<24 | uintBytes(b)) + 526336
+	default:
+		pointer = uintBytes(b)
+	}
+
+	return pointer, offset + n, nil
+}
+
+// uintBytes reads a big endian unsigned integer of up to 8 bytes
+func uintBytes(b []byte) uint {
+	var n uint
+	for _, c := range b {
+		n = n<<8 | uint(c)
+	}
+	return n
+}
+
+func uintValue(value any) uint {
+	n, _ := value.(uint64)
+	return uint(n)
+}
@@ -0,0 +1,161 @@
+// Package jwt signs and verifies the JSON Web Tokens issued instead of stateful authentication tokens.
+// Tokens are signed with HS256 under the key ID in their header, so keys can be rotated by signing
+// with a new one while the old ones still verify.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens which are malformed, signed with an unknown key or expired
+var ErrInvalidToken = errors.New("jwt: invalid token")
+
+// Claims are the claims of a token, the user claims let requests be authenticated without looking
+// the user up
+type Claims struct {
+	ID        string `json:"jti"`
+	Subject   int64  `json:"sub,string"`
+	SessionID int64  `json:"sid,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Role             string `json:"role"`
+	Activated        bool   `json:"activated"`
+	NotifyOnDownload bool   `json:"notify_on_download"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Keys are the signing keys by key ID, tokens are signed with the key of Active
+type Keys struct {
+	Active string
+	keys   map[string][]byte
+}
+
+// ParseKeys parses space separated id:hexkey pairs, the first key signs and all of them verify.
+// Keys must be at least 32 bytes long.
+func ParseKeys(s string) (*Keys, error) {
+	k := &Keys{keys: map[string][]byte{}}
+
+	for _, field := range strings.Fields(s) {
+		id, hexKey, ok := strings.Cut(field, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("jwt: key %q must be given as id:hexkey", field)
+		}
+
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %s: %w", id, err)
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("jwt: key %s must be at least 32 bytes long", id)
+		}
+
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("jwt: key %s is given twice", id)
+		}
+
+		if k.Active == "" {
+			k.Active = id
+		}
+		k.keys[id] = key
+	}
+
+	if k.Active == "" {
+		return nil, errors.New("jwt: no keys")
+	}
+
+	return k, nil
+}
+
+// NewKeys returns the keys with the single key key under id
+func NewKeys(id string, key []byte) *Keys {
+	return &Keys{Active: id, keys: map[string][]byte{id: key}}
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js), nil
+}
+
+func signature(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// Sign returns the token of claims signed with the active key
+func (k *Keys) Sign(claims *Claims) (string, error) {
+	h, err := encodeSegment(header{Alg: "HS256", Typ: "JWT", Kid: k.Active})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := h + "." + payload
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature(k.keys[k.Active], signingInput)), nil
+}
+
+// Verify checks the signature and expiry of token at now and returns its claims
+func (k *Keys) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	js, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(js, &h) != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// only HS256 is ever issued, so tokens naming another algorithm such as none are forged
+	key, ok := k.keys[h.Kid]
+	if !ok || h.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signature(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	js, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(js, &claims) != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Subject < 1 || now.Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// IsToken reports whether token has the shape of a JWT, as opposed to the other kinds of tokens
+func IsToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,237 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{0x01}, 32)
+	otherKey = bytes.Repeat([]byte{0x02}, 32)
+)
+
+// forge builds a token from raw JSON segments, signed with key unless key is nil
+func forge(headerJSON, claimsJSON string, key []byte) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(headerJSON)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claimsJSON))
+	if key == nil {
+		return signingInput + "."
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature(key, signingInput))
+}
+
+func TestParseKeys(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name       string
+		keys       string
+		wantActive string
+		wantIDs    []string
+		wantErr    bool
+	}{
+		{"single key", "k1:" + key, "k1", []string{"k1"}, false},
+		{"rotated keys", "k2:" + key + "  k1:" + strings.Repeat("cd", 32), "k2", []string{"k2", "k1"}, false},
+		{"longer key", "k1:" + key + "ff", "k1", []string{"k1"}, false},
+		{"no keys", "", "", nil, true},
+		{"only spaces", "   ", "", nil, true},
+		{"missing colon", key, "", nil, true},
+		{"missing id", ":" + key, "", nil, true},
+		{"not hex", "k1:" + strings.Repeat("zz", 32), "", nil, true},
+		{"odd length", "k1:" + key + "f", "", nil, true},
+		{"key too short", "k1:" + strings.Repeat("ab", 31), "", nil, true},
+		{"key given twice", "k1:" + key + " k1:" + key, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeys(tt.keys)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Active != tt.wantActive {
+				t.Errorf("got active key %q, want %q", k.Active, tt.wantActive)
+			}
+			for _, id := range tt.wantIDs {
+				if _, ok := k.keys[id]; !ok {
+					t.Errorf("key %q is missing", id)
+				}
+			}
+			if len(k.keys) != len(tt.wantIDs) {
+				t.Errorf("got %d keys, want %d", len(k.keys), len(tt.wantIDs))
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := &Claims{
+		ID:        "jti-1",
+		Subject:   42,
+		SessionID: 7,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(15 * time.Minute).Unix(),
+		Name:      "Alice",
+		Email:     "alice@example.com",
+		Role:      "user",
+		Activated: true,
+	}
+
+	k := NewKeys("k1", testKey)
+	token, err := k.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsToken(token) {
+		t.Errorf("IsToken(%q) = false", token)
+	}
+
+	got, err := k.Verify(token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, claims) {
+		t.Errorf("got claims %+v, want %+v", got, claims)
+	}
+
+	// after rotating, tokens of the old key still verify and new ones carry the new key ID
+	rotated := &Keys{Active: "k2", keys: map[string][]byte{"k1": testKey, "k2": otherKey}}
+	_, err = rotated.Verify(token, now)
+	if err != nil {
+		t.Errorf("token of the previous key: got error %v", err)
+	}
+
+	newToken, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = k.Verify(newToken, now)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token of a key unknown to the verifier: got error %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	k := NewKeys("k1", testKey)
+
+	const hs256 = `{"alg":"HS256","typ":"JWT","kid":"k1"}`
+	const valid = `{"jti":"x","sub":"42","iat":1699999000,"exp":1700000900}`
+
+	good := forge(hs256, valid, testKey)
+	parts := strings.Split(good, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"two parts", parts[0] + "." + parts[1]},
+		{"four parts", good + "." + parts[2]},
+		{"header not base64", "!!." + parts[1] + "." + parts[2]},
+		{"header not JSON", forge(`not json`, valid, testKey)},
+		{"padded header", parts[0] + "=." + parts[1] + "." + parts[2]},
+
+		// algorithm confusion, only HS256 is accepted whatever the header says
+		{"alg none without a signature", forge(`{"alg":"none","typ":"JWT","kid":"k1"}`, valid, nil)},
+		{"alg none with a valid MAC", forge(`{"alg":"none","typ":"JWT","kid":"k1"}`, valid, testKey)},
+		{"alg lowercase", forge(`{"alg":"hs256","typ":"JWT","kid":"k1"}`, valid, testKey)},
+		{"alg HS512", forge(`{"alg":"HS512","typ":"JWT","kid":"k1"}`, valid, testKey)},
+		{"alg RS256", forge(`{"alg":"RS256","typ":"JWT","kid":"k1"}`, valid, testKey)},
+		{"alg missing", forge(`{"typ":"JWT","kid":"k1"}`, valid, testKey)},
+
+		{"unknown key ID", forge(`{"alg":"HS256","typ":"JWT","kid":"k2"}`, valid, testKey)},
+		{"missing key ID", forge(`{"alg":"HS256","typ":"JWT"}`, valid, testKey)},
+
+		// bad signatures
+		{"signed with another key", forge(hs256, valid, otherKey)},
+		{"empty signature", parts[0] + "." + parts[1] + "."},
+		{"truncated signature", good[:len(good)-2]},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!"},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"x","sub":"1","iat":1699999000,"exp":1700000900}`)) + "." + parts[2]},
+		{"tampered header", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"k1" }`)) + "." + parts[1] + "." + parts[2]},
+
+		// claims
+		{"expired", forge(hs256, `{"jti":"x","sub":"42","iat":1699990000,"exp":1699999999}`, testKey)},
+		{"expiring now", forge(hs256, `{"jti":"x","sub":"42","iat":1699990000,"exp":1700000000}`, testKey)},
+		{"no expiry", forge(hs256, `{"jti":"x","sub":"42","iat":1699990000}`, testKey)},
+		{"no subject", forge(hs256, `{"jti":"x","iat":1699999000,"exp":1700000900}`, testKey)},
+		{"subject 0", forge(hs256, `{"jti":"x","sub":"0","iat":1699999000,"exp":1700000900}`, testKey)},
+		{"subject not a string", forge(hs256, `{"jti":"x","sub":42,"iat":1699999000,"exp":1700000900}`, testKey)},
+		{"claims not JSON", forge(hs256, `not json`, testKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := k.Verify(tt.token, now)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got claims %+v and error %v, want ErrInvalidToken", claims, err)
+			}
+		})
+	}
+
+	_, err := k.Verify(good, now)
+	if err != nil {
+		t.Errorf("the untampered token: got error %v", err)
+	}
+}
+
+func TestIsToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"a.b.c", true},
+		{"..", true},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZ", false},
+		{"a.b", false},
+		{"a.b.c.d", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsToken(tt.token); got != tt.want {
+			t.Errorf("IsToken(%q) = %t, want %t", tt.token, got, tt.want)
+		}
+	}
+}
+
+// FuzzVerify checks that no input panics and nothing but signed tokens verify
+func FuzzVerify(f *testing.F) {
+	k := NewKeys("k1", testKey)
+	now := time.Unix(1700000000, 0)
+
+	signed, err := k.Sign(&Claims{Subject: 1, ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	signingInput := signed[:strings.LastIndex(signed, ".")+1]
+
+	f.Add(signed)
+	f.Add(forge(`{"alg":"none","kid":"k1"}`, `{"sub":"1","exp":1800000000}`, nil))
+	f.Add("a.b.c")
+
+	f.Fuzz(func(t *testing.T, token string) {
+		_, err := k.Verify(token, now)
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v", err)
+		}
+		// the MAC covers the header and claims as they are sent
+		if err == nil && !strings.HasPrefix(token, signingInput) {
+			t.Errorf("%q verifies", token)
+		}
+	})
+}
@@ -0,0 +1,447 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// heifBrands are the major brands of HEIF images, HEIC and AVIF included
+var heifBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "hevm", "hevs", "mif1", "msf1", "avif", "avis"}
+
+func isHEIF(head []byte) bool {
+	return len(head) >= 12 && string(head[4:8]) == "ftyp" && slices.Contains(heifBrands, string(head[8:12]))
+}
+
+// maxMetaBox bounds the meta box, which is kept in memory to find the metadata items
+const maxMetaBox = 16 << 20
+
+// extent is a range of bytes holding (part of) an item
+type extent struct {
+	offset uint64
+	length uint64
+}
+
+// stripHEIF copies a HEIF image with the content of its Exif and XMP items overwritten by zeros. Their
+// offsets stay valid that way, the boxes describing the items are left as they are. The meta box has to
+// come before the media data it points into, as it does in the files cameras and phones write.
+func stripHEIF(w *bufio.Writer, r *bufio.Reader) error {
+	var pos uint64
+	var erase []extent
+	seenMeta := false
+
+	for {
+		header, size, err := readBoxHeader(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		typ := string(header[4:8])
+		headerSize := uint64(len(header))
+
+		switch {
+		case typ == "meta" && !seenMeta:
+			if size == 0 || size > maxMetaBox {
+				return fmt.Errorf("%w: meta box of %d bytes", ErrUnsupported, size)
+			}
+
+			box := make([]byte, size)
+			copy(box, header)
+			_, err = io.ReadFull(r, box[headerSize:])
+			if err != nil {
+				return heifError(err)
+			}
+
+			var inside []extent
+			erase, inside, err = metadataItems(box[headerSize:])
+			if err != nil {
+				return err
+			}
+			for _, e := range inside {
+				clear(box[headerSize+e.offset : headerSize+e.offset+e.length])
+			}
+
+			w.Write(box)
+			seenMeta = true
+		case typ == "mdat" && !seenMeta:
+			return fmt.Errorf("%w: media data before the meta box", ErrUnsupported)
+		default:
+			w.Write(header)
+
+			// a size of 0 means the box runs to the end of the file
+			length := int64(-1)
+			if size != 0 {
+				length = int64(size - headerSize)
+			}
+
+			err = copyErasing(w, r, pos+headerSize, length, erase)
+			if err != nil {
+				return err
+			}
+			if size == 0 {
+				return nil
+			}
+		}
+
+		pos += size
+	}
+}
+
+// readBoxHeader reads the header of the next top level box and returns it with the size of the
+// whole box, io.EOF if there are no more boxes
+func readBoxHeader(r *bufio.Reader) ([]byte, uint64, error) {
+	header := make([]byte, 8, 16)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, heifError(err)
+	}
+
+	size := uint64(binary.BigEndian.Uint32(header))
+	if size == 1 {
+		header = header[:16]
+		_, err = io.ReadFull(r, header[8:])
+		if err != nil {
+			return nil, 0, heifError(err)
+		}
+		size = binary.BigEndian.Uint64(header[8:])
+	}
+
+	if size != 0 && size < uint64(len(header)) {
+		return nil, 0, fmt.Errorf("%w: box of %d bytes", ErrInvalid, size)
+	}
+
+	return header, size, nil
+}
+
+// copyErasing copies length bytes, or all if length is -1, starting at pos of the file and
+// writes zeros instead of the bytes inside erase
+func copyErasing(w *bufio.Writer, r *bufio.Reader, pos uint64, length int64, erase []extent) error {
+	var src io.Reader = r
+	if length >= 0 {
+		src = io.LimitReader(r, length)
+	}
+
+	buf := make([]byte, 32*1024)
+	copied := int64(0)
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			start := pos + uint64(copied)
+			end := start + uint64(n)
+
+			for _, e := range erase {
+				from, to := max(e.offset, start), min(e.offset+e.length, end)
+				if from < to {
+					clear(chunk[from-start : to-start])
+				}
+			}
+
+			_, werr := w.Write(chunk)
+			if werr != nil {
+				return werr
+			}
+			copied += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if length >= 0 && copied < length {
+		return fmt.Errorf("%w: truncated HEIF", ErrInvalid)
+	}
+
+	return nil
+}
+
+// metadataItems finds the Exif and XMP items of the payload of a meta box. It returns their extents in the
+// file and, for items kept in the idat box, their extents inside the payload.
+func metadataItems(meta []byte) (file []extent, inside []extent, err error) {
+	if len(meta) < 4 {
+		return nil, nil, fmt.Errorf("%w: meta box too short", ErrInvalid)
+	}
+
+	var iinf, iloc []byte
+	idat := -1
+
+	children := meta[4:]
+	offset := 4
+	for len(children) > 0 {
+		typ, payload, headerSize, size, err := splitBox(children)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch typ {
+		case "iinf":
+			iinf = payload
+		case "iloc":
+			iloc = payload
+		case "idat":
+			idat = offset + headerSize
+		}
+
+		children = children[size:]
+		offset += size
+	}
+
+	if iinf == nil || iloc == nil {
+		return nil, nil, nil
+	}
+
+	ids, err := metadataItemIDs(iinf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	locations, err := itemLocations(iloc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, location := range locations {
+		if !slices.Contains(ids, location.id) {
+			continue
+		}
+
+		// a length of 0 means the rest of the file, which metadata never is
+		if slices.ContainsFunc(location.extents, func(e extent) bool { return e.length == 0 }) {
+			return nil, nil, fmt.Errorf("%w: metadata item without a length", ErrUnsupported)
+		}
+
+		switch location.method {
+		case 0:
+			// an extent wrapping around would not be erased
+			if slices.ContainsFunc(location.extents, func(e extent) bool { return e.offset+e.length < e.offset }) {
+				return nil, nil, fmt.Errorf("%w: item past the end of the file", ErrInvalid)
+			}
+			file = append(file, location.extents...)
+		case 1:
+			if idat < 0 {
+				return nil, nil, fmt.Errorf("%w: item in a missing idat box", ErrInvalid)
+			}
+			for _, e := range location.extents {
+				room := uint64(len(meta) - idat)
+				if e.offset > room || e.length > room-e.offset {
+					return nil, nil, fmt.Errorf("%w: item outside of the idat box", ErrInvalid)
+				}
+				e.offset += uint64(idat)
+				inside = append(inside, e)
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: item constructed from other items", ErrUnsupported)
+		}
+	}
+
+	return file, inside, nil
+}
+
+// splitBox returns the type and payload of the first box of b together with the sizes of its header and the whole box
+func splitBox(b []byte) (string, []byte, int, int, error) {
+	if len(b) < 8 {
+		return "", nil, 0, 0, fmt.Errorf("%w: truncated box", ErrInvalid)
+	}
+
+	headerSize := 8
+	size := uint64(binary.BigEndian.Uint32(b))
+	switch size {
+	case 0:
+		size = uint64(len(b))
+	case 1:
+		if len(b) < 16 {
+			return "", nil, 0, 0, fmt.Errorf("%w: truncated box", ErrInvalid)
+		}
+		headerSize = 16
+		size = binary.BigEndian.Uint64(b[8:])
+	}
+
+	if size < uint64(headerSize) || size > uint64(len(b)) {
+		return "", nil, 0, 0, fmt.Errorf("%w: box of %d bytes", ErrInvalid, size)
+	}
+
+	return string(b[4:8]), b[headerSize:size], headerSize, int(size), nil
+}
+
+// boxReader reads the big endian fields of a box, reading past its end sets short
+type boxReader struct {
+	b     []byte
+	short bool
+}
+
+func (br *boxReader) uint(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if len(br.b) < n {
+		br.short = true
+		br.b = nil
+		return 0
+	}
+
+	var v uint64
+	for _, c := range br.b[:n] {
+		v = v<<8 | uint64(c)
+	}
+	br.b = br.b[n:]
+
+	return v
+}
+
+// metadataItemIDs returns the IDs of the Exif items and of the XMP items, which are mime items of RDF
+func metadataItemIDs(iinf []byte) ([]uint64, error) {
+	br := &boxReader{b: iinf}
+
+	version := br.uint(1)
+	br.uint(3)
+	if version == 0 {
+		br.uint(2)
+	} else {
+		br.uint(4)
+	}
+	if br.short {
+		return nil, fmt.Errorf("%w: truncated iinf box", ErrInvalid)
+	}
+
+	ids := []uint64{}
+
+	entries := br.b
+	for len(entries) > 0 {
+		typ, payload, _, size, err := splitBox(entries)
+		if err != nil {
+			return nil, err
+		}
+		entries = entries[size:]
+
+		if typ != "infe" {
+			continue
+		}
+
+		entry := &boxReader{b: payload}
+
+		// versions before 2 have no item type
+		version := entry.uint(1)
+		entry.uint(3)
+		if version < 2 {
+			continue
+		}
+
+		var id uint64
+		if version == 2 {
+			id = entry.uint(2)
+		} else {
+			id = entry.uint(4)
+		}
+		entry.uint(2)
+		itemType := string(binary.BigEndian.AppendUint32(nil, uint32(entry.uint(4))))
+		if entry.short {
+			return nil, fmt.Errorf("%w: truncated infe box", ErrInvalid)
+		}
+
+		switch itemType {
+		case "Exif":
+			ids = append(ids, id)
+		case "mime":
+			// the item name comes first, then the content type, both null terminated
+			fields := bytes.SplitN(entry.b, []byte{0}, 3)
+			if len(fields) >= 2 && bytes.Contains(fields[1], []byte("rdf+xml")) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+type itemLocation struct {
+	id uint64
+	// method is 0 for items at an offset of the file and 1 for items in the idat box
+	method  uint64
+	extents []extent
+}
+
+// itemLocations reads the iloc box
+func itemLocations(iloc []byte) ([]itemLocation, error) {
+	br := &boxReader{b: iloc}
+
+	version := br.uint(1)
+	br.uint(3)
+	if version > 2 {
+		return nil, fmt.Errorf("%w: iloc box version %d", ErrUnsupported, version)
+	}
+
+	sizes := br.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = br.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+
+	var count uint64
+	if version < 2 {
+		count = br.uint(2)
+	} else {
+		count = br.uint(4)
+	}
+
+	locations := []itemLocation{}
+
+	for i := uint64(0); i < count && !br.short; i++ {
+		var location itemLocation
+
+		if version < 2 {
+			location.id = br.uint(2)
+		} else {
+			location.id = br.uint(4)
+		}
+		if version > 0 {
+			location.method = br.uint(2) & 0xf
+		}
+		br.uint(2)
+
+		base := br.uint(baseOffsetSize)
+
+		extents := br.uint(2)
+		for j := uint64(0); j < extents && !br.short; j++ {
+			br.uint(indexSize)
+			offset := br.uint(offsetSize)
+			length := br.uint(lengthSize)
+
+			location.extents = append(location.extents, extent{offset: base + offset, length: length})
+		}
+
+		locations = append(locations, location)
+	}
+
+	if br.short {
+		return nil, fmt.Errorf("%w: truncated iloc box", ErrInvalid)
+	}
+
+	return locations, nil
+}
+
+func heifError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated HEIF", ErrInvalid)
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	content := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	b = append(b, typ...)
+	return append(b, content...)
+}
+
+var ftyp = box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+// infe is a version 2 item info entry, extra holds the item name and for mime items the content type
+func infe(id uint16, itemType, extra string) []byte {
+	p := binary.BigEndian.AppendUint16([]byte{2, 0, 0, 0}, id)
+	p = append(p, 0, 0)
+	p = append(p, itemType...)
+	return box("infe", p, []byte(extra))
+}
+
+func iinf(entries ...[]byte) []byte {
+	p := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, uint16(len(entries)))
+	return box("iinf", p, bytes.Join(entries, nil))
+}
+
+type location struct {
+	id      uint16
+	method  uint16
+	extents []extent
+}
+
+// iloc is a version 1 item location box with 8 byte offsets and lengths
+func iloc(locations ...location) []byte {
+	p := []byte{1, 0, 0, 0, 0x88, 0x00}
+	p = binary.BigEndian.AppendUint16(p, uint16(len(locations)))
+	for _, l := range locations {
+		p = binary.BigEndian.AppendUint16(p, l.id)
+		p = binary.BigEndian.AppendUint16(p, l.method)
+		p = binary.BigEndian.AppendUint16(p, 0)
+		p = binary.BigEndian.AppendUint16(p, uint16(len(l.extents)))
+		for _, e := range l.extents {
+			p = binary.BigEndian.AppendUint64(p, e.offset)
+			p = binary.BigEndian.AppendUint64(p, e.length)
+		}
+	}
+	return box("iloc", p)
+}
+
+func meta(children ...[]byte) []byte {
+	return box("meta", []byte{0, 0, 0, 0}, bytes.Join(children, nil))
+}
+
+type heifOptions struct {
+	// largeSize gives mdat a 64 bit size, toEnd a size of 0
+	largeSize bool
+	toEnd     bool
+}
+
+type heifFile struct {
+	data []byte
+	// want is data with the Exif and XMP items erased
+	want []byte
+}
+
+const (
+	heifImage = "hevc coded image"
+	heifExif  = "\x00\x00\x00\x06Exif\x00\x00MM\x00\x2a GPS 52.52 13.40"
+	heifXMP   = "<x:xmpmeta>GPS 52.52 13.40</x:xmpmeta>"
+	heifText  = "a text item which is kept"
+)
+
+// testHEIF builds an image with the Exif item in the media data, split in two extents, and the XMP item
+// in the idat box of the meta box
+func testHEIF(t testing.TB, opts heifOptions) heifFile {
+	t.Helper()
+
+	mdatHeader := binary.BigEndian.AppendUint32(nil, uint32(8+len(heifImage)+len(heifExif)))
+	mdatHeader = append(mdatHeader, "mdat"...)
+	if opts.largeSize {
+		mdatHeader = binary.BigEndian.AppendUint64([]byte("\x00\x00\x00\x01mdat"), uint64(16+len(heifImage)+len(heifExif)))
+	}
+	if opts.toEnd {
+		mdatHeader = []byte("\x00\x00\x00\x00mdat")
+	}
+
+	build := func(mdatStart uint64) []byte {
+		exif := mdatStart + uint64(len(heifImage))
+
+		return meta(
+			box("hdlr", make([]byte, 20)),
+			iinf(
+				infe(1, "hvc1", "\x00"),
+				infe(2, "Exif", "\x00"),
+				infe(3, "mime", "\x00application/rdf+xml\x00"),
+				infe(4, "mime", "\x00text/plain\x00"),
+				// version 1 entries have no item type
+				box("infe", []byte{1, 0, 0, 0, 0, 5, 0, 0}, []byte("Exif\x00")),
+				box("free"),
+			),
+			iloc(
+				location{id: 1, extents: []extent{{mdatStart, uint64(len(heifImage))}}},
+				location{id: 2, extents: []extent{{exif, 4}, {exif + 4, uint64(len(heifExif) - 4)}}},
+				location{id: 3, method: 1, extents: []extent{{0, uint64(len(heifXMP))}}},
+				location{id: 4, method: 1, extents: []extent{{uint64(len(heifXMP)), uint64(len(heifText))}}},
+			),
+			box("idat", []byte(heifXMP+heifText)),
+		)
+	}
+
+	// the size of the meta box does not depend on the offsets in it
+	start := uint64(len(ftyp) + len(build(0)) + len(mdatHeader))
+
+	var data []byte
+	data = append(data, ftyp...)
+	data = append(data, build(start)...)
+	data = append(data, mdatHeader...)
+	data = append(data, heifImage+heifExif...)
+
+	want := append([]byte(nil), data...)
+	for _, erased := range []string{heifExif, heifXMP} {
+		i := bytes.Index(want, []byte(erased))
+		clear(want[i : i+len(erased)])
+	}
+
+	return heifFile{data: data, want: want}
+}
+
+func TestStripHEIF(t *testing.T) {
+	tests := []struct {
+		name string
+		opts heifOptions
+	}{
+		{"media data with a size", heifOptions{}},
+		{"media data with a 64 bit size", heifOptions{largeSize: true}},
+		{"media data to the end of the file", heifOptions{toEnd: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := testHEIF(t, tt.opts)
+
+			for _, slow := range []bool{false, true} {
+				got, err := strip(t, file.data, slow)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(got, file.want) {
+					t.Fatalf("got %q, want %q", got, file.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStripHEIFWithoutMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"only ftyp", ftyp},
+		{"meta without items", append(append([]byte(nil), ftyp...), meta(box("hdlr", make([]byte, 20)))...)},
+		{
+			"no metadata items",
+			bytes.Join([][]byte{ftyp, meta(iinf(infe(1, "hvc1", "\x00")), iloc(location{id: 1, extents: []extent{{0, 4}}})), box("mdat", []byte("data"))}, nil),
+		},
+		{
+			"metadata item without a location",
+			bytes.Join([][]byte{ftyp, meta(iinf(infe(2, "Exif", "\x00")), iloc()), box("mdat", []byte("data"))}, nil),
+		},
+		{"AVIF brand", append(box("ftyp", []byte("avif\x00\x00\x00\x00")), box("free", []byte("x"))...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := strip(t, tt.data, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("got %q, want the image unchanged", got)
+			}
+		})
+	}
+}
+
+func TestStripHEIFErrors(t *testing.T) {
+	exif := iinf(infe(2, "Exif", "\x00"))
+	file := testHEIF(t, heifOptions{})
+
+	heif := func(boxes ...[]byte) []byte {
+		return append(append([]byte(nil), ftyp...), bytes.Join(boxes, nil)...)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"media data before the meta box", heif(box("mdat", []byte("data")), meta()), ErrUnsupported},
+		{"meta box above the limit", heif(binary.BigEndian.AppendUint32(nil, maxMetaBox+1), []byte("meta")), ErrUnsupported},
+		{"meta box to the end of the file", heif([]byte("\x00\x00\x00\x00meta\x00\x00\x00\x00")), ErrUnsupported},
+		{"iloc version 3", heif(meta(exif, box("iloc", []byte{3, 0, 0, 0}))), ErrUnsupported},
+		{"metadata item without a length", heif(meta(exif, iloc(location{id: 2, extents: []extent{{0, 0}}}))), ErrUnsupported},
+		{"metadata item constructed from other items", heif(meta(exif, iloc(location{id: 2, method: 2, extents: []extent{{0, 4}}}))), ErrUnsupported},
+
+		{"box smaller than its header", heif([]byte("\x00\x00\x00\x04free")), ErrInvalid},
+		{"box with a 64 bit size smaller than its header", heif([]byte("\x00\x00\x00\x01free\x00\x00\x00\x00\x00\x00\x00\x08")), ErrInvalid},
+		{"truncated box header", heif([]byte("\x00\x00\x00")), ErrInvalid},
+		{"truncated 64 bit size", heif([]byte("\x00\x00\x00\x01mdat\x00\x00")), ErrInvalid},
+		{"truncated meta box", file.data[:len(ftyp)+20], ErrInvalid},
+		{"truncated media data", file.data[:len(file.data)-1], ErrInvalid},
+		{"meta box without its version", heif(box("meta", []byte{0, 0})), ErrInvalid},
+		{"child box longer than the meta box", heif(box("meta", []byte{0, 0, 0, 0}, []byte("\x00\x00\x00\x20iinf"))), ErrInvalid},
+		{"truncated child box", heif(box("meta", []byte{0, 0, 0, 0}, []byte("\x00\x00\x00"))), ErrInvalid},
+		{"truncated iinf box", heif(meta(box("iinf", []byte{0, 0}), iloc())), ErrInvalid},
+		{"truncated infe box", heif(meta(iinf(box("infe", []byte{2, 0, 0, 0, 0, 2})), iloc())), ErrInvalid},
+		{"truncated iloc box", heif(meta(exif, iloc(location{id: 2, extents: []extent{{0, 4}}})[:30])), ErrInvalid},
+		{"item in a missing idat box", heif(meta(exif, iloc(location{id: 2, method: 1, extents: []extent{{0, 4}}}))), ErrInvalid},
+		{
+			"item outside of the idat box",
+			heif(meta(exif, iloc(location{id: 2, method: 1, extents: []extent{{2, 4}}}), box("idat", []byte("data")))),
+			ErrInvalid,
+		},
+		{
+			"item past the end of the file",
+			heif(meta(exif, iloc(location{id: 2, extents: []extent{{math.MaxUint64 - 1, 4}}}))),
+			ErrInvalid,
+		},
+		{
+			"item overflowing the idat box offset",
+			heif(meta(exif, iloc(location{id: 2, method: 1, extents: []extent{{math.MaxUint64 - 1, 4}}}), box("idat", []byte("data")))),
+			ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := strip(t, tt.data, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var jpegSOI = []byte{0xff, 0xd8}
+
+const (
+	markerSOS  = 0xda
+	markerEOI  = 0xd9
+	markerAPP0 = 0xe0
+	markerAPP2 = 0xe2
+	// markerAPP14 is the Adobe segment, which tells how the colors are encoded
+	markerAPP14 = 0xee
+	markerCOM   = 0xfe
+)
+
+// keepSegment reports whether a segment is needed to show the image. Of the application segments only JFIF,
+// ICC color profiles and Adobe are kept, EXIF and XMP (APP1), IPTC (APP13) and the others are dropped.
+func keepSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == markerAPP0, marker == markerAPP14:
+		return true
+	case marker == markerAPP2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker > markerAPP0 && marker <= 0xef, marker == markerCOM:
+		return false
+	default:
+		return true
+	}
+}
+
+// stripJPEG copies the segments of a JPEG image needed to show it. Whatever follows the end of the
+// image is dropped, cameras put further metadata and preview images there.
+func stripJPEG(w *bufio.Writer, r *bufio.Reader) error {
+	_, err := r.Discard(len(jpegSOI))
+	if err != nil {
+		return jpegError(err)
+	}
+	w.Write(jpegSOI)
+
+	marker, err := nextMarker(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		switch {
+		case marker == markerEOI:
+			w.Write([]byte{0xff, marker})
+			return nil
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			// restart markers and TEM have no payload
+			w.Write([]byte{0xff, marker})
+			marker, err = nextMarker(r)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var length uint16
+		err = binary.Read(r, binary.BigEndian, &length)
+		if err != nil {
+			return jpegError(err)
+		}
+		if length < 2 {
+			return fmt.Errorf("%w: segment of %d bytes", ErrInvalid, length)
+		}
+
+		payload := make([]byte, length-2)
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			return jpegError(err)
+		}
+
+		if keepSegment(marker, payload) {
+			w.Write([]byte{0xff, marker})
+			binary.Write(w, binary.BigEndian, length)
+			w.Write(payload)
+		}
+
+		if marker == markerSOS {
+			marker, err = copyScan(w, r)
+		} else {
+			marker, err = nextMarker(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextMarker reads the marker of the next segment, any fill bytes in front of it are skipped
+func nextMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, jpegError(err)
+	}
+	if b != 0xff {
+		return 0, fmt.Errorf("%w: expected a marker", ErrInvalid)
+	}
+
+	for {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, jpegError(err)
+		}
+		if b != 0xff {
+			return b, nil
+		}
+	}
+}
+
+// copyScan copies the entropy coded data after a start of scan and returns the marker ending it. Inside
+// the data 0xff is followed by a stuffed 0x00 or a restart marker, both belong to the scan.
+func copyScan(w *bufio.Writer, r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, jpegError(err)
+		}
+		if b != 0xff {
+			// a failed write means the reader went away, there is no use in reading the rest
+			err = w.WriteByte(b)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		next, err := r.ReadByte()
+		for err == nil && next == 0xff {
+			next, err = r.ReadByte()
+		}
+		if err != nil {
+			return 0, jpegError(err)
+		}
+
+		if next == 0x00 || (next >= 0xd0 && next <= 0xd7) {
+			w.Write([]byte{0xff, next})
+			continue
+		}
+
+		return next, nil
+	}
+}
+
+func jpegError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated JPEG", ErrInvalid)
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func segment(marker byte, payload []byte) []byte {
+	s := binary.BigEndian.AppendUint16([]byte{0xff, marker}, uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+// testJPEG encodes a small image with the segments inserted after the start of image
+func testJPEG(t testing.TB, segments ...[]byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		img.Set(x, x, color.RGBA{R: 255, A: 255})
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte(nil), jpegSOI...)
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return append(data, buf.Bytes()[len(jpegSOI):]...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	jfif := segment(markerAPP0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	icc := segment(markerAPP2, []byte("ICC_PROFILE\x00\x01\x01profile"))
+	adobe := segment(markerAPP14, []byte("Adobe\x00\x64\x00\x00\x00\x00\x01"))
+
+	exif := segment(0xe1, []byte("Exif\x00\x00MM\x00\x2a GPS 52.52 13.40"))
+	xmp := segment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+	mpf := segment(markerAPP2, []byte("MPF\x00preview"))
+	iptc := segment(0xed, []byte("Photoshop 3.0\x00caption"))
+	comment := segment(markerCOM, []byte("taken at home"))
+
+	tests := []struct {
+		name     string
+		segments [][]byte
+		kept     [][]byte
+	}{
+		{"no metadata", nil, nil},
+		{"EXIF and XMP", [][]byte{exif, xmp}, nil},
+		{"kept segments", [][]byte{jfif, icc, adobe}, [][]byte{jfif, icc, adobe}},
+		{"mixed", [][]byte{jfif, exif, icc, mpf, iptc, comment, adobe}, [][]byte{jfif, icc, adobe}},
+		{"empty comment", [][]byte{segment(markerCOM, nil)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testJPEG(t, tt.kept...)
+
+			for _, slow := range []bool{false, true} {
+				got, err := strip(t, testJPEG(t, tt.segments...), slow)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Fatalf("got %x, want %x", got, want)
+				}
+			}
+
+			_, err := jpeg.Decode(bytes.NewReader(want))
+			if err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEGAfterEnd(t *testing.T) {
+	data := testJPEG(t)
+	trailer := append(append([]byte(nil), data...), []byte("\xff\xd8 preview image and maker notes")...)
+
+	got, err := strip(t, trailer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want the %d bytes up to the end of image", len(got), len(data))
+	}
+}
+
+func TestStripJPEGScan(t *testing.T) {
+	scan := []byte{0x12, 0xff, 0x00, 0x34, 0xff, 0xd0, 0x56, 0xff, 0xd7, 0x78}
+
+	data := append([]byte(nil), jpegSOI...)
+	data = append(data, segment(0xe1, []byte("Exif\x00\x00"))...)
+	data = append(data, segment(markerSOS, []byte{0x01, 0x01, 0x00, 0x00, 0x3f, 0x00})...)
+	data = append(data, scan...)
+	// fill bytes before a marker are dropped
+	data = append(data, 0xff, 0xff, 0xff, markerCOM, 0x00, 0x02)
+	data = append(data, 0xff, 0xd1, 0xff, 0x01, 0xff, markerEOI)
+
+	want := append([]byte(nil), jpegSOI...)
+	want = append(want, segment(markerSOS, []byte{0x01, 0x01, 0x00, 0x00, 0x3f, 0x00})...)
+	want = append(want, scan...)
+	want = append(want, 0xff, 0xd1, 0xff, 0x01, 0xff, markerEOI)
+
+	got, err := strip(t, data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestStripJPEGInvalid(t *testing.T) {
+	valid := testJPEG(t, segment(0xe1, []byte("Exif\x00\x00")))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"only the start of image", jpegSOI},
+		{"no marker after the start of image", []byte("\xff\xd8\x00\x01")},
+		{"segment length below 2", []byte("\xff\xd8\xff\xe1\x00\x01")},
+		{"segment longer than the file", []byte("\xff\xd8\xff\xe1\xff\xff Exif")},
+		{"truncated length", []byte("\xff\xd8\xff\xe1\x00")},
+		{"only fill bytes", []byte("\xff\xd8\xff\xff\xff")},
+		{"scan without an end", []byte("\xff\xd8\xff\xda\x00\x02\x01\x02\x03")},
+		{"scan ending in 0xff", []byte("\xff\xd8\xff\xda\x00\x02\x01\xff")},
+		{"no marker after a segment", []byte("\xff\xd8\xff\xfe\x00\x02\x00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := strip(t, tt.data, false)
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("got error %v, want ErrInvalid", err)
+			}
+		})
+	}
+
+	// cutting the image off anywhere before the end of image must be noticed
+	for n := len(jpegSOI); n < len(valid); n++ {
+		_, err := strip(t, valid[:n], false)
+		if !errors.Is(err, ErrInvalid) {
+			t.Fatalf("got error %v for the first %d of %d bytes, want ErrInvalid", err, n, len(valid))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package metadata removes EXIF, GPS, XMP and similar metadata from JPEG, PNG and HEIF images while they are
+// streamed, the image data itself is copied as it is. Content of other types passes through unchanged.
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrInvalid is returned for images which are damaged or not what their header claims
+	ErrInvalid = errors.New("metadata: invalid image")
+	// ErrUnsupported is returned for valid images whose layout does not allow stripping while streaming
+	ErrUnsupported = errors.New("metadata: unsupported image layout")
+)
+
+// Strip returns the content of r without metadata. The content is stripped while it is read, so reading
+// fails with ErrInvalid or ErrUnsupported once a problem with the image turns up. The returned reader
+// must be closed.
+func Strip(r io.Reader) io.ReadCloser {
+	br := bufio.NewReader(r)
+
+	var strip func(w *bufio.Writer, r *bufio.Reader) error
+
+	head, _ := br.Peek(12)
+	switch {
+	case bytes.HasPrefix(head, jpegSOI):
+		strip = stripJPEG
+	case bytes.HasPrefix(head, pngSignature):
+		strip = stripPNG
+	case isHEIF(head):
+		strip = stripHEIF
+	default:
+		return io.NopCloser(br)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		w := bufio.NewWriter(pw)
+
+		err := strip(w, br)
+		if err == nil {
+			err = w.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
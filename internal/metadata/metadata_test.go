@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// strip reads data through Strip, one byte at a time if slow is set so every boundary gets hit
+func strip(t *testing.T, data []byte, slow bool) ([]byte, error) {
+	t.Helper()
+
+	var r io.Reader = bytes.NewReader(data)
+	if slow {
+		r = iotest.OneByteReader(r)
+	}
+
+	stripped := Strip(r)
+	defer stripped.Close()
+
+	return io.ReadAll(stripped)
+}
+
+func TestStripPassThrough(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"text", []byte("just some text, not an image")},
+		{"shorter than a header", []byte{0xff}},
+		{"PNG signature cut short", pngSignature[:7]},
+		{"ftyp of another brand", box("ftyp", []byte("qt  \x00\x00\x00\x00"))},
+		{"ftyp too short for a brand", []byte("\x00\x00\x00\x0aftypab")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := strip(t, tt.data, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("got %q, want the content unchanged", got)
+			}
+		})
+	}
+}
+
+// TestStripClose checks that closing the reader early stops stripping instead of leaving it blocked
+func TestStripClose(t *testing.T) {
+	data := testJPEG(t, segment(0xe1, []byte("Exif\x00\x00")))
+
+	stripped := Strip(bytes.NewReader(data))
+	_, err := stripped.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stripped.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stripped.Read(make([]byte, 1))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got error %v after Close, want io.ErrClosedPipe", err)
+	}
+}
+
+// FuzzStrip checks that no input panics and that failures are reported as ErrInvalid or ErrUnsupported
+func FuzzStrip(f *testing.F) {
+	f.Add([]byte("\xff\xd8\xff\xe1\x00\x08Exif\x00\x00\xff\xda\x00\x02\x01\x02\xff\x00\xff\xd0\xff\xd9"))
+	f.Add([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x01tEXtx\x00\x00\x00\x00\x00\x00\x00\x00IEND\x00\x00\x00\x00"))
+	f.Add(testHEIF(f, heifOptions{}).data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := strip(t, data, false)
+		if err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrUnsupported) {
+			t.Errorf("got error %v", err)
+		}
+	})
+}
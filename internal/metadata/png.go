@@ -0,0 +1,68 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata are the chunks dropped from PNG images, EXIF, text such as XMP and comments, and the time of the last change
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG copies the chunks of a PNG image except pngMetadata, whatever follows IEND is dropped
+func stripPNG(w *bufio.Writer, r *bufio.Reader) error {
+	_, err := r.Discard(len(pngSignature))
+	if err != nil {
+		return pngError(err)
+	}
+	w.Write(pngSignature)
+
+	header := make([]byte, 8)
+
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return pngError(err)
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		if length > 1<<31-1 {
+			return fmt.Errorf("%w: chunk of %d bytes", ErrInvalid, length)
+		}
+		chunk := string(header[4:])
+
+		// the data is followed by a CRC of the type and data
+		size := int64(length) + 4
+
+		if pngMetadata[chunk] {
+			_, err = io.CopyN(io.Discard, r, size)
+		} else {
+			w.Write(header)
+			_, err = io.CopyN(w, r, size)
+		}
+		if err != nil {
+			return pngError(err)
+		}
+
+		if chunk == "IEND" {
+			return nil
+		}
+	}
+}
+
+func pngError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated PNG", ErrInvalid)
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func chunk(typ string, data []byte) []byte {
+	c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	c = append(c, typ...)
+	c = append(c, data...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+// testPNG encodes a small image with the chunks inserted after IHDR and before IEND
+func testPNG(t testing.TB, afterHeader, beforeEnd [][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// the signature is followed by IHDR with 13 bytes of data, IEND is the last 12 bytes
+	header := len(pngSignature) + 12 + 13
+	end := len(encoded) - 12
+
+	data := append([]byte(nil), encoded[:header]...)
+	data = append(data, bytes.Join(afterHeader, nil)...)
+	data = append(data, encoded[header:end]...)
+	data = append(data, bytes.Join(beforeEnd, nil)...)
+	return append(data, encoded[end:]...)
+}
+
+func TestStripPNG(t *testing.T) {
+	exif := chunk("eXIf", []byte("MM\x00\x2a GPS 52.52 13.40"))
+	text := chunk("tEXt", []byte("Comment\x00taken at home"))
+	compressed := chunk("zTXt", []byte("Author\x00\x00x\x9c"))
+	xmp := chunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+	modified := chunk("tIME", []byte{0x07, 0xe8, 1, 2, 3, 4, 5})
+
+	gamma := chunk("gAMA", []byte{0, 0, 0xb1, 0x8f})
+	profile := chunk("iCCP", []byte("sRGB\x00\x00x\x9c"))
+
+	tests := []struct {
+		name        string
+		afterHeader [][]byte
+		beforeEnd   [][]byte
+		kept        [][]byte
+	}{
+		{"no metadata", nil, nil, nil},
+		{"metadata", [][]byte{exif, text, modified}, [][]byte{compressed, xmp}, nil},
+		{"kept chunks", [][]byte{gamma, profile}, nil, [][]byte{gamma, profile}},
+		{"mixed", [][]byte{gamma, exif, profile, text}, nil, [][]byte{gamma, profile}},
+		{"empty text", [][]byte{chunk("tEXt", nil)}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testPNG(t, tt.kept, nil)
+
+			for _, slow := range []bool{false, true} {
+				got, err := strip(t, testPNG(t, tt.afterHeader, tt.beforeEnd), slow)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Fatalf("got %x, want %x", got, want)
+				}
+			}
+
+			_, err := png.Decode(bytes.NewReader(want))
+			if err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripPNGAfterEnd(t *testing.T) {
+	data := testPNG(t, nil, nil)
+	trailer := append(append([]byte(nil), data...), chunk("tEXt", []byte("Comment\x00after the end"))...)
+
+	got, err := strip(t, trailer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want the %d bytes up to IEND", len(got), len(data))
+	}
+}
+
+func TestStripPNGInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"only the signature", pngSignature},
+		{"truncated chunk header", append(append([]byte(nil), pngSignature...), 0, 0, 0, 13, 'I', 'H')},
+		{"chunk longer than the file", append(append([]byte(nil), pngSignature...), chunk("IHDR", make([]byte, 13))[:20]...)},
+		{"metadata chunk longer than the file", append(append([]byte(nil), pngSignature...), chunk("tEXt", make([]byte, 64))[:40]...)},
+		{"chunk length above 2^31-1", append(append([]byte(nil), pngSignature...), 0x80, 0, 0, 0, 'I', 'D', 'A', 'T')},
+		{"no IEND", append(append([]byte(nil), pngSignature...), chunk("IHDR", make([]byte, 13))...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := strip(t, tt.data, false)
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("got error %v, want ErrInvalid", err)
+			}
+		})
+	}
+
+	valid := testPNG(t, [][]byte{chunk("tEXt", []byte("Comment\x00x"))}, nil)
+
+	// cutting the image off anywhere before the end of IEND must be noticed
+	for n := len(pngSignature); n < len(valid); n++ {
+		_, err := strip(t, valid[:n], false)
+		if !errors.Is(err, ErrInvalid) {
+			t.Fatalf("got error %v for the first %d of %d bytes, want ErrInvalid", err, n, len(valid))
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix tells API keys apart from the stateful tokens in the Authorization header
+const APIKeyPrefix = "ft_"
+
+// The scopes of API keys are permissions, a key only gets those of them the role of its user grants
+const (
+	APIKeyScopeFilesRead  = PermissionFilesRead
+	APIKeyScopeFilesWrite = PermissionFilesWrite
+)
+
+// APIKey is a long-lived credential for scripts, it is limited to its scopes
+type APIKey struct {
+	ID        int64     `json:"id"`
+	Plaintext string    `json:"key,omitempty"`
+	Hash      []byte    `json:"-"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func (key *APIKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, APIKeyScopeFilesRead, APIKeyScopeFilesWrite), "scopes", "must only contain files:read or files:write")
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+32, "key", "must be 35 bytes long")
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_keys (hash, name, scopes, user_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{key.Hash, key.Name, pq.Array(key.Scopes), key.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetByPlaintext(keyPlaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT id, hash, name, scopes, created_at, user_id
+		FROM api_keys
+		WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID,
+		&key.Hash,
+		&key.Name,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+		&key.UserID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(u *User) ([]*APIKey, error) {
+	query := `
+		SELECT id, hash, name, scopes, created_at, user_id
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Hash,
+			&key.Name,
+			pq.Array(&key.Scopes),
+			&key.CreatedAt,
+			&key.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m APIKeyModel) DeleteFromUser(id int64, u *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, u.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AuditEvent is one change made through the API, WebDAV, gRPC or SFTP. Action is the method and route
+// such as DELETE /users/files/{id}, Objects what it was done to such as file:42. ActorID is nil if nobody
+// was logged in, like for a failed login, and is kept when the user is deleted.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	ActorID   *int64    `json:"actor_id"`
+	Action    string    `json:"action"`
+	Objects   []string  `json:"objects"`
+	IP        string    `json:"ip"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows down the events of GetAll, zero values match every event. Action matches the
+// actions starting with it, so POST /tokens finds every login.
+type AuditFilter struct {
+	ActorID *int64
+	Action  string
+	Object  string
+	IP      string
+	From    *time.Time
+	To      *time.Time
+}
+
+// AuditEventModel only ever inserts, the table refuses updates and deletes
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, objects, ip, success)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	if event.Objects == nil {
+		event.Objects = []string{}
+	}
+
+	args := []interface{}{event.ActorID, event.Action, pq.Array(event.Objects), event.IP, event.Success}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// likeEscaper escapes the wildcards of LIKE, so an action prefix is matched as it is
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetAll returns one page of the events matching filter
+func (m AuditEventModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, actor_id, action, objects, ip, success, created_at
+		FROM audit_events
+		WHERE ($1::bigint IS NULL OR actor_id = $1)
+		AND action LIKE $2 || '%%'
+		AND ($3 = '' OR $3 = ANY(objects))
+		AND ($4 = '' OR ip = $4)
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY %s %s, id DESC
+		LIMIT $7 OFFSET $8`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		filter.ActorID,
+		likeEscaper.Replace(filter.Action),
+		filter.Object,
+		filter.IP,
+		filter.From,
+		filter.To,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.ActorID,
+			&event.Action,
+			pq.Array(&event.Objects),
+			&event.IP,
+			&event.Success,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
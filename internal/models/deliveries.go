@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// States of a delivery, pending until the mailer is done with it
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+// Delivery is a signed link of a file emailed to a recipient, DownloadedAt is set by the first
+// download through it
+type Delivery struct {
+	ID     int64  `json:"id"`
+	FileID int64  `json:"-"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	// Error is why the email could not be sent, if it failed
+	Error        string     `json:"error,omitempty"`
+	LinkExpiry   time.Time  `json:"link_expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SentAt       *time.Time `json:"sent_at"`
+	DownloadedAt *time.Time `json:"downloaded_at"`
+}
+
+type DeliveryModel struct {
+	DB *sql.DB
+}
+
+func (m DeliveryModel) Insert(delivery *Delivery) error {
+	query := `
+		INSERT INTO file_deliveries (file_id, email, status, link_expiry)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{delivery.FileID, delivery.Email, delivery.Status, delivery.LinkExpiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+// UpdateStatus records whether the email of the delivery with id was sent, errorMessage says why not
+func (m DeliveryModel) UpdateStatus(id int64, status, errorMessage string) error {
+	query := `
+		UPDATE file_deliveries
+		SET status = $1, error = $2, sent_at = CASE WHEN $1 = 'sent' THEN NOW() END
+		WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, errorMessage, id)
+	return err
+}
+
+// SetDownloaded records the first download through the link of the delivery with id of the file with fileID
+func (m DeliveryModel) SetDownloaded(id, fileID int64) error {
+	query := `
+		UPDATE file_deliveries
+		SET downloaded_at = NOW()
+		WHERE id = $1 AND file_id = $2 AND downloaded_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, fileID)
+	return err
+}
+
+// GetAllForFile returns one page of the deliveries of file, the last one first
+func (m DeliveryModel) GetAllForFile(file *File, filters Filters) ([]*Delivery, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, file_id, email, status, error, link_expiry, created_at, sent_at, downloaded_at
+		FROM file_deliveries
+		WHERE file_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3`
+
+	args := []interface{}{file.ID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*Delivery{}
+
+	for rows.Next() {
+		var delivery Delivery
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.FileID,
+			&delivery.Email,
+			&delivery.Status,
+			&delivery.Error,
+			&delivery.LinkExpiry,
+			&delivery.CreatedAt,
+			&delivery.SentAt,
+			&delivery.DownloadedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
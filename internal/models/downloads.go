@@ -0,0 +1,79 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Download is one download of a file code, the IP address is truncated before it is stored
+type Download struct {
+	ID           int64     `json:"id"`
+	FileID       int64     `json:"-"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+}
+
+type DownloadModel struct {
+	DB *sql.DB
+}
+
+func (m DownloadModel) Insert(download *Download) error {
+	query := `
+		INSERT INTO downloads (file_id, ip, user_agent)
+		VALUES ($1, $2, $3)
+		RETURNING id, downloaded_at`
+
+	args := []interface{}{download.FileID, download.IP, download.UserAgent}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&download.ID, &download.DownloadedAt)
+}
+
+func (m DownloadModel) GetAllForFile(file *File, filters Filters) ([]*Download, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, file_id, downloaded_at, ip, user_agent
+		FROM downloads
+		WHERE file_id = $1
+		ORDER BY %s %s, id DESC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{file.ID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	downloads := []*Download{}
+
+	for rows.Next() {
+		var download Download
+		err := rows.Scan(
+			&totalRecords,
+			&download.ID,
+			&download.FileID,
+			&download.DownloadedAt,
+			&download.IP,
+			&download.UserAgent,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		downloads = append(downloads, &download)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return downloads, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EmailChange is a change of the email address of a user waiting for the new address to be confirmed
+type EmailChange struct {
+	UserID int64
+	// Email is the new address and OldEmail the one it replaced, which is only set by Confirm
+	Email    string
+	OldEmail string
+	Token    *Token
+}
+
+// EmailChangeModel keeps one pending change per user, asking for another replaces it
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// New stores a change of the email address of the user to email, confirmed with the plaintext of
+// the returned token
+func (m EmailChangeModel) New(userID int64, email string, ttl time.Duration) (*EmailChange, error) {
+	token, err := GenerateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO email_changes (user_id, email, hash, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, hash = EXCLUDED.hash, expiry = EXCLUDED.expiry`
+
+	args := []interface{}{userID, email, token.Hash, token.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailChange{UserID: userID, Email: email, Token: token}, nil
+}
+
+// Confirm changes the email address of the user of an unexpired change in one transaction, the change
+// is used up with it. It returns ErrDuplicateEmail if the new address got an account in the meantime,
+// the change is kept then.
+func (m EmailChangeModel) Confirm(tokenPlaintext string) (*EmailChange, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var change EmailChange
+
+	query := `
+		DELETE FROM email_changes
+		WHERE hash = $1 AND expiry > $2
+		RETURNING user_id, email`
+
+	err = tx.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(&change.UserID, &change.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT email
+		FROM users
+		WHERE id = $1`
+
+	err = tx.QueryRowContext(ctx, query, change.UserID).Scan(&change.OldEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE users
+		SET email = $1, last_updated = $2
+		WHERE id = $3`
+
+	_, err = tx.ExecContext(ctx, query, change.Email, time.Now(), change.UserID)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users_email_key"):
+			return nil, ErrDuplicateEmail
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// DeleteExpired removes the changes which were not confirmed in time and returns how many there were
+func (m EmailChangeModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM email_changes
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -2,236 +2,1149 @@ package models
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/Li-Elias/File-Transfer/internal/codes"
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
 	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/lib/pq"
 )
 
-var (
-	ErrDuplicatePath = errors.New("duplicate path")
+// Malware scan states of a file, only clean and skipped files can be downloaded
+const (
+	ScanStatusPending  = "pending"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	ScanStatusFailed   = "failed"
+	// ScanStatusSkipped files are encrypted with a client passphrase the server cannot scan with
+	ScanStatusSkipped = "skipped"
 )
 
 type File struct {
 	ID          int64     `json:"id"`
 	Name        string    `json:"name"`
+	Description string    `json:"description"`
 	Size        int64     `json:"size"`
 	Path        string    `json:"-"`
 	Code        string    `json:"code"`
 	Expiry      time.Time `json:"expiry"`
 	CreatedAt   time.Time `json:"created_at"`
 	LastUpdated time.Time `json:"last_updated"`
-	UserID      int64     `json:"-"`
+	// UserID is nil for files uploaded by guests, which have no owner
+	UserID            *int64   `json:"-"`
+	TransferID        *int64   `json:"transfer_id,omitempty"`
+	Password          password `json:"-"`
+	PasswordProtected bool     `json:"password_protected"`
+	MaxDownloads      *int     `json:"max_downloads,omitempty"`
+	DownloadCount     int      `json:"download_count"`
+	ChecksumSHA256    string   `json:"checksum_sha256,omitempty"`
+	ContentType       string   `json:"content_type"`
+	// EncryptionKey is the per-file key wrapped by the master key, or by the client passphrase
+	// if PassphraseSalt is set. It is nil for unencrypted files.
+	EncryptionKey       []byte   `json:"-"`
+	EncryptionNonce     []byte   `json:"-"`
+	PassphraseSalt      []byte   `json:"-"`
+	PassphraseProtected bool     `json:"passphrase_protected"`
+	NotifyOnDownload    bool     `json:"notify_on_download"`
+	Tags                []string `json:"tags"`
+	ScanStatus          string   `json:"scan_status"`
+	// DeletedAt is set while the file is in the trash, trashed files cannot be downloaded
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Pending files wait for the client to upload the content to a presigned URL, they
+	// cannot be downloaded and only appear to their owner until they are completed
+	Pending bool `json:"pending,omitempty"`
+	// Version goes up with every change of the name, content, details or share, updates
+	// of a file changed in the meantime fail with ErrEditConflict
+	Version int `json:"version"`
+	// Folder is the directory of a file uploaded with a folder, such as "photos/2024", and empty otherwise
+	Folder string `json:"folder,omitempty"`
+	// HasThumbnail is set once a preview of an image has been generated, see GetThumbnail
+	HasThumbnail bool `json:"has_thumbnail"`
+	// Paste files were created from text sent as is, they are shown in the browser instead of saved
+	Paste bool `json:"paste,omitempty"`
+	// AllowedNetworks are the CIDR ranges a file can be downloaded from, anywhere if there are none
+	AllowedNetworks []string `json:"allowed_networks,omitempty"`
+	// BlockedCountries are the ISO codes of the countries a file cannot be downloaded from, with -geoip-database
+	BlockedCountries []string `json:"blocked_countries,omitempty"`
+	// OrganizationID is the organization whose space the file was uploaded into, members see it there
+	OrganizationID *int64 `json:"organization_id,omitempty"`
+	// LegalHold keeps the file from being deleted by the janitor, at its expiry, by a retention policy or
+	// from the trash, until an admin releases it. Deleting it only moves it to the trash.
+	LegalHold bool `json:"legal_hold,omitempty"`
 }
 
 type FileModel struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Tracer *tracing.Tracer
+	// CaseInsensitiveCodes finds files by their code regardless of case, see codeMatch
+	CaseInsensitiveCodes bool
+	// ctx is what queries are traced as part of, see WithContext
+	ctx context.Context
 }
 
-func ValidateFile(v *validator.Validator, file *File) {
+// WithContext returns a FileModel whose queries are traced as part of ctx. Canceling ctx does not
+// cancel the queries, they keep their own timeout.
+func (m FileModel) WithContext(ctx context.Context) FileStore {
+	m.ctx = context.WithoutCancel(ctx)
+	return m
+}
+
+// query starts the span of a query together with its timeout, the returned function ends both
+func (m FileModel) query(name, query string) (context.Context, func()) {
+	parent := m.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, span := m.Tracer.Start(parent, "FileModel."+name, tracing.KindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// fileColumns are the columns read by scanFile, in order
+const fileColumns = `id, name, description, size, path, code, expiry, created_at, last_updated, user_id, transfer_id, password_hash, max_downloads, download_count, checksum_sha256, content_type, encryption_key, encryption_nonce, passphrase_salt, notify_on_download, deleted_at, tags, scan_status, pending, version, folder, thumbnail IS NOT NULL, paste, organization_id, legal_hold, allowed_networks, blocked_countries`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFile(row rowScanner) (*File, error) {
+	var file File
+
+	err := row.Scan(
+		&file.ID,
+		&file.Name,
+		&file.Description,
+		&file.Size,
+		&file.Path,
+		&file.Code,
+		&file.Expiry,
+		&file.CreatedAt,
+		&file.LastUpdated,
+		&file.UserID,
+		&file.TransferID,
+		&file.Password.hash,
+		&file.MaxDownloads,
+		&file.DownloadCount,
+		&file.ChecksumSHA256,
+		&file.ContentType,
+		&file.EncryptionKey,
+		&file.EncryptionNonce,
+		&file.PassphraseSalt,
+		&file.NotifyOnDownload,
+		&file.DeletedAt,
+		pq.Array(&file.Tags),
+		&file.ScanStatus,
+		&file.Pending,
+		&file.Version,
+		&file.Folder,
+		&file.HasThumbnail,
+		&file.Paste,
+		&file.OrganizationID,
+		&file.LegalHold,
+		pq.Array(&file.AllowedNetworks),
+		pq.Array(&file.BlockedCountries),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	file.PasswordProtected = file.Password.IsSet()
+	file.PassphraseProtected = file.PassphraseSalt != nil
+
+	return &file, nil
+}
+
+func ValidateFile(v *validator.Validator, file *File, maxSize int64) {
+	v.Check(file.Name != "", "file_name", "must be provided")
 	v.Check(len(file.Name) <= 50, "file_name", "must not be more than 50 bytes long")
-	v.Check(file.Size <= 1_000_000, "file_size", "must not be more than 1_000_000 bytes big")
-	v.Check(len(file.Code) == 8, "code", "must be 8 bytes long")
+	v.Check(len(file.Folder) <= 255, "folder", "must not be more than 255 bytes long")
+	v.Check(len(file.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+	v.Check(file.Size <= maxSize, "file_size", fmt.Sprintf("must not be more than %d bytes big", maxSize))
+	v.Check(file.Code != "", "code", "must be provided")
+
+	ValidateTags(v, file.Tags)
+	ValidateNetworks(v, "allowed_networks", file.AllowedNetworks)
+	ValidateCountries(v, "blocked_countries", file.BlockedCountries)
+
+	if file.MaxDownloads != nil {
+		v.Check(*file.MaxDownloads > 0, "max_downloads", "must be greater than zero")
+	}
+}
+
+// ValidateTags checks tags which have already been normalized with NormalizeTags
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= 20, "tags", "must not contain more than 20 tags")
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+
+	for _, tag := range tags {
+		v.Check(tag != "", "tags", "must not contain empty tags")
+		v.Check(len(tag) <= 32, "tags", "must not contain tags longer than 32 bytes")
+	}
+}
+
+// NormalizeTags trims and lowercases tags, so "Work" and "work " are the same tag
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		normalized[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+	return normalized
+}
+
+// ValidateNetworks checks networks which have already been normalized with NormalizeNetworks
+func ValidateNetworks(v *validator.Validator, key string, networks []string) {
+	v.Check(len(networks) <= 50, key, "must not contain more than 50 networks")
+	v.Check(validator.Unique(networks), key, "must not contain duplicate values")
+
+	for _, network := range networks {
+		_, err := ParseNetwork(network)
+		v.Check(err == nil, key, "must only contain IP addresses and CIDR ranges such as 10.0.0.0/8")
+	}
+}
+
+// NormalizeNetworks writes networks in their canonical form, a single address becomes a range of one
+// such as 10.1.2.3/32. Invalid networks are kept as they are for ValidateNetworks to report.
+func NormalizeNetworks(networks []string) []string {
+	normalized := make([]string, len(networks))
+	for i, network := range networks {
+		parsed, err := ParseNetwork(network)
+		if err != nil {
+			normalized[i] = network
+			continue
+		}
+		normalized[i] = parsed.String()
+	}
+	return normalized
+}
+
+// ParseNetwork parses a CIDR range or a single IP address, with surrounding spaces
+func ParseNetwork(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+// AllowsIP reports whether file can be downloaded from ip, files without allowed networks can be from anywhere
+func (file *File) AllowsIP(ip net.IP) bool {
+	if len(file.AllowedNetworks) == 0 {
+		return true
+	}
+
+	for _, network := range file.AllowedNetworks {
+		parsed, err := ParseNetwork(network)
+		if err == nil && ip != nil && parsed.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateCountries checks countries which have already been normalized with NormalizeCountries
+func ValidateCountries(v *validator.Validator, key string, countries []string) {
+	v.Check(len(countries) <= 250, key, "must not contain more than 250 countries")
+	v.Check(validator.Unique(countries), key, "must not contain duplicate values")
+
+	for _, country := range countries {
+		v.Check(ValidCountry(country), key, "must only contain two letter country codes such as DE")
+	}
+}
+
+// ValidCountry reports whether country looks like an ISO 3166-1 alpha-2 code, whether it is
+// assigned is up to the GeoIP database
+func ValidCountry(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, c := range country {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeCountries trims and uppercases country codes, the way GeoIP databases write them
+func NormalizeCountries(countries []string) []string {
+	normalized := make([]string, len(countries))
+	for i, country := range countries {
+		normalized[i] = strings.ToUpper(strings.TrimSpace(country))
+	}
+	return normalized
+}
+
+// newStoragePath returns an opaque key for the blob of a file, a random UUID which tells
+// nothing about the owner or the name and never collides with the key of another file
+func newStoragePath() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// version 4, variant 10
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("files/%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Insert also assigns the storage path of the file
+func (m FileModel) Insert(file *File) error {
+	path, err := newStoragePath()
+	if err != nil {
+		return err
+	}
+
+	file.Path = path
+
+	query := `
+		INSERT INTO files (name, description, size, path, code, expiry, user_id, transfer_id, password_hash, max_downloads, notify_on_download, tags, scan_status, checksum_sha256, pending, folder, paste, organization_id, allowed_networks, blocked_countries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		RETURNING id, created_at, last_updated, version`
+
+	args := []interface{}{file.Name, file.Description, file.Size, file.Path, file.Code, file.Expiry, file.UserID, file.TransferID, file.Password.hash, file.MaxDownloads, file.NotifyOnDownload, pq.Array(file.Tags), file.ScanStatus, file.ChecksumSHA256, file.Pending, file.Folder, file.Paste, file.OrganizationID, pq.Array(file.AllowedNetworks), pq.Array(file.BlockedCountries)}
+
+	ctx, done := m.query("Insert", query)
+	defer done()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&file.ID, &file.CreatedAt, &file.LastUpdated, &file.Version)
+	if err != nil {
+		return err
+	}
+
+	file.PasswordProtected = file.Password.IsSet()
+
+	return nil
+}
+
+// getFile runs a query returning a single row of fileColumns
+func (m FileModel) getFile(query string, args ...interface{}) (*File, error) {
+	ctx, done := m.query("getFile", query)
+	defer done()
+
+	file, err := scanFile(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// getFiles runs a query returning rows of fileColumns
+func (m FileModel) getFiles(query string, args ...interface{}) ([]*File, error) {
+	ctx, done := m.query("getFiles", query)
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []*File{}
+
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (m FileModel) GetFromUser(id int64, u *User) (*File, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE id = $1 AND user_id = $2 AND expiry > $3 AND deleted_at IS NULL`
+
+	return m.getFile(query, id, u.ID, time.Now())
+}
+
+// GetManyFromUser returns the stored files of u among ids, ordered by ID. Missing IDs are left out.
+func (m FileModel) GetManyFromUser(ids []int64, u *User) ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE id = ANY($1) AND user_id = $2 AND expiry > $3 AND deleted_at IS NULL AND NOT pending
+		ORDER BY id`
+
+	return m.getFiles(query, pq.Array(ids), u.ID, time.Now())
+}
+
+// GetAllFromUser returns one page of the files of u whose name contains name (case insensitive)
+// and which carry all of tags
+func (m FileModel) GetAllFromUser(u *User, name string, tags []string, filters Filters) ([]*File, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+fileColumns+`
+		FROM files
+		WHERE user_id = $1 AND expiry > $2 AND deleted_at IS NULL
+		AND (strpos(lower(name), lower($3)) > 0 OR $3 = '')
+		AND tags @> $4
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{u.ID, time.Now(), name, pq.Array(tags), filters.limit(), filters.offset()}
+
+	ctx, done := m.query("GetAllFromUser", query)
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	files := []*File{}
+
+	for rows.Next() {
+		file, err := scanFile(totalScanner{row: rows, total: &totalRecords})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		files = append(files, file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return files, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// SearchFromUser returns one page of the files of u matching q, best matches first. Words of q are
+// looked up in the name and description, the name also matches on similarity so parts of file
+// names like "report" in "quarterly_report.pdf" are found.
+func (m FileModel) SearchFromUser(u *User, q string, filters Filters) ([]*File, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + fileColumns + `
+		FROM files, websearch_to_tsquery('simple', $3) query
+		WHERE user_id = $1 AND expiry > $2 AND deleted_at IS NULL
+		AND (search @@ query OR name % $3 OR strpos(lower(name), lower($3)) > 0)
+		ORDER BY ts_rank(search, query) + similarity(name, $3) DESC, id ASC
+		LIMIT $4 OFFSET $5`
+
+	args := []interface{}{u.ID, time.Now(), q, filters.limit(), filters.offset()}
+
+	ctx, done := m.query("SearchFromUser", query)
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	files := []*File{}
+
+	for rows.Next() {
+		file, err := scanFile(totalScanner{row: rows, total: &totalRecords})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		files = append(files, file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return files, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetFromUserByName returns the newest file of u called name
+func (m FileModel) GetFromUserByName(name string, u *User) (*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE name = $1 AND user_id = $2 AND expiry > $3 AND deleted_at IS NULL AND NOT pending
+		ORDER BY id DESC
+		LIMIT 1`
+
+	return m.getFile(query, name, u.ID, time.Now())
+}
+
+// GetAllLatestFromUser returns the newest file of u for every name, ordered by name
+func (m FileModel) GetAllLatestFromUser(u *User) ([]*File, error) {
+	query := `
+		SELECT DISTINCT ON (name) ` + fileColumns + `
+		FROM files
+		WHERE user_id = $1 AND expiry > $2 AND deleted_at IS NULL AND NOT pending
+		ORDER BY name, id DESC`
+
+	return m.getFiles(query, u.ID, time.Now())
+}
+
+// GetAllOwnedBy returns every file of u, the expired, trashed and pending ones too, ordered by ID
+func (m FileModel) GetAllOwnedBy(u *User) ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE user_id = $1
+		ORDER BY id`
+
+	return m.getFiles(query, u.ID)
+}
+
+// GetAllFromOrganization returns one page of the files uploaded into the space of the organization
+// with id, newest first
+func (m FileModel) GetAllFromOrganization(id int64, filters Filters) ([]*File, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + fileColumns + `
+		FROM files
+		WHERE organization_id = $1 AND expiry > $2 AND deleted_at IS NULL AND NOT pending
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4`
+
+	args := []interface{}{id, time.Now(), filters.limit(), filters.offset()}
+
+	ctx, done := m.query("GetAllFromOrganization", query)
+	defer done()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	files := []*File{}
+
+	for rows.Next() {
+		file, err := scanFile(totalScanner{row: rows, total: &totalRecords})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		files = append(files, file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return files, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetOrganizationUsage returns how many bytes the unexpired files of the organization with id take up,
+// files in the trash count as they are still stored
+func (m FileModel) GetOrganizationUsage(id int64) (int64, error) {
+	query := `
+		SELECT COALESCE(SUM(size), 0)
+		FROM files
+		WHERE organization_id = $1 AND expiry > $2`
+
+	ctx, done := m.query("GetOrganizationUsage", query)
+	defer done()
+
+	var usage int64
+
+	err := m.DB.QueryRowContext(ctx, query, id, time.Now()).Scan(&usage)
+	if err != nil {
+		return 0, err
+	}
+
+	return usage, nil
+}
+
+// GetUserUsage returns how many bytes the unexpired files of the user with userID take up and how many
+// there are, files in the trash count as they are still stored
+func (m FileModel) GetUserUsage(userID int64) (int64, int, error) {
+	query := `
+		SELECT COALESCE(SUM(size), 0), count(*)
+		FROM files
+		WHERE user_id = $1 AND expiry > $2`
+
+	ctx, done := m.query("GetUserUsage", query)
+	defer done()
+
+	var usage int64
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now()).Scan(&usage, &count)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return usage, count, nil
+}
+
+func (m FileModel) GetAllFromTransfer(t *Transfer) ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE transfer_id = $1 AND expiry > $2 AND deleted_at IS NULL
+		ORDER BY id`
+
+	return m.getFiles(query, t.ID, time.Now())
+}
+
+// codeMatch is the condition of a lookup by the code $1. Case-insensitive lookups prefer the code with the
+// exact case, codes generated before -code-case-insensitive may only differ in case.
+func codeMatch(caseInsensitive bool) string {
+	if caseInsensitive {
+		return "(code = $1 OR lower(code) = lower($1))"
+	}
+	return "code = $1"
+}
+
+// GetFromCode returns the file with code, word codes match with any case and separators
+func (m FileModel) GetFromCode(code string) (*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE ` + codeMatch(m.CaseInsensitiveCodes) + ` AND expiry > $2 AND deleted_at IS NULL AND NOT pending
+		ORDER BY code = $1 DESC
+		LIMIT 1`
+
+	return m.getFile(query, codes.Normalize(code), time.Now())
+}
+
+// UpdateFromUser renews the code and expiry of a file about to get new content, the file
+// keeps its name so the new content has to be uploaded under the same one. It fails with
+// ErrEditConflict unless the file is still at version.
+func (m FileModel) UpdateFromUser(name string, id int64, u *User, version int, code string, expiry time.Time) (*File, error) {
+	query := `
+		UPDATE files
+		SET expiry = $1, last_updated = $2, code = $3, version = version + 1
+		WHERE name = $4 AND id = $5 AND user_id = $6 AND expiry > $7 AND deleted_at IS NULL AND version = $8
+		RETURNING ` + fileColumns
+
+	args := []interface{}{
+		expiry,
+		time.Now(),
+		code,
+		name,
+		id,
+		u.ID,
+		time.Now(),
+		version,
+	}
+
+	file, err := m.getFile(query, args...)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, ErrEditConflict
+	}
+
+	return file, err
+}
+
+// UpdateShare stores the code and expiry of file, its content and last_updated stay the same.
+// It fails with ErrEditConflict if file was changed since it was read.
+func (m FileModel) UpdateShare(file *File) error {
+	query := `
+		UPDATE files
+		SET code = $1, expiry = $2, version = version + 1
+		WHERE id = $3 AND expiry > $4 AND deleted_at IS NULL AND version = $5
+		RETURNING version`
+
+	args := []interface{}{file.Code, file.Expiry, file.ID, time.Now(), file.Version}
+
+	return m.updateVersion("UpdateShare", query, file, args...)
+}
+
+// UpdateDetails stores the name, description, tags, notification setting, allowed networks and blocked
+// countries of file.
+// It fails with ErrEditConflict if file was changed since it was read.
+func (m FileModel) UpdateDetails(file *File) error {
+	query := `
+		UPDATE files
+		SET name = $1, description = $2, tags = $3, notify_on_download = $4, allowed_networks = $5, blocked_countries = $6, version = version + 1
+		WHERE id = $7 AND expiry > $8 AND deleted_at IS NULL AND version = $9
+		RETURNING version`
+
+	args := []interface{}{file.Name, file.Description, pq.Array(file.Tags), file.NotifyOnDownload, pq.Array(file.AllowedNetworks), pq.Array(file.BlockedCountries), file.ID, time.Now(), file.Version}
+
+	return m.updateVersion("UpdateDetails", query, file, args...)
+}
+
+// updateVersion runs an update of file returning its new version, no row means the file changed in the meantime
+func (m FileModel) updateVersion(name, query string, file *File, args ...interface{}) error {
+	ctx, done := m.query(name, query)
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&file.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateContent records the size, checksum, content type, encryption key and scan status of newly stored content
+func (m FileModel) UpdateContent(file *File) error {
+	query := `
+		UPDATE files
+		SET size = $1, checksum_sha256 = $2, content_type = $3, encryption_key = $4, encryption_nonce = $5, passphrase_salt = $6, scan_status = $7, thumbnail = NULL
+		WHERE id = $8`
+
+	args := []interface{}{file.Size, file.ChecksumSHA256, file.ContentType, file.EncryptionKey, file.EncryptionNonce, file.PassphraseSalt, file.ScanStatus, file.ID}
+
+	ctx, done := m.query("UpdateContent", query)
+	defer done()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// Complete records the content of a pending file once it has been uploaded, from then on it can be downloaded
+func (m FileModel) Complete(file *File) error {
+	query := `
+		UPDATE files
+		SET pending = false, size = $1, checksum_sha256 = $2, content_type = $3, scan_status = $4
+		WHERE id = $5 AND pending AND expiry > $6 AND deleted_at IS NULL`
+
+	args := []interface{}{file.Size, file.ChecksumSHA256, file.ContentType, file.ScanStatus, file.ID, time.Now()}
+
+	ctx, done := m.query("Complete", query)
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	file.Pending = false
+
+	return nil
+}
+
+// RegisterDownload counts a download of file, it returns ErrRecordNotFound
+// once the download limit of the file has been used up
+func (m FileModel) RegisterDownload(file *File) error {
+	query := `
+		UPDATE files
+		SET download_count = download_count + 1
+		WHERE id = $1 AND expiry > $2 AND deleted_at IS NULL AND (max_downloads IS NULL OR download_count < max_downloads)
+		RETURNING download_count`
+
+	ctx, done := m.query("RegisterDownload", query)
+	defer done()
+
+	err := m.DB.QueryRowContext(ctx, query, file.ID, time.Now()).Scan(&file.DownloadCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FullName returns the name of file with its folder in front
+func (file *File) FullName() string {
+	return path.Join(file.Folder, file.Name)
+}
+
+func (file *File) DownloadLimitReached() bool {
+	return file.MaxDownloads != nil && file.DownloadCount >= *file.MaxDownloads
+}
+
+// includes expired files
+func (m FileModel) Get(id int64) (*File, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE id = $1`
+
+	return m.getFile(query, id)
 }
 
-func (m FileModel) Insert(file *File) error {
+func (m FileModel) GetAllUnexpired() ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE expiry > $1 AND deleted_at IS NULL
+		ORDER BY id`
+
+	return m.getFiles(query, time.Now())
+}
+
+// includes expired files
+func (m FileModel) GetAll() ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files`
+
+	return m.getFiles(query)
+}
+
+// GetAllStored returns the files whose content is kept in storage, including the ones in the trash
+func (m FileModel) GetAllStored() ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE NOT pending AND expiry > $1
+		ORDER BY id`
+
+	return m.getFiles(query, time.Now())
+}
+
+// GetAllExpired also returns pending files created before pendingBefore, which were never completed
+func (m FileModel) GetAllExpired(pendingBefore time.Time) ([]*File, error) {
 	query := `
-		INSERT INTO files (name, size, path, code, expiry, user_id)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, last_updated`
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE NOT legal_hold AND (expiry <= $1 OR (pending AND created_at <= $2))`
 
-	args := []interface{}{file.Name, file.Size, file.Path, file.Code, file.Expiry, file.UserID}
+	return m.getFiles(query, time.Now(), pendingBefore)
+}
+
+func (m FileModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM files
+		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := m.query("Delete", query)
+	defer done()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&file.ID, &file.CreatedAt, &file.LastUpdated)
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "files_path_key"`:
-			return ErrDuplicatePath
-		default:
-			return err
-		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (m FileModel) GetFromUser(id int64, u *User) (*File, error) {
+// only deletes the file if it has not been extended or completed in the meantime
+func (m FileModel) DeleteExpired(id int64, pendingBefore time.Time) error {
 	if id < 1 {
-		return nil, ErrRecordNotFound
+		return ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, name, size, path, code, expiry, created_at, last_updated
-		FROM files
-		WHERE id = $1 AND user_id = $2 AND expiry > $3`
+		DELETE FROM files
+		WHERE id = $1 AND NOT legal_hold AND (expiry <= $2 OR (pending AND created_at <= $3))`
 
-	args := []interface{}{id, u.ID, time.Now()}
+	ctx, done := m.query("DeleteExpired", query)
+	defer done()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, time.Now(), pendingBefore)
+	if err != nil {
+		return err
+	}
 
-	var file File
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&file.ID,
-		&file.Name,
-		&file.Size,
-		&file.Path,
-		&file.Code,
-		&file.Expiry,
-		&file.CreatedAt,
-		&file.LastUpdated,
-	)
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// also returns path
+func (m FileModel) DeleteFromUser(id int64, u *User) (string, error) {
+	if id < 1 {
+		return "", ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM files
+		WHERE id = $1 AND user_id = $2 AND expiry > $3 AND deleted_at IS NULL
+		RETURNING path`
+
+	args := []interface{}{id, u.ID, time.Now()}
 
+	ctx, done := m.query("DeleteFromUser", query)
+	defer done()
+
+	var path string
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&path)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return "", ErrRecordNotFound
 		default:
-			return nil, err
+			return "", err
 		}
 	}
 
-	return &file, nil
+	return path, nil
+}
+
+// Trash moves a file of u into the trash, its content is kept until it is restored or purged
+func (m FileModel) Trash(id int64, u *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE files
+		SET deleted_at = $1
+		WHERE id = $2 AND user_id = $3 AND expiry > $1 AND deleted_at IS NULL`
+
+	ctx, done := m.query("Trash", query)
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), id, u.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
 
-func (m FileModel) GetAllFromUser(u *User) ([]*File, error) {
+// Restore takes a file of u out of the trash, files which expired in the meantime stay gone
+func (m FileModel) Restore(id int64, u *User) (*File, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-		SELECT id, name, size, path, code, expiry, created_at, last_updated
+		UPDATE files
+		SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND expiry > $3 AND deleted_at IS NOT NULL
+		RETURNING ` + fileColumns
+
+	return m.getFile(query, id, u.ID, time.Now())
+}
+
+// GetTrashFromUser returns one page of the unexpired files of u in the trash
+func (m FileModel) GetTrashFromUser(u *User, filters Filters) ([]*File, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), `+fileColumns+`
 		FROM files
-		WHERE user_id = $1 AND expiry > $2`
+		WHERE user_id = $1 AND expiry > $2 AND deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{u.ID, time.Now(), filters.limit(), filters.offset()}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := m.query("GetTrashFromUser", query)
+	defer done()
 
-	rows, err := m.DB.QueryContext(ctx, query, u.ID, time.Now())
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	files := []*File{}
 
 	for rows.Next() {
-		var file File
-		err := rows.Scan(
-			&file.ID,
-			&file.Name,
-			&file.Size,
-			&file.Path,
-			&file.Code,
-			&file.Expiry,
-			&file.CreatedAt,
-			&file.LastUpdated,
-		)
+		file, err := scanFile(totalScanner{row: rows, total: &totalRecords})
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
-		files = append(files, &file)
+		files = append(files, file)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return files, nil
+	return files, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
-func (m FileModel) GetFromCode(code string) (*File, error) {
+// GetAllTrashedBefore returns the files moved into the trash before t
+func (m FileModel) GetAllTrashedBefore(t time.Time) ([]*File, error) {
 	query := `
-			SELECT id, name, size, path, code, expiry, created_at, last_updated
-			FROM files
-			WHERE code = $1 AND expiry > $2`
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE deleted_at <= $1 AND NOT legal_hold`
 
-	var file File
+	return m.getFiles(query, t)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// DeleteTrashed only deletes the file if it has not been restored in the meantime
+func (m FileModel) DeleteTrashed(id int64, before time.Time) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, code, time.Now()).Scan(
-		&file.ID,
-		&file.Name,
-		&file.Size,
-		&file.Path,
-		&file.Code,
-		&file.Expiry,
-		&file.CreatedAt,
-		&file.LastUpdated,
-	)
+	query := `
+		DELETE FROM files
+		WHERE id = $1 AND deleted_at <= $2 AND NOT legal_hold`
 
+	ctx, done := m.query("DeleteTrashed", query)
+	defer done()
+
+	result, err := m.DB.ExecContext(ctx, query, id, before)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
-		}
+		return err
 	}
 
-	return &file, nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllExpiringBefore returns the stored files of users which expire before t, except those whose owner was
+// already told about their current expiry, see MarkExpiryNotified
+func (m FileModel) GetAllExpiringBefore(t time.Time) ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE user_id IS NOT NULL AND expiry > $1 AND expiry <= $2 AND deleted_at IS NULL AND NOT pending
+		AND notified_expiry IS DISTINCT FROM expiry
+		ORDER BY expiry, id`
+
+	return m.getFiles(query, time.Now(), t)
 }
 
-func (m FileModel) UpdateFromUser(path string, id int64, u *User, code string) (*File, error) {
+// MarkExpiryNotified records that the owner of file was told about its expiry. A file whose expiry changes
+// is notified about again. It fails with ErrRecordNotFound if another server marked it first or the expiry
+// changed since file was read, so each expiry is notified about once.
+func (m FileModel) MarkExpiryNotified(file *File) error {
 	query := `
 		UPDATE files
-		SET expiry = $1, last_updated = $2, code = $3
-		WHERE path = $4 AND id = $5 AND user_id = $6 AND expiry > $7
-		RETURNING *`
+		SET notified_expiry = expiry
+		WHERE id = $1 AND expiry = $2 AND notified_expiry IS DISTINCT FROM expiry`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := m.query("MarkExpiryNotified", query)
+	defer done()
 
-	args := []interface{}{
-		time.Now().Add(2 * time.Minute),
-		time.Now(),
-		code,
-		path,
-		id,
-		u.ID,
-		time.Now(),
+	result, err := m.DB.ExecContext(ctx, query, file.ID, file.Expiry)
+	if err != nil {
+		return err
 	}
 
-	var file File
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&file.ID,
-		&file.Name,
-		&file.Size,
-		&file.Path,
-		&file.Code,
-		&file.Expiry,
-		&file.CreatedAt,
-		&file.LastUpdated,
-		&file.UserID,
-	)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
-		}
+		return err
 	}
 
-	return &file, nil
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
 
-func (m FileModel) Delete(id int64) error {
+// SetLegalHold puts the file with id on legal hold or releases it, the file is returned as it is now
+func (m FileModel) SetLegalHold(id int64, hold bool) (*File, error) {
 	if id < 1 {
-		return ErrRecordNotFound
+		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM files
-		WHERE id = $1 AND expiry > $2`
+		UPDATE files
+		SET legal_hold = $1
+		WHERE id = $2
+		RETURNING ` + fileColumns
+
+	return m.getFile(query, hold, id)
+}
+
+// GetAllPendingScan returns up to limit files waiting for a malware scan, oldest first
+func (m FileModel) GetAllPendingScan(limit int) ([]*File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE scan_status = $1 AND expiry > $2 AND NOT pending
+		ORDER BY last_updated, id
+		LIMIT $3`
+
+	return m.getFiles(query, ScanStatusPending, time.Now(), limit)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// UpdateScanStatus records the result of a scan. It only applies if the content has not been
+// replaced during the scan, the replacement is scanned on its own.
+func (m FileModel) UpdateScanStatus(file *File) error {
+	query := `
+		UPDATE files
+		SET scan_status = $1
+		WHERE id = $2 AND scan_status = $3 AND checksum_sha256 = $4`
+
+	args := []interface{}{file.ScanStatus, file.ID, ScanStatusPending, file.ChecksumSHA256}
+
+	ctx, done := m.query("UpdateScanStatus", query)
+	defer done()
 
-	result, err := m.DB.ExecContext(ctx, query, id, time.Now())
+	result, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -248,37 +1161,58 @@ func (m FileModel) Delete(id int64) error {
 	return nil
 }
 
-// also returns path
-func (m FileModel) DeleteFromUser(id int64, u *User) (string, error) {
-	if id < 1 {
-		return "", ErrRecordNotFound
-	}
-
+// UpdateThumbnail stores the thumbnail of file, unless its content was replaced since the thumbnail was made of it
+func (m FileModel) UpdateThumbnail(file *File, thumbnail []byte) error {
 	query := `
-		DELETE FROM files
-		WHERE id = $1 AND user_id = $2 AND expiry > $3
-		RETURNING path`
+		UPDATE files
+		SET thumbnail = $1
+		WHERE id = $2 AND checksum_sha256 = $3`
 
-	args := []interface{}{id, u.ID, time.Now()}
+	args := []interface{}{thumbnail, file.ID, file.ChecksumSHA256}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	ctx, done := m.query("UpdateThumbnail", query)
+	defer done()
 
-	var path string
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&path)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 
+	file.HasThumbnail = true
+
+	return nil
+}
+
+// GetThumbnail returns the JPEG thumbnail of the file with id, files without one are not found
+func (m FileModel) GetThumbnail(id int64) ([]byte, error) {
+	query := `
+		SELECT thumbnail
+		FROM files
+		WHERE id = $1 AND thumbnail IS NOT NULL`
+
+	ctx, done := m.query("GetThumbnail", query)
+	defer done()
+
+	var thumbnail []byte
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&thumbnail)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return "", ErrRecordNotFound
+			return nil, ErrRecordNotFound
 		default:
-			return "", err
+			return nil, err
 		}
 	}
 
-	return path, nil
+	return thumbnail, nil
 }
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Reasons a login attempt failed for
+const (
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginLocked             = "locked"
+	LoginSuspended          = "suspended"
+)
+
+// LoginAttempt is one login with a password, through POST /tokens/authenticate, SFTP or WebDAV.
+// UserID is nil for emails without an account.
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	UserID    *int64    `json:"-"`
+	Email     string    `json:"-"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+func (m LoginAttemptModel) Insert(attempt *LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (user_id, email, ip, user_agent, success, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []interface{}{attempt.UserID, attempt.Email, attempt.IP, attempt.UserAgent, attempt.Success, attempt.Reason}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&attempt.ID, &attempt.CreatedAt)
+}
+
+// FailuresForEmail counts the attempts for email with wrong credentials since the given time and since
+// the last successful login, it returns the time of the latest of them too
+func (m LoginAttemptModel) FailuresForEmail(email string, since time.Time) (int, time.Time, error) {
+	query := `
+		SELECT count(*), max(created_at)
+		FROM login_attempts
+		WHERE email = $1 AND reason = $2 AND created_at > $3
+		AND created_at > COALESCE((SELECT max(created_at) FROM login_attempts WHERE email = $1 AND success), $3)`
+
+	return m.countFailures(query, email, since)
+}
+
+// FailuresFromIP counts the attempts from ip with wrong credentials since the given time, whichever
+// email they were for. Logging in successfully does not start over, anyone can do that with their own account.
+func (m LoginAttemptModel) FailuresFromIP(ip string, since time.Time) (int, time.Time, error) {
+	query := `
+		SELECT count(*), max(created_at)
+		FROM login_attempts
+		WHERE ip = $1 AND reason = $2 AND created_at > $3`
+
+	return m.countFailures(query, ip, since)
+}
+
+func (m LoginAttemptModel) countFailures(query, value string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	var last sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, value, LoginInvalidCredentials, since).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, last.Time, nil
+}
+
+// GetAllForUser returns the login history of a user, attempts for the email of an account before
+// it was registered are left out
+func (m LoginAttemptModel) GetAllForUser(u *User, filters Filters) ([]*LoginAttempt, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, user_id, email, ip, user_agent, success, reason, created_at
+		FROM login_attempts
+		WHERE user_id = $1
+		ORDER BY %s %s, id DESC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{u.ID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	attempts := []*LoginAttempt{}
+
+	for rows.Next() {
+		var attempt LoginAttempt
+		err := rows.Scan(
+			&totalRecords,
+			&attempt.ID,
+			&attempt.UserID,
+			&attempt.Email,
+			&attempt.IP,
+			&attempt.UserAgent,
+			&attempt.Success,
+			&attempt.Reason,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		attempts = append(attempts, &attempt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return attempts, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// DeleteForEmail removes the attempts for an email address, including those made before it had an account
+func (m LoginAttemptModel) DeleteForEmail(email string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+
+	return err
+}
+
+// DeleteOlderThan removes the attempts made before the given time and returns how many there were
+func (m LoginAttemptModel) DeleteOlderThan(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
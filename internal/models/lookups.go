@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/abuse"
+)
+
+// LookupFailureModel keeps the failed code lookups of abuse.Tracker, so every instance sees the same counts
+// and they survive restarts
+type LookupFailureModel struct {
+	DB *sql.DB
+}
+
+var _ abuse.Store = LookupFailureModel{}
+
+func (m LookupFailureModel) Get(ctx context.Context, key string) (abuse.Record, error) {
+	query := `
+		SELECT key, failures, last_failure, locked_until
+		FROM lookup_failures
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var record abuse.Record
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&record.Key, &record.Failures, &record.LastFailure, &record.LockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return abuse.Record{}, nil
+		}
+		return abuse.Record{}, err
+	}
+
+	return record, nil
+}
+
+func (m LookupFailureModel) Fail(ctx context.Context, key string, now, since time.Time) (abuse.Record, error) {
+	query := `
+		INSERT INTO lookup_failures (key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN lookup_failures.last_failure < $3 THEN 1 ELSE lookup_failures.failures + 1 END,
+			last_failure = $2
+		RETURNING key, failures, last_failure, locked_until`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var record abuse.Record
+
+	err := m.DB.QueryRowContext(ctx, query, key, now, since).Scan(&record.Key, &record.Failures, &record.LastFailure, &record.LockedUntil)
+	if err != nil {
+		return abuse.Record{}, err
+	}
+
+	return record, nil
+}
+
+func (m LookupFailureModel) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE lookup_failures
+		SET locked_until = $2
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, key, until)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return abuse.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m LookupFailureModel) List(ctx context.Context, since, now time.Time) ([]abuse.Record, error) {
+	query := `
+		SELECT key, failures, last_failure, locked_until
+		FROM lookup_failures
+		WHERE last_failure >= $1 OR locked_until > $2
+		ORDER BY failures DESC, key`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []abuse.Record{}
+
+	for rows.Next() {
+		var record abuse.Record
+		err := rows.Scan(&record.Key, &record.Failures, &record.LastFailure, &record.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (m LookupFailureModel) Delete(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM lookup_failures
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return abuse.ErrNotFound
+	}
+
+	return nil
+}
+
+func (m LookupFailureModel) DeleteExpired(ctx context.Context, since, now time.Time) error {
+	query := `
+		DELETE FROM lookup_failures
+		WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until <= $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, since, now)
+	return err
+}
@@ -0,0 +1,644 @@
+package mocks
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/codes"
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+var errDuplicateCode = errors.New("duplicate code")
+
+// FileStore keeps the files ordered by ID
+type FileStore struct {
+	mu     sync.Mutex
+	files  []*models.File
+	nextID int64
+	// thumbnails are kept apart from the files like the column which is not part of fileColumns
+	thumbnails map[int64][]byte
+	// notifiedExpiry is the expiry the owner of a file was told about, see MarkExpiryNotified
+	notifiedExpiry map[int64]time.Time
+}
+
+// clone copies file so callers cannot change the stored one through its pointers and slices
+func clone(file *models.File) *models.File {
+	copied := *file
+	copied.Tags = slices.Clone(file.Tags)
+	copied.AllowedNetworks = slices.Clone(file.AllowedNetworks)
+	copied.BlockedCountries = slices.Clone(file.BlockedCountries)
+	if file.TransferID != nil {
+		id := *file.TransferID
+		copied.TransferID = &id
+	}
+	if file.MaxDownloads != nil {
+		maxDownloads := *file.MaxDownloads
+		copied.MaxDownloads = &maxDownloads
+	}
+	if file.DeletedAt != nil {
+		deletedAt := *file.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+	if file.OrganizationID != nil {
+		id := *file.OrganizationID
+		copied.OrganizationID = &id
+	}
+	return &copied
+}
+
+// live reports whether file is neither expired nor in the trash
+func live(file *models.File) bool {
+	return file.Expiry.After(time.Now()) && file.DeletedAt == nil
+}
+
+// ownedBy reports whether file belongs to u, files uploaded by guests belong to nobody
+func ownedBy(file *models.File, u *models.User) bool {
+	return file.UserID != nil && *file.UserID == u.ID
+}
+
+// find returns copies of the files matching match, ordered by ID
+func (s *FileStore) find(match func(*models.File) bool) []*models.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := []*models.File{}
+	for _, file := range s.files {
+		if match(file) {
+			files = append(files, clone(file))
+		}
+	}
+
+	return files
+}
+
+func (s *FileStore) findOne(match func(*models.File) bool) (*models.File, error) {
+	files := s.find(match)
+	if len(files) == 0 {
+		return nil, models.ErrRecordNotFound
+	}
+	return files[0], nil
+}
+
+// update applies change to the first file matching match and returns a copy of the result
+func (s *FileStore) update(match func(*models.File) bool, change func(*models.File)) (*models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, file := range s.files {
+		if match(file) {
+			change(file)
+			return clone(file), nil
+		}
+	}
+
+	return nil, models.ErrRecordNotFound
+}
+
+// remove deletes the first file matching match and returns it
+func (s *FileStore) remove(match func(*models.File) bool) (*models.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, file := range s.files {
+		if match(file) {
+			s.files = slices.Delete(s.files, i, i+1)
+			return file, nil
+		}
+	}
+
+	return nil, models.ErrRecordNotFound
+}
+
+// page sorts files by the column of filters, then by ID, and returns the page of filters
+func page(files []*models.File, filters models.Filters) ([]*models.File, models.Metadata) {
+	if !slices.Contains(filters.SortSafelist, filters.Sort) {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	column := strings.TrimPrefix(filters.Sort, "-")
+	descending := strings.HasPrefix(filters.Sort, "-")
+
+	slices.SortStableFunc(files, func(a, b *models.File) int {
+		var c int
+		switch column {
+		case "name":
+			c = cmp.Compare(a.Name, b.Name)
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "expiry":
+			c = a.Expiry.Compare(b.Expiry)
+		case "created_at":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "deleted_at":
+			c = a.DeletedAt.Compare(*b.DeletedAt)
+		}
+		if descending {
+			c = -c
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		return c
+	})
+
+	total := len(files)
+	if total == 0 {
+		return files, models.Metadata{}
+	}
+
+	start := min((filters.Page-1)*filters.PageSize, total)
+	end := min(start+filters.PageSize, total)
+
+	return files[start:end], models.Metadata{
+		CurrentPage:  filters.Page,
+		PageSize:     filters.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(total) / float64(filters.PageSize))),
+		TotalRecords: total,
+	}
+}
+
+// WithContext returns s itself, the mock does not trace
+func (s *FileStore) WithContext(ctx context.Context) models.FileStore {
+	return s
+}
+
+// Insert fills in what the database would, the ID, timestamps, storage path and column defaults
+func (s *FileStore) Insert(file *models.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.files {
+		if stored.Code == file.Code {
+			return errDuplicateCode
+		}
+	}
+
+	s.nextID++
+	file.ID = s.nextID
+	file.Path = fmt.Sprintf("files/mock-%d", file.ID)
+	file.CreatedAt = time.Now()
+	file.LastUpdated = file.CreatedAt
+	file.PasswordProtected = file.Password.IsSet()
+	file.Version = 1
+
+	stored := clone(file)
+	stored.ContentType = "application/octet-stream"
+	stored.DownloadCount = 0
+	stored.EncryptionKey = nil
+	stored.EncryptionNonce = nil
+	stored.PassphraseSalt = nil
+	stored.PassphraseProtected = false
+	stored.DeletedAt = nil
+	stored.HasThumbnail = false
+	s.files = append(s.files, stored)
+
+	return nil
+}
+
+// Get includes expired files
+func (s *FileStore) Get(id int64) (*models.File, error) {
+	return s.findOne(func(file *models.File) bool {
+		return file.ID == id
+	})
+}
+
+func (s *FileStore) GetFromUser(id int64, u *models.User) (*models.File, error) {
+	return s.findOne(func(file *models.File) bool {
+		return file.ID == id && ownedBy(file, u) && live(file)
+	})
+}
+
+func (s *FileStore) GetManyFromUser(ids []int64, u *models.User) ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return slices.Contains(ids, file.ID) && ownedBy(file, u) && live(file) && !file.Pending
+	}), nil
+}
+
+func (s *FileStore) GetFromUserByName(name string, u *models.User) (*models.File, error) {
+	files := s.find(func(file *models.File) bool {
+		return file.Name == name && ownedBy(file, u) && live(file) && !file.Pending
+	})
+	if len(files) == 0 {
+		return nil, models.ErrRecordNotFound
+	}
+	return files[len(files)-1], nil
+}
+
+func (s *FileStore) GetFromCode(code string) (*models.File, error) {
+	code = codes.Normalize(code)
+	return s.findOne(func(file *models.File) bool {
+		return file.Code == code && live(file) && !file.Pending
+	})
+}
+
+// GetAll includes expired files
+func (s *FileStore) GetAll() ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return true
+	}), nil
+}
+
+func (s *FileStore) GetAllFromUser(u *models.User, name string, tags []string, filters models.Filters) ([]*models.File, models.Metadata, error) {
+	files := s.find(func(file *models.File) bool {
+		if !ownedBy(file, u) || !live(file) || !strings.Contains(strings.ToLower(file.Name), strings.ToLower(name)) {
+			return false
+		}
+		for _, tag := range tags {
+			if !slices.Contains(file.Tags, tag) {
+				return false
+			}
+		}
+		return true
+	})
+
+	files, metadata := page(files, filters)
+	return files, metadata, nil
+}
+
+// SearchFromUser matches files whose name or description contains a word of q, in the order of their IDs
+func (s *FileStore) SearchFromUser(u *models.User, q string, filters models.Filters) ([]*models.File, models.Metadata, error) {
+	words := strings.Fields(strings.ToLower(q))
+
+	files := s.find(func(file *models.File) bool {
+		if !ownedBy(file, u) || !live(file) {
+			return false
+		}
+		text := strings.ToLower(file.Name + " " + file.Description)
+		return slices.ContainsFunc(words, func(word string) bool {
+			return strings.Contains(text, word)
+		})
+	})
+
+	filters.Sort = "id"
+	filters.SortSafelist = []string{"id"}
+
+	files, metadata := page(files, filters)
+	return files, metadata, nil
+}
+
+func (s *FileStore) GetAllOwnedBy(u *models.User) ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return ownedBy(file, u)
+	}), nil
+}
+
+func (s *FileStore) GetAllLatestFromUser(u *models.User) ([]*models.File, error) {
+	latest := map[string]*models.File{}
+	for _, file := range s.find(func(file *models.File) bool {
+		return ownedBy(file, u) && live(file) && !file.Pending
+	}) {
+		latest[file.Name] = file
+	}
+
+	files := []*models.File{}
+	for _, file := range latest {
+		files = append(files, file)
+	}
+	slices.SortFunc(files, func(a, b *models.File) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return files, nil
+}
+
+func (s *FileStore) GetAllFromTransfer(t *models.Transfer) ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return file.TransferID != nil && *file.TransferID == t.ID && live(file)
+	}), nil
+}
+
+func inOrganization(file *models.File, id int64) bool {
+	return file.OrganizationID != nil && *file.OrganizationID == id
+}
+
+// GetAllFromOrganization returns the files of the organization in the order of their IDs
+func (s *FileStore) GetAllFromOrganization(id int64, filters models.Filters) ([]*models.File, models.Metadata, error) {
+	files := s.find(func(file *models.File) bool {
+		return inOrganization(file, id) && live(file) && !file.Pending
+	})
+
+	filters.Sort = "id"
+	filters.SortSafelist = []string{"id"}
+
+	files, metadata := page(files, filters)
+	return files, metadata, nil
+}
+
+func (s *FileStore) GetOrganizationUsage(id int64) (int64, error) {
+	var usage int64
+	for _, file := range s.find(func(file *models.File) bool {
+		return inOrganization(file, id) && file.Expiry.After(time.Now())
+	}) {
+		usage += file.Size
+	}
+	return usage, nil
+}
+
+func (s *FileStore) GetUserUsage(userID int64) (int64, int, error) {
+	var usage int64
+	files := s.find(func(file *models.File) bool {
+		return file.UserID != nil && *file.UserID == userID && file.Expiry.After(time.Now())
+	})
+	for _, file := range files {
+		usage += file.Size
+	}
+	return usage, len(files), nil
+}
+
+func (s *FileStore) GetAllUnexpired() ([]*models.File, error) {
+	return s.find(live), nil
+}
+
+func (s *FileStore) GetAllStored() ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return !file.Pending && file.Expiry.After(time.Now())
+	}), nil
+}
+
+// expired reports whether file expired or is pending since before pendingBefore
+func expired(file *models.File, pendingBefore time.Time) bool {
+	return !file.LegalHold && (!file.Expiry.After(time.Now()) || (file.Pending && !file.CreatedAt.After(pendingBefore)))
+}
+
+func (s *FileStore) GetAllExpired(pendingBefore time.Time) ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return expired(file, pendingBefore)
+	}), nil
+}
+
+func (s *FileStore) GetAllPendingScan(limit int) ([]*models.File, error) {
+	files := s.find(func(file *models.File) bool {
+		return file.ScanStatus == models.ScanStatusPending && file.Expiry.After(time.Now()) && !file.Pending
+	})
+
+	slices.SortStableFunc(files, func(a, b *models.File) int {
+		return a.LastUpdated.Compare(b.LastUpdated)
+	})
+
+	return files[:min(limit, len(files))], nil
+}
+
+func (s *FileStore) UpdateFromUser(name string, id int64, u *models.User, version int, code string, expiry time.Time) (*models.File, error) {
+	file, err := s.update(func(file *models.File) bool {
+		return file.Name == name && file.ID == id && ownedBy(file, u) && live(file) && file.Version == version
+	}, func(file *models.File) {
+		file.Expiry = expiry
+		file.LastUpdated = time.Now()
+		file.Code = code
+		file.Version++
+	})
+	if err != nil {
+		return nil, models.ErrEditConflict
+	}
+	return file, nil
+}
+
+func (s *FileStore) UpdateShare(file *models.File) error {
+	updated, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && live(stored) && stored.Version == file.Version
+	}, func(stored *models.File) {
+		stored.Code = file.Code
+		stored.Expiry = file.Expiry
+		stored.Version++
+	})
+	if err != nil {
+		return models.ErrEditConflict
+	}
+
+	file.Version = updated.Version
+
+	return nil
+}
+
+func (s *FileStore) UpdateDetails(file *models.File) error {
+	updated, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && live(stored) && stored.Version == file.Version
+	}, func(stored *models.File) {
+		stored.Name = file.Name
+		stored.Description = file.Description
+		stored.Tags = slices.Clone(file.Tags)
+		stored.NotifyOnDownload = file.NotifyOnDownload
+		stored.AllowedNetworks = slices.Clone(file.AllowedNetworks)
+		stored.BlockedCountries = slices.Clone(file.BlockedCountries)
+		stored.Version++
+	})
+	if err != nil {
+		return models.ErrEditConflict
+	}
+
+	file.Version = updated.Version
+
+	return nil
+}
+
+// UpdateContent does not report missing files, like the model
+func (s *FileStore) UpdateContent(file *models.File) error {
+	s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID
+	}, func(stored *models.File) {
+		stored.Size = file.Size
+		stored.ChecksumSHA256 = file.ChecksumSHA256
+		stored.ContentType = file.ContentType
+		stored.EncryptionKey = file.EncryptionKey
+		stored.EncryptionNonce = file.EncryptionNonce
+		stored.PassphraseSalt = file.PassphraseSalt
+		stored.PassphraseProtected = file.PassphraseSalt != nil
+		stored.ScanStatus = file.ScanStatus
+		stored.HasThumbnail = false
+		delete(s.thumbnails, stored.ID)
+	})
+	return nil
+}
+
+func (s *FileStore) UpdateScanStatus(file *models.File) error {
+	_, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && stored.ScanStatus == models.ScanStatusPending && stored.ChecksumSHA256 == file.ChecksumSHA256
+	}, func(stored *models.File) {
+		stored.ScanStatus = file.ScanStatus
+	})
+	return err
+}
+
+func (s *FileStore) UpdateThumbnail(file *models.File, thumbnail []byte) error {
+	_, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && stored.ChecksumSHA256 == file.ChecksumSHA256
+	}, func(stored *models.File) {
+		if s.thumbnails == nil {
+			s.thumbnails = map[int64][]byte{}
+		}
+		s.thumbnails[stored.ID] = slices.Clone(thumbnail)
+		stored.HasThumbnail = true
+	})
+	if err != nil {
+		return err
+	}
+
+	file.HasThumbnail = true
+
+	return nil
+}
+
+func (s *FileStore) GetThumbnail(id int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, file := range s.files {
+		if file.ID == id && s.thumbnails[id] != nil {
+			return slices.Clone(s.thumbnails[id]), nil
+		}
+	}
+
+	return nil, models.ErrRecordNotFound
+}
+
+func (s *FileStore) Complete(file *models.File) error {
+	_, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && stored.Pending && live(stored)
+	}, func(stored *models.File) {
+		stored.Pending = false
+		stored.Size = file.Size
+		stored.ChecksumSHA256 = file.ChecksumSHA256
+		stored.ContentType = file.ContentType
+		stored.ScanStatus = file.ScanStatus
+	})
+	if err != nil {
+		return err
+	}
+
+	file.Pending = false
+
+	return nil
+}
+
+func (s *FileStore) RegisterDownload(file *models.File) error {
+	updated, err := s.update(func(stored *models.File) bool {
+		return stored.ID == file.ID && live(stored) && (stored.MaxDownloads == nil || stored.DownloadCount < *stored.MaxDownloads)
+	}, func(stored *models.File) {
+		stored.DownloadCount++
+	})
+	if err != nil {
+		return err
+	}
+
+	file.DownloadCount = updated.DownloadCount
+
+	return nil
+}
+
+func (s *FileStore) Delete(id int64) error {
+	_, err := s.remove(func(file *models.File) bool {
+		return file.ID == id
+	})
+	return err
+}
+
+func (s *FileStore) DeleteExpired(id int64, pendingBefore time.Time) error {
+	_, err := s.remove(func(file *models.File) bool {
+		return file.ID == id && expired(file, pendingBefore)
+	})
+	return err
+}
+
+func (s *FileStore) DeleteFromUser(id int64, u *models.User) (string, error) {
+	file, err := s.remove(func(file *models.File) bool {
+		return file.ID == id && ownedBy(file, u) && live(file)
+	})
+	if err != nil {
+		return "", err
+	}
+	return file.Path, nil
+}
+
+func (s *FileStore) Trash(id int64, u *models.User) error {
+	_, err := s.update(func(file *models.File) bool {
+		return file.ID == id && ownedBy(file, u) && live(file)
+	}, func(file *models.File) {
+		now := time.Now()
+		file.DeletedAt = &now
+	})
+	return err
+}
+
+func (s *FileStore) Restore(id int64, u *models.User) (*models.File, error) {
+	return s.update(func(file *models.File) bool {
+		return file.ID == id && ownedBy(file, u) && file.Expiry.After(time.Now()) && file.DeletedAt != nil
+	}, func(file *models.File) {
+		file.DeletedAt = nil
+	})
+}
+
+func (s *FileStore) GetTrashFromUser(u *models.User, filters models.Filters) ([]*models.File, models.Metadata, error) {
+	files := s.find(func(file *models.File) bool {
+		return ownedBy(file, u) && file.Expiry.After(time.Now()) && file.DeletedAt != nil
+	})
+
+	files, metadata := page(files, filters)
+	return files, metadata, nil
+}
+
+func (s *FileStore) GetAllTrashedBefore(t time.Time) ([]*models.File, error) {
+	return s.find(func(file *models.File) bool {
+		return file.DeletedAt != nil && !file.DeletedAt.After(t) && !file.LegalHold
+	}), nil
+}
+
+func (s *FileStore) GetAllExpiringBefore(t time.Time) ([]*models.File, error) {
+	s.mu.Lock()
+	notified := maps.Clone(s.notifiedExpiry)
+	s.mu.Unlock()
+
+	files := s.find(func(file *models.File) bool {
+		notifiedExpiry, ok := notified[file.ID]
+		return file.UserID != nil && live(file) && !file.Pending && !file.Expiry.After(t) &&
+			!(ok && notifiedExpiry.Equal(file.Expiry))
+	})
+	slices.SortStableFunc(files, func(a, b *models.File) int {
+		return a.Expiry.Compare(b.Expiry)
+	})
+	return files, nil
+}
+
+func (s *FileStore) MarkExpiryNotified(file *models.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.files {
+		if stored.ID != file.ID || !stored.Expiry.Equal(file.Expiry) {
+			continue
+		}
+		if notified, ok := s.notifiedExpiry[file.ID]; ok && notified.Equal(stored.Expiry) {
+			break
+		}
+		if s.notifiedExpiry == nil {
+			s.notifiedExpiry = make(map[int64]time.Time)
+		}
+		s.notifiedExpiry[file.ID] = stored.Expiry
+		return nil
+	}
+
+	return models.ErrRecordNotFound
+}
+
+func (s *FileStore) SetLegalHold(id int64, hold bool) (*models.File, error) {
+	return s.update(func(file *models.File) bool {
+		return file.ID == id
+	}, func(file *models.File) {
+		file.LegalHold = hold
+	})
+}
+
+func (s *FileStore) DeleteTrashed(id int64, before time.Time) error {
+	_, err := s.remove(func(file *models.File) bool {
+		return file.ID == id && file.DeletedAt != nil && !file.DeletedAt.After(before) && !file.LegalHold
+	})
+	return err
+}
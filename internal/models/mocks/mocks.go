@@ -0,0 +1,18 @@
+// Package mocks keeps files, users and tokens in memory, so handlers can be tested without a database.
+// The stores follow the queries of the models closely but do not trace, rank searches or time out.
+package mocks
+
+import (
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+// NewModels returns models whose files, users and tokens are kept in memory, the other models have no database
+func NewModels() models.Models {
+	tokens := &TokenStore{}
+
+	return models.Models{
+		Files:  &FileStore{},
+		Users:  &UserStore{tokens: tokens},
+		Tokens: tokens,
+	}
+}
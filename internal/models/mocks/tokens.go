@@ -0,0 +1,66 @@
+package mocks
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+type TokenStore struct {
+	mu     sync.Mutex
+	tokens []models.Token
+}
+
+func (s *TokenStore) New(userID int64, ttl time.Duration, scope string) (*models.Token, error) {
+	token, err := models.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Insert(token)
+
+	return token, err
+}
+
+// Insert keeps the token without its plaintext, like the database
+func (s *TokenStore) Insert(token *models.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *token
+	stored.Plaintext = ""
+	s.tokens = append(s.tokens, stored)
+
+	return nil
+}
+
+func (s *TokenStore) DeleteAllForUser(scope string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.Scope != scope || token.UserID != userID {
+			kept = append(kept, token)
+		}
+	}
+	s.tokens = kept
+
+	return nil
+}
+
+// userID returns the user of the unexpired token of scope with hash
+func (s *TokenStore) userID(scope string, hash []byte) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.Scope == scope && bytes.Equal(token.Hash, hash) && token.Expiry.After(time.Now()) {
+			return token.UserID, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package mocks
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/models"
+)
+
+type UserStore struct {
+	mu     sync.Mutex
+	users  []*models.User
+	nextID int64
+	tokens *TokenStore
+}
+
+// emailTaken reports whether another user than id has email, emails are case insensitive like citext
+func (s *UserStore) emailTaken(email string, id int64) bool {
+	for _, user := range s.users {
+		if user.ID != id && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserStore) find(match func(*models.User) bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+
+	return nil, models.ErrRecordNotFound
+}
+
+func (s *UserStore) Insert(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, 0) {
+		return models.ErrDuplicateEmail
+	}
+
+	s.nextID++
+	user.ID = s.nextID
+	user.CreatedAt = time.Now()
+	user.LastUpdated = user.CreatedAt
+	user.Role = models.RoleMember
+	user.Suspended = false
+
+	stored := *user
+	s.users = append(s.users, &stored)
+
+	return nil
+}
+
+func (s *UserStore) Get(id int64) (*models.User, error) {
+	return s.find(func(user *models.User) bool {
+		return user.ID == id
+	})
+}
+
+func (s *UserStore) GetByEmail(email string) (*models.User, error) {
+	return s.find(func(user *models.User) bool {
+		return strings.EqualFold(user.Email, email)
+	})
+}
+
+func (s *UserStore) GetByToken(tokenScope, tokenPlaintext string) (*models.User, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	id, ok := s.tokens.userID(tokenScope, hash[:])
+	if !ok {
+		return nil, models.ErrRecordNotFound
+	}
+
+	return s.Get(id)
+}
+
+func (s *UserStore) GetAll() ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*models.User, len(s.users))
+	for i, user := range s.users {
+		found := *user
+		users[i] = &found
+	}
+
+	return users, nil
+}
+
+func (s *UserStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, stored := range s.users {
+		if stored.ID == id {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return nil
+		}
+	}
+
+	return models.ErrRecordNotFound
+}
+
+func (s *UserStore) Update(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, user.ID) {
+		return models.ErrDuplicateEmail
+	}
+
+	for i, stored := range s.users {
+		if stored.ID == user.ID {
+			user.LastUpdated = time.Now()
+			updated := *user
+			updated.CreatedAt = stored.CreatedAt
+			s.users[i] = &updated
+			return nil
+		}
+	}
+
+	return models.ErrEditConflict
+}
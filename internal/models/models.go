@@ -1,8 +1,13 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/tracing"
+	"github.com/lib/pq"
 )
 
 var (
@@ -10,16 +15,140 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// FileStore keeps the files, FileModel keeps them in PostgreSQL and the mocks package in memory
+type FileStore interface {
+	// WithContext returns a FileStore whose queries are traced as part of ctx
+	WithContext(ctx context.Context) FileStore
+
+	Insert(file *File) error
+	Get(id int64) (*File, error)
+	GetFromUser(id int64, u *User) (*File, error)
+	GetManyFromUser(ids []int64, u *User) ([]*File, error)
+	GetFromUserByName(name string, u *User) (*File, error)
+	GetFromCode(code string) (*File, error)
+	GetAll() ([]*File, error)
+	GetAllFromUser(u *User, name string, tags []string, filters Filters) ([]*File, Metadata, error)
+	SearchFromUser(u *User, q string, filters Filters) ([]*File, Metadata, error)
+	GetAllLatestFromUser(u *User) ([]*File, error)
+	GetAllOwnedBy(u *User) ([]*File, error)
+	GetAllFromTransfer(t *Transfer) ([]*File, error)
+	GetAllFromOrganization(id int64, filters Filters) ([]*File, Metadata, error)
+	GetOrganizationUsage(id int64) (int64, error)
+	GetUserUsage(userID int64) (int64, int, error)
+	GetAllUnexpired() ([]*File, error)
+	GetAllStored() ([]*File, error)
+	GetAllExpired(pendingBefore time.Time) ([]*File, error)
+	GetAllPendingScan(limit int) ([]*File, error)
+	GetAllExpiringBefore(t time.Time) ([]*File, error)
+	MarkExpiryNotified(file *File) error
+	SetLegalHold(id int64, hold bool) (*File, error)
+	UpdateFromUser(name string, id int64, u *User, version int, code string, expiry time.Time) (*File, error)
+	UpdateShare(file *File) error
+	UpdateDetails(file *File) error
+	UpdateContent(file *File) error
+	UpdateScanStatus(file *File) error
+	UpdateThumbnail(file *File, thumbnail []byte) error
+	GetThumbnail(id int64) ([]byte, error)
+	Complete(file *File) error
+	RegisterDownload(file *File) error
+	Delete(id int64) error
+	DeleteExpired(id int64, pendingBefore time.Time) error
+	DeleteFromUser(id int64, u *User) (string, error)
+	Trash(id int64, u *User) error
+	Restore(id int64, u *User) (*File, error)
+	GetTrashFromUser(u *User, filters Filters) ([]*File, Metadata, error)
+	GetAllTrashedBefore(t time.Time) ([]*File, error)
+	DeleteTrashed(id int64, before time.Time) error
+}
+
+// UserStore keeps the users, implemented by UserModel
+type UserStore interface {
+	Insert(user *User) error
+	Get(id int64) (*User, error)
+	GetByEmail(email string) (*User, error)
+	GetByToken(tokenScope, tokenPlaintext string) (*User, error)
+	GetAll() ([]*User, error)
+	Update(user *User) error
+	Delete(id int64) error
+}
+
+// TokenStore keeps the tokens, implemented by TokenModel
+type TokenStore interface {
+	New(userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(token *Token) error
+	DeleteAllForUser(scope string, userID int64) error
+}
+
+var (
+	_ FileStore  = FileModel{}
+	_ UserStore  = UserModel{}
+	_ TokenStore = TokenModel{}
+)
+
 type Models struct {
-	Users  UserModel
-	Tokens TokenModel
-	Files  FileModel
+	Users              UserStore
+	Tokens             TokenStore
+	APIKeys            APIKeyModel
+	Files              FileStore
+	Uploads            UploadModel
+	Transfers          TransferModel
+	Downloads          DownloadModel
+	Webhooks           WebhookModel
+	Stats              StatsModel
+	LookupFailures     LookupFailureModel
+	LoginAttempts      LoginAttemptModel
+	Passkeys           PasskeyModel
+	Identities         IdentityModel
+	Sessions           SessionModel
+	Revocations        RevocationModel
+	UserSettings       UserSettingsModel
+	EmailChanges       EmailChangeModel
+	Permissions        PermissionModel
+	Organizations      OrganizationModel
+	FileShares         FileShareModel
+	OwnershipTransfers OwnershipTransferModel
+	Deliveries         DeliveryModel
+	Plans              PlanModel
+	Subscriptions      SubscriptionModel
+	AuditEvents        AuditEventModel
+	RetentionPolicies  RetentionPolicyModel
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the models of db, queries through Files are traced with tracer. With
+// caseInsensitiveCodes files and transfers are found by their code regardless of case.
+func NewModels(db *sql.DB, tracer *tracing.Tracer, caseInsensitiveCodes bool) Models {
 	return Models{
-		Users:  UserModel{DB: db},
-		Tokens: TokenModel{DB: db},
-		Files:  FileModel{DB: db},
+		Users:              UserModel{DB: db},
+		Tokens:             TokenModel{DB: db},
+		APIKeys:            APIKeyModel{DB: db},
+		Files:              FileModel{DB: db, Tracer: tracer, CaseInsensitiveCodes: caseInsensitiveCodes},
+		Uploads:            UploadModel{DB: db},
+		Transfers:          TransferModel{DB: db, CaseInsensitiveCodes: caseInsensitiveCodes},
+		Downloads:          DownloadModel{DB: db},
+		Webhooks:           WebhookModel{DB: db},
+		Stats:              StatsModel{DB: db},
+		LookupFailures:     LookupFailureModel{DB: db},
+		LoginAttempts:      LoginAttemptModel{DB: db},
+		Passkeys:           PasskeyModel{DB: db},
+		Identities:         IdentityModel{DB: db},
+		Sessions:           SessionModel{DB: db},
+		Revocations:        RevocationModel{DB: db},
+		UserSettings:       UserSettingsModel{DB: db},
+		EmailChanges:       EmailChangeModel{DB: db},
+		Permissions:        PermissionModel{DB: db},
+		Organizations:      OrganizationModel{DB: db},
+		FileShares:         FileShareModel{DB: db},
+		OwnershipTransfers: OwnershipTransferModel{DB: db},
+		Deliveries:         DeliveryModel{DB: db},
+		Plans:              PlanModel{DB: db},
+		Subscriptions:      SubscriptionModel{DB: db},
+		AuditEvents:        AuditEventModel{DB: db},
+		RetentionPolicies:  RetentionPolicyModel{DB: db},
 	}
 }
+
+// isUniqueViolation reports whether err is a violation of the unique constraint named constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == constraint
+}
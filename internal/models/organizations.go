@@ -0,0 +1,435 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// The roles of the members of an organization, owners invite and remove members and delete it
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleMember = "member"
+)
+
+// ScopeOrganizationInvitation tokens are kept in the organization_invitations table, next to the address
+const ScopeOrganizationInvitation = "organization-invitation"
+
+// Organization is a team with a space of files every member sees
+type Organization struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Quota is the most bytes the files of the organization may take up together, nil for no limit
+	Quota     *int64    `json:"quota"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role is the role of the member the organization was looked up for, empty for admins
+	Role string `json:"role,omitempty"`
+}
+
+type OrganizationMember struct {
+	UserID   int64     `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// OrganizationInvitation is an invitation of an email address, whoever has an account with it can accept it
+type OrganizationInvitation struct {
+	OrganizationID int64
+	Email          string
+	Role           string
+	Token          *Token
+}
+
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+	v.Check(organization.Name != "", "name", "must be provided")
+	v.Check(len(organization.Name) <= 50, "name", "must not be more than 50 bytes long")
+
+	if organization.Quota != nil {
+		v.Check(*organization.Quota >= 0, "quota", "must not be negative")
+	}
+}
+
+func ValidateOrganizationRole(v *validator.Validator, role string) {
+	v.Check(validator.PermittedValue(role, OrganizationRoleOwner, OrganizationRoleMember), "role", "must be owner or member")
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the organization with owner as its first member
+func (m OrganizationModel) Insert(organization *Organization, owner *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name, quota)
+		VALUES ($1, $2)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, organization.Name, organization.Quota).Scan(&organization.ID, &organization.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, organization.ID, owner.ID, OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	organization.Role = OrganizationRoleOwner
+
+	return tx.Commit()
+}
+
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, name, quota, created_at
+		FROM organizations
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var organization Organization
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&organization.ID, &organization.Name, &organization.Quota, &organization.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// GetForUser returns the organization with id if u is a member, with the role of u
+func (m OrganizationModel) GetForUser(id int64, u *User) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT organizations.id, organizations.name, organizations.quota, organizations.created_at, organization_members.role
+		FROM organizations
+		INNER JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organizations.id = $1 AND organization_members.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var organization Organization
+
+	err := m.DB.QueryRowContext(ctx, query, id, u.ID).Scan(&organization.ID, &organization.Name, &organization.Quota, &organization.CreatedAt, &organization.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+func (m OrganizationModel) getOrganizations(query string, args ...interface{}) ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	organizations := []*Organization{}
+
+	for rows.Next() {
+		var organization Organization
+
+		err := rows.Scan(&organization.ID, &organization.Name, &organization.Quota, &organization.CreatedAt, &organization.Role)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, &organization)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// GetAllForUser returns the organizations u is a member of with the role of u, ordered by ID
+func (m OrganizationModel) GetAllForUser(u *User) ([]*Organization, error) {
+	query := `
+		SELECT organizations.id, organizations.name, organizations.quota, organizations.created_at, organization_members.role
+		FROM organizations
+		INNER JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organization_members.user_id = $1
+		ORDER BY organizations.id`
+
+	return m.getOrganizations(query, u.ID)
+}
+
+// GetAll returns every organization ordered by ID, for admins
+func (m OrganizationModel) GetAll() ([]*Organization, error) {
+	query := `
+		SELECT id, name, quota, created_at, ''
+		FROM organizations
+		ORDER BY id`
+
+	return m.getOrganizations(query)
+}
+
+func (m OrganizationModel) UpdateQuota(organization *Organization) error {
+	query := `
+		UPDATE organizations
+		SET quota = $1
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, organization.Quota, organization.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete removes the organization, its files are kept by the members who uploaded them
+func (m OrganizationModel) Delete(id int64) error {
+	query := `
+		DELETE FROM organizations
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllOwnedOnlyBy removes the organizations userID is the only owner of, before the user is deleted
+func (m OrganizationModel) DeleteAllOwnedOnlyBy(userID int64) error {
+	query := `
+		DELETE FROM organizations
+		WHERE id IN (
+			SELECT organization_id
+			FROM organization_members
+			WHERE role = $1
+			GROUP BY organization_id
+			HAVING count(*) = 1 AND bool_and(user_id = $2)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, OrganizationRoleOwner, userID)
+
+	return err
+}
+
+// GetMembers returns the members of the organization with id, in the order they joined
+func (m OrganizationModel) GetMembers(id int64) ([]*OrganizationMember, error) {
+	query := `
+		SELECT users.id, users.name, users.email, organization_members.role, organization_members.created_at
+		FROM organization_members
+		INNER JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.organization_id = $1
+		ORDER BY organization_members.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*OrganizationMember{}
+
+	for rows.Next() {
+		var member OrganizationMember
+
+		err := rows.Scan(&member.UserID, &member.Name, &member.Email, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (m OrganizationModel) RemoveMember(id, userID int64) error {
+	query := `
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// NewInvitation invites email into the organization with id as role, inviting the address again
+// replaces the invitation it has
+func (m OrganizationModel) NewInvitation(id int64, email, role string, ttl time.Duration) (*OrganizationInvitation, error) {
+	token, err := GenerateToken(0, ttl, ScopeOrganizationInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO organization_invitations (hash, organization_id, email, role, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (organization_id, email) DO UPDATE
+		SET hash = EXCLUDED.hash, role = EXCLUDED.role, expiry = EXCLUDED.expiry`
+
+	args := []interface{}{token.Hash, id, email, role, token.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrganizationInvitation{OrganizationID: id, Email: email, Role: role, Token: token}, nil
+}
+
+// AcceptInvitation makes u a member of the organization of an unexpired invitation of the email address
+// of u, the invitation is used up with it. Members keep the role they have.
+func (m OrganizationModel) AcceptInvitation(tokenPlaintext string, u *User) (*Organization, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var role string
+
+	query := `
+		DELETE FROM organization_invitations
+		WHERE hash = $1 AND email = $2 AND expiry > $3
+		RETURNING organization_id, role`
+
+	err = tx.QueryRowContext(ctx, query, hash[:], u.Email, time.Now()).Scan(&id, &role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, id, u.ID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetForUser(id, u)
+}
+
+// DeleteExpiredInvitations removes the invitations which were not accepted in time and returns how many
+// there were
+func (m OrganizationModel) DeleteExpiredInvitations() (int64, error) {
+	query := `
+		DELETE FROM organization_invitations
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ScopeOwnershipTransfer tokens are kept in the file_ownership_transfers table, next to the file
+const ScopeOwnershipTransfer = "ownership-transfer"
+
+// OwnershipTransfer is a file offered to another user, who becomes its owner by confirming it
+type OwnershipTransfer struct {
+	FileID     int64
+	FromUserID int64
+	ToUserID   int64
+	Token      *Token
+}
+
+// OwnershipTransferModel keeps one pending transfer per file, offering the file again replaces it
+type OwnershipTransferModel struct {
+	DB *sql.DB
+}
+
+// New offers the file with fileID of the user with fromUserID to the user with toUserID, confirmed with
+// the plaintext of the returned token
+func (m OwnershipTransferModel) New(fileID, fromUserID, toUserID int64, ttl time.Duration) (*OwnershipTransfer, error) {
+	token, err := GenerateToken(toUserID, ttl, ScopeOwnershipTransfer)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO file_ownership_transfers (hash, file_id, from_user_id, to_user_id, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (file_id) DO UPDATE
+		SET hash = EXCLUDED.hash, from_user_id = EXCLUDED.from_user_id, to_user_id = EXCLUDED.to_user_id, expiry = EXCLUDED.expiry`
+
+	args := []interface{}{token.Hash, fileID, fromUserID, toUserID, token.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OwnershipTransfer{FileID: fileID, FromUserID: fromUserID, ToUserID: toUserID, Token: token}, nil
+}
+
+// Get returns the unexpired transfer to u of the token without using it up
+func (m OwnershipTransferModel) Get(tokenPlaintext string, u *User) (*OwnershipTransfer, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT file_id, from_user_id
+		FROM file_ownership_transfers
+		WHERE hash = $1 AND to_user_id = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	transfer := OwnershipTransfer{ToUserID: u.ID}
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], u.ID, time.Now()).Scan(&transfer.FileID, &transfer.FromUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &transfer, nil
+}
+
+// Confirm makes u the owner of the file of an unexpired transfer to u, as long as the file still belongs
+// to the user who offered it. The file leaves the organization and the batch it was uploaded into, which
+// belong to the previous owner, and takes the download notifications setting of u. The share of the file
+// with u, if any, is dropped as u owns it now. The transfer is used up with it.
+func (m OwnershipTransferModel) Confirm(tokenPlaintext string, u *User) (*OwnershipTransfer, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	transfer := OwnershipTransfer{ToUserID: u.ID}
+
+	query := `
+		DELETE FROM file_ownership_transfers
+		WHERE hash = $1 AND to_user_id = $2 AND expiry > $3
+		RETURNING file_id, from_user_id`
+
+	err = tx.QueryRowContext(ctx, query, hash[:], u.ID, time.Now()).Scan(&transfer.FileID, &transfer.FromUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		UPDATE files
+		SET user_id = $1, organization_id = NULL, transfer_id = NULL, notify_on_download = $2,
+			last_updated = NOW(), version = version + 1
+		WHERE id = $3 AND user_id = $4 AND expiry > $5 AND deleted_at IS NULL AND NOT pending`
+
+	args := []interface{}{u.ID, u.NotifyOnDownload, transfer.FileID, transfer.FromUserID, time.Now()}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query = `
+		DELETE FROM file_shares
+		WHERE file_id = $1 AND user_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, transfer.FileID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// DeleteExpired removes the transfers which were not confirmed in time and returns how many there were
+func (m OwnershipTransferModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM file_ownership_transfers
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
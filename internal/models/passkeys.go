@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+const (
+	ScopePasskeyRegistration = "passkey-registration"
+	ScopePasskeyLogin        = "passkey-login"
+)
+
+var ErrDuplicatePasskey = errors.New("duplicate passkey")
+
+// Passkey is a WebAuthn credential a user logs in with instead of their password
+type Passkey struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"-"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+func ValidatePasskey(v *validator.Validator, passkey *Passkey) {
+	v.Check(passkey.Name != "", "name", "must be provided")
+	v.Check(len(passkey.Name) <= 50, "name", "must not be more than 50 bytes long")
+}
+
+func (m PasskeyModel) Insert(passkey *Passkey) error {
+	query := `
+		INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, name)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []interface{}{passkey.UserID, passkey.CredentialID, passkey.PublicKey, passkey.SignCount, passkey.Name}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "passkeys_credential_id_key"):
+			return ErrDuplicatePasskey
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PasskeyModel) GetByCredentialID(credentialID []byte) (*Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		FROM passkeys
+		WHERE credential_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var passkey Passkey
+
+	err := m.DB.QueryRowContext(ctx, query, credentialID).Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.CredentialID,
+		&passkey.PublicKey,
+		&passkey.SignCount,
+		&passkey.Name,
+		&passkey.CreatedAt,
+		&passkey.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &passkey, nil
+}
+
+func (m PasskeyModel) GetAllForUser(u *User) ([]*Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+
+	for rows.Next() {
+		var passkey Passkey
+		err := rows.Scan(
+			&passkey.ID,
+			&passkey.UserID,
+			&passkey.CredentialID,
+			&passkey.PublicKey,
+			&passkey.SignCount,
+			&passkey.Name,
+			&passkey.CreatedAt,
+			&passkey.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, &passkey)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// UpdateSignCount stores the signature counter of a login with the passkey. The old counter is part
+// of the condition, so of two logins with the same counter only one gets through.
+func (m PasskeyModel) UpdateSignCount(passkey *Passkey, signCount uint32) error {
+	query := `
+		UPDATE passkeys
+		SET sign_count = $1, last_used_at = NOW()
+		WHERE id = $2 AND sign_count = $3
+		RETURNING last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, signCount, passkey.ID, passkey.SignCount).Scan(&passkey.LastUsedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	passkey.SignCount = signCount
+	return nil
+}
+
+func (m PasskeyModel) DeleteFromUser(id int64, u *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM passkeys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, u.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// NewChallenge returns a random challenge for a passkey operation of scope, userID is nil for logins
+// as the passkey tells the user. Only the hash of the challenge is stored.
+func (m PasskeyModel) NewChallenge(userID *int64, scope string, ttl time.Duration) ([]byte, error) {
+	challenge := make([]byte, 32)
+
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(challenge)
+
+	query := `
+		INSERT INTO passkey_challenges (hash, user_id, scope, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []interface{}{hash[:], userID, scope, time.Now().Add(ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// ConsumeChallenge deletes an unexpired challenge of scope and returns the user it was issued to, so
+// every challenge answers one response at most
+func (m PasskeyModel) ConsumeChallenge(challenge []byte, scope string) (*int64, error) {
+	hash := sha256.Sum256(challenge)
+
+	query := `
+		DELETE FROM passkey_challenges
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID *int64
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], scope).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return userID, nil
+}
+
+// DeleteExpiredChallenges removes the challenges which were never answered and returns how many there were
+func (m PasskeyModel) DeleteExpiredChallenges() (int64, error) {
+	query := `
+		DELETE FROM passkey_challenges
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+)
+
+// The permissions roles grant, the files ones are the scopes of API keys too
+const (
+	PermissionFilesRead  = "files:read"
+	PermissionFilesWrite = "files:write"
+	PermissionAdminRead  = "admin:read"
+	PermissionAdminWrite = "admin:write"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// PermissionModel reads the roles and the permissions they grant, both are created by the migrations
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// GetAllByRole returns the permissions of every role by its name, roles without any are included
+func (m PermissionModel) GetAllByRole() (map[string]Permissions, error) {
+	query := `
+		SELECT roles.name, roles_permissions.permission
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role = roles.name
+		ORDER BY roles.name, roles_permissions.permission`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string]Permissions{}
+
+	for rows.Next() {
+		var role string
+		var permission *string
+
+		err := rows.Scan(&role, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		if permission == nil {
+			roles[role] = Permissions{}
+			continue
+		}
+		roles[role] = append(roles[role], *permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
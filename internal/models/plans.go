@@ -0,0 +1,323 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+var (
+	ErrDuplicatePlanName  = errors.New("duplicate plan name")
+	ErrDuplicatePlanPrice = errors.New("duplicate plan price")
+)
+
+// Plan is what the uploads of the users assigned to it may take up. Nil limits are those of the server,
+// -file-max-size and -file-max-expiry, and no limit for the storage and the number of files. Users
+// without a plan get the limits of the server.
+type Plan struct {
+	ID   int64
+	Name string
+	// MaxFileSize is the largest file in bytes, it cannot raise -file-max-size
+	MaxFileSize *int64
+	// MaxStorage is the most bytes the unexpired files of a user may take up together
+	MaxStorage *int64
+	// MaxExpiry is the longest expiry of a file, it cannot raise -file-max-expiry
+	MaxExpiry *time.Duration
+	// MaxFiles is the most unexpired files a user may have
+	MaxFiles *int
+	// StripePriceID is the price users subscribe to the plan with, nil if it is not sold
+	StripePriceID *string
+	CreatedAt     time.Time
+}
+
+// MarshalJSON shows the maximum expiry as a duration such as 24h, like expires_in is given
+func (p *Plan) MarshalJSON() ([]byte, error) {
+	var expiry *string
+	if p.MaxExpiry != nil {
+		d := p.MaxExpiry.String()
+		expiry = &d
+	}
+
+	return json.Marshal(struct {
+		ID            int64     `json:"id"`
+		Name          string    `json:"name"`
+		MaxFileSize   *int64    `json:"max_file_size"`
+		MaxStorage    *int64    `json:"max_storage"`
+		MaxExpiry     *string   `json:"max_expiry"`
+		MaxFiles      *int      `json:"max_files"`
+		StripePriceID *string   `json:"stripe_price_id"`
+		CreatedAt     time.Time `json:"created_at"`
+	}{p.ID, p.Name, p.MaxFileSize, p.MaxStorage, expiry, p.MaxFiles, p.StripePriceID, p.CreatedAt})
+}
+
+func ValidatePlan(v *validator.Validator, plan *Plan) {
+	v.Check(plan.Name != "", "name", "must be provided")
+	v.Check(len(plan.Name) <= 50, "name", "must not be more than 50 bytes long")
+
+	if plan.MaxFileSize != nil {
+		v.Check(*plan.MaxFileSize > 0, "max_file_size", "must be greater than zero")
+	}
+	if plan.MaxStorage != nil {
+		v.Check(*plan.MaxStorage >= 0, "max_storage", "must not be negative")
+	}
+	if plan.MaxExpiry != nil {
+		v.Check(*plan.MaxExpiry >= time.Second, "max_expiry", "must be at least 1s")
+	}
+	if plan.MaxFiles != nil {
+		v.Check(*plan.MaxFiles >= 0, "max_files", "must not be negative")
+	}
+	if plan.StripePriceID != nil {
+		v.Check(*plan.StripePriceID != "", "stripe_price_id", "must not be empty")
+	}
+}
+
+type PlanModel struct {
+	DB *sql.DB
+}
+
+const planColumns = `plans.id, plans.name, plans.max_file_size, plans.max_storage, plans.max_expiry_seconds, plans.max_files, plans.stripe_price_id, plans.created_at`
+
+func scanPlan(row rowScanner) (*Plan, error) {
+	var plan Plan
+	var expirySeconds *int64
+
+	err := row.Scan(&plan.ID, &plan.Name, &plan.MaxFileSize, &plan.MaxStorage, &expirySeconds, &plan.MaxFiles, &plan.StripePriceID, &plan.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if expirySeconds != nil {
+		expiry := time.Duration(*expirySeconds) * time.Second
+		plan.MaxExpiry = &expiry
+	}
+
+	return &plan, nil
+}
+
+// expirySeconds is how the maximum expiry of plan is stored, in whole seconds
+func (plan *Plan) expirySeconds() *int64 {
+	if plan.MaxExpiry == nil {
+		return nil
+	}
+	seconds := int64(plan.MaxExpiry.Seconds())
+	return &seconds
+}
+
+func (m PlanModel) Insert(plan *Plan) error {
+	query := `
+		INSERT INTO plans (name, max_file_size, max_storage, max_expiry_seconds, max_files, stripe_price_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []interface{}{plan.Name, plan.MaxFileSize, plan.MaxStorage, plan.expirySeconds(), plan.MaxFiles, plan.StripePriceID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&plan.ID, &plan.CreatedAt)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "plans_name_key"):
+			return ErrDuplicatePlanName
+		case isUniqueViolation(err, "plans_stripe_price_id_key"):
+			return ErrDuplicatePlanPrice
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PlanModel) Get(id int64) (*Plan, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.getBy("id", id)
+}
+
+// GetByName returns the plan called name, such as the one of -default-plan
+func (m PlanModel) GetByName(name string) (*Plan, error) {
+	return m.getBy("name", name)
+}
+
+// GetByStripePrice returns the plan which is sold with the Stripe price priceID
+func (m PlanModel) GetByStripePrice(priceID string) (*Plan, error) {
+	return m.getBy("stripe_price_id", priceID)
+}
+
+// getBy returns the plan whose column is value, column must be a unique one
+func (m PlanModel) getBy(column string, value interface{}) (*Plan, error) {
+	query := `
+		SELECT ` + planColumns + `
+		FROM plans
+		WHERE ` + column + ` = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	plan, err := scanPlan(m.DB.QueryRowContext(ctx, query, value))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// GetForUser returns the plan of the user with userID, nil if the user has none
+func (m PlanModel) GetForUser(userID int64) (*Plan, error) {
+	query := `
+		SELECT ` + planColumns + `
+		FROM plans
+		INNER JOIN users ON users.plan_id = plans.id
+		WHERE users.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	plan, err := scanPlan(m.DB.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// GetAll returns every plan ordered by ID
+func (m PlanModel) GetAll() ([]*Plan, error) {
+	query := `
+		SELECT ` + planColumns + `
+		FROM plans
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []*Plan{}
+
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+func (m PlanModel) Update(plan *Plan) error {
+	query := `
+		UPDATE plans
+		SET name = $1, max_file_size = $2, max_storage = $3, max_expiry_seconds = $4, max_files = $5, stripe_price_id = $6
+		WHERE id = $7`
+
+	args := []interface{}{plan.Name, plan.MaxFileSize, plan.MaxStorage, plan.expirySeconds(), plan.MaxFiles, plan.StripePriceID, plan.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "plans_name_key"):
+			return ErrDuplicatePlanName
+		case isUniqueViolation(err, "plans_stripe_price_id_key"):
+			return ErrDuplicatePlanPrice
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete removes the plan with id, its users get the limits of the server
+func (m PlanModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM plans
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetForUser assigns the plan with planID to the user with userID, nil for the limits of the server
+func (m PlanModel) SetForUser(userID int64, planID *int64) error {
+	query := `
+		UPDATE users
+		SET plan_id = $1
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, planID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
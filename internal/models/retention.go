@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// RetentionPolicy limits how long the files of a user, or of the space of an organization, are kept,
+// whatever expiry they were uploaded with. Files of an organization only follow its policy, those of a
+// user outside of organizations the policy of the user. Files on legal hold are exempt.
+type RetentionPolicy struct {
+	// MaxLifetime is the longest a file is kept after it was uploaded, nil for no limit
+	MaxLifetime *time.Duration
+	// DeleteAfterDownload expires files once they were downloaded for the first time
+	DeleteAfterDownload bool
+	UpdatedAt           time.Time
+}
+
+// MarshalJSON shows the maximum lifetime as a duration such as 720h, like expires_in is given
+func (p *RetentionPolicy) MarshalJSON() ([]byte, error) {
+	var lifetime *string
+	if p.MaxLifetime != nil {
+		d := p.MaxLifetime.String()
+		lifetime = &d
+	}
+
+	return json.Marshal(struct {
+		MaxLifetime         *string   `json:"max_lifetime"`
+		DeleteAfterDownload bool      `json:"delete_after_download"`
+		UpdatedAt           time.Time `json:"updated_at"`
+	}{lifetime, p.DeleteAfterDownload, p.UpdatedAt})
+}
+
+func ValidateRetentionPolicy(v *validator.Validator, policy *RetentionPolicy) {
+	if policy.MaxLifetime != nil {
+		v.Check(*policy.MaxLifetime >= time.Second, "max_lifetime", "must be at least 1s")
+	}
+	v.Check(policy.MaxLifetime != nil || policy.DeleteAfterDownload, "max_lifetime", "must be provided unless delete_after_download is set")
+}
+
+// Owners of retention policies, the column of the policy and the table it references
+const (
+	retentionUser         = "user_id"
+	retentionOrganization = "organization_id"
+)
+
+var retentionOwners = map[string]string{
+	retentionUser:         "users",
+	retentionOrganization: "organizations",
+}
+
+type RetentionPolicyModel struct {
+	DB *sql.DB
+}
+
+func (m RetentionPolicyModel) GetForUser(userID int64) (*RetentionPolicy, error) {
+	return m.get(retentionUser, userID)
+}
+
+func (m RetentionPolicyModel) GetForOrganization(organizationID int64) (*RetentionPolicy, error) {
+	return m.get(retentionOrganization, organizationID)
+}
+
+// SetForUser replaces the policy of the user with userID, ErrRecordNotFound if there is no such user
+func (m RetentionPolicyModel) SetForUser(userID int64, policy *RetentionPolicy) error {
+	return m.set(retentionUser, userID, policy)
+}
+
+// SetForOrganization replaces the policy of an organization, ErrRecordNotFound if there is no such organization
+func (m RetentionPolicyModel) SetForOrganization(organizationID int64, policy *RetentionPolicy) error {
+	return m.set(retentionOrganization, organizationID, policy)
+}
+
+func (m RetentionPolicyModel) DeleteForUser(userID int64) error {
+	return m.delete(retentionUser, userID)
+}
+
+func (m RetentionPolicyModel) DeleteForOrganization(organizationID int64) error {
+	return m.delete(retentionOrganization, organizationID)
+}
+
+// Apply moves the expiry of the files which the policies want gone earlier forward, to the end of their
+// lifetime or to now once they were downloaded, so the janitor deletes them like any expired file. It
+// returns how many files it changed.
+func (m RetentionPolicyModel) Apply() (int64, error) {
+	query := `
+		UPDATE files
+		SET expiry = LEAST(
+				files.created_at + retention_policies.max_lifetime_seconds * interval '1 second',
+				CASE WHEN retention_policies.delete_after_download AND files.download_count > 0 THEN $1::timestamptz END
+			),
+			version = files.version + 1
+		FROM retention_policies
+		WHERE (retention_policies.organization_id = files.organization_id
+			OR (files.organization_id IS NULL AND retention_policies.user_id = files.user_id))
+		AND NOT files.legal_hold AND files.deleted_at IS NULL AND files.expiry > $1
+		AND LEAST(
+			files.created_at + retention_policies.max_lifetime_seconds * interval '1 second',
+			CASE WHEN retention_policies.delete_after_download AND files.download_count > 0 THEN $1::timestamptz END
+		) < files.expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m RetentionPolicyModel) get(owner string, id int64) (*RetentionPolicy, error) {
+	query := fmt.Sprintf(`
+		SELECT max_lifetime_seconds, delete_after_download, updated_at
+		FROM retention_policies
+		WHERE %s = $1`, owner)
+
+	var policy RetentionPolicy
+	var seconds *int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&seconds, &policy.DeleteAfterDownload, &policy.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if seconds != nil {
+		d := time.Duration(*seconds) * time.Second
+		policy.MaxLifetime = &d
+	}
+
+	return &policy, nil
+}
+
+func (m RetentionPolicyModel) set(owner string, id int64, policy *RetentionPolicy) error {
+	query := fmt.Sprintf(`
+		INSERT INTO retention_policies (%[1]s, max_lifetime_seconds, delete_after_download)
+		SELECT id, $2, $3 FROM %[2]s WHERE id = $1
+		ON CONFLICT (%[1]s) DO UPDATE
+		SET max_lifetime_seconds = EXCLUDED.max_lifetime_seconds, delete_after_download = EXCLUDED.delete_after_download, updated_at = NOW()
+		RETURNING updated_at`, owner, retentionOwners[owner])
+
+	var seconds *int64
+	if policy.MaxLifetime != nil {
+		s := int64(*policy.MaxLifetime / time.Second)
+		seconds = &s
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, seconds, policy.DeleteAfterDownload).Scan(&policy.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m RetentionPolicyModel) delete(owner string, id int64) error {
+	query := fmt.Sprintf(`
+		DELETE FROM retention_policies
+		WHERE %s = $1`, owner)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevocationModel keeps the sessions whose JWTs were revoked before they expire. A revocation is
+// only needed until Expiry, when the last JWT issued for the session has expired anyway.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// Insert revokes the JWTs of the sessions with sessionIDs until expiry
+func (m RevocationModel) Insert(sessionIDs []int64, expiry time.Time) error {
+	query := `
+		INSERT INTO token_revocations (session_id, expiry)
+		VALUES ($1, $2)
+		ON CONFLICT (session_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, id := range sessionIDs {
+		_, err := m.DB.ExecContext(ctx, query, id, expiry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllUnexpired returns the expiry of the revocations which still apply, by session ID
+func (m RevocationModel) GetAllUnexpired() (map[int64]time.Time, error) {
+	query := `
+		SELECT session_id, expiry
+		FROM token_revocations
+		WHERE expiry > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := map[int64]time.Time{}
+
+	for rows.Next() {
+		var id int64
+		var expiry time.Time
+
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		revocations[id] = expiry
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpired removes the revocations which no longer apply and returns how many there were
+func (m RevocationModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM token_revocations
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned for a refresh token which was already exchanged for a new one,
+// the session has been revoked as either copy may be in the wrong hands
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// Session is one login, its refresh token is replaced with a new one on every refresh. IP and UserAgent
+// are those of the last login or refresh.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Expiry     time.Time `json:"expiry"`
+	// Current marks the session of the token the sessions were listed with
+	Current bool `json:"current"`
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// New starts a session and returns its refresh token, which is valid for ttl
+func (m SessionModel) New(session *Session, ttl time.Duration) (*Token, error) {
+	refresh, err := GenerateToken(session.UserID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO sessions (user_id, refresh_hash, ip, user_agent, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, last_used_at, expiry`
+
+	args := []interface{}{session.UserID, refresh.Hash, session.IP, session.UserAgent, refresh.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt, &session.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh, nil
+}
+
+// Refresh replaces the refresh token of the session of tokenPlaintext with a new one valid for ttl. The old
+// token is remembered, using it again revokes the session and returns it with ErrRefreshTokenReused.
+func (m SessionModel) Refresh(tokenPlaintext, ip, userAgent string, ttl time.Duration) (*Session, *Token, error) {
+	old := sha256.Sum256([]byte(tokenPlaintext))
+
+	refresh, err := GenerateToken(0, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+		UPDATE sessions
+		SET previous_refresh_hash = refresh_hash, refresh_hash = $1, ip = $2, user_agent = $3, last_used_at = NOW(), expiry = $4
+		WHERE refresh_hash = $5 AND expiry > NOW()
+		RETURNING id, user_id, ip, user_agent, created_at, last_used_at, expiry`
+
+	args := []interface{}{refresh.Hash, ip, userAgent, refresh.Expiry, old[:]}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var session Session
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.Expiry,
+	)
+	if err == nil {
+		refresh.UserID = session.UserID
+		return &session, refresh, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	query = `
+		DELETE FROM sessions
+		WHERE previous_refresh_hash = $1
+		RETURNING id, user_id`
+
+	err = m.DB.QueryRowContext(ctx, query, old[:]).Scan(&session.ID, &session.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &session, nil, ErrRefreshTokenReused
+}
+
+// GetAllForUser returns the unexpired sessions of a user, the most recently used first
+func (m SessionModel) GetAllForUser(u *User) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, ip, user_agent, created_at, last_used_at, expiry
+		FROM sessions
+		WHERE user_id = $1 AND expiry > NOW()
+		ORDER BY last_used_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetIDForToken returns the session of an authentication token, nil if it has none
+func (m SessionModel) GetIDForToken(tokenPlaintext string) (*int64, error) {
+	query := `
+		SELECT session_id
+		FROM tokens
+		WHERE hash = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var id *int64
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], ScopeAuthentication).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+// DeleteFromUser revokes a session with its authentication tokens
+func (m SessionModel) DeleteFromUser(id int64, u *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, u.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllForUser revokes all sessions of a user with their authentication tokens and returns their IDs
+func (m SessionModel) DeleteAllForUser(userID int64) ([]int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE user_id = $1
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// DeleteExpired removes the sessions whose refresh token expired and returns how many there were
+func (m SessionModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/codes"
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// UserSettings are the defaults of the uploads of a user, nil fields fall back to the configured defaults
+type UserSettings struct {
+	UserID              int64
+	DefaultExpiry       *time.Duration
+	DefaultMaxDownloads *int
+	// CodeStyle is the style of the codes of new files and transfers, codes.StyleWords or codes.StyleAlphanumeric
+	CodeStyle *string
+}
+
+// MarshalJSON shows the default expiry as a duration such as 24h, like expires_in is given
+func (s *UserSettings) MarshalJSON() ([]byte, error) {
+	var expiry *string
+	if s.DefaultExpiry != nil {
+		d := s.DefaultExpiry.String()
+		expiry = &d
+	}
+
+	return json.Marshal(struct {
+		DefaultExpiry       *string `json:"default_expiry"`
+		DefaultMaxDownloads *int    `json:"default_max_downloads"`
+		CodeStyle           *string `json:"code_style"`
+	}{expiry, s.DefaultMaxDownloads, s.CodeStyle})
+}
+
+func ValidateUserSettings(v *validator.Validator, settings *UserSettings, maxExpiry time.Duration) {
+	if settings.DefaultExpiry != nil {
+		v.Check(*settings.DefaultExpiry >= time.Second, "default_expiry", "must be at least 1s")
+		v.Check(*settings.DefaultExpiry <= maxExpiry, "default_expiry", fmt.Sprintf("must not be more than %s", maxExpiry))
+	}
+
+	if settings.DefaultMaxDownloads != nil {
+		v.Check(*settings.DefaultMaxDownloads > 0, "default_max_downloads", "must be greater than zero")
+	}
+
+	if settings.CodeStyle != nil {
+		v.Check(validator.PermittedValue(*settings.CodeStyle, codes.StyleAlphanumeric, codes.StyleWords), "code_style", "must be alphanumeric or words")
+	}
+}
+
+type UserSettingsModel struct {
+	DB *sql.DB
+}
+
+// Get returns the settings of a user, users who never changed them get the empty settings
+func (m UserSettingsModel) Get(userID int64) (*UserSettings, error) {
+	query := `
+		SELECT default_expiry_seconds, default_max_downloads, code_style
+		FROM user_settings
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	settings := UserSettings{UserID: userID}
+	var expirySeconds *int64
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&expirySeconds, &settings.DefaultMaxDownloads, &settings.CodeStyle)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &settings, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if expirySeconds != nil {
+		expiry := time.Duration(*expirySeconds) * time.Second
+		settings.DefaultExpiry = &expiry
+	}
+
+	return &settings, nil
+}
+
+func (m UserSettingsModel) Upsert(settings *UserSettings) error {
+	query := `
+		INSERT INTO user_settings (user_id, default_expiry_seconds, default_max_downloads, code_style)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET default_expiry_seconds = EXCLUDED.default_expiry_seconds, default_max_downloads = EXCLUDED.default_max_downloads,
+			code_style = EXCLUDED.code_style`
+
+	var expirySeconds *int64
+	if settings.DefaultExpiry != nil {
+		seconds := int64(settings.DefaultExpiry.Seconds())
+		expirySeconds = &seconds
+	}
+
+	args := []interface{}{settings.UserID, expirySeconds, settings.DefaultMaxDownloads, settings.CodeStyle}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SharedFile is a file another user shared with the user it was looked up for
+type SharedFile struct {
+	*File
+	// SharedBy is the name of the owner of the file
+	SharedBy string    `json:"shared_by"`
+	SharedAt time.Time `json:"shared_at"`
+}
+
+// FileShareModel keeps which files were shared with which users, a share goes with the file or the user
+type FileShareModel struct {
+	DB *sql.DB
+}
+
+// Insert shares the file with fileID with the user with userID, sharing it again changes nothing
+func (m FileShareModel) Insert(fileID, userID int64) error {
+	query := `
+		INSERT INTO file_shares (file_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (file_id, user_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, fileID, userID)
+
+	return err
+}
+
+// sharedScanner scans the columns of a SharedFile after those of the file
+type sharedScanner struct {
+	row    rowScanner
+	shared *SharedFile
+}
+
+func (s sharedScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, &s.shared.SharedBy, &s.shared.SharedAt)...)
+}
+
+// GetAllForUser returns one page of the files shared with u which can still be downloaded, the last
+// shared first
+func (m FileShareModel) GetAllForUser(u *User, filters Filters) ([]*SharedFile, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), ` + fileColumns + `, shared_by, shared_at
+		FROM (
+			SELECT files.*, users.name AS shared_by, file_shares.created_at AS shared_at
+			FROM file_shares
+			INNER JOIN files ON files.id = file_shares.file_id
+			INNER JOIN users ON users.id = files.user_id
+			WHERE file_shares.user_id = $1
+		) AS shared
+		WHERE expiry > $2 AND deleted_at IS NULL AND NOT pending
+		ORDER BY shared_at DESC, id DESC
+		LIMIT $3 OFFSET $4`
+
+	args := []interface{}{u.ID, time.Now(), filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	files := []*SharedFile{}
+
+	for rows.Next() {
+		var shared SharedFile
+
+		shared.File, err = scanFile(sharedScanner{row: totalScanner{row: rows, total: &totalRecords}, shared: &shared})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		files = append(files, &shared)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return files, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
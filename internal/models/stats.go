@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Stats are aggregate numbers about users and stored content, expired files which the janitor
+// has not removed yet are left out.
+type Stats struct {
+	Users          int64 `json:"users"`
+	ActivatedUsers int64 `json:"activated_users"`
+	SuspendedUsers int64 `json:"suspended_users"`
+	Files          int64 `json:"files"`
+	StoredBytes    int64 `json:"stored_bytes"`
+	Downloads      int64 `json:"downloads"`
+	Transfers      int64 `json:"transfers"`
+	Uploads        int64 `json:"uploads"`
+}
+
+type StatsModel struct {
+	DB *sql.DB
+}
+
+func (m StatsModel) Get() (*Stats, error) {
+	query := `
+		SELECT
+			(SELECT count(*) FROM users),
+			(SELECT count(*) FROM users WHERE activated),
+			(SELECT count(*) FROM users WHERE suspended),
+			(SELECT count(*) FROM files WHERE expiry > $1 AND deleted_at IS NULL),
+			(SELECT coalesce(sum(size), 0) FROM files WHERE expiry > $1),
+			(SELECT coalesce(sum(download_count), 0) FROM files WHERE expiry > $1),
+			(SELECT count(*) FROM transfers WHERE expiry > $1),
+			(SELECT count(*) FROM uploads WHERE expiry > $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stats Stats
+
+	err := m.DB.QueryRowContext(ctx, query, time.Now()).Scan(
+		&stats.Users,
+		&stats.ActivatedUsers,
+		&stats.SuspendedUsers,
+		&stats.Files,
+		&stats.StoredBytes,
+		&stats.Downloads,
+		&stats.Transfers,
+		&stats.Uploads,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
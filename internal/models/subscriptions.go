@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Subscription is the Stripe subscription a user pays for their plan with, UpdatedAt is when the last
+// event applied to it happened
+type Subscription struct {
+	UserID         int64
+	CustomerID     string
+	SubscriptionID string
+	Status         string
+	UpdatedAt      time.Time
+}
+
+type SubscriptionModel struct {
+	DB *sql.DB
+}
+
+// Start records the subscription of a completed checkout and puts its user on the plan with planID. It
+// replaces an earlier subscription of the user, unless that was updated by an event which happened later.
+func (m SubscriptionModel) Start(sub *Subscription, planID *int64) error {
+	query := `
+		INSERT INTO subscriptions (user_id, customer_id, subscription_id, status, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET customer_id = EXCLUDED.customer_id, subscription_id = EXCLUDED.subscription_id,
+			status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+		WHERE subscriptions.updated_at <= EXCLUDED.updated_at`
+
+	args := []interface{}{sub.UserID, sub.CustomerID, sub.SubscriptionID, sub.Status, sub.UpdatedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET plan_id = $1 WHERE id = $2`, planID, sub.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update records the status of the subscription with subscriptionID and puts its user on the plan with
+// planID, nil for none. It returns ErrRecordNotFound if the subscription is not the current one of any
+// user or the event happened before the last one applied to it.
+func (m SubscriptionModel) Update(subscriptionID, status string, planID *int64, at time.Time) error {
+	query := `
+		UPDATE subscriptions
+		SET status = $1, updated_at = $2
+		WHERE subscription_id = $3 AND updated_at <= $2
+		RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+
+	err = tx.QueryRowContext(ctx, query, status, at, subscriptionID).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET plan_id = $1 WHERE id = $2`, planID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetForUser returns the last subscription of the user with userID, its customer is reused by new checkouts
+func (m SubscriptionModel) GetForUser(userID int64) (*Subscription, error) {
+	query := `
+		SELECT user_id, customer_id, subscription_id, status, updated_at
+		FROM subscriptions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sub Subscription
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&sub.UserID, &sub.CustomerID, &sub.SubscriptionID, &sub.Status, &sub.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &sub, nil
+}
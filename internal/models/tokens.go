@@ -15,6 +15,11 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopeMagicLink      = "magic-link"
+	// ScopeEmailChange tokens are kept in the email_changes table, next to the new address
+	ScopeEmailChange = "email-change"
+	// ScopeRefresh tokens are kept in the sessions table, one per session
+	ScopeRefresh = "refresh"
 )
 
 type Token struct {
@@ -23,13 +28,16 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// SessionID is the session of an authentication token, nil for those issued before sessions existed
+	SessionID *int64 `json:"-"`
 }
 
 type TokenModel struct {
 	DB *sql.DB
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// GenerateToken returns a new random token without storing it
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
@@ -57,7 +65,7 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	token, err := GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
@@ -69,10 +77,10 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, session_id)
+		VALUES ($1, $2, $3, $4, $5)`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.SessionID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/codes"
+)
+
+// Transfer groups several files under a single code
+type Transfer struct {
+	ID        int64     `json:"id"`
+	Code      string    `json:"code"`
+	Expiry    time.Time `json:"expiry"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []*File   `json:"files"`
+	UserID    int64     `json:"-"`
+}
+
+type TransferModel struct {
+	DB *sql.DB
+	// CaseInsensitiveCodes finds transfers by their code regardless of case, see codeMatch
+	CaseInsensitiveCodes bool
+}
+
+func (m TransferModel) Insert(transfer *Transfer) error {
+	query := `
+		INSERT INTO transfers (code, expiry, user_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	args := []interface{}{transfer.Code, transfer.Expiry, transfer.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&transfer.ID, &transfer.CreatedAt)
+}
+
+// GetFromCode returns the transfer with code, word codes match with any case and separators
+func (m TransferModel) GetFromCode(code string) (*Transfer, error) {
+	query := `
+		SELECT id, code, expiry, created_at, user_id
+		FROM transfers
+		WHERE ` + codeMatch(m.CaseInsensitiveCodes) + ` AND expiry > $2
+		ORDER BY code = $1 DESC
+		LIMIT 1`
+
+	var transfer Transfer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, codes.Normalize(code), time.Now()).Scan(
+		&transfer.ID,
+		&transfer.Code,
+		&transfer.Expiry,
+		&transfer.CreatedAt,
+		&transfer.UserID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &transfer, nil
+}
+
+func (m TransferModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM transfers
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteExpired removes expired transfers that no longer hold any files and returns how many were deleted
+func (m TransferModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM transfers
+		WHERE expiry <= $1
+		AND NOT EXISTS (SELECT 1 FROM files WHERE files.transfer_id = transfers.id)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+)
+
+// Upload is an unfinished resumable upload, its chunks are assembled into a File once Offset reaches Length.
+type Upload struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Length    int64         `json:"length"`
+	Offset    int64         `json:"offset"`
+	Chunks    int           `json:"chunks"`
+	FileTTL   time.Duration `json:"-"`
+	Expiry    time.Time     `json:"expiry"`
+	CreatedAt time.Time     `json:"created_at"`
+	UserID    int64         `json:"-"`
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+func ValidateUpload(v *validator.Validator, upload *Upload, maxSize int64) {
+	v.Check(upload.Name != "", "file_name", "must be provided")
+	v.Check(len(upload.Name) <= 50, "file_name", "must not be more than 50 bytes long")
+	v.Check(upload.Length >= 0, "file_size", "must not be negative")
+	v.Check(upload.Length <= maxSize, "file_size", fmt.Sprintf("must not be more than %d bytes big", maxSize))
+}
+
+func (upload *Upload) IsComplete() bool {
+	return upload.Offset == upload.Length
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	upload.ID = hex.EncodeToString(randomBytes)
+
+	query := `
+		INSERT INTO uploads (id, name, length, file_ttl_seconds, expiry, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING upload_offset, chunks, created_at`
+
+	args := []interface{}{upload.ID, upload.Name, upload.Length, int64(upload.FileTTL.Seconds()), upload.Expiry, upload.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&upload.Offset, &upload.Chunks, &upload.CreatedAt)
+}
+
+func (m UploadModel) GetFromUser(id string, u *User) (*Upload, error) {
+	query := `
+		SELECT id, name, length, upload_offset, chunks, file_ttl_seconds, expiry, created_at, user_id
+		FROM uploads
+		WHERE id = $1 AND user_id = $2 AND expiry > $3`
+
+	args := []interface{}{id, u.ID, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+	var fileTTLSeconds int64
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&upload.ID,
+		&upload.Name,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Chunks,
+		&fileTTLSeconds,
+		&upload.Expiry,
+		&upload.CreatedAt,
+		&upload.UserID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	upload.FileTTL = time.Duration(fileTTLSeconds) * time.Second
+
+	return &upload, nil
+}
+
+// includes expired uploads
+func (m UploadModel) GetAll() ([]*Upload, error) {
+	return m.getAll(`
+		SELECT id, name, length, upload_offset, chunks, file_ttl_seconds, expiry, created_at, user_id
+		FROM uploads`)
+}
+
+func (m UploadModel) GetAllExpired() ([]*Upload, error) {
+	return m.getAll(`
+		SELECT id, name, length, upload_offset, chunks, file_ttl_seconds, expiry, created_at, user_id
+		FROM uploads
+		WHERE expiry <= $1`, time.Now())
+}
+
+func (m UploadModel) GetAllForUser(u *User) ([]*Upload, error) {
+	return m.getAll(`
+		SELECT id, name, length, upload_offset, chunks, file_ttl_seconds, expiry, created_at, user_id
+		FROM uploads
+		WHERE user_id = $1`, u.ID)
+}
+
+func (m UploadModel) getAll(query string, args ...interface{}) ([]*Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*Upload{}
+
+	for rows.Next() {
+		var upload Upload
+		var fileTTLSeconds int64
+		err := rows.Scan(
+			&upload.ID,
+			&upload.Name,
+			&upload.Length,
+			&upload.Offset,
+			&upload.Chunks,
+			&fileTTLSeconds,
+			&upload.Expiry,
+			&upload.CreatedAt,
+			&upload.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		upload.FileTTL = time.Duration(fileTTLSeconds) * time.Second
+		uploads = append(uploads, &upload)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
+
+// Advance records a chunk of n bytes, it fails with ErrEditConflict if another
+// request has written a chunk since upload was read.
+func (m UploadModel) Advance(upload *Upload, n int64) error {
+	query := `
+		UPDATE uploads
+		SET upload_offset = upload_offset + $1, chunks = chunks + 1
+		WHERE id = $2 AND upload_offset = $3 AND chunks = $4
+		RETURNING upload_offset, chunks`
+
+	args := []interface{}{n, upload.ID, upload.Offset, upload.Chunks}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&upload.Offset, &upload.Chunks)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UploadModel) Delete(id string) error {
+	query := `
+		DELETE FROM uploads
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
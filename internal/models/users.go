@@ -16,6 +16,13 @@ var (
 	AnonymousUser     = &User{}
 )
 
+// The roles created by the migrations, the permissions they grant are kept in the database
+const (
+	RoleAdmin    = "admin"
+	RoleMember   = "member"
+	RoleReadonly = "readonly"
+)
+
 type User struct {
 	ID          int64     `json:"id"`
 	Name        string    `json:"name"`
@@ -24,6 +31,10 @@ type User struct {
 	CreatedAt   time.Time `json:"created_at"`
 	LastUpdated time.Time `json:"last_updated"`
 	Activated   bool      `json:"activated"`
+	Role        string    `json:"role"`
+	Suspended   bool      `json:"suspended"`
+	// NotifyOnDownload sends an email the first time a code of any file of the user is downloaded
+	NotifyOnDownload bool `json:"notify_on_download"`
 }
 
 type password struct {
@@ -35,6 +46,49 @@ type UserModel struct {
 	DB *sql.DB
 }
 
+// userColumns are the columns read by scanUser, in order
+const userColumns = `users.id, users.name, users.email, users.password_hash, users.created_at, users.last_updated, users.activated, users.role, users.suspended, users.notify_on_download`
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+
+	err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.CreatedAt,
+		&user.LastUpdated,
+		&user.Activated,
+		&user.Role,
+		&user.Suspended,
+		&user.NotifyOnDownload,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// getUser runs a query returning a single row of userColumns
+func (m UserModel) getUser(query string, args ...interface{}) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := scanUser(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
 func (p *password) Set(plaintextPassword string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
 	if err != nil {
@@ -58,6 +112,10 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+func (p *password) IsSet() bool {
+	return p.hash != nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -91,17 +149,17 @@ func (m UserModel) Insert(user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, last_updated`
+		RETURNING id, created_at, last_updated, role, suspended`
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.LastUpdated)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.LastUpdated, &user.Role, &user.Suspended)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -110,43 +168,64 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1`
+
+	return m.getUser(query, id)
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, last_updated
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = $1`
 
-	var user User
+	return m.getUser(query, email)
+}
+
+func (m UserModel) GetAll() ([]*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		ORDER BY id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.LastUpdated,
-	)
-
+	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
+		users = append(users, user)
 	}
-	return &user, nil
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
 }
 
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, last_updated = $5
-		WHERE id = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, role = $5, suspended = $6, notify_on_download = $7, last_updated = $8
+		WHERE id = $9
 		RETURNING last_updated`
 
 	args := []interface{}{
@@ -154,6 +233,9 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Role,
+		user.Suspended,
+		user.NotifyOnDownload,
 		time.Now(),
 		user.ID,
 	}
@@ -164,7 +246,7 @@ func (m UserModel) Update(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.LastUpdated)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -175,11 +257,41 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// Delete removes a user, everything of it down to the rows of its files goes with it
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (m UserModel) GetByToken(tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT users.id, users.name, users.email, users.password_hash, users.created_at, users.last_updated, users.activated
+		SELECT ` + userColumns + `
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -189,28 +301,5 @@ func (m UserModel) GetByToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
-	var user User
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.CreatedAt,
-		&user.LastUpdated,
-		&user.Activated,
-	)
-	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
-		}
-	}
-
-	return &user, nil
+	return m.getUser(query, args...)
 }
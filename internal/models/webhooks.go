@@ -0,0 +1,274 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Li-Elias/File-Transfer/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileDownloaded = "file.downloaded"
+	EventFileExpired    = "file.expired"
+	// EventFileDeleted is sent once a file is gone for good, files in the trash can still be restored
+	EventFileDeleted = "file.deleted"
+	// EventFileExpiring is sent -expiry-notice before a file expires, once for every expiry
+	EventFileExpiring = "file.expiring"
+)
+
+// Webhook receives events about the files of its user, deliveries are signed with Secret
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+}
+
+// WebhookDelivery is a queued event for a webhook, failed deliveries are retried at NextAttempt
+type WebhookDelivery struct {
+	ID          int64
+	WebhookID   int64
+	URL         string
+	Secret      string
+	Event       string
+	Payload     []byte
+	Attempts    int
+	NextAttempt time.Time
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(err != nil || !localHost(u.Hostname()), "url", "must not point to a private or loopback address")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, EventFileUploaded, EventFileDownloaded, EventFileExpired, EventFileDeleted, EventFileExpiring), "events", "must only contain file.uploaded, file.downloaded, file.expired, file.deleted or file.expiring")
+	}
+}
+
+// localHost reports whether host names this machine or a private network, as an address or as localhost. Names
+// which only resolve to such addresses are turned away when the webhook is dialed.
+func localHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast())
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	webhook.Secret = hex.EncodeToString(randomBytes)
+
+	query := `
+		INSERT INTO webhooks (url, secret, events, user_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+// GetAllForUser leaves out the secrets, they are only shown when a webhook is created
+func (m WebhookModel) GetAllForUser(u *User) ([]*Webhook, error) {
+	query := `
+		SELECT id, url, events, created_at, user_id
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.CreatedAt,
+			&webhook.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) DeleteFromUser(id int64, u *User) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, u.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Enqueue queues a delivery of payload for every webhook of the user subscribed to event
+func (m WebhookModel) Enqueue(userID int64, event string, payload []byte) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3
+		FROM webhooks
+		WHERE user_id = $1 AND $2 = ANY(events)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, event, payload)
+
+	return err
+}
+
+func (m WebhookModel) GetDueDeliveries(limit int) ([]*WebhookDelivery, error) {
+	query := `
+		SELECT webhook_deliveries.id, webhooks.id, webhooks.url, webhooks.secret, webhook_deliveries.event,
+			webhook_deliveries.payload, webhook_deliveries.attempts, webhook_deliveries.next_attempt
+		FROM webhook_deliveries
+		INNER JOIN webhooks
+		ON webhooks.id = webhook_deliveries.webhook_id
+		WHERE webhook_deliveries.next_attempt <= $1
+		ORDER BY webhook_deliveries.next_attempt, webhook_deliveries.id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.URL,
+			&delivery.Secret,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.NextAttempt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RetryDelivery records a failed attempt and schedules the next one
+func (m WebhookModel) RetryDelivery(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = $1, next_attempt = $2
+		WHERE id = $3`
+
+	args := []interface{}{delivery.Attempts, delivery.NextAttempt, delivery.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+func (m WebhookModel) DeleteDelivery(id int64) error {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+// Package oauth logs users in with the accounts they have at Google or GitHub, using the OAuth 2.0
+// authorization code flow with PKCE.
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderGoogle = "google"
+	ProviderGitHub = "github"
+)
+
+// ErrDenied is returned when the user did not allow the login or the code was not accepted
+var ErrDenied = errors.New("oauth: login was denied")
+
+// Identity is the account of a user at a provider, Subject is its ID there and never changes
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OAuth 2.0 provider
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+	Client       *http.Client
+
+	// identity fetches the identity with the access token of a login
+	identity func(ctx context.Context, p *Provider, accessToken string) (*Identity, error)
+}
+
+func NewGoogle(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         ProviderGoogle,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		Scopes:       []string{"openid", "email", "profile"},
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		identity:     googleIdentity,
+	}
+}
+
+func NewGitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         ProviderGitHub,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		Scopes:       []string{"read:user", "user:email"},
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		identity:     githubIdentity,
+	}
+}
+
+// AuthCodeURL returns the URL to send the user to for logging in. The provider sends them back to
+// redirectURI with state and a code, verifier is the PKCE code verifier the code is exchanged with.
+func (p *Provider) AuthCodeURL(state, verifier, redirectURI string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	return p.AuthURL + "?" + query.Encode()
+}
+
+// Exchange trades the code of a login for the identity of the user
+func (p *Provider) Exchange(ctx context.Context, code, verifier, redirectURI string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub answers with a form unless asked for JSON
+	req.Header.Set("Accept", "application/json")
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+
+	err = p.do(req, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	// GitHub reports a bad code with 200 OK and an error
+	if result.Error != "" || result.AccessToken == "" {
+		return nil, fmt.Errorf("%w: %s", ErrDenied, result.Error)
+	}
+
+	return p.identity(ctx, p, result.AccessToken)
+}
+
+// get fetches endpoint with the access token into dst
+func (p *Provider) get(ctx context.Context, endpoint, accessToken string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return p.do(req, dst)
+}
+
+func (p *Provider) do(req *http.Request, dst interface{}) error {
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s returned %s", ErrDenied, p.Name, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("oauth: %s returned %s", p.Name, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
+
+func googleIdentity(ctx context.Context, p *Provider, accessToken string) (*Identity, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	err := p.get(ctx, "https://openidconnect.googleapis.com/v1/userinfo", accessToken, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Sub == "" {
+		return nil, errors.New("oauth: google returned no subject")
+	}
+
+	identity := &Identity{
+		Provider:      p.Name,
+		Subject:       info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.Name,
+	}
+
+	return identity, nil
+}
+
+func githubIdentity(ctx context.Context, p *Provider, accessToken string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+
+	err := p.get(ctx, "https://api.github.com/user", accessToken, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID == 0 {
+		return nil, errors.New("oauth: github returned no user ID")
+	}
+
+	// the profile only has the email the user made public, if any, so the primary one is used
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	err = p.get(ctx, "https://api.github.com/user/emails", accessToken, &emails)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Provider: p.Name,
+		Subject:  fmt.Sprint(user.ID),
+		Name:     user.Name,
+	}
+
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+
+	return identity, nil
+}
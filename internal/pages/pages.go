@@ -0,0 +1,51 @@
+// Package pages renders the HTML pages served to browsers next to the JSON API
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+var functions = template.FuncMap{
+	"size": FormatSize,
+}
+
+// Render executes the page templateFile within base.html. The whole page is returned, so a
+// failing template never leaves half a page written.
+func Render(templateFile string, data interface{}) ([]byte, error) {
+	tmpl, err := template.New("page").Funcs(functions).ParseFS(templateFS, "templates/base.html", "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = tmpl.ExecuteTemplate(&buf, "base", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// FormatSize formats a number of bytes with a binary unit, such as 1.5 MiB
+func FormatSize(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
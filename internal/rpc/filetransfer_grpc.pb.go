@@ -0,0 +1,290 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: filetransfer.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FileTransfer_Upload_FullMethodName     = "/filetransfer.v1.FileTransfer/Upload"
+	FileTransfer_Download_FullMethodName   = "/filetransfer.v1.FileTransfer/Download"
+	FileTransfer_ListFiles_FullMethodName  = "/filetransfer.v1.FileTransfer/ListFiles"
+	FileTransfer_DeleteFile_FullMethodName = "/filetransfer.v1.FileTransfer/DeleteFile"
+)
+
+// FileTransferClient is the client API for FileTransfer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileTransferClient interface {
+	// Upload stores a file. The first message carries the metadata, the following ones the content.
+	Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransfer_UploadClient, error)
+	// Download streams the file with a code. The first message carries the file, the following ones the content.
+	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileTransfer_DownloadClient, error)
+	// ListFiles lists the files of the authenticated user.
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	// DeleteFile deletes a file of the authenticated user, or moves it into the trash.
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
+}
+
+type fileTransferClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileTransferClient(cc grpc.ClientConnInterface) FileTransferClient {
+	return &fileTransferClient{cc}
+}
+
+func (c *fileTransferClient) Upload(ctx context.Context, opts ...grpc.CallOption) (FileTransfer_UploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransfer_ServiceDesc.Streams[0], FileTransfer_Upload_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferUploadClient{stream}
+	return x, nil
+}
+
+type FileTransfer_UploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*File, error)
+	grpc.ClientStream
+}
+
+type fileTransferUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileTransferUploadClient) CloseAndRecv() (*File, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(File)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileTransfer_DownloadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileTransfer_ServiceDesc.Streams[1], FileTransfer_Download_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileTransferDownloadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileTransfer_DownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileTransferDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileTransferDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileTransferClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
+	out := new(ListFilesResponse)
+	err := c.cc.Invoke(ctx, FileTransfer_ListFiles_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileTransferClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
+	out := new(DeleteFileResponse)
+	err := c.cc.Invoke(ctx, FileTransfer_DeleteFile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileTransferServer is the server API for FileTransfer service.
+// All implementations must embed UnimplementedFileTransferServer
+// for forward compatibility
+type FileTransferServer interface {
+	// Upload stores a file. The first message carries the metadata, the following ones the content.
+	Upload(FileTransfer_UploadServer) error
+	// Download streams the file with a code. The first message carries the file, the following ones the content.
+	Download(*DownloadRequest, FileTransfer_DownloadServer) error
+	// ListFiles lists the files of the authenticated user.
+	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	// DeleteFile deletes a file of the authenticated user, or moves it into the trash.
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
+	mustEmbedUnimplementedFileTransferServer()
+}
+
+// UnimplementedFileTransferServer must be embedded to have forward compatible implementations.
+type UnimplementedFileTransferServer struct {
+}
+
+func (UnimplementedFileTransferServer) Upload(FileTransfer_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedFileTransferServer) Download(*DownloadRequest, FileTransfer_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedFileTransferServer) ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
+func (UnimplementedFileTransferServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedFileTransferServer) mustEmbedUnimplementedFileTransferServer() {}
+
+// UnsafeFileTransferServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileTransferServer will
+// result in compilation errors.
+type UnsafeFileTransferServer interface {
+	mustEmbedUnimplementedFileTransferServer()
+}
+
+func RegisterFileTransferServer(s grpc.ServiceRegistrar, srv FileTransferServer) {
+	s.RegisterService(&FileTransfer_ServiceDesc, srv)
+}
+
+func _FileTransfer_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileTransferServer).Upload(&fileTransferUploadServer{stream})
+}
+
+type FileTransfer_UploadServer interface {
+	SendAndClose(*File) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type fileTransferUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferUploadServer) SendAndClose(m *File) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileTransferUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileTransfer_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileTransferServer).Download(m, &fileTransferDownloadServer{stream})
+}
+
+type FileTransfer_DownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileTransferDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileTransferDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileTransfer_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTransfer_ListFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServer).ListFiles(ctx, req.(*ListFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileTransfer_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileTransferServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileTransfer_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileTransferServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FileTransfer_ServiceDesc is the grpc.ServiceDesc for FileTransfer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileTransfer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "filetransfer.v1.FileTransfer",
+	HandlerType: (*FileTransferServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListFiles",
+			Handler:    _FileTransfer_ListFiles_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _FileTransfer_DeleteFile_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _FileTransfer_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _FileTransfer_Download_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "filetransfer.proto",
+}
@@ -0,0 +1,59 @@
+// Package settings reads configuration files into flat settings. Nested keys are joined with dashes, so
+//
+//	smtp:
+//	  host: mail.example.com
+//
+// in YAML and
+//
+//	[smtp]
+//	host = "mail.example.com"
+//
+// in TOML both set smtp-host. Lists become space separated values. Only the parts of both formats
+// which make sense for settings are supported: tables, strings, numbers, booleans and lists of those.
+package settings
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read parses the YAML or TOML file at path, the format is taken from the extension
+func Read(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[string]string
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		settings, err = parseYAML(string(data))
+	case ".toml":
+		settings, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("%s: unknown config format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+// setting adds the value of a key path, a key set twice is an error
+func setting(settings map[string]string, path []string, value string) error {
+	key := strings.Join(path, "-")
+	if _, ok := settings[key]; ok {
+		return fmt.Errorf("%s is set more than once", key)
+	}
+
+	settings[key] = value
+	return nil
+}
+
+func lineError(line int, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,56 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		file    string
+		data    string
+		want    map[string]string
+		wantErr string
+	}{
+		{"config.yaml", "db:\n  dsn: x\n", map[string]string{"db-dsn": "x"}, ""},
+		{"config.YML", "port: 1\n", map[string]string{"port": "1"}, ""},
+		{"config.toml", "[db]\ndsn = \"x\"\n", map[string]string{"db-dsn": "x"}, ""},
+		{"config.json", "{}", nil, "config.json: unknown config format"},
+		{"broken.toml", "port = 15m\n", nil, "broken.toml: line 1: values must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			err := os.WriteFile(path, []byte(tt.data), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Read(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := Read(filepath.Join(dir, "missing.yaml"))
+	if !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing file, want a not exist error", err)
+	}
+}
@@ -0,0 +1,277 @@
+package settings
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads tables, dotted keys, basic and literal strings, integers, floats, booleans and
+// arrays of those, which may span several lines. Inline tables, arrays of tables, multi-line
+// strings and dates are not supported.
+func parseTOML(data string) (map[string]string, error) {
+	settings := map[string]string{}
+	var table []string
+
+	lines := strings.Split(data, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			return nil, lineError(n, "arrays of tables are not supported")
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end < 0 || !tomlComment(line[end+1:]) {
+				return nil, lineError(n, "invalid table header")
+			}
+
+			keys, err := tomlKey(line[1:end])
+			if err != nil {
+				return nil, lineError(n, "%s", err)
+			}
+			table = keys
+			continue
+		}
+
+		eq := tomlKeyEnd(line)
+		if eq < 0 {
+			return nil, lineError(n, "expected key = value")
+		}
+
+		keys, err := tomlKey(line[:eq])
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		value := strings.TrimSpace(line[eq+1:])
+
+		// arrays continue until their closing bracket
+		start := n
+		for strings.HasPrefix(value, "[") && !tomlArrayClosed(value) && i+1 < len(lines) {
+			i++
+			value += "\n" + strings.TrimSpace(lines[i])
+		}
+
+		parsed, err := tomlValue(value)
+		if err != nil {
+			return nil, lineError(start, "%s", err)
+		}
+
+		err = setting(settings, append(append([]string{}, table...), keys...), parsed)
+		if err != nil {
+			return nil, lineError(start, "%s", err)
+		}
+	}
+
+	return settings, nil
+}
+
+// tomlKeyEnd returns the index of the equals sign after the key, skipping quoted parts of the key
+func tomlKeyEnd(line string) int {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '=':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// tomlKey splits a possibly dotted key like smtp.host or "rate-limit".global
+func tomlKey(s string) ([]string, error) {
+	var keys []string
+
+	for _, part := range strings.Split(s, ".") {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '"' || part[0] == '\'') && part[len(part)-1] == part[0] {
+			part = part[1 : len(part)-1]
+		}
+		if part == "" || strings.ContainsAny(part, " \t\"'") {
+			return nil, errors.New("invalid key")
+		}
+		keys = append(keys, part)
+	}
+
+	return keys, nil
+}
+
+func tomlComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// tomlArrayClosed reports whether the brackets of the array s starts with are balanced, outside of strings and comments
+func tomlArrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			// skip to the end of the line
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// tomlValue reads a value followed by an optional comment, arrays become space separated
+func tomlValue(s string) (string, error) {
+	if strings.HasPrefix(s, "[") {
+		items, rest, err := tomlArray(s)
+		if err != nil {
+			return "", err
+		}
+		if !tomlComment(rest) {
+			return "", errors.New("unexpected text after an array")
+		}
+		return strings.Join(items, " "), nil
+	}
+
+	value, rest, err := tomlScalar(s)
+	if err != nil {
+		return "", err
+	}
+	if !tomlComment(rest) {
+		return "", errors.New("unexpected text after a value")
+	}
+
+	return value, nil
+}
+
+func tomlArray(s string) ([]string, string, error) {
+	s = s[1:]
+	items := []string{}
+
+	for {
+		s = tomlSkip(s)
+		if s == "" {
+			return nil, "", errors.New("unterminated array")
+		}
+		if s[0] == ']' {
+			return items, s[1:], nil
+		}
+
+		item, rest, err := tomlScalar(s)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+
+		s = tomlSkip(rest)
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, "", errors.New("expected a comma or the end of the array")
+		}
+	}
+}
+
+// tomlSkip drops the whitespace, newlines and comments inside an array
+func tomlSkip(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
+		}
+		end := strings.IndexByte(s, '\n')
+		if end < 0 {
+			return ""
+		}
+		s = s[end:]
+	}
+}
+
+// tomlScalar reads the string, number or boolean s starts with and returns it with the text after it
+func tomlScalar(s string) (string, string, error) {
+	if s == "" {
+		return "", "", errors.New("missing value")
+	}
+
+	switch s[0] {
+	case '"':
+		if strings.HasPrefix(s, `"""`) {
+			return "", "", errors.New("multi-line strings are not supported")
+		}
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '\n':
+				return "", "", errors.New("unterminated string")
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", errors.New("invalid escape sequence in string")
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case '\'':
+		if strings.HasPrefix(s, "'''") {
+			return "", "", errors.New("multi-line strings are not supported")
+		}
+		end := strings.IndexAny(s[1:], "'\n")
+		if end < 0 || s[1+end] != '\'' {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : 1+end], s[2+end:], nil
+	case '{':
+		return "", "", errors.New("inline tables are not supported")
+	case '[':
+		return "", "", errors.New("nested arrays are not supported")
+	}
+
+	end := strings.IndexAny(s, " \t\r\n,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	token := s[:end]
+
+	if token != "true" && token != "false" {
+		// underscores may separate digits, like 1_000_000
+		_, err := strconv.ParseFloat(strings.ReplaceAll(token, "_", ""), 64)
+		if err != nil {
+			return "", "", errors.New("values must be strings, numbers or booleans, quote durations like \"15m\"")
+		}
+		token = strings.ReplaceAll(token, "_", "")
+	}
+
+	return token, s[end:], nil
+}
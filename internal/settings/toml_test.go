@@ -0,0 +1,118 @@
+package settings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"only comments", "# settings\n\n  # indented\n", map[string]string{}},
+		{"flat", "port = 4000\nenv = \"production\"\n", map[string]string{"port": "4000", "env": "production"}},
+		{
+			"tables",
+			"port = 1\n[db]\ndsn = \"postgres://localhost/db\"\n[limiter.burst]\nsize = 4\n",
+			map[string]string{"port": "1", "db-dsn": "postgres://localhost/db", "limiter-burst-size": "4"},
+		},
+		{"table header with a comment", "[smtp] # mail\nhost = \"x\"\n", map[string]string{"smtp-host": "x"}},
+		{"dotted keys", "smtp.host = \"x\"\nsmtp . port = 25\n", map[string]string{"smtp-host": "x", "smtp-port": "25"}},
+		{"quoted keys", "\"rate-limit\".global = 5\n'b' = 6\n", map[string]string{"rate-limit-global": "5", "b": "6"}},
+		{"equals sign in a quoted key", "\"a=b\" = 1\n", map[string]string{"a=b": "1"}},
+		{"booleans", "a = true\nb = false\n", map[string]string{"a": "true", "b": "false"}},
+		{"numbers", "a = -1.5\nb = 1e3\nc = +7\n", map[string]string{"a": "-1.5", "b": "1e3", "c": "+7"}},
+		{"underscores in numbers", "size = 1_000_000\n", map[string]string{"size": "1000000"}},
+		{"escapes", `name = "tab\there\u00e9 \"q\""`, map[string]string{"name": "tab\there\u00e9 \"q\""}},
+		{"literal strings", `path = 'C:\Users\x'`, map[string]string{"path": `C:\Users\x`}},
+		{"comment after a value", "port = 4000 # the API port\n", map[string]string{"port": "4000"}},
+		{"hash inside a string", "color = \"a#b\"\n", map[string]string{"color": "a#b"}},
+		{"array", "tags = [\"a\", 'b', 3, ]\n", map[string]string{"tags": "a b 3"}},
+		{"empty array", "tags = []\n", map[string]string{"tags": ""}},
+		{"bracket inside an array string", "tags = [\"x]\", \"y\"]\n", map[string]string{"tags": "x] y"}},
+		{
+			"multi-line array",
+			"origins = [\n  \"https://a.example\", # first\n  # a comment with a ]\n  \"https://b.example\",\n]\nport = 1\n",
+			map[string]string{"origins": "https://a.example https://b.example", "port": "1"},
+		},
+		{"CRLF line endings", "[db]\r\ndsn = \"x\"\r\nport = 1\r\n", map[string]string{"db-dsn": "x", "db-port": "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.data)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"array of tables", "[[servers]]\n", "line 1: arrays of tables are not supported"},
+		{"unterminated table header", "[db\n", "line 1: invalid table header"},
+		{"text after a table header", "[db] x\n", "line 1: invalid table header"},
+		{"empty table name", "[]\n", "line 1: invalid key"},
+		{"missing equals sign", "port 4000\n", "line 1: expected key = value"},
+		{"missing key", "= 1\n", "line 1: invalid key"},
+		{"empty dotted key part", "a..b = 1\n", "line 1: invalid key"},
+		{"space in a key", "a b = 1\n", "line 1: invalid key"},
+		{"key set twice", "a = 1\na = 2\n", "line 2: a is set more than once"},
+		{"key set twice through a table", "db-dsn = 1\n[db]\ndsn = 2\n", "line 3: db-dsn is set more than once"},
+		{"multi-line basic string", "a = \"\"\"x\"\"\"\n", "line 1: multi-line strings are not supported"},
+		{"multi-line literal string", "a = '''x'''\n", "line 1: multi-line strings are not supported"},
+		{"inline table", "a = {b = 1}\n", "line 1: inline tables are not supported"},
+		{"nested array", "a = [[1], [2]]\n", "line 1: nested arrays are not supported"},
+		{"unterminated string", "a = \"abc\n", "line 1: unterminated string"},
+		{"unterminated literal string", "a = 'abc\n", "line 1: unterminated string"},
+		{"escaped closing quote", `a = "abc\"`, "line 1: unterminated string"},
+		{"invalid escape", `a = "\q"`, "line 1: invalid escape sequence in string"},
+		{"unquoted duration", "timeout = 15m\n", "line 1: values must be strings, numbers or booleans"},
+		{"date", "day = 2024-01-01\n", "line 1: values must be strings, numbers or booleans"},
+		{"text after a value", "a = 1 2\n", "line 1: unexpected text after a value"},
+		{"text after a string", "a = \"x\" y\n", "line 1: unexpected text after a value"},
+		{"text after an array", "a = [1] 2\n", "line 1: unexpected text after an array"},
+		{"unterminated array", "a = [1,\n", "line 1: unterminated array"},
+		{"missing comma", "a = [1 2]\n", "line 1: expected a comma or the end of the array"},
+		{"missing value", "a =\n", "line 1: missing value"},
+		{"error in a multi-line array", "b = 1\na = [\n  1,\n  x,\n]\n", "line 2: values must be strings, numbers or booleans"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// FuzzParseTOML checks that no input panics
+func FuzzParseTOML(f *testing.F) {
+	for _, seed := range []string{
+		"port = 4000\n",
+		"[db]\ndsn = \"x\"\n[limiter.burst]\nsize = 1_000\n",
+		"tags = [\n  \"a\", # one\n  'b',\n]\n",
+		"\"rate-limit\".global = \"\\u00e9\" # comment\n",
+		"a = [\"x]\", 1e3, true]\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		_, _ = parseTOML(data)
+	})
+}
@@ -0,0 +1,296 @@
+package settings
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// yamlKey is a mapping key whose value is on the following, more indented lines
+type yamlKey struct {
+	indent int
+	name   string
+	// used is set once a nested key or list item has been found
+	used bool
+}
+
+// parseYAML reads block mappings, block and flow sequences of scalars and plain, single and
+// double quoted scalars. Anchors, multi-line scalars and several documents are not supported.
+func parseYAML(data string) (map[string]string, error) {
+	settings := map[string]string{}
+	lists := map[string][]string{}
+	var listOrder [][]string
+	var stack []*yamlKey
+
+	path := func() []string {
+		names := make([]string, len(stack))
+		for i, key := range stack {
+			names[i] = key.name
+		}
+		return names
+	}
+
+	// closeKeys pops the keys at or below indent, the ones without anything under them are empty
+	closeKeys := func(indent int) error {
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			if !stack[len(stack)-1].used {
+				err := setting(settings, path(), "")
+				if err != nil {
+					return err
+				}
+			}
+			stack = stack[:len(stack)-1]
+		}
+		return nil
+	}
+
+	for i, line := range strings.Split(data, "\n") {
+		n := i + 1
+		line = strings.TrimRight(line, " \r")
+
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, lineError(n, "indentation must use spaces")
+		}
+		if content == "---" && len(stack) == 0 && len(settings) == 0 {
+			continue
+		}
+		if content == "---" || content == "..." {
+			return nil, lineError(n, "only a single document is supported")
+		}
+
+		indent := len(line) - len(content)
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			// items belong to the closest key indented less or as much as they are
+			err := closeKeys(indent + 1)
+			if err != nil {
+				return nil, lineError(n, "%s", err)
+			}
+			if len(stack) == 0 {
+				return nil, lineError(n, "list item without a key")
+			}
+
+			parent := stack[len(stack)-1]
+			key := strings.Join(path(), "-")
+			if parent.used && lists[key] == nil {
+				return nil, lineError(n, "%s mixes keys and list items", key)
+			}
+
+			value, err := yamlScalar(strings.TrimPrefix(content, "-"))
+			if err != nil {
+				return nil, lineError(n, "%s", err)
+			}
+
+			if lists[key] == nil {
+				listOrder = append(listOrder, path())
+			}
+			lists[key] = append(lists[key], value)
+			parent.used = true
+			continue
+		}
+
+		err := closeKeys(indent)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		name, rest, err := yamlMappingKey(content)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			if lists[strings.Join(path(), "-")] != nil {
+				return nil, lineError(n, "%s mixes keys and list items", strings.Join(path(), "-"))
+			}
+			parent.used = true
+		}
+
+		if strings.TrimSpace(rest) == "" || strings.HasPrefix(strings.TrimSpace(rest), "#") {
+			stack = append(stack, &yamlKey{indent: indent, name: name})
+			continue
+		}
+
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		if strings.HasPrefix(rest, "[") {
+			var items []string
+			items, err = yamlFlowSequence(rest)
+			value = strings.Join(items, " ")
+		} else {
+			value, err = yamlScalar(rest)
+		}
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		err = setting(settings, append(path(), name), value)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+	}
+
+	err := closeKeys(0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range listOrder {
+		err := setting(settings, list, strings.Join(lists[strings.Join(list, "-")], " "))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return settings, nil
+}
+
+// yamlMappingKey splits "key: value" into the key and the rest of the line
+func yamlMappingKey(content string) (string, string, error) {
+	if content[0] == '"' || content[0] == '\'' {
+		name, rest, err := yamlQuoted(content)
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", errors.New("expected a colon after the key")
+		}
+		return name, rest[1:], nil
+	}
+
+	i := strings.Index(content, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(content, ":") {
+			return "", "", errors.New("expected key: value")
+		}
+		i = len(content) - 1
+	}
+
+	name := strings.TrimSpace(content[:i])
+	if name == "" {
+		return "", "", errors.New("missing key")
+	}
+	if strings.ContainsAny(name, "{}[]&*!|>%@`") {
+		return "", "", errors.New("only plain keys are supported")
+	}
+
+	return name, content[i+1:], nil
+}
+
+// yamlScalar reads a single value, followed by nothing but an optional comment
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+
+	switch s[0] {
+	case '"', '\'':
+		value, rest, err := yamlQuoted(s)
+		if err != nil {
+			return "", err
+		}
+		rest = strings.TrimSpace(rest)
+		if rest != "" && rest[0] != '#' {
+			return "", errors.New("unexpected text after a quoted value")
+		}
+		return value, nil
+	case '[', '{', '&', '*', '!', '|', '>', '%', '@', '`':
+		return "", errors.New("only plain and quoted scalars are supported as values")
+	}
+
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if strings.Contains(s, ": ") {
+		return "", errors.New("nested mappings must start on a new line")
+	}
+	if s == "~" || s == "null" {
+		return "", nil
+	}
+
+	return s, nil
+}
+
+// yamlQuoted reads the quoted string s starts with and returns it with the text after it
+func yamlQuoted(s string) (string, string, error) {
+	if s[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		}
+		return "", "", errors.New("unterminated string")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", errors.New("invalid escape sequence in string")
+			}
+			return value, s[i+1:], nil
+		}
+	}
+
+	return "", "", errors.New("unterminated string")
+}
+
+// yamlFlowSequence reads a list like [a, "b c"]
+func yamlFlowSequence(s string) ([]string, error) {
+	s = strings.TrimPrefix(s, "[")
+	items := []string{}
+
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return nil, errors.New("unterminated list")
+		}
+		if s[0] == ']' {
+			rest := strings.TrimSpace(s[1:])
+			if rest != "" && rest[0] != '#' {
+				return nil, errors.New("unexpected text after a list")
+			}
+			return items, nil
+		}
+
+		var item string
+		if s[0] == '"' || s[0] == '\'' {
+			value, rest, err := yamlQuoted(s)
+			if err != nil {
+				return nil, err
+			}
+			item, s = value, strings.TrimLeft(rest, " ")
+		} else {
+			end := strings.IndexAny(s, ",]")
+			if end < 0 {
+				return nil, errors.New("unterminated list")
+			}
+			item, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		items = append(items, item)
+
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, errors.New("expected a comma or the end of the list")
+		}
+	}
+}
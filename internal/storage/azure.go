@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	azureVersion = "2021-08-06"
+	// azureBlockSize is the size of the blocks larger and unsized uploads are staged in
+	azureBlockSize = 8 << 20
+)
+
+// Azure talks to Azure Blob Storage, or the Azurite emulator, using requests signed with the account key.
+type Azure struct {
+	client    *http.Client
+	endpoint  *url.URL
+	account   string
+	key       []byte
+	container string
+}
+
+func NewAzure(cfg *Storage) (*Azure, error) {
+	if cfg.Azure.Account == "" {
+		return nil, errors.New("azure account must be provided")
+	}
+	if cfg.Azure.Container == "" {
+		return nil, errors.New("azure container must be provided")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.Azure.Key)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("azure key must be the base64 encoded account key")
+	}
+
+	endpoint := cfg.Azure.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.Azure.Account)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid azure endpoint %q", endpoint)
+	}
+
+	return &Azure{
+		client:    &http.Client{},
+		endpoint:  u,
+		account:   cfg.Azure.Account,
+		key:       key,
+		container: cfg.Azure.Container,
+	}, nil
+}
+
+// Put stores objects of up to one block with a single request, anything else is staged block by
+// block and committed at the end, so the size does not have to be known up front.
+func (a *Azure) Put(key string, r io.Reader, size int64) error {
+	if size >= 0 && size <= azureBlockSize {
+		return a.putBlob(key, r, size)
+	}
+
+	buf := make([]byte, azureBlockSize)
+	ids := []string{}
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		if n < azureBlockSize && len(ids) == 0 {
+			return a.putBlob(key, bytes.NewReader(buf[:n]), int64(n))
+		}
+		if n == 0 {
+			break
+		}
+
+		// block IDs have to be of the same length within a blob
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(ids))))
+
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+		req, err := a.newRequest(http.MethodPut, key, query, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return err
+		}
+
+		err = a.send(req)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+
+		if n < azureBlockSize {
+			break
+		}
+	}
+
+	var blockList strings.Builder
+	blockList.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range ids {
+		blockList.WriteString("<Latest>" + id + "</Latest>")
+	}
+	blockList.WriteString("</BlockList>")
+
+	body := blockList.String()
+	req, err := a.newRequest(http.MethodPut, key, url.Values{"comp": {"blocklist"}}, strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	return a.send(req)
+}
+
+func (a *Azure) putBlob(key string, r io.Reader, size int64) error {
+	req, err := a.newRequest(http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+
+	return a.send(req)
+}
+
+func (a *Azure) Get(key string) (io.ReadSeekCloser, error) {
+	req, err := a.newRequest(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteObject{
+		size: res.ContentLength,
+		body: res.Body,
+		open: func(offset int64) (io.ReadCloser, error) {
+			return a.openRange(key, offset)
+		},
+	}, nil
+}
+
+// openRange streams the object from offset on
+func (a *Azure) openRange(key string, offset int64) (io.ReadCloser, error) {
+	req, err := a.newRequest(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Range", fmt.Sprintf("bytes=%d-", offset))
+
+	res, err := a.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Delete removes the blob on the server, Azure answers blobs that do not exist with a 404
+func (a *Azure) Delete(key string) error {
+	req, err := a.newRequest(http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return a.send(req)
+}
+
+func (a *Azure) Stat(key string) (*Info, error) {
+	req, err := a.newRequest(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Time{}
+	}
+
+	return &Info{Size: size, ModTime: modTime}, nil
+}
+
+func (a *Azure) List() ([]string, error) {
+	keys := []string{}
+	marker := ""
+
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		req, err := a.newRequest(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := a.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			NextMarker string `xml:"NextMarker"`
+			Blobs      []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+		}
+
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, blob := range result.Blobs {
+			keys = append(keys, blob.Name)
+		}
+
+		if result.NextMarker == "" {
+			return keys, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// blobURL returns the URL of the blob under key, or of the container if key is empty
+func (a *Azure) blobURL(key string, query url.Values) *url.URL {
+	u := *a.endpoint
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + uriEncode(a.container, false)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + a.container
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + uriEncode(key, false)
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (a *Azure) newRequest(method, key string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	req, err := http.NewRequest(method, a.blobURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	return req, nil
+}
+
+// send performs a request whose response body is not needed
+func (a *Azure) send(req *http.Request) error {
+	res, err := a.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// do signs and sends req, so headers can be added to requests until then
+func (a *Azure) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+	a.sign(req)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrObjectNotFound
+	case res.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("azure: %s %s: %s: %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return res, nil
+}
+
+// sign sets the Shared Key Authorization header of req
+func (a *Azure) sign(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var headers []string
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	var canonicalResource strings.Builder
+	canonicalResource.WriteString("/" + a.account + req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		canonicalResource.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-Md5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + canonicalResource.String(),
+	}, "\n")
+
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", "SharedKey "+a.account+":"+signature)
+}
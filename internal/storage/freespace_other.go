@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "errors"
+
+// FreeSpace is not supported on this platform
+func (l *Local) FreeSpace() (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// FreeSpace returns the space available to unprivileged users on the file system of the storage directory
+func (l *Local) FreeSpace() (uint64, error) {
+	// the directory is only created by the first write
+	err := os.MkdirAll(l.dir, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(l.dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
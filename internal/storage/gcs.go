@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCS talks to Google Cloud Storage through its JSON API. Requests are authorized with access
+// tokens of a service account, without credentials they are sent as is for emulators.
+type GCS struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	account  *gcsServiceAccount
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// gcsServiceAccount holds the fields of a service account key file which are needed to request tokens
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+func NewGCS(cfg *Storage) (*GCS, error) {
+	if cfg.GCS.Bucket == "" {
+		return nil, errors.New("gcs bucket must be provided")
+	}
+	if cfg.GCS.Credentials == "" && cfg.GCS.Endpoint == "" {
+		return nil, errors.New("gcs credentials must be provided")
+	}
+
+	endpoint := cfg.GCS.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid gcs endpoint %q", endpoint)
+	}
+
+	g := &GCS{
+		client:   &http.Client{},
+		endpoint: u,
+		bucket:   cfg.GCS.Bucket,
+	}
+
+	if cfg.GCS.Credentials != "" {
+		g.account, err = readGCSServiceAccount(cfg.GCS.Credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+func readGCSServiceAccount(path string) (*gcsServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var account gcsServiceAccount
+	err = json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf("gcs credentials: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("gcs credentials must be a service account key file")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gcs credentials: invalid private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gcs credentials: %w", err)
+	}
+
+	var ok bool
+	account.key, ok = key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs credentials: private key is not an RSA key")
+	}
+
+	return &account, nil
+}
+
+func (g *GCS) Put(key string, r io.Reader, size int64) error {
+	// media uploads need the length up front, as with S3
+	if size < 0 {
+		tmp, err := os.CreateTemp("", "gcs-upload-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err = io.Copy(tmp, r)
+		if err != nil {
+			return err
+		}
+
+		_, err = tmp.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		r = tmp
+	}
+
+	u := *g.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/upload/storage/v1/b/" + g.bucket + "/o"
+	u.RawPath = ""
+	u.RawQuery = url.Values{"uploadType": {"media"}, "name": {key}}.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	return g.send(req)
+}
+
+func (g *GCS) Get(key string) (io.ReadSeekCloser, error) {
+	info, err := g.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteObject{
+		size: info.Size,
+		open: func(offset int64) (io.ReadCloser, error) {
+			return g.openRange(key, offset)
+		},
+	}, nil
+}
+
+// openRange streams the object from offset on
+func (g *GCS) openRange(key string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, g.objectURL(key, url.Values{"alt": {"media"}}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	res, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Delete removes the object on the server, GCS answers objects that do not exist with a 404
+func (g *GCS) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, g.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	return g.send(req)
+}
+
+func (g *GCS) Stat(key string) (*Info, error) {
+	req, err := http.NewRequest(http.MethodGet, g.objectURL(key, url.Values{"fields": {"size,updated"}}).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := g.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var object struct {
+		Size    string    `json:"size"`
+		Updated time.Time `json:"updated"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(object.Size, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{Size: size, ModTime: object.Updated}, nil
+}
+
+func (g *GCS) List() ([]string, error) {
+	keys := []string{}
+	pageToken := ""
+
+	for {
+		query := url.Values{"fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		u := *g.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/storage/v1/b/" + g.bucket + "/o"
+		u.RawPath = ""
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := g.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+		}
+
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Items {
+			keys = append(keys, object.Name)
+		}
+
+		if result.NextPageToken == "" {
+			return keys, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
+
+// objectURL returns the JSON API URL of the object under key, slashes in the key have to be escaped there
+func (g *GCS) objectURL(key string, query url.Values) *url.URL {
+	u := *g.endpoint
+	base := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/storage/v1/b/" + g.bucket + "/o/" + key
+	u.RawPath = base + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(key)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// send performs a request whose response body is not needed
+func (g *GCS) send(req *http.Request) error {
+	res, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+func (g *GCS) do(req *http.Request) (*http.Response, error) {
+	if g.account != nil {
+		token, err := g.accessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrObjectNotFound
+	case res.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("gcs: %s %s: %s: %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return res, nil
+}
+
+// accessToken returns a cached token of the service account, a new one is requested shortly before it expires
+func (g *GCS) accessToken() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.token != "" && time.Now().Before(g.tokenExpiry.Add(-time.Minute)) {
+		return g.token, nil
+	}
+
+	assertion, err := g.account.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+
+	res, err := g.client.PostForm(g.account.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("gcs: token request: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+
+	g.token = result.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+
+	return g.token, nil
+}
+
+// assertion returns the signed JWT which is exchanged for an access token
+func (a *gcsServiceAccount) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"iss":   a.ClientEmail,
+		"scope": gcsScope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+// tmpDir holds blobs while they are written, file and upload keys never start with it
+func (l *Local) tmpDir() string {
+	return filepath.Join(l.dir, ".tmp")
+}
+
+// Put writes to a temporary file which only replaces the blob once r has been read completely,
+// a failed write leaves the previous content in place.
+func (l *Local) Put(key string, r io.Reader, size int64) error {
+	path := l.path(key)
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(l.tmpDir(), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(l.tmpDir(), "put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (l *Local) Get(key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrObjectNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// also removes the parent folder once it is empty
+func (l *Local) Delete(key string) error {
+	path := l.path(key)
+
+	err := os.Remove(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return ErrObjectNotFound
+		default:
+			return err
+		}
+	}
+
+	return l.deleteEmptyFolder(filepath.Dir(path))
+}
+
+func (l *Local) Stat(key string) (*Info, error) {
+	fileInfo, err := os.Stat(l.path(key))
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrObjectNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &Info{Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+func (l *Local) List() ([]string, error) {
+	keys := []string{}
+
+	err := filepath.WalkDir(l.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == l.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == l.tmpDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (l *Local) deleteEmptyFolder(dirPath string) error {
+	if filepath.Clean(dirPath) == filepath.Clean(l.dir) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) == 0 {
+		err := os.Remove(dirPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
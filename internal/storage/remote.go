@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// remoteObject streams an object of a remote backend, after seeking the remainder is requested again from the new offset
+type remoteObject struct {
+	open   func(offset int64) (io.ReadCloser, error)
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *remoteObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		body, err := o.open(o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *remoteObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+
+	return offset, nil
+}
+
+func (o *remoteObject) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
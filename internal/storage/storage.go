@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+type Storage struct {
+	Backend string
+	Local   struct {
+		Dir string
+	}
+	S3 struct {
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+	}
+	Azure struct {
+		Endpoint  string
+		Account   string
+		Key       string
+		Container string
+	}
+	GCS struct {
+		Endpoint string
+		Bucket   string
+		// Credentials is the path of a service account key file
+		Credentials string
+	}
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores file contents under slash separated keys, e.g. "files/3f2b8c1e-9d4a-4f6b-8a2e-5c7d9e0f1a2b",
+// the random UUIDs the files are given.
+type Backend interface {
+	// Put writes the contents of r under key, replacing any existing object.
+	// size may be -1 if the length of r is not known in advance.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the object for reading, seeking allows serving parts of it without fetching everything.
+	Get(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+	Stat(key string) (*Info, error)
+	// List returns the keys of all stored objects.
+	List() ([]string, error)
+}
+
+// DirectUploader is implemented by backends clients can upload to without going through the API
+type DirectUploader interface {
+	// PresignPut returns a URL which accepts a PUT of the object under key until expires has passed
+	PresignPut(key string, expires time.Duration) (string, error)
+	// Copy copies the object under src to dst inside the backend
+	Copy(src, dst string) error
+}
+
+// FreeSpacer is implemented by backends which can run out of space, like a local disk
+type FreeSpacer interface {
+	// FreeSpace returns the bytes which can still be written
+	FreeSpace() (uint64, error)
+}
+
+func New(cfg *Storage) (Backend, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Local.Dir), nil
+	case "s3":
+		return NewS3(cfg)
+	case "azure":
+		return NewAzure(cfg)
+	case "gcs":
+		return NewGCS(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
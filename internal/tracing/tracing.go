@@ -0,0 +1,400 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// exportInterval is how often finished spans are sent, a full batch is sent right away
+	exportInterval = 5 * time.Second
+	batchSize      = 512
+	// maxQueued bounds the spans waiting for export, more are dropped while the collector is unreachable
+	maxQueued = 4096
+)
+
+// Kinds of spans as defined by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+type Config struct {
+	// Endpoint is the base URL of an OTLP/HTTP collector, such as http://localhost:4318, empty disables tracing
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of traces started here which are recorded, traces started by a caller
+	// follow its sampling decision
+	SampleRatio float64
+}
+
+// Tracer records spans and exports them in batches to an OTLP/HTTP collector, encoded as JSON.
+// A nil Tracer records nothing, so instrumented code does not have to check whether tracing is enabled.
+type Tracer struct {
+	client  *http.Client
+	url     string
+	service string
+	ratio   float64
+	onError func(error)
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush   chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts a tracer exporting to cfg.Endpoint, export errors are passed to onError.
+// It returns nil without an endpoint.
+func New(cfg *Config, onError func(error)) (*Tracer, error) {
+	if cfg.Endpoint == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", cfg.Endpoint)
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, errors.New("sample ratio must be between 0 and 1")
+	}
+
+	t := &Tracer{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		service: cfg.ServiceName,
+		ratio:   cfg.SampleRatio,
+		onError: onError,
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t, nil
+}
+
+// Shutdown exports the remaining spans, spans ended afterwards are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	close(t.stop)
+
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.stop:
+			t.export()
+			return
+		}
+		t.export()
+	}
+}
+
+// export sends all queued spans, in batches so a long queue does not make for one huge request
+func (t *Tracer) export() {
+	for {
+		t.mu.Lock()
+		batch := t.queue
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		t.queue = t.queue[len(batch):]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			t.onError(fmt.Errorf("otlp: dropped %d spans, the export queue was full", dropped))
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		err := t.send(batch)
+		if err != nil {
+			t.onError(err)
+			return
+		}
+	}
+}
+
+func (t *Tracer) send(batch []*Span) error {
+	spans := make([]any, len(batch))
+	for i, span := range batch {
+		spans[i] = span.otlp()
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]Attribute{String("service.name", t.service)}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/Li-Elias/File-Transfer"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("otlp: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+
+	if len(t.queue) >= maxQueued {
+		t.dropped++
+		return
+	}
+
+	t.queue = append(t.queue, span)
+	if len(t.queue) >= batchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// spanContext identifies a span across process boundaries, as carried by the traceparent header
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type contextKey struct{}
+
+// Extract returns ctx continuing the trace of the W3C traceparent header in h, if there is a valid one
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(h.Get("traceparent"), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+
+	var sc spanContext
+	_, err1 := hex.Decode(sc.traceID[:], []byte(parts[1]))
+	_, err2 := hex.Decode(sc.spanID[:], []byte(parts[2]))
+	flags, err3 := strconv.ParseUint(parts[3], 16, 8)
+	if err1 != nil || err2 != nil || err3 != nil || sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return ctx
+	}
+	sc.sampled = flags&1 == 1
+
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// TraceID returns the hex encoded ID of the trace ctx is part of, or an empty string if it is not recorded
+func TraceID(ctx context.Context) string {
+	sc, ok := ctx.Value(contextKey{}).(spanContext)
+	if !ok || !sc.sampled {
+		return ""
+	}
+	return hex.EncodeToString(sc.traceID[:])
+}
+
+// Start begins a span as a child of the span in ctx, or a new trace without one. The span has to be ended.
+func (t *Tracer) Start(ctx context.Context, name string, kind int, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+
+	parent, ok := ctx.Value(contextKey{}).(spanContext)
+	if ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+		span.sampled = parent.sampled
+	} else {
+		rand.Read(span.traceID[:])
+		// the lower 8 bytes of the trace ID are random, so they decide the sampling
+		span.sampled = float64(binary.BigEndian.Uint64(span.traceID[8:])>>11)/(1<<53) < t.ratio
+	}
+	rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, contextKey{}, span.spanContext), span
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation of a trace. All methods may be called on a nil Span.
+type Span struct {
+	spanContext
+	tracer   *Tracer
+	parentID [8]byte
+	kind     int
+
+	mu    sync.Mutex
+	name  string
+	start time.Time
+	end   time.Time
+	attrs []Attribute
+	err   string
+	ended bool
+}
+
+// SetName replaces the name given to Start, for names which are only known later, like the route of a request
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed with err, a nil err is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for export, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// otlp returns the span in the JSON encoding of OTLP, IDs are hex and 64 bit integers strings
+func (s *Span) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := map[string]any{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		span["status"] = map[string]any{"code": 2, "message": s.err}
+	}
+
+	return span
+}
+
+func otlpAttributes(attrs []Attribute) []any {
+	out := make([]any, 0, len(attrs))
+
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": attr.Key, "value": value})
+	}
+
+	return out
+}
@@ -0,0 +1,10 @@
+// Package web holds the browser UI served under /app. It is a single page without a build
+// step, the styles and scripts are inline and it only talks to the public API.
+package web
+
+import (
+	_ "embed"
+)
+
+//go:embed index.html
+var Index []byte
@@ -0,0 +1,268 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cborPairs is a CBOR map for encodeCBOR, kept in order so the encoding is deterministic
+type cborPairs [][2]interface{}
+
+// encodeCBOR encodes the values decodeCBOR returns, for building test input
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborPairs:
+		out := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair[0])...)
+			out = append(out, encodeCBOR(pair[1])...)
+		}
+		return out
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	default:
+		panic("encodeCBOR: unsupported type")
+	}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestDecodeCBOR decodes the examples of RFC 8949, appendix A, which authenticators can send
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"01", int64(1)},
+		{"0a", int64(10)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1819", int64(25)},
+		{"1864", int64(100)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"1b7fffffffffffffff", int64(9223372036854775807)},
+		{"20", int64(-1)},
+		{"29", int64(-10)},
+		{"3863", int64(-100)},
+		{"3903e7", int64(-1000)},
+		{"3b7fffffffffffffff", int64(-9223372036854775808)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"c11a514b67b0", int64(1363896240)},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		// empty byte strings are copied into nil
+		{"40", []byte(nil)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6161", "a"},
+		{"6449455446", "IETF"},
+		{"62225c", "\"\\"},
+		{"62c3bc", "ü"},
+		{"63e6b0b4", "水"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a0", map[interface{}]interface{}{}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"826161a161626163", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			got, rest, err := decodeCBOR(mustHex(t, tt.hex))
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if len(rest) != 0 {
+				t.Errorf("got %d bytes left over", len(rest))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	got, rest, err := decodeCBOR(mustHex(t, "8201026161ff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, []interface{}{int64(1), int64(2)}) || !bytes.Equal(rest, mustHex(t, "6161ff")) {
+		t.Errorf("got %#v and rest %x, want [1 2] and 6161ff", got, rest)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"truncated 1 byte argument", "18"},
+		{"truncated 2 byte argument", "1903"},
+		{"truncated 4 byte argument", "1a000f42"},
+		{"truncated 8 byte argument", "1b000000e8d4a510"},
+		{"reserved argument", "1c"},
+		{"indefinite byte string", "5f42010243030405ff"},
+		{"indefinite text string", "7f657374726561646d696e67ff"},
+		{"indefinite array", "9f018202039f0405ffff"},
+		{"indefinite map", "bf61610161629f0203ffff"},
+		{"unsigned integer above int64", "1bffffffffffffffff"},
+		{"negative integer below int64", "3b8000000000000000"},
+		{"half float", "f93c00"},
+		{"single float", "fa47c35000"},
+		{"double float", "fb7e37e43c8800759c"},
+		{"other simple value", "f0"},
+		{"truncated byte string", "44010203"},
+		{"truncated text string", "64494554"},
+		{"truncated array", "830102"},
+		{"truncated map", "a2010203"},
+		{"map without value", "a101"},
+		{"byte string key", "a14101f5"},
+		{"array key", "a18001"},
+		{"map key", "a1a001"},
+		{"tag without item", "c1"},
+		{"byte string longer than the input", "5bffffffffffffffff01"},
+		{"text string longer than the input", "7a7fffffff"},
+		{"array longer than the input", "9bffffffffffffffff"},
+		{"array of 2^32 items", "9affffffff0102"},
+		{"map longer than the input", "bbffffffffffffffff"},
+		{"map of 2^32 pairs", "baffffffff0102"},
+		{"tag in a truncated array", "82c1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCBOR(mustHex(t, tt.hex))
+			if !errors.Is(err, errCBOR) {
+				t.Errorf("got error %v, want errCBOR", err)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x81}, depth), 0x00)
+	}
+
+	_, _, err := decodeCBOR(nested(maxCBORDepth))
+	if err != nil {
+		t.Errorf("got error %v for %d nested arrays, want none", err, maxCBORDepth)
+	}
+
+	_, _, err = decodeCBOR(nested(maxCBORDepth + 1))
+	if !errors.Is(err, errCBOR) {
+		t.Errorf("got error %v for %d nested arrays, want errCBOR", err, maxCBORDepth+1)
+	}
+
+	// a million nested arrays must be rejected without recursing into all of them
+	_, _, err = decodeCBOR(nested(1 << 20))
+	if !errors.Is(err, errCBOR) {
+		t.Errorf("got error %v for deeply nested arrays, want errCBOR", err)
+	}
+
+	// tags count towards the depth as well
+	_, _, err = decodeCBOR(append(bytes.Repeat([]byte{0xc1}, 1<<20), 0x00))
+	if !errors.Is(err, errCBOR) {
+		t.Errorf("got error %v for deeply nested tags, want errCBOR", err)
+	}
+}
+
+func TestCBORMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		hex     string
+		wantErr bool
+	}{
+		{"map", "a26161016162820203", false},
+		{"empty map", "a0", false},
+		{"array", "83010203", true},
+		{"integer", "01", true},
+		{"trailing bytes", "a0a0", true},
+		{"malformed", "a201", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cborMap(mustHex(t, tt.hex))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// FuzzDecodeCBOR checks that no input panics and what is left over is the end of the input
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range []string{"00", "1b7fffffffffffffff", "8301820203820405", "a26161016162820203", "c11a514b67b0", "9affffffff0102", "818181818100"} {
+		data, _ := hex.DecodeString(seed)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := decodeCBOR(data)
+		if err == nil && !bytes.HasSuffix(data, rest) {
+			t.Errorf("rest %x is not the end of %x", rest, data)
+		}
+
+		_, _ = parsePublicKey(data)
+	})
+}
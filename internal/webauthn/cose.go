@@ -0,0 +1,107 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// The COSE algorithms passkeys are registered with, in the order they are preferred
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// errUnsupportedKey is returned for COSE keys of other types or algorithms than the ones above
+var errUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// publicKey verifies the signatures of one credential
+type publicKey interface {
+	verify(message, signature []byte) bool
+}
+
+type es256Key struct {
+	key *ecdsa.PublicKey
+}
+
+func (k es256Key) verify(message, signature []byte) bool {
+	hash := sha256.Sum256(message)
+	return ecdsa.VerifyASN1(k.key, hash[:], signature)
+}
+
+type rs256Key struct {
+	key *rsa.PublicKey
+}
+
+func (k rs256Key) verify(message, signature []byte) bool {
+	hash := sha256.Sum256(message)
+	return rsa.VerifyPKCS1v15(k.key, crypto.SHA256, hash[:], signature) == nil
+}
+
+type ed25519Key struct {
+	key ed25519.PublicKey
+}
+
+func (k ed25519Key) verify(message, signature []byte) bool {
+	return ed25519.Verify(k.key, message, signature)
+}
+
+// parsePublicKey parses a public key in the COSE_Key format of the attested credential data
+func parsePublicKey(data []byte) (publicKey, error) {
+	m, err := cborMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+	x, _ := m[int64(-2)].([]byte)
+	y, _ := m[int64(-3)].([]byte)
+
+	switch {
+	case kty == 2 && alg == AlgES256 && crv == 1:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedKey
+		}
+
+		// ecdh checks that the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		_, err := ecdh.P256().NewPublicKey(point)
+		if err != nil {
+			return nil, errUnsupportedKey
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return es256Key{key: key}, nil
+	case kty == 1 && alg == AlgEdDSA && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+
+		return ed25519Key{key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		// for RSA keys -1 is the modulus and -2 the exponent
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedKey
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return nil, errUnsupportedKey
+		}
+
+		return rs256Key{key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	default:
+		return nil, errUnsupportedKey
+	}
+}
@@ -0,0 +1,157 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// The P-256 key of the COSE examples, RFC 9052 appendix C.7.1
+const (
+	exampleP256X = "65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d"
+	exampleP256Y = "1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c"
+)
+
+// The public key of the first Ed25519 test vector, RFC 8032 section 7.1
+const exampleEd25519 = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+
+func es256COSEKey(x, y []byte) []byte {
+	return encodeCBOR(cborPairs{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func ed25519COSEKey(x []byte) []byte {
+	return encodeCBOR(cborPairs{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, x}})
+}
+
+func rs256COSEKey(n, e []byte) []byte {
+	return encodeCBOR(cborPairs{{1, 3}, {3, AlgRS256}, {-1, n}, {-2, e}})
+}
+
+func TestParsePublicKey(t *testing.T) {
+	x, y := mustHex(t, exampleP256X), mustHex(t, exampleP256Y)
+	ed := mustHex(t, exampleEd25519)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := rsaKey.N.Bytes()
+	e := big.NewInt(int64(rsaKey.E)).Bytes()
+
+	// flipping a bit of y moves the point off the curve
+	offCurve := append([]byte(nil), y...)
+	offCurve[31] ^= 1
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr error
+	}{
+		{"ES256", es256COSEKey(x, y), nil},
+		{"EdDSA", ed25519COSEKey(ed), nil},
+		{"RS256", rs256COSEKey(n, e), nil},
+		{"ES256 with x too short", es256COSEKey(x[1:], y), errUnsupportedKey},
+		{"ES256 with y too long", es256COSEKey(x, append(y, 0)), errUnsupportedKey},
+		{"ES256 without y", encodeCBOR(cborPairs{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}}), errUnsupportedKey},
+		{"ES256 point not on the curve", es256COSEKey(x, offCurve), errUnsupportedKey},
+		{"ES256 point at infinity", es256COSEKey(make([]byte, 32), make([]byte, 32)), errUnsupportedKey},
+		{"ES256 on P-384", encodeCBOR(cborPairs{{1, 2}, {3, AlgES256}, {-1, 2}, {-2, x}, {-3, y}}), errUnsupportedKey},
+		{"ES384", encodeCBOR(cborPairs{{1, 2}, {3, -35}, {-1, 1}, {-2, x}, {-3, y}}), errUnsupportedKey},
+		{"ES256 with a text x", encodeCBOR(cborPairs{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, string(x)}, {-3, y}}), errUnsupportedKey},
+		{"EdDSA with a short key", ed25519COSEKey(ed[:31]), errUnsupportedKey},
+		{"EdDSA on X25519", encodeCBOR(cborPairs{{1, 1}, {3, AlgEdDSA}, {-1, 4}, {-2, ed}}), errUnsupportedKey},
+		{"RS256 with a 1024 bit modulus", rs256COSEKey(n[:128], e), errUnsupportedKey},
+		{"RS256 with an even exponent", rs256COSEKey(n, []byte{1, 0, 0}), errUnsupportedKey},
+		{"RS256 with exponent 1", rs256COSEKey(n, []byte{1}), errUnsupportedKey},
+		{"RS256 without exponent", rs256COSEKey(n, nil), errUnsupportedKey},
+		{"RS256 with a 5 byte exponent", rs256COSEKey(n, []byte{1, 0, 0, 0, 1}), errUnsupportedKey},
+		{"unknown key type", encodeCBOR(cborPairs{{1, 4}, {3, AlgES256}}), errUnsupportedKey},
+		{"empty map", encodeCBOR(cborPairs{}), errUnsupportedKey},
+		{"not a map", encodeCBOR([]interface{}{int64(1), int64(2)}), errCBOR},
+		{"trailing bytes", append(es256COSEKey(x, y), 0), errCBOR},
+		{"truncated", es256COSEKey(x, y)[:40], errCBOR},
+		{"empty", nil, errCBOR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePublicKey(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicKeyVerify(t *testing.T) {
+	message := []byte("authenticator data and client data hash")
+	hash := sha256.Sum256(message)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSignature := ed25519.Sign(edPrivate, message)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       []byte
+		signature []byte
+	}{
+		{"ES256", es256COSEKey(ecKey.X.FillBytes(make([]byte, 32)), ecKey.Y.FillBytes(make([]byte, 32))), ecSignature},
+		{"EdDSA", ed25519COSEKey(edPublic), edSignature},
+		{"RS256", rs256COSEKey(rsaKey.N.Bytes(), big.NewInt(int64(rsaKey.E)).Bytes()), rsaSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePublicKey(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !key.verify(message, tt.signature) {
+				t.Error("the signature does not verify")
+			}
+
+			tampered := append([]byte(nil), message...)
+			tampered[0] ^= 1
+			if key.verify(tampered, tt.signature) {
+				t.Error("the signature verifies for another message")
+			}
+
+			if key.verify(message, tt.signature[:len(tt.signature)-1]) {
+				t.Error("a truncated signature verifies")
+			}
+
+			if key.verify(message, nil) {
+				t.Error("an empty signature verifies")
+			}
+		})
+	}
+}
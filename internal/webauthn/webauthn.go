@@ -0,0 +1,337 @@
+// Package webauthn registers passkeys and verifies logins with them, following the Web Authentication
+// spec for relying parties. Attestation is not requested, so the authenticators are not checked
+// against a list of trusted ones, any passkey of the user will do.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrVerification is returned, wrapped with the reason, for responses of authenticators which do not
+// verify, such as those for another challenge, origin or key
+var ErrVerification = errors.New("webauthn: verification failed")
+
+// The flags of the authenticator data
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// Base64URL is a byte slice encoded as unpadded base64url in JSON, as in the JSON forms of the WebAuthn
+// options and credentials browsers convert with PublicKeyCredential.parseCreationOptionsFromJSON and toJSON
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// RelyingParty is the site passkeys are registered for. ID is its domain, Origins the origins of the
+// pages allowed to use them, such as https://example.com.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// User is the account a passkey is registered for, ID must not contain personal information
+type User struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type relyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor names a passkey already registered
+type CredentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   Base64URL `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create to register a passkey
+type CreationOptions struct {
+	RP                     relyingPartyEntity     `json:"rp"`
+	User                   User                   `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get to log in with a passkey. They allow no
+// credentials in particular, so the browser offers the passkeys it has for the site.
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions returns the options to register a passkey for user with, passkeys in exclude are
+// not registered again. The passkey has to be discoverable and verify the user, so that it can be
+// used on its own without a password.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, timeoutMS int, exclude [][]byte) *CreationOptions {
+	excluded := []CredentialDescriptor{}
+	for _, id := range exclude {
+		excluded = append(excluded, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return &CreationOptions{
+		RP:        relyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		Challenge: challenge,
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            timeoutMS,
+		ExcludeCredentials: excluded,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to log in with a passkey with
+func (rp *RelyingParty) RequestOptions(challenge []byte, timeoutMS int) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeoutMS,
+		RPID:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+// AttestationResponse is the response of navigator.credentials.create in the JSON form of toJSON
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+}
+
+// AssertionResponse is the response of navigator.credentials.get in the JSON form of toJSON
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle"`
+}
+
+// Credential is a registered passkey, PublicKey is in the COSE_Key format
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type        string    `json:"type"`
+	Challenge   Base64URL `json:"challenge"`
+	Origin      string    `json:"origin"`
+	CrossOrigin bool      `json:"crossOrigin"`
+}
+
+// Challenge returns the challenge in the client data of a response, so the one issued can be looked up
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil || len(data.Challenge) == 0 {
+		return nil, fmt.Errorf("%w: invalid client data", ErrVerification)
+	}
+
+	return data.Challenge, nil
+}
+
+// verifyClientData checks the client data of a response to the operation typ for challenge
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, typ string, challenge []byte) error {
+	var data clientData
+	err := json.Unmarshal(clientDataJSON, &data)
+	if err != nil {
+		return fmt.Errorf("%w: invalid client data", ErrVerification)
+	}
+
+	if data.Type != typ {
+		return fmt.Errorf("%w: client data is for %q", ErrVerification, data.Type)
+	}
+
+	if subtle.ConstantTimeCompare(data.Challenge, challenge) != 1 {
+		return fmt.Errorf("%w: wrong challenge", ErrVerification)
+	}
+
+	allowed := false
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			allowed = true
+			break
+		}
+	}
+	if !allowed || data.CrossOrigin {
+		return fmt.Errorf("%w: origin %q is not allowed", ErrVerification, data.Origin)
+	}
+
+	return nil
+}
+
+// authenticatorData is the part of the authenticator data both operations check
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+	// rest is the attested credential data and extensions
+	rest []byte
+}
+
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrVerification)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: passkey is for another relying party", ErrVerification)
+	}
+
+	auth := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+		rest:      data[37:],
+	}
+
+	if auth.flags&flagUserPresent == 0 || auth.flags&flagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user was not verified", ErrVerification)
+	}
+
+	return auth, nil
+}
+
+// VerifyRegistration checks the response of an authenticator registering a passkey for challenge and
+// returns the passkey. Attestation statements are not verified, as none were requested.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response AttestationResponse) (*Credential, error) {
+	err := rp.verifyClientData(response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := cborMap(response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrVerification)
+	}
+
+	data, _ := object["authData"].([]byte)
+	auth, err := rp.parseAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// the attested credential data is the AAGUID, the length of the credential ID, the ID and the key
+	rest := auth.rest
+	if auth.flags&flagAttestedCredentialData == 0 || len(rest) < 18 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, fmt.Errorf("%w: invalid credential ID", ErrVerification)
+	}
+
+	id := rest[:idLength]
+	rest = rest[idLength:]
+
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid public key", ErrVerification)
+	}
+	key := rest[:len(rest)-len(extensions)]
+
+	_, err = parsePublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	credential := &Credential{
+		ID:        append([]byte(nil), id...),
+		PublicKey: append([]byte(nil), key...),
+		SignCount: auth.signCount,
+	}
+
+	return credential, nil
+}
+
+// VerifyAssertion checks the response of an authenticator logging in with credential for challenge and
+// returns the new signature counter of the passkey. Counters which do not go up tell of a cloned
+// authenticator, passkeys which are synced between devices always send 0.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, credential *Credential, response AssertionResponse) (uint32, error) {
+	err := rp.verifyClientData(response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	auth, err := rp.parseAuthenticatorData(response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	clientDataHash := sha256.Sum256(response.ClientDataJSON)
+	message := append(append([]byte(nil), response.AuthenticatorData...), clientDataHash[:]...)
+
+	if !key.verify(message, response.Signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	if (auth.signCount != 0 || credential.SignCount != 0) && auth.signCount <= credential.SignCount {
+		return 0, fmt.Errorf("%w: signature counter did not increase", ErrVerification)
+	}
+
+	return auth.signCount, nil
+}
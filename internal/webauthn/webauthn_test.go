@@ -0,0 +1,206 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var testRP = &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func clientDataJSON(t *testing.T, typ string, challenge []byte, origin string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authData builds authenticator data for the relying party rpID, attested is appended after the counter
+func authData(rpID string, flags byte, signCount uint32, attested []byte) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := append(hash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	return append(data, attested...)
+}
+
+// attestedCredential is the attested credential data for id and the COSE key, with a zero AAGUID
+func attestedCredential(id, key []byte) []byte {
+	data := make([]byte, 16)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(id)))
+	data = append(data, id...)
+	return append(data, key...)
+}
+
+func attestationObject(authData []byte) []byte {
+	return encodeCBOR(cborPairs{{"fmt", "none"}, {"attStmt", cborPairs{}}, {"authData", authData}})
+}
+
+type testAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	cose   []byte
+	credID []byte
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testAuthenticator{
+		key:    key,
+		cose:   es256COSEKey(key.X.FillBytes(make([]byte, 32)), key.Y.FillBytes(make([]byte, 32))),
+		credID: []byte("credential-1"),
+	}
+}
+
+// assert signs authData with the client data like an authenticator answering navigator.credentials.get
+func (a *testAuthenticator) assert(t *testing.T, authData, clientDataJSON []byte) AssertionResponse {
+	t.Helper()
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	hash := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return AssertionResponse{ClientDataJSON: clientDataJSON, AuthenticatorData: authData, Signature: signature}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	a := newTestAuthenticator(t)
+	challenge := []byte("registration challenge")
+
+	created := clientDataJSON(t, "webauthn.create", challenge, "https://example.com")
+	flags := byte(flagUserPresent | flagUserVerified | flagAttestedCredentialData)
+	valid := authData("example.com", flags, 0, attestedCredential(a.credID, a.cose))
+
+	tests := []struct {
+		name       string
+		clientData []byte
+		object     []byte
+		wantErr    bool
+	}{
+		{"valid", created, attestationObject(valid), false},
+		{"with extensions", created, attestationObject(append(valid, encodeCBOR(cborPairs{{"credProtect", 2}})...)), false},
+		{"wrong challenge", clientDataJSON(t, "webauthn.create", []byte("other"), "https://example.com"), attestationObject(valid), true},
+		{"wrong origin", clientDataJSON(t, "webauthn.create", challenge, "https://evil.example"), attestationObject(valid), true},
+		{"login client data", clientDataJSON(t, "webauthn.get", challenge, "https://example.com"), attestationObject(valid), true},
+		{"malformed client data", []byte(`{"type":`), attestationObject(valid), true},
+		{"other relying party", created, attestationObject(authData("evil.example", flags, 0, attestedCredential(a.credID, a.cose))), true},
+		{"user not verified", created, attestationObject(authData("example.com", flagUserPresent|flagAttestedCredentialData, 0, attestedCredential(a.credID, a.cose))), true},
+		{"no attested credential flag", created, attestationObject(authData("example.com", flagUserPresent|flagUserVerified, 0, attestedCredential(a.credID, a.cose))), true},
+		{"no attested credential data", created, attestationObject(authData("example.com", flags, 0, nil)), true},
+		{"empty credential ID", created, attestationObject(authData("example.com", flags, 0, attestedCredential(nil, a.cose))), true},
+		{"credential ID too long", created, attestationObject(authData("example.com", flags, 0, attestedCredential(make([]byte, 1024), a.cose))), true},
+		{"credential ID past the end", created, attestationObject(valid[:37+18+4]), true},
+		{"truncated public key", created, attestationObject(valid[:len(valid)-10]), true},
+		{"unsupported public key", created, attestationObject(authData("example.com", flags, 0, attestedCredential(a.credID, encodeCBOR(cborPairs{{1, 2}, {3, -35}})))), true},
+		{"truncated authenticator data", created, attestationObject(valid[:36]), true},
+		{"authenticator data is not bytes", created, encodeCBOR(cborPairs{{"fmt", "none"}, {"authData", "text"}}), true},
+		{"attestation object is not a map", created, encodeCBOR([]interface{}{valid}), true},
+		{"truncated attestation object", created, attestationObject(valid)[:20], true},
+		{"empty attestation object", created, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential, err := testRP.VerifyRegistration(challenge, AttestationResponse{ClientDataJSON: tt.clientData, AttestationObject: tt.object})
+			if tt.wantErr {
+				if !errors.Is(err, ErrVerification) {
+					t.Errorf("got error %v, want ErrVerification", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(credential.ID) != string(a.credID) || string(credential.PublicKey) != string(a.cose) {
+				t.Errorf("got credential %x with key %x, want %x with key %x", credential.ID, credential.PublicKey, a.credID, a.cose)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := newTestAuthenticator(t)
+	challenge := []byte("login challenge")
+
+	got := clientDataJSON(t, "webauthn.get", challenge, "https://example.com")
+	flags := byte(flagUserPresent | flagUserVerified)
+
+	other := newTestAuthenticator(t)
+
+	// the signature has to cover the authenticator data as sent
+	tampered := a.assert(t, authData("example.com", flags, 1, nil), got)
+	tampered.AuthenticatorData = authData("example.com", flags, 2, nil)
+
+	tests := []struct {
+		name          string
+		stored        uint32
+		response      AssertionResponse
+		wantSignCount uint32
+		wantErr       bool
+	}{
+		{"valid", 4, a.assert(t, authData("example.com", flags, 5, nil), got), 5, false},
+		{"synced passkey without counter", 0, a.assert(t, authData("example.com", flags, 0, nil), got), 0, false},
+		{"counter did not increase", 5, a.assert(t, authData("example.com", flags, 5, nil), got), 0, true},
+		{"counter went back to 0", 5, a.assert(t, authData("example.com", flags, 0, nil), got), 0, true},
+		{"signed by another key", 0, other.assert(t, authData("example.com", flags, 1, nil), got), 0, true},
+		{"wrong challenge", 0, a.assert(t, authData("example.com", flags, 1, nil), clientDataJSON(t, "webauthn.get", []byte("other"), "https://example.com")), 0, true},
+		{"wrong origin", 0, a.assert(t, authData("example.com", flags, 1, nil), clientDataJSON(t, "webauthn.get", challenge, "https://evil.example")), 0, true},
+		{"registration client data", 0, a.assert(t, authData("example.com", flags, 1, nil), clientDataJSON(t, "webauthn.create", challenge, "https://example.com")), 0, true},
+		{"other relying party", 0, a.assert(t, authData("evil.example", flags, 1, nil), got), 0, true},
+		{"user not present", 0, a.assert(t, authData("example.com", flagUserVerified, 1, nil), got), 0, true},
+		{"truncated authenticator data", 0, a.assert(t, authData("example.com", flags, 1, nil)[:36], got), 0, true},
+		{"tampered authenticator data", 0, tampered, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential := &Credential{ID: a.credID, PublicKey: a.cose, SignCount: tt.stored}
+
+			signCount, err := testRP.VerifyAssertion(challenge, credential, tt.response)
+			if tt.wantErr {
+				if !errors.Is(err, ErrVerification) {
+					t.Errorf("got error %v, want ErrVerification", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signCount != tt.wantSignCount {
+				t.Errorf("got sign count %d, want %d", signCount, tt.wantSignCount)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionMalformedKey(t *testing.T) {
+	a := newTestAuthenticator(t)
+	challenge := []byte("login challenge")
+
+	response := a.assert(t, authData("example.com", flagUserPresent|flagUserVerified, 1, nil), clientDataJSON(t, "webauthn.get", challenge, "https://example.com"))
+
+	for _, key := range [][]byte{nil, a.cose[:10], append(a.cose, 0)} {
+		_, err := testRP.VerifyAssertion(challenge, &Credential{ID: a.credID, PublicKey: key}, response)
+		if !errors.Is(err, ErrVerification) {
+			t.Errorf("got error %v for the key %x, want ErrVerification", err, key)
+		}
+	}
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS files_expiry_idx;
\ No newline at end of file
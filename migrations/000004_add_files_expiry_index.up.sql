@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS files_expiry_idx ON files (expiry);
\ No newline at end of file
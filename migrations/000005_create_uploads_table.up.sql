@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id text PRIMARY KEY,
+    name text NOT NULL,
+    length bigint NOT NULL,
+    upload_offset bigint NOT NULL DEFAULT 0,
+    chunks int NOT NULL DEFAULT 0,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE files DROP COLUMN IF EXISTS transfer_id;
+
+DROP TABLE IF EXISTS transfers;
\ No newline at end of file
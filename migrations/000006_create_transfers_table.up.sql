@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS transfers (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE
+);
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS transfer_id bigint REFERENCES transfers ON DELETE SET NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE uploads DROP COLUMN IF EXISTS file_ttl_seconds;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS file_ttl_seconds bigint NOT NULL DEFAULT 120;
\ No newline at end of file
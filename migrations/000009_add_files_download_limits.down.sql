@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN IF EXISTS download_count;
+ALTER TABLE files DROP COLUMN IF EXISTS max_downloads;
\ No newline at end of file
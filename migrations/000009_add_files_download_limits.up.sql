@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS max_downloads int;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS download_count int NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN IF EXISTS encryption_nonce;
+ALTER TABLE files DROP COLUMN IF EXISTS encryption_key;
\ No newline at end of file
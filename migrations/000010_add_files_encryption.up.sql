@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_key bytea;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_nonce bytea;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS passphrase_salt;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS passphrase_salt bytea;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS checksum_sha256;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS checksum_sha256 text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS content_type text NOT NULL DEFAULT 'application/octet-stream';
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS downloads;
\ No newline at end of file
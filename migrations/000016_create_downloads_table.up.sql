@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS downloads (
+    id bigserial PRIMARY KEY,
+    file_id bigint NOT NULL REFERENCES files ON DELETE CASCADE,
+    downloaded_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ip text NOT NULL,
+    user_agent text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS downloads_file_id_idx ON downloads (file_id);
\ No newline at end of file
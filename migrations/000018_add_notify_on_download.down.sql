@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN IF EXISTS notify_on_download;
+ALTER TABLE users DROP COLUMN IF EXISTS notify_on_download;
\ No newline at end of file
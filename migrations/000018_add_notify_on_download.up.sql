@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN notify_on_download boolean NOT NULL DEFAULT false;
+ALTER TABLE files ADD COLUMN notify_on_download boolean NOT NULL DEFAULT false;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS files_deleted_at_idx ON files (deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS files_tags_idx;
+
+ALTER TABLE files DROP COLUMN IF EXISTS tags;
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS files_name_trgm_idx;
+DROP INDEX IF EXISTS files_search_idx;
+
+ALTER TABLE files DROP COLUMN IF EXISTS search;
+ALTER TABLE files DROP COLUMN IF EXISTS description;
+
+DROP EXTENSION IF EXISTS pg_trgm;
\ No newline at end of file
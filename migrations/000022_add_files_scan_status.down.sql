@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS files_scan_status_idx;
+
+ALTER TABLE files DROP COLUMN IF EXISTS scan_status;
\ No newline at end of file
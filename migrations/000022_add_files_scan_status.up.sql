@@ -0,0 +1,3 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_status text NOT NULL DEFAULT 'clean';
+
+CREATE INDEX IF NOT EXISTS files_scan_status_idx ON files (scan_status) WHERE scan_status = 'pending';
\ No newline at end of file
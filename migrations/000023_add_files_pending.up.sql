@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS pending boolean NOT NULL DEFAULT false;
\ No newline at end of file
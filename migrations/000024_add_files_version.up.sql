@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS thumbnail;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS thumbnail bytea;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS paste boolean NOT NULL DEFAULT false;
\ No newline at end of file
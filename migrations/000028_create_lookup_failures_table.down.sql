@@ -0,0 +1 @@
+DROP TABLE IF EXISTS lookup_failures;
\ No newline at end of file
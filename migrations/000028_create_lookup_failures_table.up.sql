@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS lookup_failures (
+    key text PRIMARY KEY,
+    failures int NOT NULL DEFAULT 0,
+    last_failure timestamp with time zone NOT NULL,
+    locked_until timestamp with time zone
+);
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    credential_id bytea UNIQUE NOT NULL,
+    public_key bytea NOT NULL,
+    sign_count bigint NOT NULL DEFAULT 0,
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS passkeys_user_id_idx ON passkeys (user_id);
+
+CREATE TABLE IF NOT EXISTS passkey_challenges (
+    hash bytea PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    scope text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
\ No newline at end of file
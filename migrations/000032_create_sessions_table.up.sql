@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    refresh_hash bytea UNIQUE NOT NULL,
+    previous_refresh_hash bytea,
+    ip text NOT NULL,
+    user_agent text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_previous_refresh_hash_idx ON sessions (previous_refresh_hash);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS session_id bigint REFERENCES sessions ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS tokens_session_id_idx ON tokens (session_id);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ALTER COLUMN user_id DROP NOT NULL;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    default_expiry_seconds bigint,
+    default_max_downloads integer
+);
\ No newline at end of file
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name text PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    code text PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role text NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission text NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name)
+VALUES ('admin'), ('member'), ('readonly');
+
+INSERT INTO permissions (code)
+VALUES ('files:read'), ('files:write'), ('admin:read'), ('admin:write');
+
+INSERT INTO roles_permissions (role, permission)
+VALUES
+    ('admin', 'files:read'), ('admin', 'files:write'), ('admin', 'admin:read'), ('admin', 'admin:write'),
+    ('member', 'files:read'), ('member', 'files:write'),
+    ('readonly', 'files:read');
+
+UPDATE users SET role = 'member' WHERE role = 'user';
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'member';
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles ON UPDATE CASCADE;
\ No newline at end of file
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS files_organization_id_idx;
+ALTER TABLE files DROP COLUMN IF EXISTS organization_id;
+
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
\ No newline at end of file
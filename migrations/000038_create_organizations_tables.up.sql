@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    quota bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    hash bytea PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    email citext NOT NULL,
+    role text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    UNIQUE (organization_id, email)
+);
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS files_organization_id_idx ON files (organization_id);
\ No newline at end of file
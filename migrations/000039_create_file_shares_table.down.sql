@@ -0,0 +1 @@
+DROP TABLE IF EXISTS file_shares;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS file_shares (
+    file_id bigint NOT NULL REFERENCES files ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (file_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS file_shares_user_id_idx ON file_shares (user_id, created_at);
\ No newline at end of file
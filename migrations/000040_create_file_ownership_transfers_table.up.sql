@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS file_ownership_transfers (
+    hash bytea PRIMARY KEY,
+    file_id bigint UNIQUE NOT NULL REFERENCES files ON DELETE CASCADE,
+    from_user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    to_user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL
+);
\ No newline at end of file
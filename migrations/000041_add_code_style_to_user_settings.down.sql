@@ -0,0 +1 @@
+ALTER TABLE user_settings DROP COLUMN IF EXISTS code_style;
\ No newline at end of file
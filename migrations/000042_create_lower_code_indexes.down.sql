@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS files_lower_code_idx;
+DROP INDEX IF EXISTS transfers_lower_code_idx;
\ No newline at end of file
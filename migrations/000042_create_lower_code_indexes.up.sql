@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS files_lower_code_idx ON files (lower(code));
+CREATE INDEX IF NOT EXISTS transfers_lower_code_idx ON transfers (lower(code));
\ No newline at end of file
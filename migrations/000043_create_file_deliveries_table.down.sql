@@ -0,0 +1 @@
+DROP TABLE IF EXISTS file_deliveries;
\ No newline at end of file
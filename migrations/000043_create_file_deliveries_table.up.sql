@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS file_deliveries (
+    id bigserial PRIMARY KEY,
+    file_id bigint NOT NULL REFERENCES files ON DELETE CASCADE,
+    email citext NOT NULL,
+    status text NOT NULL,
+    error text NOT NULL DEFAULT '',
+    link_expiry timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sent_at timestamp(0) with time zone,
+    downloaded_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS file_deliveries_file_id_idx ON file_deliveries (file_id);
\ No newline at end of file
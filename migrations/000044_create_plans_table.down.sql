@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS plan_id;
+DROP TABLE IF EXISTS plans;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    max_file_size bigint,
+    max_storage bigint,
+    max_expiry_seconds bigint,
+    max_files integer,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_id bigint REFERENCES plans ON DELETE SET NULL;
\ No newline at end of file
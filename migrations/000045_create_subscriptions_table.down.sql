@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS subscriptions;
+
+ALTER TABLE plans DROP COLUMN IF EXISTS stripe_price_id;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS stripe_price_id text UNIQUE;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    customer_id text NOT NULL,
+    subscription_id text UNIQUE NOT NULL,
+    status text NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL
+);
\ No newline at end of file
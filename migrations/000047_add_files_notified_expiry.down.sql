@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS notified_expiry;
\ No newline at end of file
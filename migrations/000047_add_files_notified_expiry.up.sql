@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS notified_expiry timestamp(0) with time zone;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN IF EXISTS legal_hold;
+DROP TABLE IF EXISTS retention_policies;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS retention_policies (
+    id bigserial PRIMARY KEY,
+    user_id bigint UNIQUE REFERENCES users ON DELETE CASCADE,
+    organization_id bigint UNIQUE REFERENCES organizations ON DELETE CASCADE,
+    max_lifetime_seconds bigint,
+    delete_after_download boolean NOT NULL DEFAULT false,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK ((user_id IS NULL) <> (organization_id IS NULL))
+);
+
+ALTER TABLE files ADD COLUMN IF NOT EXISTS legal_hold boolean NOT NULL DEFAULT false;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS allowed_networks;
\ No newline at end of file
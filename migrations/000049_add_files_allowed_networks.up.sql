@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS allowed_networks text[];
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files DROP COLUMN IF EXISTS blocked_countries;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS blocked_countries text[];
\ No newline at end of file